@@ -1,20 +1,33 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
+	"github.com/mpataki/shop/internal/agents"
+	"github.com/mpataki/shop/internal/api"
 	"github.com/mpataki/shop/internal/commands"
 	"github.com/mpataki/shop/internal/config"
 	"github.com/mpataki/shop/internal/events"
 	"github.com/mpataki/shop/internal/mcp"
 	"github.com/mpataki/shop/internal/process"
 	"github.com/mpataki/shop/internal/tui"
+	"github.com/mpataki/shop/internal/workflow"
+	"github.com/mpataki/shop/internal/workspace"
+	"github.com/muesli/termenv"
 	"github.com/spf13/cobra"
 )
 
@@ -24,16 +37,47 @@ func main() {
 		Short: "Claude Agent Orchestration System",
 		Long:  "Shop coordinates multiple Claude Code agents through defined workflows.",
 		RunE:  runTUI,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			mode, _ := cmd.Flags().GetString("color")
+			return applyColorMode(mode)
+		},
 	}
+	rootCmd.PersistentFlags().String("color", "auto", "Colorize output: auto, always, or never (auto honours NO_COLOR and TTY detection)")
+	addThemeFlag(rootCmd)
 
+	rootCmd.AddCommand(newTUICommand())
 	rootCmd.AddCommand(newRunCommand())
+	rootCmd.AddCommand(newBatchCommand())
+	rootCmd.AddCommand(newBenchCommand())
 	rootCmd.AddCommand(newResumeCommand())
 	rootCmd.AddCommand(newStatusCommand())
+	rootCmd.AddCommand(newWhoamiCommand())
 	rootCmd.AddCommand(newListCommand())
+	rootCmd.AddCommand(newWaitingCommand())
+	rootCmd.AddCommand(newWorkflowsCommand())
+	rootCmd.AddCommand(newWorkflowInfoCommand())
+	rootCmd.AddCommand(newValidateCommand())
+	rootCmd.AddCommand(newAgentDefCommand())
+	rootCmd.AddCommand(newScaffoldAgentsCommand())
 	rootCmd.AddCommand(newKillCommand())
 	rootCmd.AddCommand(newDeleteCommand())
+	rootCmd.AddCommand(newPruneBranchesCommand())
 	rootCmd.AddCommand(newContinueCommand())
 	rootCmd.AddCommand(newStopCommand())
+	rootCmd.AddCommand(newPauseCommand())
+	rootCmd.AddCommand(newMetaCommand())
+	rootCmd.AddCommand(newResetCommand())
+	rootCmd.AddCommand(newAdoptCommand())
+	rootCmd.AddCommand(newRecoverCommand())
+	rootCmd.AddCommand(newFixSignalCommand())
+	rootCmd.AddCommand(newStepCommand())
+	rootCmd.AddCommand(newExecCommand())
+	rootCmd.AddCommand(newArtifactsCommand())
+	rootCmd.AddCommand(newContextCommand())
+	rootCmd.AddCommand(newLogsCommand())
+	rootCmd.AddCommand(newServeCommand())
+	rootCmd.AddCommand(newVacuumCommand())
+	rootCmd.AddCommand(newRemindersCommand())
 	rootCmd.AddCommand(newMCPServerCommand())
 
 	if err := rootCmd.Execute(); err != nil {
@@ -41,12 +85,57 @@ func main() {
 	}
 }
 
+func addThemeFlag(cmd *cobra.Command) {
+	cmd.Flags().String("theme", "", "TUI colour theme: "+strings.Join(tui.ThemeNames(), ", ")+" (default from SHOP_THEME)")
+	cmd.RegisterFlagCompletionFunc("theme", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return tui.ThemeNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// newManager returns the manager that starts agents' claude processes,
+// limited to cfg.MaxConcurrentClaude at once when that is set.
+func newManager(cfg *config.Config) process.Manager {
+	if cfg.MaxConcurrentClaude <= 0 {
+		return process.NewCLIManager()
+	}
+	return process.Throttle(process.NewCLIManager(), process.NewSlotLimiter(cfg.ClaudeSlotsDir(), cfg.MaxConcurrentClaude))
+}
+
+func newTUICommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "tui [run-id]",
+		Short:             "Launch the TUI, optionally on one run's detail view",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE:              runTUI,
+	}
+	addThemeFlag(cmd)
+	return cmd
+}
+
 func runTUI(cmd *cobra.Command, args []string) error {
+	var runID int64
+	if len(args) == 1 {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid run ID: %w", err)
+		}
+		runID = id
+	}
+
 	cfg, err := config.New()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	theme := cfg.Theme
+	if cmd.Flags().Changed("theme") {
+		theme, _ = cmd.Flags().GetString("theme")
+	}
+	if err := tui.SetTheme(theme); err != nil {
+		return err
+	}
+
 	if err := cfg.EnsureDataDir(); err != nil {
 		return fmt.Errorf("failed to create data directory: %w", err)
 	}
@@ -57,11 +146,14 @@ func runTUI(cmd *cobra.Command, args []string) error {
 	}
 	defer store.Close()
 
-	pm := process.NewCLIManager()
-	proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
+	pm := newManager(cfg)
+	proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
 	proc.Start()
 
 	app := tui.NewApp(proc, store, cfg)
+	if runID > 0 {
+		app.OpenRun(runID)
+	}
 	p := tea.NewProgram(app, tea.WithAltScreen())
 
 	_, err = p.Run()
@@ -70,14 +162,47 @@ func runTUI(cmd *cobra.Command, args []string) error {
 
 func newRunCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "run <workflow> <prompt>",
+		Use:   "run <workflow> [prompt]",
 		Short: "Start a new workflow run",
-		Args:  cobra.ExactArgs(2),
+		Long: `Start a new workflow run. Use - as the workflow to read the script from
+stdin, e.g. 'generate-workflow | shop run - "add a flag"'; the run keeps
+its own copy of the script.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeWorkflows,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			workflowName := args[0]
-			prompt := args[1]
 			noExec, _ := cmd.Flags().GetBool("no-exec")
 			repoPath, _ := cmd.Flags().GetString("repo")
+			until, _ := cmd.Flags().GetString("until")
+			promptFile, _ := cmd.Flags().GetString("prompt-file")
+			allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
+			refresh, _ := cmd.Flags().GetBool("refresh")
+			keepGoing, _ := cmd.Flags().GetBool("keep-going")
+			metaPairs, _ := cmd.Flags().GetStringArray("meta")
+			priority, _ := cmd.Flags().GetInt("priority")
+			metadata, err := parseMetadata(metaPairs)
+			if err != nil {
+				return err
+			}
+
+			var prompt string
+			switch {
+			case len(args) == 2 && promptFile != "":
+				return fmt.Errorf("give the prompt as an argument or with --prompt-file, not both")
+			case len(args) == 2:
+				prompt = args[1]
+			case promptFile != "":
+				data, err := os.ReadFile(promptFile)
+				if err != nil {
+					return fmt.Errorf("read prompt file: %w", err)
+				}
+				prompt = string(data)
+			}
+			if !allowEmpty {
+				if err := commands.ValidatePrompt(prompt); err != nil {
+					return err
+				}
+			}
 
 			cfg, err := config.New()
 			if err != nil {
@@ -93,14 +218,40 @@ func newRunCommand() *cobra.Command {
 			}
 			defer store.Close()
 
-			workflowPath := findWorkflow(workflowName, cfg)
-			if workflowPath == "" {
-				return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+			var workflowPath, inlineSource string
+			if workflowName == "-" {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("read workflow from stdin: %w", err)
+				}
+				if strings.TrimSpace(string(data)) == "" {
+					return fmt.Errorf("no workflow script on stdin")
+				}
+				if err := workflow.Validate(string(data)); err != nil {
+					return fmt.Errorf("invalid workflow on stdin: %w", err)
+				}
+				workflowName, inlineSource = "stdin", string(data)
+			} else {
+				workflowPath = findWorkflow(workflowName, cfg)
+				if workflowPath == "" {
+					return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+				}
+				if filepath.Ext(workflowPath) != ".js" {
+					return fmt.Errorf("not a workflow script: %s (expected .js)", workflowPath)
+				}
 			}
 
-			if filepath.Ext(workflowPath) != ".js" {
-				return fmt.Errorf("not a workflow script: %s (expected .js)", workflowPath)
+			if repoPath, err = resolveRepo(cfg, repoPath, refresh); err != nil {
+				return err
 			}
+			script := inlineSource
+			if workflowPath != "" {
+				data, _ := os.ReadFile(workflowPath)
+				script = string(data)
+			}
+			warnMissingAgents(script, repoPath)
+			warnSkipPermissions(script)
+			warnDataDirInRepo(cfg, repoPath)
 
 			// Create run
 			runID, err := store.CreateRun()
@@ -116,14 +267,20 @@ func newRunCommand() *cobra.Command {
 			}
 
 			// Create processor and submit StartRun command
-			pm := process.NewCLIManager()
-			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
 
 			startCmd, err := commands.NewCommand(runID, commands.CmdStartRun, commands.StartRunPayload{
-				WorkflowPath:  workflowPath,
-				WorkflowName:  workflowName,
-				InitialPrompt: prompt,
-				SourceRepo:    repoPath,
+				WorkflowPath:   workflowPath,
+				WorkflowName:   workflowName,
+				WorkflowSource: inlineSource,
+				InitialPrompt:  prompt,
+				SourceRepo:     repoPath,
+				Until:          until,
+				AllowEmpty:     allowEmpty,
+				KeepGoing:      keepGoing,
+				Metadata:       metadata,
+				Priority:       priority,
 			})
 			if err != nil {
 				return err
@@ -152,233 +309,396 @@ func newRunCommand() *cobra.Command {
 				fmt.Printf("Waiting: %s\n", state.WaitingReason)
 				fmt.Printf("\nUse 'shop continue %d' to open the Claude session.\n", runID)
 			}
+			printPaused(state)
 
 			return nil
 		},
 	}
 
 	cmd.Flags().Bool("no-exec", false, "Create run but don't execute")
-	cmd.Flags().StringP("repo", "r", ".", "Source git repository for worktree (default: current directory)")
+	cmd.Flags().String("until", "", "Pause the run before this agent starts")
+	cmd.Flags().String("prompt-file", "", "Read the prompt from a file instead of an argument")
+	cmd.Flags().Bool("allow-empty", false, "Start the run even if the prompt is empty")
+	cmd.Flags().Bool("keep-going", false, "Hand a stuck agent's signal back to the script instead of waiting for a human")
+	cmd.Flags().StringArray("meta", nil, "Attach key=value metadata to the run (repeatable)")
+	cmd.Flags().Int("priority", 0, "With SHOP_MAX_CONCURRENT_CLAUDE set, this run's agents get a free slot before lower-priority runs'")
+	cmd.Flags().StringP("repo", "r", ".", "Source git repository or remote URL for the worktree (default: current directory)")
+	cmd.Flags().Bool("refresh", false, "With a remote --repo, pull the cached clone before branching")
 	return cmd
 }
 
-func findWorkflow(name string, cfg *config.Config) string {
-	dirs := []string{cfg.ProjectWorkflowDir, cfg.UserWorkflowDir}
+// resolveRepo turns a remote --repo URL into its cached clone, cloning it
+// on first use.
+func resolveRepo(cfg *config.Config, repo string, refresh bool) (string, error) {
+	if !workspace.IsRemote(repo) {
+		return repo, nil
+	}
+	fmt.Fprintf(os.Stderr, "Using a cached clone of %s\n", repo)
+	return workspace.ResolveRepo(cfg.RepoCacheDir(), repo, refresh)
+}
 
-	for _, dir := range dirs {
-		if strings.HasSuffix(name, ".js") {
-			path := filepath.Join(dir, name)
-			if _, err := os.Stat(path); err == nil {
-				return path
-			}
-		}
+// printPaused explains how to continue a run stopped at an --until breakpoint.
+func printPaused(state *events.RunState) {
+	if state.Status != events.RunStatusPaused {
+		return
+	}
+	fmt.Printf("Paused: %s\n", state.WaitingReason)
+	fmt.Printf("\nUse 'shop resume %d' to continue past it.\n", state.ID)
+}
 
-		path := filepath.Join(dir, name+".js")
-		if _, err := os.Stat(path); err == nil {
-			return path
-		}
+// shortSHA abbreviates a commit hash for display.
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
 	}
+	return sha
+}
 
+func findWorkflow(name string, cfg *config.Config) string {
+	if w, ok := cfg.FindWorkflow(name); ok {
+		return w.Path
+	}
 	return ""
 }
 
-func newResumeCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "resume <run-id>",
-		Short: "Resume an interrupted run",
-		Args:  cobra.ExactArgs(1),
+func newBatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch <workflow>",
+		Short: "Run a workflow once per prompt and report the results",
+		Long: `Start an independent run of the workflow for every prompt in
+--prompts-file and print a results table once they have all settled.
+The file holds one prompt per line, a JSON array of strings (.json), or
+prompts in the first column of a CSV file (.csv). --parallel bounds how
+many runs execute at once.
+
+--assert takes a JSON object mapping prompts to the expected outcome,
+e.g. {"add a flag": {"status": "complete", "signal": {"status": "DONE"}}},
+checks each run's final status and final signal against it, and exits
+non-zero if any prompt doesn't match.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeWorkflows,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			runID, err := strconv.ParseInt(args[0], 10, 64)
-			if err != nil {
-				return fmt.Errorf("invalid run ID: %w", err)
+			workflowName := args[0]
+			promptsFile, _ := cmd.Flags().GetString("prompts-file")
+			repoPath, _ := cmd.Flags().GetString("repo")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			output, _ := cmd.Flags().GetString("output")
+			assertFile, _ := cmd.Flags().GetString("assert")
+			if output != "table" && output != "json" {
+				return fmt.Errorf("invalid --output %q: use table or json", output)
 			}
-
-			cfg, store, err := openStore()
-			if err != nil {
-				return err
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
 			}
-			defer store.Close()
-
-			pm := process.NewCLIManager()
-			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
 
-			resumeCmd, err := commands.NewCommand(runID, commands.CmdResumeRun, commands.ResumeRunPayload{})
+			prompts, err := commands.ReadPrompts(promptsFile)
 			if err != nil {
 				return err
 			}
 
-			if err := proc.SubmitCommand(resumeCmd); err != nil {
-				return err
-			}
-
-			fmt.Printf("Resuming run #%d\n", runID)
-
-			done := proc.ProcessRunSync(runID)
-			<-done
-
-			state, _ := store.ProjectRunFromDB(runID)
-			if state != nil {
-				fmt.Printf("Run completed with status: %s\n", state.Status)
-				if state.Error != "" {
-					fmt.Printf("Error: %s\n", state.Error)
+			var expectations map[string]commands.Expectation
+			if assertFile != "" {
+				if expectations, err = commands.ReadExpectations(assertFile); err != nil {
+					return err
+				}
+				inBatch := map[string]bool{}
+				for _, prompt := range prompts {
+					inBatch[prompt] = true
+				}
+				for prompt := range expectations {
+					if !inBatch[prompt] {
+						fmt.Fprintf(os.Stderr, "Warning: %s has an expectation for %q, which isn't in %s\n", assertFile, truncate(prompt, 40), promptsFile)
+					}
 				}
-			}
-			return nil
-		},
-	}
-}
-
-func newStatusCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "status <run-id>",
-		Short: "Show run status",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			runID, err := strconv.ParseInt(args[0], 10, 64)
-			if err != nil {
-				return fmt.Errorf("invalid run ID: %w", err)
 			}
 
-			_, store, err := openStore()
+			cfg, store, err := openStore()
 			if err != nil {
 				return err
 			}
 			defer store.Close()
 
-			state, err := store.ProjectRunFromDB(runID)
-			if err != nil {
-				return fmt.Errorf("failed to get run: %w", err)
+			workflowPath := findWorkflow(workflowName, cfg)
+			if workflowPath == "" {
+				return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
 			}
-
-			fmt.Printf("Run #%d: %s\n", state.ID, state.WorkflowName)
-			fmt.Printf("Status: %s\n", state.Status)
-			fmt.Printf("Prompt: %s\n", state.InitialPrompt)
-			fmt.Printf("Workspace: %s\n", state.WorkspacePath)
-			if state.WorkflowPath != "" {
-				fmt.Printf("Workflow: %s\n", state.WorkflowPath)
+			if filepath.Ext(workflowPath) != ".js" {
+				return fmt.Errorf("not a workflow script: %s (expected .js)", workflowPath)
 			}
-			if state.CurrentAgent != "" {
-				fmt.Printf("Agent: %s\n", state.CurrentAgent)
+			refresh, _ := cmd.Flags().GetBool("refresh")
+			if repoPath, err = resolveRepo(cfg, repoPath, refresh); err != nil {
+				return err
+			}
+			if script, err := os.ReadFile(workflowPath); err == nil {
+				warnMissingAgents(string(script), repoPath)
+				warnSkipPermissions(string(script))
 			}
+			warnDataDirInRepo(cfg, repoPath)
 
-			if state.Status == events.RunStatusWaitingHuman {
-				if state.WaitingSessionID != "" {
-					fmt.Printf("Session: %s\n", state.WaitingSessionID)
-				}
-				if state.WaitingReason != "" {
-					fmt.Printf("Reason: %s\n", state.WaitingReason)
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			if output == "table" {
+				fmt.Printf("Running %d prompts through %q (%d at a time)...\n", len(prompts), workflowName, parallel)
+			}
+			results := proc.RunBatch(commands.StartRunPayload{
+				WorkflowPath: workflowPath,
+				WorkflowName: workflowName,
+				SourceRepo:   repoPath,
+			}, prompts, parallel)
+
+			entries := batchEntries(results, expectations)
+			if output == "json" {
+				if err := printBatchJSON(entries); err != nil {
+					return err
 				}
-				fmt.Printf("\nUse 'shop continue %d' to open the Claude session.\n", state.ID)
+			} else {
+				printBatchTable(entries)
 			}
 
-			if state.Error != "" {
-				fmt.Printf("Error: %s\n", state.Error)
+			if expectations == nil {
+				return nil
 			}
-
-			if len(state.Executions) > 0 {
-				fmt.Println("\nExecutions:")
-				for i, exec := range state.Executions {
-					status := string(exec.Status)
-					fmt.Printf("  [%d] %s [%s]\n", i+1, exec.AgentName, status)
+			failed := 0
+			for _, e := range entries {
+				if e.Pass != nil && !*e.Pass {
+					failed++
 				}
 			}
-
+			if failed > 0 {
+				return fmt.Errorf("%d of %d prompts did not meet expectations", failed, len(entries))
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().String("prompts-file", "", "File of prompts: one per line, a JSON array (.json) or CSV (.csv)")
+	cmd.Flags().Int("parallel", 1, "Maximum number of runs executing at once")
+	cmd.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmd.Flags().StringP("repo", "r", ".", "Source git repository or remote URL for worktrees (default: current directory)")
+	cmd.Flags().Bool("refresh", false, "With a remote --repo, pull the cached clone first")
+	cmd.Flags().String("assert", "", "JSON file of expected outcomes per prompt; exit non-zero on any mismatch")
+	cmd.MarkFlagRequired("prompts-file")
+	return cmd
 }
 
-func newListCommand() *cobra.Command {
+type batchEntry struct {
+	Prompt          string        `json:"prompt"`
+	RunID           int64         `json:"run_id,omitempty"`
+	Status          string        `json:"status"`
+	Error           string        `json:"error,omitempty"`
+	Duration        time.Duration `json:"-"`
+	DurationSeconds float64       `json:"duration_seconds"`
+
+	// Pass is set when the prompt has an expectation (--assert)
+	Pass       *bool    `json:"pass,omitempty"`
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// batchEntries pairs batch results with the outcome of their expectations.
+func batchEntries(results []commands.BatchResult, expectations map[string]commands.Expectation) []batchEntry {
+	entries := []batchEntry{}
+	for _, r := range results {
+		e := batchEntry{
+			Prompt:          r.Prompt,
+			RunID:           r.RunID,
+			Status:          r.Status,
+			Error:           r.Error,
+			Duration:        r.Duration,
+			DurationSeconds: r.Duration.Round(time.Millisecond).Seconds(),
+		}
+		if want, ok := expectations[r.Prompt]; ok {
+			e.Mismatches = want.Check(r)
+			pass := len(e.Mismatches) == 0
+			e.Pass = &pass
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func printBatchJSON(entries []batchEntry) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func printBatchTable(entries []batchEntry) {
+	fmt.Printf("\n%-6s %-14s %-9s %-6s %s\n", "RUN", "STATUS", "DURATION", "RESULT", "PROMPT")
+	for _, e := range entries {
+		id := "-"
+		if e.RunID != 0 {
+			id = "#" + strconv.FormatInt(e.RunID, 10)
+		}
+		result := "-"
+		if e.Pass != nil {
+			result = "PASS"
+			if !*e.Pass {
+				result = "FAIL"
+			}
+		}
+		fmt.Printf("%-6s %-14s %-9s %-6s %s\n", id, e.Status, e.Duration.Round(time.Second), result, truncate(e.Prompt, 50))
+		if e.Error != "" {
+			fmt.Printf("%-6s %s\n", "", truncate(e.Error, 80))
+		}
+		for _, m := range e.Mismatches {
+			fmt.Printf("%-6s ✗ %s\n", "", m)
+		}
+	}
+}
+
+func newBenchCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "list",
-		Short: "List recent runs",
+		Use:   "bench <workflow> <prompt>",
+		Short: "Time repeated runs of a workflow",
+		Long: `Run the workflow --runs times on the same prompt, each in a fresh
+workspace, and report the min, median (p50), p90 and max duration of the
+whole run and of each agent. --parallel bounds how many runs execute at
+once; like batch runs, the runs are kept and show up in shop list.
+
+--stub replaces Claude with agents that report the first status their
+run() call accepts (DONE if none) straight away, which times shop and
+the workflow's control flow without spending tokens.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeWorkflows,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, store, err := openStore()
-			if err != nil {
-				return err
+			workflowName, prompt := args[0], args[1]
+			runs, _ := cmd.Flags().GetInt("runs")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			repoPath, _ := cmd.Flags().GetString("repo")
+			output, _ := cmd.Flags().GetString("output")
+			stub, _ := cmd.Flags().GetBool("stub")
+			if output != "table" && output != "json" {
+				return fmt.Errorf("invalid --output %q: use table or json", output)
+			}
+			if runs < 1 || parallel < 1 {
+				return fmt.Errorf("--runs and --parallel must be at least 1")
 			}
-			defer store.Close()
-
-			active, _ := cmd.Flags().GetBool("active")
 
-			runs, err := store.ListRunIDs(20)
+			cfg, store, err := openStore()
 			if err != nil {
 				return err
 			}
+			defer store.Close()
 
-			if len(runs) == 0 {
-				fmt.Println("No runs found.")
-				return nil
+			workflowPath := findWorkflow(workflowName, cfg)
+			if workflowPath == "" {
+				return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
 			}
-
-			// Project each run
-			type runEntry struct {
-				state *events.RunState
+			if filepath.Ext(workflowPath) != ".js" {
+				return fmt.Errorf("not a workflow script: %s (expected .js)", workflowPath)
+			}
+			refresh, _ := cmd.Flags().GetBool("refresh")
+			if repoPath, err = resolveRepo(cfg, repoPath, refresh); err != nil {
+				return err
 			}
-			var entries []runEntry
-
-			for _, r := range runs {
-				evts, err := store.GetEvents(r.ID)
-				if err != nil {
-					continue
-				}
-				state := events.ProjectRun(r.ID, r.CreatedAt, evts)
 
-				if active {
-					if state.Status != events.RunStatusRunning &&
-						state.Status != events.RunStatusWaitingHuman &&
-						state.Status != events.RunStatusPending {
-						continue
-					}
-				}
-				// Skip deleted runs
-				if state.Status == events.RunStatusDeleted {
-					continue
+			var pm process.Manager = commands.NewStubManager(store)
+			if !stub {
+				if script, err := os.ReadFile(workflowPath); err == nil {
+					warnMissingAgents(string(script), repoPath)
+					warnSkipPermissions(string(script))
 				}
-
-				entries = append(entries, runEntry{state: state})
+				warnDataDirInRepo(cfg, repoPath)
+				pm = newManager(cfg)
 			}
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
 
-			if len(entries) == 0 {
-				if active {
-					fmt.Println("No active runs found.")
-				} else {
-					fmt.Println("No runs found.")
-				}
-				return nil
+			if output == "table" {
+				fmt.Printf("Running %q %d times (%d at a time)...\n", workflowName, runs, parallel)
+			}
+			prompts := make([]string, runs)
+			for i := range prompts {
+				prompts[i] = prompt
 			}
+			results := proc.RunBatch(commands.StartRunPayload{
+				WorkflowPath: workflowPath,
+				WorkflowName: workflowName,
+				SourceRepo:   repoPath,
+			}, prompts, parallel)
+			report := commands.Bench(results)
+
+			if output == "json" {
+				return printBenchJSON(report, results)
+			}
+			printBenchTable(report, results)
+			return nil
+		},
+	}
 
-			fmt.Printf("%-4s %-15s %-14s %-12s %s\n", "ID", "WORKFLOW", "STATUS", "AGENT", "WAITING FOR")
+	cmd.Flags().IntP("runs", "n", 5, "Number of runs")
+	cmd.Flags().Int("parallel", 1, "Maximum number of runs executing at once")
+	cmd.Flags().Bool("stub", false, "Use stub agents that report their first accepted status at once instead of Claude")
+	cmd.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmd.Flags().StringP("repo", "r", ".", "Source git repository or remote URL for worktrees (default: current directory)")
+	cmd.Flags().Bool("refresh", false, "With a remote --repo, pull the cached clone first")
+	return cmd
+}
 
-			for _, e := range entries {
-				s := e.state
-				agent := s.CurrentAgent
-				if agent == "" {
-					agent = "-"
-				}
+type benchStats struct {
+	N          int     `json:"n"`
+	MinSeconds float64 `json:"min_seconds"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P90Seconds float64 `json:"p90_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+}
 
-				waitingFor := "-"
-				if s.Status == events.RunStatusWaitingHuman && s.WaitingReason != "" {
-					waitingFor = truncate(s.WaitingReason, 40)
-				}
+func newBenchStats(s commands.DurationStats) benchStats {
+	seconds := func(d time.Duration) float64 { return d.Round(time.Millisecond).Seconds() }
+	return benchStats{N: s.N, MinSeconds: seconds(s.Min), P50Seconds: seconds(s.P50), P90Seconds: seconds(s.P90), MaxSeconds: seconds(s.Max)}
+}
 
-				fmt.Printf("%-4d %-15s %-14s %-12s %s\n",
-					s.ID, truncate(s.WorkflowName, 15), string(s.Status), truncate(agent, 12), waitingFor)
-			}
+func printBenchJSON(report commands.BenchReport, results []commands.BatchResult) error {
+	out := struct {
+		Runs     int                   `json:"runs"`
+		Complete int                   `json:"complete"`
+		RunIDs   []int64               `json:"run_ids"`
+		Total    benchStats            `json:"total"`
+		Agents   map[string]benchStats `json:"agents"`
+	}{Runs: report.Runs, Complete: report.Complete, RunIDs: []int64{}, Total: newBenchStats(report.Total), Agents: map[string]benchStats{}}
+	for _, r := range results {
+		if r.RunID != 0 {
+			out.RunIDs = append(out.RunIDs, r.RunID)
+		}
+	}
+	for agent, s := range report.Agents {
+		out.Agents[agent] = newBenchStats(s)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
 
-			return nil
-		},
+func printBenchTable(report commands.BenchReport, results []commands.BatchResult) {
+	fmt.Printf("\n%d of %d runs complete\n", report.Complete, report.Runs)
+	for _, r := range results {
+		if r.Status != string(events.RunStatusComplete) {
+			fmt.Printf("  run #%d %s: %s\n", r.RunID, r.Status, truncate(r.Error, 70))
+		}
 	}
 
-	cmd.Flags().Bool("active", false, "Show only active runs (exclude completed/failed)")
-	return cmd
+	row := func(name string, s commands.DurationStats) {
+		d := func(d time.Duration) string { return d.Round(time.Millisecond).String() }
+		fmt.Printf("%-20s %4d %10s %10s %10s %10s\n", name, s.N, d(s.Min), d(s.P50), d(s.P90), d(s.Max))
+	}
+	fmt.Printf("\n%-20s %4s %10s %10s %10s %10s\n", "", "N", "MIN", "P50", "P90", "MAX")
+	row("total", report.Total)
+	agents := make([]string, 0, len(report.Agents))
+	for agent := range report.Agents {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	for _, agent := range agents {
+		row(truncate(agent, 20), report.Agents[agent])
+	}
 }
 
-func newKillCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "kill <run-id>",
-		Short: "Kill a running run",
-		Args:  cobra.ExactArgs(1),
+func newResumeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "resume <run-id>",
+		Short:             "Resume an interrupted run",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			runID, err := strconv.ParseInt(args[0], 10, 64)
 			if err != nil {
@@ -391,76 +711,1970 @@ func newKillCommand() *cobra.Command {
 			}
 			defer store.Close()
 
-			pm := process.NewCLIManager()
-			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
 
-			killCmd, err := commands.NewCommand(runID, commands.CmdKillRun, commands.KillRunPayload{})
+			until, _ := cmd.Flags().GetString("until")
+			repoPath, _ := cmd.Flags().GetString("repo")
+			strict, _ := cmd.Flags().GetBool("strict")
+			if quiet, _ := cmd.Flags().GetBool("quiet"); !quiet {
+				proc.OnReplayProgress(func(id int64, p workflow.ReplayProgress) {
+					if id != runID {
+						return
+					}
+					if p.Live {
+						fmt.Printf("  call %d (%s) runs live\n", p.CallIndex, p.Agent)
+					} else {
+						fmt.Printf("  replaying call %d/%d (%s, cached)\n", p.Replayed, p.Cached, p.Agent)
+					}
+				})
+			}
+			resumeCmd, err := commands.NewCommand(runID, commands.CmdResumeRun, commands.ResumeRunPayload{Until: until, Repo: repoPath, Strict: strict})
 			if err != nil {
 				return err
 			}
 
-			if err := proc.SubmitCommand(killCmd); err != nil {
+			if err := proc.SubmitCommand(resumeCmd); err != nil {
 				return err
 			}
 
+			fmt.Printf("Resuming run #%d\n", runID)
+
 			done := proc.ProcessRunSync(runID)
 			<-done
 
-			fmt.Printf("Killed run #%d\n", runID)
+			state, _ := store.ProjectRunFromDB(runID)
+			if state != nil {
+				fmt.Printf("Run completed with status: %s\n", state.Status)
+				if state.Error != "" {
+					fmt.Printf("Error: %s\n", state.Error)
+				}
+				printPaused(state)
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().String("until", "", "Pause the run again before this agent starts")
+	cmd.Flags().String("repo", "", "The source repo's new location, if it moved since the run started")
+	cmd.Flags().Bool("strict", false, "Fail the run if the script asks for a different agent than history ran at a call, instead of re-running it")
+	cmd.Flags().BoolP("quiet", "q", false, "Don't report calls as they are replayed from history")
+	return cmd
 }
 
-func newDeleteCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "delete <run-id>",
-		Short: "Delete a run and its workspace",
-		Args:  cobra.ExactArgs(1),
+func newStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "status <run-id>",
+		Short:             "Show run status",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			runID, err := strconv.ParseInt(args[0], 10, 64)
 			if err != nil {
 				return fmt.Errorf("invalid run ID: %w", err)
 			}
 
-			cfg, store, err := openStore()
-			if err != nil {
-				return err
+			watch, _ := cmd.Flags().GetBool("watch")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			if watch && interval <= 0 {
+				return fmt.Errorf("--interval must be positive, got %s", interval)
 			}
-			defer store.Close()
-
-			pm := process.NewCLIManager()
-			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
 
-			delCmd, err := commands.NewCommand(runID, commands.CmdDeleteRun, commands.DeleteRunPayload{})
+			_, store, err := openStore()
 			if err != nil {
 				return err
 			}
+			defer store.Close()
 
-			if err := proc.SubmitCommand(delCmd); err != nil {
-				return err
-			}
+			for {
+				state, err := store.ProjectRunFromDB(runID)
+				if err != nil {
+					return fmt.Errorf("failed to get run: %w", err)
+				}
+
+				if !watch {
+					printStatus(state)
+					printChildRuns(store, state.ID, 1)
+					return nil
+				}
+
+				// Redraw in place
+				fmt.Print("\033[H\033[2J")
+				printStatus(state)
+				printChildRuns(store, state.ID, 1)
+				if state.Status.IsTerminal() || state.Status.IsSuspended() {
+					return nil
+				}
+				// A run made with --no-exec, or reset, waits for someone to start it
+				if state.Status == events.RunStatusPending {
+					if queued, err := store.GetPendingCommands(runID); err == nil && len(queued) == 0 {
+						hint := fmt.Sprintf("'shop resume %d' runs it", runID)
+						if state.WorkflowPath == "" && state.WorkflowSource == "" {
+							hint = "it has no workflow to run"
+						}
+						fmt.Printf("\nRun %d is pending and nothing is queued to start it; %s.\n", runID, hint)
+						return nil
+					}
+				}
+				fmt.Printf("\nWatching (every %s, Ctrl+C to exit)...\n", interval)
+				time.Sleep(interval)
+			}
+		},
+	}
+
+	cmd.Flags().BoolP("watch", "w", false, "Refresh until the run finishes, waits for input, or is pending with nothing to start it")
+	cmd.Flags().Duration("interval", 2*time.Second, "Refresh interval for --watch")
+	return cmd
+}
+
+func newWhoamiCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "whoami [path]",
+		Short: "Show which run a workspace directory belongs to",
+		Long: `Find the run whose workspace contains path (default: the current directory),
+e.g. from inside a run's worktree, and print its ID and status.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "."
+			if len(args) > 0 {
+				path = args[0]
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.GetRunByWorkspace(abs)
+			if err != nil {
+				// The workspace may have been recorded through a symlink (or not)
+				resolved, evalErr := filepath.EvalSymlinks(abs)
+				if evalErr != nil || resolved == abs {
+					return err
+				}
+				if state, err = store.GetRunByWorkspace(resolved); err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("Run #%d: %s\n", state.ID, state.WorkflowName)
+			fmt.Printf("Status: %s\n", state.Status)
+			fmt.Printf("Workspace: %s\n", state.WorkspacePath)
+			if state.Branch != "" {
+				fmt.Printf("Branch: %s\n", state.Branch)
+			}
+			fmt.Printf("\nUse 'shop status %d' for details.\n", state.ID)
+			return nil
+		},
+	}
+}
+
+// printChildRuns lists the runs parentID spawned, and theirs, indented by
+// depth.
+func printChildRuns(store *events.Store, parentID int64, depth int) {
+	kids, err := store.ListChildRuns(parentID)
+	if err != nil || len(kids) == 0 {
+		return
+	}
+	if depth == 1 {
+		fmt.Println("\nChild runs:")
+	}
+	for _, kid := range kids {
+		fmt.Printf("%s↳ child run #%d %s [%s]\n", strings.Repeat("  ", depth), kid.ID, kid.WorkflowName, kid.Status)
+		printChildRuns(store, kid.ID, depth+1)
+	}
+}
+
+func printStatus(state *events.RunState) {
+	fmt.Printf("Run #%d: %s\n", state.ID, state.WorkflowName)
+	fmt.Printf("Status: %s\n", state.Status)
+	fmt.Printf("Prompt: %s\n", state.InitialPrompt)
+	fmt.Printf("Workspace: %s\n", state.WorkspacePath)
+	if state.WorkspacePath != "" && !workspace.Exists(state.WorkspacePath) {
+		fmt.Printf("           (workspace missing; 'shop delete %d' removes the run)\n", state.ID)
+	} else if state.Branch != "" && !workspace.Exists(filepath.Join(state.WorkspacePath, "repo")) {
+		fmt.Println("           (worktree removed; the branch is kept)")
+	}
+	if state.Branch != "" {
+		fmt.Printf("Branch: %s", state.Branch)
+		if state.BaseCommit != "" {
+			fmt.Printf(" (from %s)", shortSHA(state.BaseCommit))
+		}
+		fmt.Println()
+	}
+	if state.ParentRunID != 0 {
+		fmt.Printf("Parent: run #%d (call %d; shares its workspace)\n", state.ParentRunID, state.ParentCallIndex)
+	}
+	if state.KeepGoing {
+		fmt.Println("Keep going: stuck agents don't suspend the run")
+	}
+	if len(state.Metadata) > 0 {
+		fmt.Printf("Metadata: %s\n", strings.Join(metadataPairs(state.Metadata), ", "))
+	}
+	if state.WorkflowPath != "" {
+		fmt.Printf("Workflow: %s\n", state.WorkflowPath)
+		current, err := os.ReadFile(state.WorkflowPath)
+		switch {
+		case state.WorkflowSource == "":
+		case os.IsNotExist(err):
+			fmt.Println("          (deleted since the run started; the run keeps its original script)")
+		case err == nil && string(current) != state.WorkflowSource:
+			fmt.Println("          (edited since the run started; the run keeps its original script)")
+		}
+	}
+	if state.CurrentAgent != "" {
+		fmt.Printf("Agent: %s\n", state.CurrentAgent)
+	}
+	if progress := state.Progress(); progress.Step > 0 {
+		fmt.Printf("Progress: step %d", progress.Step)
+		if progress.LastAgent != "" {
+			fmt.Printf(", last: %s", progress.LastAgent)
+		}
+		fmt.Printf(" (%s)\n", strings.Join(progress.Agents, " → "))
+	}
+
+	if final := state.FinalStatus(); final != "" {
+		fmt.Printf("Final signal: %s", final)
+		if outcome := workflow.RunOutcome(state); outcome != "" {
+			fmt.Printf(" (%s)", outcome)
+		}
+		fmt.Println()
+	}
+
+	if state.Status == events.RunStatusWaitingHuman {
+		if state.WaitingSessionID != "" {
+			fmt.Printf("Session: %s\n", state.WaitingSessionID)
+		}
+		if state.WaitingReason != "" {
+			fmt.Printf("Reason: %s\n", state.WaitingReason)
+		}
+		fmt.Printf("\nUse 'shop continue %d' to open the Claude session.\n", state.ID)
+	}
+
+	printPaused(state)
+
+	if state.Error != "" {
+		fmt.Printf("Error: %s\n", state.Error)
+	}
+
+	if state.Summary != "" {
+		fmt.Println("\nSummary:")
+		for _, line := range strings.Split(state.Summary, "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+
+	if len(state.Executions) > 0 {
+		fmt.Println("\nExecutions:")
+		for i, exec := range state.Executions {
+			status := string(exec.Status)
+			if exec.AgentName == "_checkpoint" {
+				fmt.Printf("  [%d] pause() at call %d [%s]: %s\n", i+1, exec.CallIndex, status, truncate(exec.Prompt, 80))
+				if exec.WaitingReason != "" && exec.WaitingReason != exec.Prompt {
+					fmt.Printf("      waited: %s\n", truncate(exec.WaitingReason, 80))
+				}
+				continue
+			}
+			fmt.Printf("  [%d] %s [%s]", i+1, exec.AgentName, status)
+			if exec.ChildRunID != 0 {
+				fmt.Printf(" → run #%d", exec.ChildRunID)
+			}
+			if exec.Attempt > 1 {
+				fmt.Printf(" (attempt %d)", exec.Attempt)
+			}
+			if exec.SkippedPermissions {
+				fmt.Print(" (skipped permissions)")
+			}
+			if exec.SignalReminders > 0 {
+				fmt.Print(" (reminded to signal)")
+			}
+			fmt.Println()
+			if r := exec.Result; r != nil {
+				fmt.Printf("      %d turns", r.NumTurns)
+				if r.StopReason != "" {
+					fmt.Printf(", stop: %s", r.StopReason)
+				}
+				if r.Result != "" {
+					fmt.Printf(": %s", truncate(strings.Join(strings.Fields(r.Result), " "), 80))
+				}
+				fmt.Println()
+			}
+			if exec.WaitingReason != "" {
+				fmt.Printf("      waited: %s\n", truncate(exec.WaitingReason, 80))
+			}
+		}
+	}
+
+	if len(state.LogMessages) > 0 {
+		fmt.Println("\nLog:")
+		logs := state.LogMessages
+		if len(logs) > statusLogLines {
+			fmt.Printf("  (%d earlier lines; see shop logs %d)\n", len(logs)-statusLogLines, state.ID)
+			logs = logs[len(logs)-statusLogLines:]
+		}
+		for _, l := range logs {
+			fmt.Printf("  %s %s\n", l.CreatedAt.Local().Format("15:04:05"), l.Message)
+		}
+	}
+}
+
+// statusLogLines is how many of the latest log() lines shop status shows.
+const statusLogLines = 10
+
+func newListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recent runs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			active, _ := cmd.Flags().GetBool("active")
+			output, _ := cmd.Flags().GetString("output")
+			if output != "table" && output != "json" && output != "csv" {
+				return fmt.Errorf("invalid --output %q: use table, json or csv", output)
+			}
+
+			runs, err := store.ListRunsWithSummary(20)
+			if err != nil {
+				return err
+			}
+
+			var entries []events.RunSummary
+			for _, state := range runs {
+				if active {
+					if state.Status != events.RunStatusRunning &&
+						!state.Status.IsSuspended() &&
+						state.Status != events.RunStatusPending {
+						continue
+					}
+				}
+				// Skip deleted runs
+				if state.Status == events.RunStatusDeleted {
+					continue
+				}
+
+				entries = append(entries, state)
+			}
+
+			switch output {
+			case "json":
+				return printRunsJSON(entries)
+			case "csv":
+				return printRunsCSV(entries)
+			}
+
+			if len(entries) == 0 {
+				if active {
+					fmt.Println("No active runs found.")
+				} else {
+					fmt.Println("No runs found.")
+				}
+				return nil
+			}
+
+			fmt.Printf("%-4s %-15s %-14s %-4s %-12s %s\n", "ID", "WORKFLOW", "STATUS", "PRI", "AGENT", "WAITING FOR")
+
+			depths := make([]int, len(entries))
+			if tree, _ := cmd.Flags().GetBool("tree"); tree {
+				entries, depths = runTree(entries)
+			}
+			for i, s := range entries {
+				name := s.WorkflowName
+				if depths[i] > 0 {
+					name = strings.Repeat("  ", depths[i]-1) + "↳ " + name
+				}
+				agent := s.CurrentAgent
+				if agent == "" {
+					agent = "-"
+				}
+
+				waitingFor := "-"
+				if s.Status == events.RunStatusWaitingHuman && s.WaitingReason != "" {
+					waitingFor = truncate(s.WaitingReason, 40)
+				}
+
+				fmt.Printf("%-4d %-15s %-14s %-4d %-12s %s\n",
+					s.ID, truncate(name, 15), string(s.Status), s.Priority, truncate(agent, 12), waitingFor)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("active", false, "Show only active runs (exclude completed/failed)")
+	cmd.Flags().Bool("tree", false, "Indent child runs (from run_workflow()) under their parents")
+	cmd.Flags().StringP("output", "o", "table", "Output format: table, json or csv")
+	return cmd
+}
+
+func newWaitingCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "waiting",
+		Short: "List every run waiting for human input",
+		Long: `List all runs waiting for a human, longest waiting first, with the agent
+that asked, its question, how long it has waited and the command that
+answers it. Unlike list --active, runs that are running or paused are left
+out, and older runs are not cut off.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			runs, err := store.ListWaitingRuns()
+			if err != nil {
+				return err
+			}
+			if len(runs) == 0 {
+				fmt.Println("No runs are waiting for input.")
+				return nil
+			}
+
+			for i, s := range runs {
+				if i > 0 {
+					fmt.Println()
+				}
+				agent := s.CurrentAgent
+				if agent == "_checkpoint" {
+					agent = "checkpoint"
+				}
+				fmt.Printf("#%d %s  %s", s.ID, s.WorkflowName, agent)
+				if s.WaitingSince != nil {
+					fmt.Printf("  waiting %s (since %s)", time.Since(*s.WaitingSince).Round(time.Minute), s.WaitingSince.Local().Format("Jan 2 15:04"))
+				}
+				fmt.Println()
+				if s.WaitingReason != "" {
+					fmt.Printf("  %s\n", truncate(strings.Join(strings.Fields(s.WaitingReason), " "), 100))
+				}
+				fmt.Printf("  → shop continue %d   (or -m \"answer\")\n", s.ID)
+			}
+			return nil
+		},
+	}
+}
+
+// runTree reorders runs so each listed run's children follow it, returning
+// each run's depth: 0 for runs whose parent isn't in the list.
+func runTree(runs []events.RunSummary) ([]events.RunSummary, []int) {
+	listed := map[int64]bool{}
+	children := map[int64][]events.RunSummary{}
+	for _, s := range runs {
+		listed[s.ID] = true
+	}
+	var roots []events.RunSummary
+	for _, s := range runs {
+		if s.ParentRunID != 0 && listed[s.ParentRunID] {
+			children[s.ParentRunID] = append(children[s.ParentRunID], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+
+	var ordered []events.RunSummary
+	var depths []int
+	var add func(s events.RunSummary, depth int)
+	add = func(s events.RunSummary, depth int) {
+		ordered = append(ordered, s)
+		depths = append(depths, depth)
+		for _, kid := range children[s.ID] {
+			add(kid, depth+1)
+		}
+	}
+	for _, s := range roots {
+		add(s, 0)
+	}
+	return ordered, depths
+}
+
+// printRunsCSV writes runs as CSV with a header row, for spreadsheets.
+// Times are RFC 3339; completed and duration are empty for unfinished runs.
+func printRunsCSV(runs []events.RunSummary) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"id", "workflow", "status", "prompt", "created", "completed", "duration_seconds", "executions", "final_signal_status", "reason"})
+	for _, s := range runs {
+		var completed, duration string
+		if s.FinishedAt != nil {
+			completed = s.FinishedAt.Format(time.RFC3339)
+			if !s.StartedAt.IsZero() {
+				duration = strconv.Itoa(int(s.FinishedAt.Sub(s.StartedAt).Seconds()))
+			}
+		}
+		reason := s.Error
+		if reason == "" {
+			reason = s.WaitingReason
+		}
+		w.Write([]string{
+			strconv.FormatInt(s.ID, 10),
+			s.WorkflowName,
+			string(s.Status),
+			s.InitialPrompt,
+			s.CreatedAt.Format(time.RFC3339),
+			completed,
+			duration,
+			strconv.Itoa(s.ExecutionCount),
+			s.FinalStatus(),
+			reason,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+type runListEntry struct {
+	ID            int64             `json:"id"`
+	WorkflowName  string            `json:"workflow_name"`
+	Status        string            `json:"status"`
+	CurrentAgent  string            `json:"current_agent,omitempty"`
+	WaitingReason string            `json:"waiting_reason,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Executions    executionSummary  `json:"executions"`
+	FinalStatus   string            `json:"final_status,omitempty"`
+	Outcome       string            `json:"outcome,omitempty"`
+	ParentRunID   int64             `json:"parent_run_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Priority      int               `json:"priority,omitempty"`
+}
+
+type executionSummary struct {
+	Total      int    `json:"total"`
+	Failed     int    `json:"failed"`
+	LastAgent  string `json:"last_agent,omitempty"`
+	LastStatus string `json:"last_status,omitempty"`
+}
+
+// printRunsJSON writes runs as a JSON array for scripts and dashboards.
+func printRunsJSON(runs []events.RunSummary) error {
+	entries := []runListEntry{}
+	for _, s := range runs {
+		entries = append(entries, runListEntry{
+			ID:            s.ID,
+			WorkflowName:  s.WorkflowName,
+			Status:        string(s.Status),
+			CurrentAgent:  s.CurrentAgent,
+			WaitingReason: s.WaitingReason,
+			CreatedAt:     s.CreatedAt,
+			Executions: executionSummary{
+				Total:      s.ExecutionCount,
+				Failed:     s.FailedCount,
+				LastAgent:  s.LastAgent,
+				LastStatus: string(s.LastStatus),
+			},
+			FinalStatus: s.FinalStatus(),
+			Outcome:     workflow.RunOutcome(s.RunState),
+			ParentRunID: s.ParentRunID,
+			Metadata:    s.Metadata,
+			Priority:    s.Priority,
+		})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// warnMissingAgents prints a warning for each agent the workflow runs by
+// literal name that has no Claude agent definition.
+func warnMissingAgents(script, repoPath string) {
+	for _, name := range agents.Missing(agents.Dirs(repoPath), script) {
+		fmt.Fprintf(os.Stderr, "Warning: workflow runs agent %q but no Claude agent definition was found (see 'shop agent-def', or 'shop scaffold-agents' to create one)\n", name)
+	}
+}
+
+// warnDataDirInRepo warns when shop's data directory, and so every
+// workspace, lies inside the source repo, where git and agents see it.
+func warnDataDirInRepo(cfg *config.Config, repoPath string) {
+	if repoPath != "" && workspace.Within(repoPath, cfg.DataDir) {
+		fmt.Fprintf(os.Stderr, "Warning: shop's data directory %s is inside %s; workspaces there are nested in the repo (set SHOP_DATA_DIR to move it)\n", cfg.DataDir, repoPath)
+	}
+}
+
+// warnSkipPermissions notes, once per run, that the workflow's agents will
+// run with --dangerously-skip-permissions.
+func warnSkipPermissions(script string) {
+	settings, err := workflow.LoadSettings(script)
+	if err != nil || !settings.SkipsPermissions() {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Warning: agents run with --dangerously-skip-permissions (set skip_permissions: false in the workflow's settings to keep permission prompts)")
+}
+
+func newAgentDefCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent-def [name]",
+		Short: "Show the Claude agent definition shop will use for an agent",
+		Long: `Print the Claude agent definition that 'claude --agent <name>' resolves,
+from .claude/agents in the repo or ~/.claude/agents. Without a name, list
+the available definitions. With --workflow, check the agents a workflow runs.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeAgents,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, _ := cmd.Flags().GetString("repo")
+			workflowName, _ := cmd.Flags().GetString("workflow")
+			dirs := agents.Dirs(repoPath)
+
+			if workflowName != "" {
+				cfg, err := config.New()
+				if err != nil {
+					return err
+				}
+				workflowPath := findWorkflow(workflowName, cfg)
+				if workflowPath == "" {
+					return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+				}
+				script, err := os.ReadFile(workflowPath)
+				if err != nil {
+					return err
+				}
+				names := agents.Referenced(string(script))
+				if len(names) == 0 {
+					fmt.Println("No agents named literally in run() calls.")
+					return nil
+				}
+				missing := 0
+				for _, name := range names {
+					if def, err := agents.Find(dirs, name); err == nil {
+						fmt.Printf("%-20s %-8s %s\n", name, def.Source, def.Path)
+					} else {
+						fmt.Printf("%-20s %-8s %s\n", name, "MISSING", "-")
+						missing++
+					}
+				}
+				if missing > 0 {
+					return fmt.Errorf("%d agent(s) have no Claude agent definition", missing)
+				}
+				return nil
+			}
+
+			if len(args) == 0 {
+				defs := agents.List(dirs)
+				if len(defs) == 0 {
+					fmt.Println("No Claude agent definitions found.")
+					return nil
+				}
+				fmt.Printf("%-20s %-8s %s\n", "NAME", "SOURCE", "PATH")
+				for _, def := range defs {
+					fmt.Printf("%-20s %-8s %s\n", def.Name, def.Source, def.Path)
+				}
+				return nil
+			}
+
+			def, err := agents.Find(dirs, args[0])
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(def.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s (%s)\n\n", def.Path, def.Source)
+			fmt.Print(string(content))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("repo", "r", ".", "Repository whose .claude/agents to search")
+	cmd.Flags().String("workflow", "", "Check every agent this workflow runs by name")
+	cmd.RegisterFlagCompletionFunc("workflow", completeWorkflows)
+	return cmd
+}
+
+func newScaffoldAgentsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scaffold-agents <workflow>",
+		Short: "Write starter Claude agent definitions for a workflow's missing agents",
+		Long: `For each agent the workflow runs by name that has no Claude agent
+definition, write a stub to the repo's .claude/agents/<name>.md describing
+how to report its outcome with the statuses the workflow declares for it.
+Existing definitions are never overwritten.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeWorkflows,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, _ := cmd.Flags().GetString("repo")
+
+			cfg, err := config.New()
+			if err != nil {
+				return err
+			}
+			path := findWorkflow(args[0], cfg)
+			if path == "" {
+				return fmt.Errorf("workflow %q not found (looked in %s and %s)", args[0], cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+			}
+			script, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			dirs := agents.Dirs(repoPath)
+			created, err := agents.Scaffold(dirs, dirs[0].Path, string(script))
+			for _, file := range created {
+				fmt.Printf("Created %s\n", file)
+			}
+			if err != nil {
+				return err
+			}
+			if len(created) == 0 {
+				fmt.Println("Every agent the workflow runs by name already has a definition.")
+				return nil
+			}
+			fmt.Println("\nFill in the TODOs before running the workflow.")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("repo", "r", ".", "Repository whose .claude/agents to write to")
+	return cmd
+}
+
+func newWorkflowsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "workflows",
+		Short: "List available workflows",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.New()
+			if err != nil {
+				return err
+			}
+
+			workflows, err := cfg.ListWorkflows()
+			if err != nil {
+				return err
+			}
+			if len(workflows) == 0 {
+				fmt.Printf("No workflows found in %s or %s.\n", cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+				return nil
+			}
+
+			fmt.Printf("%-24s %-8s %s\n", "NAME", "SOURCE", "DESCRIPTION")
+			for _, wf := range workflows {
+				desc := wf.Description
+				if desc == "" {
+					desc = "-"
+				}
+				fmt.Printf("%-24s %-8s %s\n", truncate(wf.Name, 24), wf.Source, truncate(desc, 60))
+			}
+			return nil
+		},
+	}
+}
+
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate <workflow>",
+		Short: "Check that a workflow script loads and defines workflow()",
+		Long: `Load a workflow script without running any agents and report errors that
+would stop it running. With --lint, also warn about scripts that load but
+are probably wrong: statuses checked for that no run() declares, declared
+statuses never checked, unknown settings, suspicious limits, and agents
+with no Claude agent definition.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeWorkflows,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			lint, _ := cmd.Flags().GetBool("lint")
+			repoPath, _ := cmd.Flags().GetString("repo")
+
+			cfg, err := config.New()
+			if err != nil {
+				return err
+			}
+			path := findWorkflow(args[0], cfg)
+			if path == "" {
+				return fmt.Errorf("workflow %q not found (looked in %s and %s)", args[0], cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+			}
+			script, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			if err := workflow.Validate(string(script)); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if !lint {
+				fmt.Printf("%s: ok\n", path)
+				return nil
+			}
+
+			warnings := workflow.Lint(string(script))
+			for _, name := range agents.Missing(agents.Dirs(repoPath), string(script)) {
+				warnings = append(warnings, fmt.Sprintf("agent %q has no Claude agent definition (see 'shop agent-def')", name))
+			}
+			if len(warnings) == 0 {
+				fmt.Printf("%s: ok, no warnings\n", path)
+				return nil
+			}
+			for _, w := range warnings {
+				fmt.Printf("%s: warning: %s\n", path, w)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("lint", false, "Also warn about likely mistakes")
+	cmd.Flags().StringP("repo", "r", ".", "Repository whose .claude/agents to check agent definitions against")
+	return cmd
+}
+
+func newWorkflowInfoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "workflow-info <workflow>",
+		Aliases: []string{"spec-info"},
+		Short:   "Describe a workflow: its settings and the agents it runs",
+		Long: `Show a workflow's description, its settings (with defaults filled in) and the
+agents it runs by name, with the Claude agent definition each resolves to.
+Workflows are imperative scripts, so agents chosen at runtime and the order
+and conditions of calls are not shown; read the script for those.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeWorkflows,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, _ := cmd.Flags().GetString("repo")
+
+			cfg, err := config.New()
+			if err != nil {
+				return err
+			}
+			path := findWorkflow(args[0], cfg)
+			if path == "" {
+				return fmt.Errorf("workflow %q not found (looked in %s and %s)", args[0], cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+			}
+			script, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Workflow: %s\n", strings.TrimSuffix(filepath.Base(path), ".js"))
+			fmt.Printf("Path: %s\n", path)
+			if desc := config.ReadDescription(path); desc != "" {
+				fmt.Printf("Description: %s\n", desc)
+			}
+
+			fmt.Println("\nSettings:")
+			settings, err := workflow.LoadSettings(string(script))
+			if err != nil {
+				fmt.Printf("  (could not be read: %v)\n", err)
+			} else {
+				printWorkflowSettings(settings)
+			}
+
+			fmt.Println("\nAgents (run by name, in order of first use):")
+			dirs := agents.Dirs(repoPath)
+			names := agents.Referenced(string(script))
+			if settings.Finally != "" {
+				names = append(names, settings.Finally)
+			}
+			if settings.CheckpointAgent != "" {
+				names = append(names, settings.CheckpointAgent)
+			}
+			if len(names) == 0 {
+				fmt.Println("  none found; agents may be chosen at runtime")
+			}
+			for _, name := range names {
+				if def, err := agents.Find(dirs, name); err == nil {
+					fmt.Printf("  %-20s %s (%s)\n", name, def.Path, def.Source)
+				} else {
+					fmt.Printf("  %-20s no agent definition found\n", name)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("repo", "r", ".", "Repository whose .claude/agents to resolve agents against")
+	return cmd
+}
+
+// printWorkflowSettings lists a workflow's settings, marking defaults.
+func printWorkflowSettings(s workflow.Settings) {
+	finally := s.Finally
+	if finally == "" {
+		finally = "(none)"
+	}
+	fmt.Printf("  finally:                %s\n", finally)
+
+	if s.ContextTemplate == "" {
+		fmt.Println("  context_template:       (default)")
+	} else {
+		fmt.Printf("  context_template:       %s\n", truncate(strings.Join(strings.Fields(s.ContextTemplate), " "), 60))
+	}
+
+	checkpointAgent := s.CheckpointAgent
+	if checkpointAgent == "" {
+		checkpointAgent = "(none)"
+	}
+	fmt.Printf("  checkpoint_agent:       %s\n", checkpointAgent)
+	if s.CheckpointPrompt == "" {
+		fmt.Println("  checkpoint_prompt:      (default)")
+	} else {
+		fmt.Printf("  checkpoint_prompt:      %s\n", truncate(strings.Join(strings.Fields(s.CheckpointPrompt), " "), 60))
+	}
+
+	if s.SkillFile == "" {
+		fmt.Println("  skill_file:             (none)")
+	} else {
+		mode := s.SkillMode
+		if mode == "" {
+			mode = "extend"
+		}
+		fmt.Printf("  skill_file:             %s (%s)\n", s.SkillFile, mode)
+	}
+
+	if s.CleanupCommand == "" {
+		fmt.Println("  cleanup_command:        (none)")
+	} else {
+		fmt.Printf("  cleanup_command:        %s\n", truncate(s.CleanupCommand, 60))
+	}
+	fmt.Printf("  cleanup_on_success:     %v\n", s.CleanupOnSuccess)
+
+	contextChars, contextKeep := s.ContextLimits()
+	note := ""
+	if s.MaxContextChars <= 0 && s.ContextKeep <= 0 {
+		note = " (default)"
+	}
+	fmt.Printf("  max_context_chars:      %d, keeping the last %d in full%s\n", contextChars, contextKeep, note)
+
+	pauses, note := s.MaxConsecutivePauses, ""
+	if pauses <= 0 {
+		pauses, note = workflow.DefaultMaxConsecutivePauses, " (default)"
+	}
+	fmt.Printf("  max_consecutive_pauses: %d%s\n", pauses, note)
+
+	chars, note := s.MaxPromptChars, ""
+	if chars <= 0 {
+		chars, note = workflow.DefaultMaxPromptChars, " (default)"
+	}
+	fmt.Printf("  max_prompt_chars:       %d%s\n", chars, note)
+
+	skip := "true"
+	if s.SkipPermissions == nil {
+		skip += " (default)"
+	} else if !*s.SkipPermissions {
+		skip = "false"
+	}
+	fmt.Printf("  skip_permissions:       %s\n", skip)
+
+	for _, list := range []struct {
+		name     string
+		statuses []string
+	}{{"success_statuses", s.SuccessStatuses}, {"failure_statuses", s.FailureStatuses}} {
+		value := "(none)"
+		if len(list.statuses) > 0 {
+			value = strings.Join(list.statuses, ", ")
+		}
+		fmt.Printf("  %-22s %s\n", list.name+":", value)
+	}
+	fmt.Printf("  reuse_workspace:        %v\n", s.ReuseWorkspace)
+}
+
+func newKillCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kill <run-id>",
+		Short: "Kill a run that hasn't finished",
+		Long: `Kill a running, waiting, paused or pending run. The running agent (if any)
+is terminated, unfinished executions are marked failed, and the run ends
+with status killed.
+
+With --workflow instead of a run ID, kill every unfinished run of that
+workflow; --yes is required to go ahead.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeRunIDs(activeRun),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reason, _ := cmd.Flags().GetString("reason")
+			workflowName, _ := cmd.Flags().GetString("workflow")
+			yes, _ := cmd.Flags().GetBool("yes")
+			if (len(args) == 1) == (workflowName != "") {
+				return fmt.Errorf("specify a run ID or --workflow")
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			if workflowName == "" {
+				runID, err := strconv.ParseInt(args[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid run ID: %w", err)
+				}
+				if err := proc.KillRun(runID, reason); err != nil {
+					return err
+				}
+				fmt.Printf("Killed run #%d\n", runID)
+				return nil
+			}
+
+			runs, err := store.ListRunsByWorkflow(workflowName)
+			if err != nil {
+				return err
+			}
+			var active []*events.RunState
+			for _, state := range runs {
+				if activeRun(state) {
+					active = append(active, state)
+				}
+			}
+			if len(active) == 0 {
+				fmt.Printf("No unfinished runs of %q.\n", workflowName)
+				return nil
+			}
+			for _, state := range active {
+				fmt.Printf("  #%-4d %-14s %s\n", state.ID, state.Status, truncate(state.InitialPrompt, 60))
+			}
+			if !yes {
+				return fmt.Errorf("this would kill %d runs of %q; pass --yes to kill them", len(active), workflowName)
+			}
+
+			killed := 0
+			for _, state := range active {
+				if err := proc.KillRun(state.ID, reason); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					continue
+				}
+				killed++
+			}
+			fmt.Printf("Killed %d of %d runs of %q\n", killed, len(active), workflowName)
+			if killed < len(active) {
+				return fmt.Errorf("%d runs could not be killed", len(active)-killed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("reason", "", "Reason for killing the run")
+	cmd.Flags().String("workflow", "", "Kill every unfinished run of this workflow instead of one run")
+	cmd.Flags().Bool("yes", false, "With --workflow, kill without asking to confirm")
+	cmd.RegisterFlagCompletionFunc("workflow", completeWorkflows)
+	return cmd
+}
+
+func newDeleteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "delete <run-id>",
+		Short:             "Delete a run and its workspace",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			children, _ := cmd.Flags().GetBool("children")
+			if !children {
+				if kids, err := store.ListChildRuns(runID); err == nil && len(kids) > 0 {
+					fmt.Fprintf(os.Stderr, "Note: run #%d has %d child run(s), which are kept (--children deletes them too)\n", runID, len(kids))
+				}
+			}
+			if err := proc.DeleteRun(runID, children); err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted run #%d\n", runID)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("children", false, "Also delete the runs it spawned with run_workflow(), recursively")
+	return cmd
+}
+
+func newPruneBranchesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune-branches",
+		Short: "Delete run branches that are merged or whose runs were deleted",
+		Long: `Look through the repo's run branches (shop/<instance>/run-N, and
+shop/run-N from before instance IDs) and delete those whose commits are
+already merged into the default branch (origin's HEAD, else main or
+master; see --into), as found by git.
+
+A branch whose run was deleted, or is unknown to this install, is kept if
+it has unmerged commits unless --force is given. Branches checked out in a
+worktree and those of unfinished runs are always kept, and so are unmerged
+shop/run-N branches, even with --force: another install may have made them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, _ := cmd.Flags().GetString("repo")
+			into, _ := cmd.Flags().GetString("into")
+			force, _ := cmd.Flags().GetBool("force")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			if !dryRun {
+				unlock, err := store.LockMaintenance("shop prune-branches")
+				if err != nil {
+					return err
+				}
+				defer unlock()
+			}
+
+			if repoPath, err = resolveRepo(cfg, repoPath, false); err != nil {
+				return err
+			}
+			if into == "" {
+				into = workspace.DefaultBranch(repoPath)
+			}
+			branches, err := workspace.RunBranches(repoPath, cfg.InstanceID, into)
+			if err != nil {
+				return err
+			}
+			plan, err := commands.PlanBranchPrune(store, branches, force)
+			if err != nil {
+				return err
+			}
+			if len(plan) == 0 {
+				fmt.Println("No run branches found.")
+				return nil
+			}
+
+			deleted := 0
+			for _, p := range plan {
+				action := "keep"
+				if p.Delete {
+					action = "delete"
+					if !dryRun {
+						if err := workspace.DeleteBranch(repoPath, p.Name); err != nil {
+							fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+							continue
+						}
+						action = "deleted"
+					}
+					deleted++
+				}
+				fmt.Printf("%-8s %-24s %s\n", action, p.Name, p.Reason)
+			}
+			if dryRun {
+				fmt.Printf("\n%d of %d branch(es) would be deleted\n", deleted, len(plan))
+			} else {
+				fmt.Printf("\nDeleted %d of %d branch(es)\n", deleted, len(plan))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("repo", "r", ".", "Source git repository whose branches to prune (default: current directory)")
+	cmd.Flags().String("into", "", "Branch to check merges against (default: origin's HEAD, main or master)")
+	cmd.Flags().Bool("force", false, "Also delete unmerged branches of deleted runs")
+	cmd.Flags().Bool("dry-run", false, "Show what would be deleted without deleting")
+	return cmd
+}
+
+func newContinueCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "continue <run-id>",
+		Short:             "Open Claude session for a waiting run",
+		Long:              "Resume interaction with an agent that needs human input, or hand the work off to a different agent with --handoff",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(waitingRun),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+
+			if readOnly, _ := cmd.Flags().GetBool("read-only"); readOnly {
+				if cmd.Flags().Changed("handoff") || cmd.Flags().Changed("message") || cmd.Flags().Changed("input-file") {
+					return fmt.Errorf("--read-only can't be combined with --handoff, --message or --input-file")
+				}
+				return inspectSession(state)
+			}
+
+			if state.Status != events.RunStatusWaitingHuman {
+				return fmt.Errorf("run %d is not waiting for human input (status: %s)", runID, state.Status)
+			}
+
+			if agent, _ := cmd.Flags().GetString("handoff"); agent != "" {
+				if err := commands.ValidateAgent(state, agent); err != nil {
+					return err
+				}
+
+				pm := newManager(cfg)
+				proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+				handoffCmd, err := commands.NewCommand(runID, commands.CmdHandoffRun, commands.HandoffRunPayload{Agent: agent})
+				if err != nil {
+					return err
+				}
+				if err := proc.SubmitCommand(handoffCmd); err != nil {
+					return err
+				}
+
+				fmt.Printf("Handing run %d off to %s...\n", runID, agent)
+				<-proc.ProcessRunSync(runID)
+
+				state, err = store.ProjectRunFromDB(runID)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Run %d: %s\n", runID, state.Status)
+				return nil
+			}
+
+			pm := newManager(cfg)
+			session, err := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID).ContinueRun(runID)
+			if err != nil {
+				return err
+			}
+
+			message, _ := cmd.Flags().GetString("message")
+			if inputFile, _ := cmd.Flags().GetString("input-file"); inputFile != "" {
+				if message != "" {
+					return fmt.Errorf("specify only one of --message, --input-file")
+				}
+				data, err := os.ReadFile(inputFile)
+				if err != nil {
+					return fmt.Errorf("read input file: %w", err)
+				}
+				message = string(data)
+			}
+
+			if session.Fresh() {
+				fmt.Printf("No Claude session was recorded for %s; starting a fresh one seeded with its question and the run's context.\n", session.Agent)
+			}
+			if message != "" {
+				return continueNonInteractive(cfg, store, state, session, message)
+			}
+			if !isatty.IsTerminal(os.Stdin.Fd()) {
+				return fmt.Errorf("stdin is not a terminal; answer non-interactively with --message or --input-file")
+			}
+
+			fmt.Printf("Opening Claude session for: %s (answering; the workflow resumes once it reports a new signal)\n", session.Agent)
+			fmt.Printf("Reason: %s\n\n", state.WaitingReason)
+
+			claudeCmd := exec.Command("claude", session.Args()...)
+			claudeCmd.Dir = session.WorkDir
+			claudeCmd.Stdin = os.Stdin
+			claudeCmd.Stdout = os.Stdout
+			claudeCmd.Stderr = os.Stderr
+
+			if err := claudeCmd.Run(); err != nil {
+				return fmt.Errorf("claude session failed: %w", err)
+			}
+
+			fmt.Println("\nClaude session ended.")
+			fmt.Println("Run 'shop resume " + args[0] + "' to continue the workflow.")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("handoff", "", "Abandon the waiting agent and re-run its step with this agent instead")
+	cmd.Flags().StringP("message", "m", "", "Answer the waiting agent non-interactively with this message")
+	cmd.Flags().String("input-file", "", "Answer the waiting agent non-interactively with the contents of this file")
+	cmd.Flags().Bool("read-only", false, "Look at the agent's session without answering it; the run is left as it is")
+	return cmd
+}
+
+// inspectSession opens a fork of the waiting agent's session, or of the last
+// agent's if the run isn't waiting, for looking around. The fork keeps the
+// original session untouched and nothing is submitted to the run afterwards.
+func inspectSession(state *events.RunState) error {
+	sessionID, agent := state.WaitingSessionID, state.CurrentAgent
+	if waiting := state.WaitingExecution(); waiting != nil {
+		agent = waiting.AgentName
+	}
+	if sessionID == "" {
+		for i := len(state.Executions) - 1; i >= 0; i-- {
+			if e := state.Executions[i]; e.SessionID != "" {
+				sessionID, agent = e.SessionID, e.AgentName
+				break
+			}
+		}
+	}
+	if sessionID == "" {
+		return fmt.Errorf("run %d has no Claude session to open", state.ID)
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("stdin is not a terminal; --read-only opens an interactive session")
+	}
+
+	fmt.Printf("Opening Claude session for: %s (read-only: a fork of the session; run %d stays %s)\n\n", agent, state.ID, state.Status)
+
+	claudeCmd := exec.Command("claude", "--resume", sessionID, "--fork-session")
+	claudeCmd.Dir = filepath.Join(state.WorkspacePath, "repo")
+	claudeCmd.Stdin = os.Stdin
+	claudeCmd.Stdout = os.Stdout
+	claudeCmd.Stderr = os.Stderr
+	if err := claudeCmd.Run(); err != nil {
+		return fmt.Errorf("claude session failed: %w", err)
+	}
+
+	fmt.Printf("\nClaude session ended. Run %d was not changed.\n", state.ID)
+	return nil
+}
+
+// continueNonInteractive sends message to the waiting agent's session with
+// `claude -p`, then resumes the workflow if the agent reported a new signal.
+func continueNonInteractive(cfg *config.Config, store *events.Store, state *events.RunState, session *commands.ContinueSession, message string) error {
+	args, err := session.HeadlessArgs(message)
+	if err != nil {
+		return fmt.Errorf("run %d: %w", state.ID, err)
+	}
+	claudeCmd := exec.Command("claude", args...)
+	claudeCmd.Dir = session.WorkDir
+	claudeCmd.Stdout = os.Stdout
+	claudeCmd.Stderr = os.Stderr
+
+	fmt.Printf("Answering %s for run %d...\n", session.Agent, state.ID)
+	if err := claudeCmd.Run(); err != nil {
+		return fmt.Errorf("claude session failed: %w", err)
+	}
+
+	pm := newManager(cfg)
+	proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+	done, resumed, err := proc.ResumeAfterHumanSync(state.ID)
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		fmt.Printf("\nThe agent is still waiting. Run 'shop continue %d' again to answer.\n", state.ID)
+		return nil
+	}
+
+	<-done
+	final, err := store.ProjectRunFromDB(state.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nRun %d: %s\n", final.ID, final.Status)
+	return nil
+}
+
+func newStopCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "stop <run-id>",
+		Short:             "Stop a waiting run",
+		Long:              "Mark a waiting run as stuck and stop waiting for human input",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(waitingRun),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			reason, _ := cmd.Flags().GetString("reason")
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			stopCmd, err := commands.NewCommand(runID, commands.CmdStopRun, commands.StopRunPayload{Reason: reason})
+			if err != nil {
+				return err
+			}
+
+			if err := proc.SubmitCommand(stopCmd); err != nil {
+				return err
+			}
+
+			done := proc.ProcessRunSync(runID)
+			<-done
+
+			if reason != "" {
+				fmt.Printf("Run %d marked as stuck: %s\n", runID, reason)
+			} else {
+				fmt.Printf("Run %d marked as stuck\n", runID)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("reason", "", "Reason for stopping the run")
+	return cmd
+}
+
+func newPauseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pause <run-id>",
+		Short: "Pause a running run before its next agent",
+		Long: `Ask a running workflow to pause once its current agent finishes, before the
+next one starts. The process executing the run picks the request up at that
+boundary and the run becomes paused; continue it with 'shop resume'.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(activeRun),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+			reason, _ := cmd.Flags().GetString("reason")
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if state.Status != events.RunStatusRunning {
+				return fmt.Errorf("run %d is not running (status: %s)", runID, state.Status)
+			}
+
+			// Only submit: the process executing the run applies the request
+			// at the next agent boundary
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+			pauseCmd, err := commands.NewCommand(runID, commands.CmdPauseRun, commands.PauseRunPayload{Reason: reason})
+			if err != nil {
+				return err
+			}
+			if err := proc.SubmitCommand(pauseCmd); err != nil {
+				return err
+			}
+
+			fmt.Printf("Run %d will pause before its next agent starts", runID)
+			if state.CurrentAgent != "" {
+				fmt.Printf(" (%s is still working)", state.CurrentAgent)
+			}
+			fmt.Printf(".\nUse 'shop status %d --watch' to follow it and 'shop resume %d' to continue.\n", runID, runID)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("reason", "", "Reason for pausing the run")
+	return cmd
+}
+
+func newMetaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "meta <run-id> [key=value...]",
+		Short: "Show or set a run's metadata",
+		Long: `Attach key/value metadata to a run, e.g. the PR or ticket it belongs to, so
+other systems can correlate it with their own records. 'key=' removes a key.
+With no pairs, print the run's metadata. A running run picks the change up
+at its next agent boundary.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+			metadata, err := parseMetadata(args[1:])
+			if err != nil {
+				return err
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if len(metadata) == 0 {
+				for _, pair := range metadataPairs(state.Metadata) {
+					fmt.Println(pair)
+				}
+				return nil
+			}
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+			metaCmd, err := commands.NewCommand(runID, commands.CmdSetRunMetadata, commands.SetRunMetadataPayload{Metadata: metadata})
+			if err != nil {
+				return err
+			}
+			if err := proc.SubmitCommand(metaCmd); err != nil {
+				return err
+			}
+			if state.Status == events.RunStatusRunning {
+				// The process executing the run applies it
+				fmt.Printf("Run %d's metadata will be updated before its next agent starts.\n", runID)
+				return nil
+			}
+
+			<-proc.ProcessRunSync(runID)
+			state, err = store.ProjectRunFromDB(runID)
+			if err != nil {
+				return err
+			}
+			for _, pair := range metadataPairs(state.Metadata) {
+				fmt.Println(pair)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// parseMetadata turns key=value arguments into a metadata map; "key="
+// maps key to "", which removes it.
+func parseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	metadata := map[string]string{}
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata %q: expected key=value", pair)
+		}
+		metadata[k] = v
+	}
+	return metadata, commands.ValidateMetadata(metadata)
+}
+
+// metadataPairs renders metadata as sorted key=value strings.
+func metadataPairs(metadata map[string]string) []string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func newResetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset <run-id>",
+		Short: "Clear a run's executions so it starts over",
+		Long: `Discard a run's executions, signals and log so that 'shop resume' runs the
+workflow again from the start. The run ID, prompt and workspace are kept;
+with --hard the worktree is also reset to where the run's branch began and
+agent scratchpads are cleared.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+			hard, _ := cmd.Flags().GetBool("hard")
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if state.Status == events.RunStatusRunning {
+				return fmt.Errorf("run %d is running; kill it before resetting", runID)
+			}
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			resetCmd, err := commands.NewCommand(runID, commands.CmdResetRun, commands.ResetRunPayload{Hard: hard})
+			if err != nil {
+				return err
+			}
+			if err := proc.SubmitCommand(resetCmd); err != nil {
+				return err
+			}
+			<-proc.ProcessRunSync(runID)
+
+			state, err = store.ProjectRunFromDB(runID)
+			if err != nil {
+				return err
+			}
+			if state.Status != events.RunStatusPending {
+				return fmt.Errorf("run %d was not reset (status: %s)", runID, state.Status)
+			}
+			fmt.Printf("Run %d reset. Use 'shop resume %d' to run it again.\n", runID, runID)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("hard", false, "Also reset the worktree to the run's starting commit and clear scratchpads")
+	return cmd
+}
+
+func newAdoptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "adopt --branch <branch>",
+		Short: "Create a run for an existing shop branch",
+		Long: `Rebuild a run around a branch left by a run whose database record was lost
+(e.g. shop/run-5). The branch's existing worktree is reused when it is still
+in a shop workspace; otherwise a new one is checked out. The earlier
+executions can't be recovered, so the run is marked stuck: use 'shop recover',
+'shop step' or 'shop reset' to carry on. Pass --workflow and --prompt to
+record what the run was doing.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, _ := cmd.Flags().GetString("repo")
+			branch, _ := cmd.Flags().GetString("branch")
+			workflowName, _ := cmd.Flags().GetString("workflow")
+			prompt, _ := cmd.Flags().GetString("prompt")
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			var workflowPath string
+			if workflowName != "" {
+				workflowPath = findWorkflow(workflowName, cfg)
+				if workflowPath == "" {
+					return fmt.Errorf("workflow %q not found (looked in %s and %s)", workflowName, cfg.ProjectWorkflowDir, cfg.UserWorkflowDir)
+				}
+			}
+
+			runID, err := store.CreateRun()
+			if err != nil {
+				return fmt.Errorf("failed to create run: %w", err)
+			}
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			adoptCmd, err := commands.NewCommand(runID, commands.CmdAdoptRun, commands.AdoptRunPayload{
+				SourceRepo:    repoPath,
+				Branch:        branch,
+				WorkflowPath:  workflowPath,
+				WorkflowName:  workflowName,
+				InitialPrompt: prompt,
+			})
+			if err != nil {
+				return err
+			}
+			if err := proc.SubmitCommand(adoptCmd); err != nil {
+				return err
+			}
+			<-proc.ProcessRunSync(runID)
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return err
+			}
+			if state.WorkspacePath == "" {
+				return fmt.Errorf("run %d could not adopt %s; check that the branch exists in %s", runID, branch, repoPath)
+			}
+			fmt.Printf("Adopted %s as run #%d (workspace %s)\n", branch, runID, state.WorkspacePath)
+			fmt.Printf("The run is stuck; use 'shop recover %d' to decide how to continue.\n", runID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("repo", "r", ".", "Source git repository the branch belongs to")
+	cmd.Flags().String("branch", "", "Branch to adopt (e.g. shop/run-5)")
+	cmd.Flags().String("workflow", "", "Workflow the run was executing")
+	cmd.Flags().String("prompt", "", "Prompt the run was started with")
+	cmd.MarkFlagRequired("branch")
+	cmd.RegisterFlagCompletionFunc("workflow", completeWorkflows)
+	return cmd
+}
+
+func newRecoverCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recover <run-id>",
+		Short: "Inspect and recover a stuck or failed run",
+		Long: `Without flags, shows the last execution of a stuck or failed run and the
+available recovery actions. With a flag, applies that action:
+
+  --retry            re-run the last execution and continue the workflow
+  --signal <json>    replace the last execution's signal and continue
+  --complete         mark the run complete as-is`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			retry, _ := cmd.Flags().GetBool("retry")
+			complete, _ := cmd.Flags().GetBool("complete")
+			signalJSON, _ := cmd.Flags().GetString("signal")
+
+			payload := commands.RecoverRunPayload{}
+			actions := 0
+			if retry {
+				payload.Action = commands.RecoverRetry
+				actions++
+			}
+			if complete {
+				payload.Action = commands.RecoverComplete
+				actions++
+			}
+			if signalJSON != "" {
+				payload.Action = commands.RecoverSignal
+				if err := json.Unmarshal([]byte(signalJSON), &payload.Signal); err != nil {
+					return fmt.Errorf("invalid --signal JSON: %w", err)
+				}
+				actions++
+			}
+			if actions > 1 {
+				return fmt.Errorf("specify only one of --retry, --signal, --complete")
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if state.Status != events.RunStatusStuck && state.Status != events.RunStatusFailed {
+				return fmt.Errorf("run %d is not stuck or failed (status: %s)", runID, state.Status)
+			}
+
+			if actions == 0 {
+				printRecoveryInfo(state)
+				return nil
+			}
+
+			return submitRecover(cfg, store, runID, payload)
+		},
+	}
+
+	cmd.Flags().Bool("retry", false, "Re-run the last execution")
+	cmd.Flags().String("signal", "", "Replace the last execution's signal (JSON object with a status)")
+	cmd.Flags().Bool("complete", false, "Mark the run complete")
+	return cmd
+}
+
+// submitRecover applies a recovery action and runs the workflow until it
+// settles again.
+func submitRecover(cfg *config.Config, store *events.Store, runID int64, payload commands.RecoverRunPayload) error {
+	pm := newManager(cfg)
+	proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+	recoverCmd, err := commands.NewCommand(runID, commands.CmdRecoverRun, payload)
+	if err != nil {
+		return err
+	}
+	if err := proc.SubmitCommand(recoverCmd); err != nil {
+		return err
+	}
+
+	fmt.Printf("Recovering run #%d (%s)\n", runID, payload.Action)
+
+	done := proc.ProcessRunSync(runID)
+	<-done
+
+	state, _ := store.ProjectRunFromDB(runID)
+	if state != nil {
+		fmt.Printf("Run completed with status: %s\n", state.Status)
+		if state.Error != "" {
+			fmt.Printf("Error: %s\n", state.Error)
+		}
+	}
+	return nil
+}
+
+func newFixSignalCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fix-signal <run-id> <agent>",
+		Short: "Supply the signal an agent failed to report and continue the run",
+		Long: `For a stuck or failed run whose last agent ended without reporting a
+signal, read a hand-written signal (a JSON object with a status) from
+--file and record it as that agent's result. The execution is marked
+complete, its signal becomes part of later agents' context, and the
+workflow continues from there.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			file, _ := cmd.Flags().GetString("file")
+			var data []byte
+			if file == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return fmt.Errorf("read signal: %w", err)
+			}
+
+			payload := commands.RecoverRunPayload{Action: commands.RecoverSignal, Agent: args[1]}
+			if err := json.Unmarshal(data, &payload.Signal); err != nil {
+				return fmt.Errorf("invalid signal JSON in %s: %w", file, err)
+			}
+
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if state.Status != events.RunStatusStuck && state.Status != events.RunStatusFailed {
+				return fmt.Errorf("run %d is not stuck or failed (status: %s)", runID, state.Status)
+			}
+
+			return submitRecover(cfg, store, runID, payload)
+		},
+	}
+
+	cmd.Flags().String("file", "", "JSON file holding the signal (- for stdin)")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func printRecoveryInfo(state *events.RunState) {
+	fmt.Printf("Run #%d: %s [%s]\n", state.ID, state.WorkflowName, state.Status)
+	if state.Error != "" {
+		fmt.Printf("Error: %s\n", state.Error)
+	}
+	if state.WaitingReason != "" {
+		fmt.Printf("Reason: %s\n", state.WaitingReason)
+	}
+
+	if last := state.LastWorkflowExecution(); last != nil {
+		fmt.Printf("\nLast execution: %s (call %d) [%s]\n", last.AgentName, last.CallIndex, last.Status)
+		if last.Signal != nil {
+			signalJSON, _ := json.MarshalIndent(last.Signal, "  ", "  ")
+			fmt.Printf("  Signal: %s\n", signalJSON)
+		}
+		if last.Error != "" {
+			fmt.Printf("  Error: %s\n", last.Error)
+		}
+	} else {
+		fmt.Println("\nNo executions recorded.")
+	}
+
+	fmt.Println("\nRecovery actions:")
+	fmt.Printf("  shop recover %d --retry                        # re-run the last execution\n", state.ID)
+	fmt.Printf("  shop recover %d --signal '{\"status\":\"DONE\"}'   # replace its signal and continue\n", state.ID)
+	fmt.Printf("  shop recover %d --complete                     # mark the run complete\n", state.ID)
+	if last := state.LastWorkflowExecution(); last != nil && last.Signal == nil && last.AgentName != "_checkpoint" {
+		fmt.Printf("  shop fix-signal %d %s --file signal.json%s# supply the signal it never reported\n",
+			state.ID, last.AgentName, strings.Repeat(" ", max(1, 15-len(last.AgentName))))
+	}
+}
+
+func newStepCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "step <run-id>",
+		Short:             "Force the next agent for a stuck or waiting run",
+		Long:              "Re-run the last step of a stuck or waiting run with a different agent, bypassing the workflow script's choice once",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
 
-			done := proc.ProcessRunSync(runID)
-			<-done
+			agent, _ := cmd.Flags().GetString("to")
 
-			fmt.Printf("Deleted run #%d\n", runID)
+			cfg, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
+			if state.Status != events.RunStatusStuck && state.Status != events.RunStatusWaitingHuman {
+				return fmt.Errorf("run %d is not stuck or waiting (status: %s)", runID, state.Status)
+			}
+			if err := commands.ValidateAgent(state, agent); err != nil {
+				return err
+			}
+
+			pm := newManager(cfg)
+			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir(), cfg.InstanceID)
+
+			stepCmd, err := commands.NewCommand(runID, commands.CmdStepRun, commands.StepRunPayload{Agent: agent})
+			if err != nil {
+				return err
+			}
+			if err := proc.SubmitCommand(stepCmd); err != nil {
+				return err
+			}
+
+			fmt.Printf("Stepping run %d to %s...\n", runID, agent)
+			<-proc.ProcessRunSync(runID)
+
+			state, err = store.ProjectRunFromDB(runID)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Run %d: %s\n", runID, state.Status)
 			return nil
 		},
 	}
+
+	cmd.Flags().String("to", "", "Agent to run in place of the last step")
+	cmd.MarkFlagRequired("to")
+	return cmd
 }
 
-func newContinueCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "continue <run-id>",
-		Short: "Open Claude session for a waiting run",
-		Long:  "Resume interaction with an agent that needs human input",
-		Args:  cobra.ExactArgs(1),
+func newExecCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec <run-id> <n>",
+		Short: "Show one execution of a run, including the exact prompt sent",
+		Long: `Show execution n of a run (the [n] numbering of 'shop status'): its agent,
+status, signal and error, the prompt the script passed to run(), and the
+full prompt Claude received with shop's context and signalling
+instructions added.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRunIDs(nil),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			runID, err := strconv.ParseInt(args[0], 10, 64)
 			if err != nil {
 				return fmt.Errorf("invalid run ID: %w", err)
 			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid execution number: %w", err)
+			}
 
 			_, store, err := openStore()
 			if err != nil {
@@ -472,83 +2686,223 @@ func newContinueCommand() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("failed to get run: %w", err)
 			}
-
-			if state.Status != events.RunStatusWaitingHuman {
-				return fmt.Errorf("run %d is not waiting for human input (status: %s)", runID, state.Status)
+			if n < 1 || n > len(state.Executions) {
+				return fmt.Errorf("run %d has %d executions; pick one from 1 to %d", runID, len(state.Executions), len(state.Executions))
 			}
+			exec := state.Executions[n-1]
 
-			if state.WaitingSessionID == "" {
-				return fmt.Errorf("run %d has no session ID to resume", runID)
+			fmt.Printf("Execution [%d] of run #%d: %s (call %d) [%s]\n", n, runID, exec.AgentName, exec.CallIndex, exec.Status)
+			if exec.Attempt > 1 {
+				fmt.Printf("Attempt: %d\n", exec.Attempt)
+			}
+			if exec.Model != "" {
+				fmt.Printf("Model: %s\n", exec.Model)
+			}
+			if exec.SessionID != "" {
+				fmt.Printf("Session: %s\n", exec.SessionID)
+			}
+			if exec.Error != "" {
+				fmt.Printf("Error: %s\n", exec.Error)
+			}
+			if exec.Signal != nil {
+				signalJSON, _ := json.MarshalIndent(exec.Signal, "", "  ")
+				fmt.Printf("Signal: %s\n", signalJSON)
 			}
 
-			workDir := filepath.Join(state.WorkspacePath, "repo")
-
-			fmt.Printf("Opening Claude session for: %s\n", state.CurrentAgent)
-			fmt.Printf("Reason: %s\n\n", state.WaitingReason)
+			fmt.Printf("\n--- prompt (from the script) ---\n%s\n", exec.Prompt)
+			if exec.SentPrompt == "" {
+				fmt.Println("\n--- sent prompt ---\n(not recorded for this execution)")
+			} else {
+				fmt.Printf("\n--- sent prompt (%d characters) ---\n%s\n", len(exec.SentPrompt), exec.SentPrompt)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
 
-			claudeCmd := exec.Command("claude", "--resume", state.WaitingSessionID)
-			claudeCmd.Dir = workDir
-			claudeCmd.Stdin = os.Stdin
-			claudeCmd.Stdout = os.Stdout
-			claudeCmd.Stderr = os.Stderr
+func newContextCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "context <run-id>",
+		Short: "Print the context agents get from get_context",
+		Long: `Print the context an agent of the run gets from the get_context tool: the
+run's context header and the signals of the agents before it. By default
+that is every agent so far; --call-index N shows what the agent at call N
+was given, from the executions before it. The output is plain markdown, so
+two runs (or two points of one run) can be compared with diff.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+			callIndex, _ := cmd.Flags().GetInt("call-index")
 
-			if err := claudeCmd.Run(); err != nil {
-				return fmt.Errorf("claude session failed: %w", err)
+			_, store, err := openStore()
+			if err != nil {
+				return err
 			}
+			defer store.Close()
 
-			fmt.Println("\nClaude session ended.")
-			fmt.Println("Run 'shop resume " + args[0] + "' to continue the workflow.")
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
 
+			if !cmd.Flags().Changed("call-index") {
+				fmt.Print(workflow.AgentContext(state, func(events.ExecutionState) bool { return true }))
+				return nil
+			}
+			if state.GetExecutionByCallIndex(callIndex) == nil {
+				return fmt.Errorf("run %d has no execution at call %d", runID, callIndex)
+			}
+			fmt.Print(workflow.ContextBefore(state, callIndex))
 			return nil
 		},
 	}
+
+	cmd.Flags().Int("call-index", 0, "Show the context the agent at this call index was given")
+	return cmd
 }
 
-func newStopCommand() *cobra.Command {
+func newLogsCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stop <run-id>",
-		Short: "Stop a waiting run",
-		Long:  "Mark a waiting run as stuck and stop waiting for human input",
-		Args:  cobra.ExactArgs(1),
+		Use:   "logs <run-id>",
+		Short: "Print a run's log",
+		Long: `Print the lines a run logged: the script's log() calls (level info) and
+the runtime's own notices (level warn). Each line is attributed to the agent
+that ran last before it. --grep keeps lines whose message matches a regular
+expression, --level warn keeps only warnings and --agent keeps one agent's
+lines; the filters combine.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			runID, err := strconv.ParseInt(args[0], 10, 64)
 			if err != nil {
 				return fmt.Errorf("invalid run ID: %w", err)
 			}
+			pattern, _ := cmd.Flags().GetString("grep")
+			level, _ := cmd.Flags().GetString("level")
+			agent, _ := cmd.Flags().GetString("agent")
 
-			reason, _ := cmd.Flags().GetString("reason")
+			filter := events.LogFilter{Level: level, Agent: agent}
+			if level != "" && level != events.LogLevelInfo && level != events.LogLevelWarn {
+				return fmt.Errorf("invalid --level %q: use info or warn", level)
+			}
+			if pattern != "" {
+				if filter.Pattern, err = regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid --grep: %w", err)
+				}
+			}
 
-			cfg, store, err := openStore()
+			_, store, err := openStore()
 			if err != nil {
 				return err
 			}
 			defer store.Close()
 
-			pm := process.NewCLIManager()
-			proc := commands.NewProcessor(store, pm, cfg.WorkspacesDir())
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
 
-			stopCmd, err := commands.NewCommand(runID, commands.CmdStopRun, commands.StopRunPayload{Reason: reason})
+			for _, e := range events.FilterLogs(state.LogMessages, filter) {
+				agent := e.Agent
+				if agent == "" {
+					agent = "-"
+				}
+				fmt.Printf("%s %-4s %-12s %s\n", e.CreatedAt.Local().Format("15:04:05"), e.Level, agent, e.Message)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("grep", "", "Only show lines whose message matches this regular expression")
+	cmd.Flags().String("level", "", "Only show lines at this level or above (info or warn)")
+	cmd.Flags().String("agent", "", "Only show lines logged after this agent ran")
+	return cmd
+}
+
+func newArtifactsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "artifacts <run-id>",
+		Short:             "List (or gather) files agents reported as artifacts",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRunIDs(nil),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := strconv.ParseInt(args[0], 10, 64)
 			if err != nil {
-				return err
+				return fmt.Errorf("invalid run ID: %w", err)
 			}
 
-			if err := proc.SubmitCommand(stopCmd); err != nil {
+			dest, _ := cmd.Flags().GetString("copy")
+
+			_, store, err := openStore()
+			if err != nil {
 				return err
 			}
+			defer store.Close()
 
-			done := proc.ProcessRunSync(runID)
-			<-done
+			state, err := store.ProjectRunFromDB(runID)
+			if err != nil {
+				return fmt.Errorf("failed to get run: %w", err)
+			}
 
-			if reason != "" {
-				fmt.Printf("Run %d marked as stuck: %s\n", runID, reason)
-			} else {
-				fmt.Printf("Run %d marked as stuck\n", runID)
+			repoPath := filepath.Join(state.WorkspacePath, "repo")
+			found := 0
+			for _, exec := range state.Executions {
+				if exec.Status == events.ExecStatusInvalidated {
+					continue
+				}
+				for _, path := range exec.Artifacts {
+					found++
+					if dest == "" {
+						fmt.Printf("[%d] %-12s %s\n", exec.CallIndex, exec.AgentName, path)
+						continue
+					}
+					// Recorded artifacts were checked, but the repo may have changed since
+					src := filepath.Join(repoPath, path)
+					if !workspace.Within(repoPath, src) {
+						return fmt.Errorf("copy %s: it now links outside the run's repo", path)
+					}
+					if err := copyFile(src, filepath.Join(dest, path)); err != nil {
+						return fmt.Errorf("copy %s: %w", path, err)
+					}
+					fmt.Printf("Copied %s\n", path)
+				}
+			}
+
+			if found == 0 {
+				fmt.Printf("Run %d has no artifacts.\n", runID)
 			}
 			return nil
 		},
 	}
 
-	cmd.Flags().String("reason", "", "Reason for stopping the run")
+	cmd.Flags().String("copy", "", "Copy every artifact into this directory, keeping repo-relative paths")
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a read-only JSON API over HTTP",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, _ := cmd.Flags().GetString("addr")
+
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			fmt.Printf("Serving shop API on %s\n", addr)
+			return api.NewServer(store).ListenAndServe(addr)
+		},
+	}
+
+	cmd.Flags().String("addr", ":8080", "Address to listen on")
 	return cmd
 }
 
@@ -561,6 +2915,7 @@ func newMCPServerCommand() *cobra.Command {
 			dbPath, _ := cmd.Flags().GetString("db")
 			runID, _ := cmd.Flags().GetInt64("run-id")
 			callIndex, _ := cmd.Flags().GetInt("call-index")
+			agent, _ := cmd.Flags().GetString("agent")
 
 			// Legacy flag migration
 			if callIndex == 0 {
@@ -574,7 +2929,7 @@ func newMCPServerCommand() *cobra.Command {
 				customStatuses = strings.Split(statusesStr, ",")
 			}
 
-			server := mcp.NewServer(dbPath, runID, callIndex, customStatuses)
+			server := mcp.NewServer(dbPath, runID, agent, callIndex, customStatuses)
 			return server.Run()
 		},
 	}
@@ -584,8 +2939,7 @@ func newMCPServerCommand() *cobra.Command {
 	cmd.Flags().Int("call-index", 0, "Call index for this agent execution")
 	cmd.Flags().String("statuses", "", "Comma-separated custom statuses for this agent call")
 	// Legacy flags
-	cmd.Flags().String("agent", "", "Agent name (legacy, ignored)")
-	cmd.Flags().MarkHidden("agent")
+	cmd.Flags().String("agent", "", "Agent this call runs; its signals are refused if the call has since moved to another agent")
 	cmd.Flags().Int64("execution-id", 0, "Execution ID (legacy, maps to call-index)")
 	cmd.Flags().MarkHidden("execution-id")
 	cmd.Flags().String("signal-dir", "", "")
@@ -595,6 +2949,245 @@ func newMCPServerCommand() *cobra.Command {
 
 // helpers
 
+// applyColorMode sets the colour profile every lipgloss style renders with.
+func applyColorMode(mode string) error {
+	switch mode {
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			lipgloss.SetColorProfile(termenv.Ascii)
+		}
+		// Otherwise lipgloss detects the terminal itself
+	case "always":
+		lipgloss.SetColorProfile(termenv.ANSI256)
+	case "never":
+		lipgloss.SetColorProfile(termenv.Ascii)
+	default:
+		return fmt.Errorf("invalid --color %q: use auto, always, or never", mode)
+	}
+	return nil
+}
+
+func newVacuumCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vacuum",
+		Short: "Shrink the shop database",
+		Long: `Rebuild shop.db with SQLite VACUUM and report the space reclaimed.
+With --prune-signals, signals of complete, killed and deleted runs older
+than the given age are first cut down to their status, summary and reason;
+stuck and failed runs keep theirs, since they can still be resumed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pruneAge, _ := cmd.Flags().GetDuration("prune-signals")
+
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			unlock, err := store.LockMaintenance("shop vacuum")
+			if err != nil {
+				return err
+			}
+			defer unlock()
+
+			if pruneAge > 0 {
+				n, err := store.CompactSignals(time.Now().Add(-pruneAge))
+				if err != nil {
+					return fmt.Errorf("compact signals: %w", err)
+				}
+				fmt.Printf("Compacted %d signal(s) older than %s\n", n, pruneAge)
+			}
+
+			reclaimed, err := store.Vacuum()
+			if err != nil {
+				return fmt.Errorf("vacuum: %w", err)
+			}
+			fmt.Printf("Reclaimed %s\n", formatBytes(reclaimed))
+			return nil
+		},
+	}
+
+	cmd.Flags().Duration("prune-signals", 0, "Compact signals of finished runs older than this (e.g. 720h)")
+	return cmd
+}
+
+func newRemindersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reminders",
+		Short: "Show which agents had to be reminded to report a signal",
+		Long: `An agent that finishes without calling report_signal is resumed once with
+a reminder before it fails. List, per agent, how often that happened across
+recent runs: agents near the top need clearer instructions to report.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, _ := cmd.Flags().GetInt("limit")
+
+			_, store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			runs, err := store.ListRunIDs(limit)
+			if err != nil {
+				return err
+			}
+
+			type agentReminders struct {
+				name                         string
+				executions, reminded, failed int
+			}
+			byAgent := map[string]*agentReminders{}
+			for _, run := range runs {
+				state, err := store.ProjectRunFromDB(run.ID)
+				if err != nil {
+					return err
+				}
+				for _, exec := range state.Executions {
+					if strings.HasPrefix(exec.AgentName, "_") {
+						continue
+					}
+					a := byAgent[exec.AgentName]
+					if a == nil {
+						a = &agentReminders{name: exec.AgentName}
+						byAgent[exec.AgentName] = a
+					}
+					a.executions++
+					if exec.SignalReminders > 0 {
+						a.reminded++
+						if exec.Status == events.ExecStatusFailed {
+							a.failed++
+						}
+					}
+				}
+			}
+
+			var reminded []*agentReminders
+			for _, a := range byAgent {
+				if a.reminded > 0 {
+					reminded = append(reminded, a)
+				}
+			}
+			if len(reminded) == 0 {
+				fmt.Printf("No agent needed a reminder in the last %d runs.\n", len(runs))
+				return nil
+			}
+			sort.Slice(reminded, func(i, j int) bool {
+				if reminded[i].reminded != reminded[j].reminded {
+					return reminded[i].reminded > reminded[j].reminded
+				}
+				return reminded[i].name < reminded[j].name
+			})
+
+			fmt.Printf("%-20s %-10s %-10s %s\n", "AGENT", "RUNS", "REMINDED", "STILL NO SIGNAL")
+			for _, a := range reminded {
+				fmt.Printf("%-20s %-10d %-10s %d\n", truncate(a.name, 20), a.executions,
+					fmt.Sprintf("%d (%d%%)", a.reminded, a.reminded*100/a.executions), a.failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Int("limit", 200, "Number of recent runs to look at")
+	return cmd
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// ── Shell completion ──────────────────────────────────────────────────────────
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeRunIDs suggests recent run IDs (described by workflow, status and
+// prompt) for a command's first argument. keep filters runs; nil keeps all
+// but deleted ones. Nothing is suggested before shop has created its
+// database.
+func completeRunIDs(keep func(*events.RunState) bool) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		cfg, err := config.New()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		if _, err := os.Stat(cfg.DBPath); err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		store, err := events.NewStore(cfg.DBPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer store.Close()
+
+		runs, err := store.ListRunsWithSummary(50)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var ids []string
+		for _, r := range runs {
+			if r.Status == events.RunStatusDeleted || (keep != nil && !keep(r.RunState)) {
+				continue
+			}
+			desc := []string{string(r.Status)}
+			if r.WorkflowName != "" {
+				desc = append([]string{r.WorkflowName}, desc...)
+			}
+			if r.InitialPrompt != "" {
+				desc = append(desc, truncate(r.InitialPrompt, 40))
+			}
+			ids = append(ids, fmt.Sprintf("%d\t%s", r.ID, strings.Join(desc, " · ")))
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+	}
+}
+
+func activeRun(state *events.RunState) bool  { return !state.Status.IsTerminal() }
+func waitingRun(state *events.RunState) bool { return state.Status == events.RunStatusWaitingHuman }
+
+// completeWorkflows suggests workflow names with their descriptions.
+func completeWorkflows(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cfg, err := config.New()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	workflows, err := cfg.ListWorkflows()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, w := range workflows {
+		names = append(names, w.Name+"\t"+w.Description)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeAgents suggests Claude agent definitions visible from --repo.
+func completeAgents(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	repoPath, _ := cmd.Flags().GetString("repo")
+	var names []string
+	for _, d := range agents.List(agents.Dirs(repoPath)) {
+		names = append(names, d.Name+"\t"+d.Source)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
 func openStore() (*config.Config, *events.Store, error) {
 	cfg, err := config.New()
 	if err != nil {
@@ -610,6 +3203,17 @@ func openStore() (*config.Config, *events.Store, error) {
 	return cfg, store, nil
 }
 
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
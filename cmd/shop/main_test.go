@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	runErr := fn()
+	w.Close()
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	return <-out
+}
+
+func TestListCSV(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("SHOP_DATA_DIR", dataDir)
+	t.Setenv("SHOP_INSTANCE_ID", "test")
+
+	store, err := events.NewStore(filepath.Join(dataDir, "shop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID, _ := store.CreateRun()
+	evt, _ := events.NewEvent(runID, events.EventRunStarted, events.RunStartedPayload{
+		WorkflowName: "wf", InitialPrompt: `fix "login", then deploy`,
+	})
+	if _, err := store.AppendEvents(runID, 0, []events.Event{evt}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	cmd := newListCommand()
+	cmd.SetArgs([]string{"-o", "csv"})
+	out := captureStdout(t, cmd.Execute)
+
+	if !strings.Contains(out, `"fix ""login"", then deploy"`) {
+		t.Fatalf("expected the prompt quoted with its quotes doubled, got:\n%s", out)
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV, got %v:\n%s", err, out)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected a header and one run, got %d rows:\n%s", len(records), out)
+	}
+	if records[0][0] != "id" || records[0][3] != "prompt" {
+		t.Fatalf("unexpected header %v", records[0])
+	}
+	if records[1][3] != `fix "login", then deploy` {
+		t.Fatalf("expected the prompt to round-trip, got %q from:\n%s", records[1][3], out)
+	}
+}
+
+func TestArtifactsCopyStaysInRepo(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("SHOP_DATA_DIR", dataDir)
+	t.Setenv("SHOP_INSTANCE_ID", "test")
+
+	ws := t.TempDir()
+	repo := filepath.Join(ws, "repo")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "report.md"), []byte("# Report"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("not the repo's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := events.NewStore(filepath.Join(dataDir, "shop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID, _ := store.CreateRun()
+	started, _ := events.NewEvent(runID, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "wf", WorkspacePath: ws})
+	agent, _ := events.NewEvent(runID, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1})
+	done, _ := events.NewEvent(runID, events.EventAgentCompleted, events.AgentCompletedPayload{
+		AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"}, Artifacts: []string{"report.md", "notes.txt"},
+	})
+	if _, err := store.AppendEvents(runID, 0, []events.Event{started, agent, done}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// notes.txt was a file when reported; since then it became a link out
+	if err := os.Symlink(outside, filepath.Join(repo, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	cmd := newArtifactsCommand()
+	cmd.SetArgs([]string{strconv.FormatInt(runID, 10), "--copy", dest})
+	cmd.SetErr(io.Discard)
+	var runErr error
+	captureStdout(t, func() error {
+		runErr = cmd.Execute()
+		return nil
+	})
+	if runErr == nil || !strings.Contains(runErr.Error(), "links outside the run's repo") {
+		t.Fatalf("expected the outside link refused, got %v", runErr)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "report.md")); err != nil {
+		t.Fatalf("expected the in-repo artifact copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "notes.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing copied through the link, got %v", err)
+	}
+}
+
+func TestStatusWatchStopsOnIdlePendingRun(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("SHOP_DATA_DIR", dataDir)
+	t.Setenv("SHOP_INSTANCE_ID", "test")
+
+	store, err := events.NewStore(filepath.Join(dataDir, "shop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID, _ := store.CreateRun() // as with --no-exec
+	store.Close()
+	id := strconv.FormatInt(runID, 10)
+
+	cmd := newStatusCommand()
+	cmd.SetArgs([]string{id, "--watch", "--interval", "0s"})
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--interval must be positive") {
+		t.Fatalf("expected a zero interval refused, got %v", err)
+	}
+
+	cmd = newStatusCommand()
+	cmd.SetArgs([]string{id, "--watch", "--interval", "10ms"})
+	done := make(chan string)
+	go func() { done <- captureStdout(t, cmd.Execute) }()
+	select {
+	case out := <-done:
+		if !strings.Contains(out, "nothing is queued to start it") {
+			t.Fatalf("expected the watch to explain why it stopped, got:\n%s", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watch to stop on a pending run nothing will start")
+	}
+}
@@ -0,0 +1,108 @@
+// Package agents locates the Claude agent definitions that `claude --agent`
+// resolves: markdown files in a repo's .claude/agents, then ~/.claude/agents.
+package agents
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Dir is a directory Claude searches for agent definitions.
+type Dir struct {
+	Path   string
+	Source string // "project" or "user"
+}
+
+// Definition is an agent definition file found in one of the Dirs.
+type Definition struct {
+	Name   string
+	Path   string
+	Source string
+}
+
+// Dirs returns the definition directories for a repo in Claude's precedence
+// order: project definitions shadow user ones of the same name.
+func Dirs(repoDir string) []Dir {
+	dirs := []Dir{{Path: filepath.Join(repoDir, ".claude", "agents"), Source: "project"}}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, Dir{Path: filepath.Join(home, ".claude", "agents"), Source: "user"})
+	}
+	return dirs
+}
+
+// Find returns the definition Claude would use for name.
+func Find(dirs []Dir, name string) (*Definition, error) {
+	for _, d := range dirs {
+		path := filepath.Join(d.Path, name+".md")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return &Definition{Name: name, Path: path, Source: d.Source}, nil
+		}
+	}
+	looked := make([]string, len(dirs))
+	for i, d := range dirs {
+		looked[i] = d.Path
+	}
+	return nil, fmt.Errorf("no Claude agent definition for %q (looked in %s)", name, strings.Join(looked, ", "))
+}
+
+// List returns every definition in dirs, skipping names shadowed by an
+// earlier directory, sorted by name.
+func List(dirs []Dir) []Definition {
+	seen := make(map[string]bool)
+	var defs []Definition
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d.Path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || filepath.Ext(name) != ".md" {
+				continue
+			}
+			name = strings.TrimSuffix(name, ".md")
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			defs = append(defs, Definition{Name: name, Path: filepath.Join(d.Path, entry.Name()), Source: d.Source})
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// runCall matches an agent named in a run() call or as its on_error route.
+var runCall = regexp.MustCompile(`\brun\(\s*(?:"([^"]+)"|'([^']+)'|` + "`([^`$]+)`" + `)` +
+	`|\bon_error\s*:\s*(?:"([^"]+)"|'([^']+)')`)
+
+// Referenced returns the agents a workflow script names as string literals
+// in run() calls or on_error options, in order of first use. Agents chosen
+// at runtime (variables, template expressions) can't be found this way.
+func Referenced(script string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range runCall.FindAllStringSubmatch(script, -1) {
+		name := strings.Join(m[1:], "")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Missing returns the agents referenced by script that have no definition.
+func Missing(dirs []Dir, script string) []string {
+	var missing []string
+	for _, name := range Referenced(script) {
+		if _, err := Find(dirs, name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,66 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeDef(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".md"), []byte("# "+name), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPrefersProjectDefinitions(t *testing.T) {
+	project, user := t.TempDir(), t.TempDir()
+	dirs := []Dir{{Path: project, Source: "project"}, {Path: user, Source: "user"}}
+	writeDef(t, project, "coder")
+	writeDef(t, user, "coder")
+	writeDef(t, user, "reviewer")
+
+	def, err := Find(dirs, "coder")
+	if err != nil || def.Source != "project" {
+		t.Fatalf("expected project coder, got %+v, %v", def, err)
+	}
+	def, err = Find(dirs, "reviewer")
+	if err != nil || def.Source != "user" {
+		t.Fatalf("expected user reviewer, got %+v, %v", def, err)
+	}
+	if _, err := Find(dirs, "architect"); err == nil {
+		t.Fatal("expected missing definition to error")
+	}
+
+	var names []string
+	for _, d := range List(dirs) {
+		names = append(names, d.Name+":"+d.Source)
+	}
+	if want := []string{"coder:project", "reviewer:user"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+}
+
+func TestMissingReportsLiteralRunCalls(t *testing.T) {
+	dir := t.TempDir()
+	writeDef(t, dir, "coder")
+	script := `
+		function workflow(prompt) {
+			run("coder", prompt);
+			run('reviewer');
+			run(` + "`architect`" + `);
+			run(pick());
+			run("coder", { on_error: 'fixer' });
+		}`
+
+	if got, want := Referenced(script), []string{"coder", "reviewer", "architect", "fixer"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := Missing([]Dir{{Path: dir}}, script), []string{"reviewer", "architect", "fixer"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,131 @@
+package agents
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+var (
+	statusesList = regexp.MustCompile(`\bstatuses\s*:\s*\[([^\]]*)\]`)
+	stringLit    = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+)
+
+// Statuses returns, for each agent named literally in a run() call, the
+// custom statuses its calls declare, in order of first appearance. An
+// on_error agent reports in place of the failed one, so it gets the
+// statuses of the calls it's the route for.
+func Statuses(script string) map[string][]string {
+	statuses := make(map[string][]string)
+	seen := make(map[string]bool)
+	var args string // arguments of the latest run() call
+	var argsEnd int
+	for _, loc := range runCall.FindAllStringSubmatchIndex(script, -1) {
+		var name string
+		var group int
+		for g := 2; g < len(loc); g += 2 { // whichever alternative matched
+			if loc[g] >= 0 {
+				name, group = script[loc[g]:loc[g+1]], g/2
+			}
+		}
+		if _, ok := statuses[name]; !ok {
+			statuses[name] = nil
+		}
+		if group <= 3 { // run("name", ...)
+			args = callArgs(script, loc[1])
+			argsEnd = loc[1] + len(args)
+		} else if loc[0] > argsEnd { // on_error outside any run() call
+			continue
+		}
+		for _, m := range statusesList.FindAllStringSubmatch(args, -1) {
+			for _, lit := range stringLit.FindAllStringSubmatch(m[1], -1) {
+				status := lit[1] + lit[2]
+				if key := name + "\x00" + status; !seen[key] {
+					seen[key] = true
+					statuses[name] = append(statuses[name], status)
+				}
+			}
+		}
+	}
+	return statuses
+}
+
+// callArgs returns the rest of a call's argument list starting at i, up to
+// its closing parenthesis. Parentheses inside strings aren't special-cased.
+func callArgs(script string, i int) string {
+	depth := 1
+	for j := i; j < len(script); j++ {
+		switch script[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return script[i:j]
+			}
+		}
+	}
+	return script[i:]
+}
+
+// Stub returns a starter Claude agent definition for name: frontmatter, a
+// placeholder role, and how to report its outcome with the statuses the
+// workflow checks for.
+func Stub(name string, statuses []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "---\nname: %s\ndescription: TODO: when the %s agent should be used\n---\n\n", name, name)
+	fmt.Fprintf(&b, "You are the %s agent.\n\nTODO: describe this agent's role, what it should do, and what it must not do.\n\n", name)
+	b.WriteString("## Reporting your outcome\n\n")
+	b.WriteString("When your work is complete, call the `report_signal` tool exactly once with:\n\n")
+	b.WriteString("- `status`: one of\n")
+	for _, status := range statuses {
+		if !slices.Contains(events.ReservedStatuses, status) {
+			fmt.Fprintf(&b, "  - `%s`: TODO: when to report it\n", status)
+		}
+	}
+	b.WriteString("  - `DONE`: the task is complete\n")
+	b.WriteString("  - `STUCK`: you need a human; say why in `reason`\n")
+	b.WriteString("- `summary`: what you did and what the next agent needs to know\n")
+	b.WriteString("- `artifacts` (optional): repo-relative paths of files later agents need\n")
+	return b.String()
+}
+
+// Scaffold writes a Stub into dir for each agent a workflow script runs
+// that has no definition in dirs, and returns the files it created. Existing
+// files are never overwritten.
+func Scaffold(dirs []Dir, dir, script string) ([]string, error) {
+	missing := Missing(dirs, script)
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	statuses := Statuses(script)
+	var created []string
+	for _, name := range missing {
+		path := filepath.Join(dir, name+".md")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		_, err = f.WriteString(Stub(name, statuses[name]))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return created, err
+		}
+		created = append(created, path)
+	}
+	return created, nil
+}
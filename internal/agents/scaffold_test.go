@@ -0,0 +1,73 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatusesFromRunCalls(t *testing.T) {
+	script := `
+		function workflow(prompt) {
+			const r = run("reviewer", { prompt: fmt(prompt), statuses: ["APPROVED", "CHANGES_REQUESTED"] });
+			run('coder', { prompt, statuses: ["FIXED"], on_error: "fixer" });
+			run("reviewer", {
+				statuses: ['REJECTED', "APPROVED"],
+			});
+		}`
+
+	want := map[string][]string{
+		"reviewer": {"APPROVED", "CHANGES_REQUESTED", "REJECTED"},
+		"coder":    {"FIXED"},
+		"fixer":    {"FIXED"},
+	}
+	if got := Statuses(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestStubDescribesStatuses(t *testing.T) {
+	stub := Stub("reviewer", []string{"APPROVED", "DONE"})
+	for _, want := range []string{"name: reviewer\n", "`report_signal`", "`APPROVED`", "`DONE`", "`STUCK`", "`summary`"} {
+		if !strings.Contains(stub, want) {
+			t.Errorf("expected stub to contain %q:\n%s", want, stub)
+		}
+	}
+	if strings.Count(stub, "`DONE`") != 1 {
+		t.Errorf("expected the reserved DONE status listed once:\n%s", stub)
+	}
+}
+
+func TestScaffoldWritesOnlyMissingDefinitions(t *testing.T) {
+	project, user := t.TempDir(), t.TempDir()
+	dirs := []Dir{{Path: project, Source: "project"}, {Path: user, Source: "user"}}
+	writeDef(t, project, "coder")
+	writeDef(t, user, "tester")
+	script := `function workflow(prompt) {
+		run("coder"); run("tester");
+		run("reviewer", { statuses: ["APPROVED"] });
+		run("architect");
+	}`
+
+	created, err := Scaffold(dirs, project, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(project, "reviewer.md"), filepath.Join(project, "architect.md")}
+	if !reflect.DeepEqual(created, want) {
+		t.Fatalf("expected %v, got %v", want, created)
+	}
+	data, err := os.ReadFile(filepath.Join(project, "reviewer.md"))
+	if err != nil || !strings.Contains(string(data), "`APPROVED`") {
+		t.Fatalf("expected reviewer stub with its statuses, got %q (%v)", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(project, "coder.md")); string(data) != "# coder" {
+		t.Fatalf("expected the existing coder definition untouched, got %q", data)
+	}
+
+	if created, err := Scaffold(dirs, project, script); err != nil || len(created) != 0 {
+		t.Fatalf("expected a second scaffold to create nothing, got %v, %v", created, err)
+	}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/workflow"
+	"github.com/mpataki/shop/internal/workspace"
+)
+
+// Server exposes read-only JSON views of runs over HTTP.
+type Server struct {
+	store *events.Store
+}
+
+// NewServer creates an API server backed by the event store.
+func NewServer(store *events.Store) *Server {
+	return &Server{store: store}
+}
+
+// Handler returns the HTTP handler with all routes and request logging.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", s.handleListRuns)
+	mux.HandleFunc("GET /runs/{id}", s.handleGetRun)
+	mux.HandleFunc("GET /runs/{id}/executions", s.handleGetExecutions)
+	mux.HandleFunc("GET /runs/{id}/context", s.handleGetContext)
+	return logRequests(mux)
+}
+
+// ListenAndServe serves the API on addr until the server fails.
+func (s *Server) ListenAndServe(addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+// ── Views ─────────────────────────────────────────────────────────────────────
+
+type runView struct {
+	ID               int64             `json:"id"`
+	Status           string            `json:"status"`
+	WorkflowName     string            `json:"workflow_name"`
+	WorkflowPath     string            `json:"workflow_path,omitempty"`
+	InitialPrompt    string            `json:"initial_prompt"`
+	WorkspacePath    string            `json:"workspace_path,omitempty"`
+	WorkspaceExists  bool              `json:"workspace_exists"`
+	Branch           string            `json:"branch,omitempty"`
+	BaseCommit       string            `json:"base_commit,omitempty"`
+	ParentRunID      int64             `json:"parent_run_id,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	CurrentAgent     string            `json:"current_agent,omitempty"`
+	WaitingReason    string            `json:"waiting_reason,omitempty"`
+	WaitingSessionID string            `json:"waiting_session_id,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	Summary          string            `json:"summary,omitempty"`
+	Executions       int               `json:"executions"`
+	FinalSignal      map[string]any    `json:"final_signal,omitempty"`
+	FinalStatus      string            `json:"final_status,omitempty"`
+	Outcome          string            `json:"outcome,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+type executionView struct {
+	CallIndex     int                 `json:"call_index"`
+	AgentName     string              `json:"agent"`
+	Status        string              `json:"status"`
+	Attempt       int                 `json:"attempt,omitempty"`
+	Model         string              `json:"model,omitempty"`
+	OutputFormat  string              `json:"output_format,omitempty"`
+	SessionID     string              `json:"session_id,omitempty"`
+	Prompt        string              `json:"prompt"`
+	SentPrompt    string              `json:"sent_prompt,omitempty"`
+	Signal        map[string]any      `json:"signal,omitempty"`
+	Artifacts     []string            `json:"artifacts,omitempty"`
+	Result        *events.AgentResult `json:"result,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	WaitingReason string              `json:"waiting_reason,omitempty"`
+	ChildRunID    int64               `json:"child_run_id,omitempty"`
+
+	SkippedPermissions bool `json:"skipped_permissions"`
+	SignalReminders    int  `json:"signal_reminders,omitempty"`
+
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+type contextEntry struct {
+	CallIndex int            `json:"call_index"`
+	AgentName string         `json:"agent"`
+	Status    string         `json:"status"`
+	Summary   string         `json:"summary,omitempty"`
+	Signal    map[string]any `json:"signal"`
+}
+
+type contextView struct {
+	RunID         int64          `json:"run_id"`
+	WorkflowName  string         `json:"workflow_name"`
+	InitialPrompt string         `json:"initial_prompt"`
+	Header        string         `json:"header,omitempty"` // rendered context_template brief
+	Entries       []contextEntry `json:"entries"`
+}
+
+func newRunView(state *events.RunState) runView {
+	return runView{
+		ID:               state.ID,
+		Status:           string(state.Status),
+		WorkflowName:     state.WorkflowName,
+		WorkflowPath:     state.WorkflowPath,
+		InitialPrompt:    state.InitialPrompt,
+		WorkspacePath:    state.WorkspacePath,
+		WorkspaceExists:  workspace.Exists(state.WorkspacePath),
+		Branch:           state.Branch,
+		BaseCommit:       state.BaseCommit,
+		ParentRunID:      state.ParentRunID,
+		Metadata:         state.Metadata,
+		CurrentAgent:     state.CurrentAgent,
+		WaitingReason:    state.WaitingReason,
+		WaitingSessionID: state.WaitingSessionID,
+		Error:            state.Error,
+		Summary:          state.Summary,
+		Executions:       len(state.Executions),
+		FinalSignal:      state.FinalSignal(),
+		FinalStatus:      state.FinalStatus(),
+		Outcome:          workflow.RunOutcome(state),
+		CreatedAt:        state.CreatedAt,
+	}
+}
+
+// ── Handlers ──────────────────────────────────────────────────────────────────
+
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	runs, err := s.store.ListRunsWithSummary(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	views := []runView{}
+	for _, summary := range runs {
+		if summary.Status == events.RunStatusDeleted {
+			continue
+		}
+		views = append(views, newRunView(summary.RunState))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	state, ok := s.loadRun(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newRunView(state))
+}
+
+func (s *Server) handleGetExecutions(w http.ResponseWriter, r *http.Request) {
+	state, ok := s.loadRun(w, r)
+	if !ok {
+		return
+	}
+	views := []executionView{}
+	for _, exec := range state.Executions {
+		views = append(views, executionView{
+			CallIndex:     exec.CallIndex,
+			AgentName:     exec.AgentName,
+			Status:        string(exec.Status),
+			Attempt:       exec.Attempt,
+			Model:         exec.Model,
+			OutputFormat:  exec.OutputFormat,
+			SessionID:     exec.SessionID,
+			Prompt:        exec.Prompt,
+			SentPrompt:    exec.SentPrompt,
+			Signal:        exec.Signal,
+			Artifacts:     exec.Artifacts,
+			Result:        exec.Result,
+			Error:         exec.Error,
+			WaitingReason: exec.WaitingReason,
+			ChildRunID:    exec.ChildRunID,
+			StartedAt:     exec.StartedAt,
+			CompletedAt:   exec.CompletedAt,
+
+			SkippedPermissions: exec.SkippedPermissions,
+			SignalReminders:    exec.SignalReminders,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// handleGetContext returns the signals agents have reported so far — the
+// same accumulated context agents see through the get_context MCP tool.
+func (s *Server) handleGetContext(w http.ResponseWriter, r *http.Request) {
+	state, ok := s.loadRun(w, r)
+	if !ok {
+		return
+	}
+	view := contextView{
+		RunID:         state.ID,
+		WorkflowName:  state.WorkflowName,
+		InitialPrompt: state.InitialPrompt,
+		Header:        state.ContextHeader,
+		Entries:       []contextEntry{},
+	}
+	for _, exec := range state.Executions {
+		if exec.Status == events.ExecStatusInvalidated || exec.Signal == nil {
+			continue
+		}
+		status, _ := exec.Signal["status"].(string)
+		if status == "" {
+			continue
+		}
+		summary, _ := exec.Signal["summary"].(string)
+		view.Entries = append(view.Entries, contextEntry{
+			CallIndex: exec.CallIndex,
+			AgentName: exec.AgentName,
+			Status:    status,
+			Summary:   summary,
+			Signal:    exec.Signal,
+		})
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// loadRun projects the run named by the {id} path value, writing an error
+// response and returning false if it can't.
+func (s *Server) loadRun(w http.ResponseWriter, r *http.Request) (*events.RunState, bool) {
+	runID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run ID")
+		return nil, false
+	}
+	state, err := s.store.ProjectRunFromDB(runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "run not found")
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if state.Status == events.RunStatusDeleted {
+		writeError(w, http.StatusNotFound, "run not found")
+		return nil, false
+	}
+	return state, true
+}
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs method, path, status and duration for every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+func testServer(t *testing.T) (*httptest.Server, *events.Store) {
+	t.Helper()
+	store, err := events.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	srv := httptest.NewServer(NewServer(store).Handler())
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func seedRun(t *testing.T, store *events.Store) int64 {
+	t.Helper()
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signal := map[string]any{"status": "DONE", "summary": "built"}
+	evts := []events.Event{
+		events.MustNewEvent(runID, events.EventRunStarted, events.RunStartedPayload{
+			WorkflowName: "dev", InitialPrompt: "build it",
+		}),
+		events.MustNewEvent(runID, events.EventAgentStarted, events.AgentStartedPayload{
+			AgentName: "coder", CallIndex: 0, Prompt: "build it",
+		}),
+		events.MustNewEvent(runID, events.EventSignalReceived, events.SignalReceivedPayload{
+			CallIndex: 0, Signal: signal,
+		}),
+		events.MustNewEvent(runID, events.EventAgentCompleted, events.AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 0, Signal: signal,
+		}),
+	}
+	if _, err := store.AppendEvents(runID, 0, evts); err != nil {
+		t.Fatal(err)
+	}
+	return runID
+}
+
+func getJSON(t *testing.T, url string, wantStatus int, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("GET %s: expected %d, got %d", url, wantStatus, resp.StatusCode)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListAndGetRun(t *testing.T) {
+	srv, store := testServer(t)
+	runID := seedRun(t, store)
+
+	var runs []runView
+	getJSON(t, srv.URL+"/runs", http.StatusOK, &runs)
+	if len(runs) != 1 || runs[0].ID != runID || runs[0].Status != "running" {
+		t.Fatalf("unexpected runs: %+v", runs)
+	}
+
+	var run runView
+	getJSON(t, srv.URL+"/runs/1", http.StatusOK, &run)
+	if run.WorkflowName != "dev" || run.Executions != 1 {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+}
+
+func TestGetExecutionsAndContext(t *testing.T) {
+	srv, store := testServer(t)
+	seedRun(t, store)
+
+	var execs []executionView
+	getJSON(t, srv.URL+"/runs/1/executions", http.StatusOK, &execs)
+	if len(execs) != 1 || execs[0].AgentName != "coder" || execs[0].Status != "completed" {
+		t.Fatalf("unexpected executions: %+v", execs)
+	}
+
+	var ctx contextView
+	getJSON(t, srv.URL+"/runs/1/context", http.StatusOK, &ctx)
+	if len(ctx.Entries) != 1 || ctx.Entries[0].Summary != "built" {
+		t.Fatalf("unexpected context: %+v", ctx)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv, _ := testServer(t)
+
+	getJSON(t, srv.URL+"/runs/99", http.StatusNotFound, nil)
+	getJSON(t, srv.URL+"/runs/abc", http.StatusBadRequest, nil)
+	getJSON(t, srv.URL+"/runs?limit=0", http.StatusBadRequest, nil)
+
+	resp, err := http.Post(srv.URL+"/runs", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected writes to be rejected, got %d", resp.StatusCode)
+	}
+}
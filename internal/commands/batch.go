@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+// BatchResult is the outcome of one prompt of a batch.
+type BatchResult struct {
+	Prompt   string
+	RunID    int64 // 0 if the run couldn't be created
+	Status   string
+	Error    string
+	Duration time.Duration
+
+	// State is the run's final projection; nil if it couldn't be read.
+	State *events.RunState
+}
+
+// ReadPrompts loads a batch's prompts from path: a JSON array of strings
+// (.json), the first column of a CSV file (.csv, with an optional "prompt"
+// header), or otherwise one prompt per non-blank line.
+func ReadPrompts(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompts []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &prompts); err != nil {
+			return nil, fmt.Errorf("%s: expected a JSON array of strings: %w", path, err)
+		}
+	case ".csv":
+		records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for i, rec := range records {
+			if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "prompt") {
+				continue
+			}
+			prompts = append(prompts, rec[0])
+		}
+	default:
+		for _, line := range strings.Split(string(data), "\n") {
+			prompts = append(prompts, line)
+		}
+	}
+
+	var kept []string
+	for _, prompt := range prompts {
+		if strings.TrimSpace(prompt) != "" {
+			kept = append(kept, strings.TrimSpace(prompt))
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%s has no prompts", path)
+	}
+	return kept, nil
+}
+
+// RunBatch starts an independent run of the workflow for each prompt, at
+// most parallel at a time, and waits for every run to settle. Results are
+// in prompt order.
+func (p *Processor) RunBatch(base StartRunPayload, prompts []string, parallel int) []BatchResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]BatchResult, len(prompts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, prompt := range prompts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			payload := base
+			payload.InitialPrompt = prompt
+			results[i] = p.runOne(payload)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runOne starts a single batch run and waits for it to settle.
+func (p *Processor) runOne(payload StartRunPayload) (result BatchResult) {
+	result = BatchResult{Prompt: payload.InitialPrompt}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	runID, err := p.store.CreateRun()
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	result.RunID = runID
+
+	cmd, err := NewCommand(runID, CmdStartRun, payload)
+	if err == nil {
+		err = p.SubmitCommand(cmd)
+	}
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	<-p.ProcessRunSync(runID)
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		result.Status, result.Error = "error", err.Error()
+		return result
+	}
+	result.State = state
+	result.Status = string(state.Status)
+	result.Error = state.Error
+	if result.Error == "" && state.Status.IsSuspended() {
+		result.Error = state.WaitingReason
+	}
+	if state.Status == events.RunStatusPending && result.Error == "" {
+		// StartRun was rejected before the run began (e.g. the workspace
+		// couldn't be created); the processor logged why
+		result.Error = "run did not start"
+	}
+	return result
+}
+
+// Expectation is what a batch prompt's run should end with. Empty fields
+// aren't checked.
+type Expectation struct {
+	Status string         `json:"status,omitempty"` // final run status, e.g. "complete"
+	Signal map[string]any `json:"signal,omitempty"` // fields the run's final signal must have
+}
+
+// ReadExpectations loads a JSON object mapping each prompt to its
+// Expectation.
+func ReadExpectations(path string) (map[string]Expectation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var expectations map[string]Expectation
+	if err := json.Unmarshal(data, &expectations); err != nil {
+		return nil, fmt.Errorf("%s: expected a JSON object mapping prompts to {status, signal}: %w", path, err)
+	}
+	trimmed := make(map[string]Expectation, len(expectations))
+	for prompt, e := range expectations {
+		trimmed[strings.TrimSpace(prompt)] = e
+	}
+	return trimmed, nil
+}
+
+// Check compares a batch result with the expectation and describes each
+// mismatch; none means the result passes.
+func (e Expectation) Check(r BatchResult) []string {
+	var mismatches []string
+	if e.Status != "" && r.Status != e.Status {
+		mismatches = append(mismatches, fmt.Sprintf("status: expected %s, got %s", e.Status, r.Status))
+	}
+	if len(e.Signal) == 0 {
+		return mismatches
+	}
+
+	var signal map[string]any
+	if r.State != nil {
+		signal = r.State.FinalSignal()
+	}
+	if signal == nil {
+		return append(mismatches, "signal: the run has no final signal")
+	}
+	keys := make([]string, 0, len(e.Signal))
+	for k := range e.Signal {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		got, ok := signal[k]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("signal.%s: expected %s, missing", k, jsonString(e.Signal[k])))
+		case !sameJSON(got, e.Signal[k]):
+			mismatches = append(mismatches, fmt.Sprintf("signal.%s: expected %s, got %s", k, jsonString(e.Signal[k]), jsonString(got)))
+		}
+	}
+	return mismatches
+}
+
+// sameJSON compares two decoded JSON values by their encoding, so numbers
+// and nested objects compare by value.
+func sameJSON(a, b any) bool {
+	return jsonString(a) == jsonString(b)
+}
+
+func jsonString(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+func TestReadPrompts(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	want := []string{"add a flag", "fix the, bug"}
+	for _, path := range []string{
+		write("prompts.txt", "add a flag\n\n  fix the, bug  \n"),
+		write("prompts.json", `["add a flag", "", "fix the, bug"]`),
+		write("prompts.csv", "prompt,notes\nadd a flag,x\n\"fix the, bug\",y\n"),
+	} {
+		got, err := ReadPrompts(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+
+	if _, err := ReadPrompts(write("empty.txt", "\n  \n")); err == nil {
+		t.Error("expected an error for a file without prompts")
+	}
+	if _, err := ReadPrompts(write("bad.json", `{"prompt": "x"}`)); err == nil {
+		t.Error("expected an error for JSON that isn't an array of strings")
+	}
+}
+
+func TestRunBatchStartsARunPerPrompt(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+	path := writeScript(t, `function workflow(prompt) {
+		if (prompt === "give up") { stuck("can't"); }
+		run("coder", prompt);
+	}`)
+
+	prompts := []string{"one", "give up", "three", "four"}
+	results := p.RunBatch(StartRunPayload{WorkflowPath: path, WorkflowName: "test"}, prompts, 2)
+
+	if len(results) != len(prompts) {
+		t.Fatalf("expected %d results, got %d", len(prompts), len(results))
+	}
+	seen := map[int64]bool{}
+	for i, r := range results {
+		if r.Prompt != prompts[i] {
+			t.Errorf("result %d: expected prompt %q, got %q", i, prompts[i], r.Prompt)
+		}
+		if r.RunID == 0 || seen[r.RunID] {
+			t.Errorf("result %d: expected its own run, got run %d", i, r.RunID)
+		}
+		seen[r.RunID] = true
+
+		state, err := store.ProjectRunFromDB(r.RunID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.InitialPrompt != prompts[i] {
+			t.Errorf("run %d: expected prompt %q, got %q", r.RunID, prompts[i], state.InitialPrompt)
+		}
+
+		want := string(events.RunStatusComplete)
+		if prompts[i] == "give up" {
+			want = string(events.RunStatusStuck)
+		}
+		if r.Status != want {
+			t.Errorf("%q: expected %s, got %s (%s)", prompts[i], want, r.Status, r.Error)
+		}
+	}
+	if n := countAgent(fm.startedAgents(), "coder"); n != 3 {
+		t.Fatalf("expected coder to run for the 3 runs that reach it, got %d", n)
+	}
+}
+
+func TestExpectationCheck(t *testing.T) {
+	state := &events.RunState{
+		Status: events.RunStatusComplete,
+		Executions: []events.ExecutionState{
+			{AgentName: "coder", Status: events.ExecStatusCompleted, Signal: map[string]any{"status": "NEEDS_CHANGES"}},
+			{AgentName: "reviewer", Status: events.ExecStatusCompleted, Signal: map[string]any{
+				"status": "APPROVED", "score": float64(3), "tags": []any{"go", "tests"},
+			}},
+		},
+	}
+	result := BatchResult{Status: string(events.RunStatusComplete), State: state}
+
+	for _, tc := range []struct {
+		name string
+		want Expectation
+		fail []string
+	}{
+		{"empty expectation", Expectation{}, nil},
+		{"status matches", Expectation{Status: "complete"}, nil},
+		{"signal matches", Expectation{Signal: map[string]any{"status": "APPROVED", "score": 3, "tags": []any{"go", "tests"}}}, nil},
+		{"status differs", Expectation{Status: "stuck"}, []string{"status: expected stuck, got complete"}},
+		{"signal differs", Expectation{Signal: map[string]any{"status": "DONE", "score": 3}}, []string{`signal.status: expected "DONE", got "APPROVED"`}},
+		{"signal field missing", Expectation{Signal: map[string]any{"reason": "x"}}, []string{`signal.reason: expected "x", missing`}},
+		{"both differ", Expectation{Status: "failed", Signal: map[string]any{"score": 5}}, []string{
+			"status: expected failed, got complete", "signal.score: expected 5, got 3",
+		}},
+	} {
+		if got := tc.want.Check(result); !reflect.DeepEqual(got, tc.fail) {
+			t.Errorf("%s: expected mismatches %q, got %q", tc.name, tc.fail, got)
+		}
+	}
+
+	noRun := BatchResult{Status: "error"}
+	if got := (Expectation{Signal: map[string]any{"status": "DONE"}}).Check(noRun); len(got) != 1 || got[0] != "signal: the run has no final signal" {
+		t.Errorf("expected a missing signal mismatch, got %q", got)
+	}
+}
+
+func TestExpectationCheckIgnoresFinallyAgent(t *testing.T) {
+	p, _, _ := fakeProcessor(t, map[string]map[string]any{
+		"reviewer": {"status": "APPROVED"},
+		"reporter": done("reported"),
+	})
+	path := writeScript(t, `
+		const settings = { finally: "reporter" };
+		function workflow(prompt) { run("reviewer", { statuses: ["APPROVED"] }); }`)
+
+	results := p.RunBatch(StartRunPayload{WorkflowPath: path, WorkflowName: "test"}, []string{"review it"}, 1)
+	want := Expectation{Status: "complete", Signal: map[string]any{"status": "APPROVED"}}
+	if got := want.Check(results[0]); len(got) != 0 {
+		t.Fatalf("expected the reviewer's signal checked, not the finally agent's, got %q", got)
+	}
+}
+
+func TestReadExpectations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expect.json")
+	content := `{" add a flag ": {"status": "complete"}, "fix the bug": {"signal": {"status": "DONE"}}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadExpectations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["add a flag"].Status != "complete" || got["fix the bug"].Signal["status"] != "DONE" {
+		t.Fatalf("unexpected expectations: %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`["not", "a map"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExpectations(path); err == nil {
+		t.Fatal("expected an error for a JSON array")
+	}
+}
@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/process"
+)
+
+// DurationStats summarises a set of durations.
+type DurationStats struct {
+	N   int
+	Min time.Duration
+	P50 time.Duration
+	P90 time.Duration
+	Max time.Duration
+}
+
+// NewDurationStats computes the stats of ds (nearest-rank percentiles).
+func NewDurationStats(ds []time.Duration) DurationStats {
+	if len(ds) == 0 {
+		return DurationStats{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p float64) time.Duration {
+		return sorted[max(int(math.Ceil(p*float64(len(sorted))))-1, 0)]
+	}
+	return DurationStats{
+		N:   len(sorted),
+		Min: sorted[0],
+		P50: rank(0.5),
+		P90: rank(0.9),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// BenchReport is the timing of a batch of runs of one workflow: each run's
+// total duration and, per agent, the duration of its finished executions.
+type BenchReport struct {
+	Runs     int
+	Complete int
+	Total    DurationStats
+	Agents   map[string]DurationStats
+}
+
+// Bench aggregates the timings of batch results.
+func Bench(results []BatchResult) BenchReport {
+	report := BenchReport{Runs: len(results), Agents: map[string]DurationStats{}}
+	var totals []time.Duration
+	perAgent := map[string][]time.Duration{}
+	for _, r := range results {
+		if r.Status == string(events.RunStatusComplete) {
+			report.Complete++
+		}
+		totals = append(totals, r.Duration)
+		if r.State == nil {
+			continue
+		}
+		for _, exec := range r.State.Executions {
+			if exec.CompletedAt != nil {
+				perAgent[exec.AgentName] = append(perAgent[exec.AgentName], exec.CompletedAt.Sub(exec.StartedAt))
+			}
+		}
+	}
+	report.Total = NewDurationStats(totals)
+	for agent, ds := range perAgent {
+		report.Agents[agent] = NewDurationStats(ds)
+	}
+	return report
+}
+
+// StubManager is a process.Manager that runs no agents: each one reports
+// the first status its run() call accepts (DONE if it names none) as soon
+// as it starts. It times everything around the agents, e.g. to try out
+// `shop bench` or a workflow's control flow without spending tokens.
+type StubManager struct {
+	store *events.Store
+}
+
+func NewStubManager(store *events.Store) *StubManager {
+	return &StubManager{store: store}
+}
+
+func (m *StubManager) StartAgent(ctx context.Context, opts process.AgentOpts) (string, int, <-chan process.ProcessResult, error) {
+	runID, callIndex, statuses, err := readMCPServerArgs(opts.MCPConfigPath)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	status := "DONE"
+	if len(statuses) > 0 {
+		status = statuses[0]
+	}
+	cmd, err := NewCommand(runID, CmdReportSignal, ReportSignalPayload{
+		CallIndex: callIndex,
+		Signal:    map[string]any{"status": status, "summary": "stub " + opts.SignalAgent},
+	})
+	if err == nil {
+		err = m.store.SubmitCommand(cmd.ID, cmd.RunID, string(cmd.Type), cmd.Payload)
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	sessionID := events.NewID()
+	done := make(chan process.ProcessResult, 1)
+	done <- process.ProcessResult{SessionID: sessionID}
+	close(done)
+	return sessionID, 0, done, nil
+}
+
+func (m *StubManager) Kill(pid int) error { return nil }
+
+// readMCPServerArgs reads back the run, call index and statuses that
+// WriteMCPConfig gave the shop MCP server in the mcp.json at path.
+func readMCPServerArgs(path string) (runID int64, callIndex int, statuses []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	var cfg struct {
+		MCPServers map[string]struct {
+			Args []string `json:"args"`
+		} `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return 0, 0, nil, err
+	}
+	args := cfg.MCPServers["shop"].Args
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--run-id":
+			runID, _ = strconv.ParseInt(args[i+1], 10, 64)
+		case "--call-index":
+			callIndex, _ = strconv.Atoi(args[i+1])
+		case "--statuses":
+			statuses = strings.Split(args[i+1], ",")
+		}
+	}
+	return runID, callIndex, statuses, nil
+}
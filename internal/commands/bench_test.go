@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+func TestNewDurationStats(t *testing.T) {
+	var ds []time.Duration
+	for i := 10; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Second)
+	}
+	got := NewDurationStats(ds)
+	want := DurationStats{N: 10, Min: time.Second, P50: 5 * time.Second, P90: 9 * time.Second, Max: 10 * time.Second}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if ds[0] != 10*time.Second {
+		t.Fatal("expected the input left unsorted")
+	}
+	if one := NewDurationStats([]time.Duration{time.Second}); one.P50 != time.Second || one.P90 != time.Second {
+		t.Fatalf("expected a single sample to be every percentile, got %+v", one)
+	}
+}
+
+func TestBenchWithStubManager(t *testing.T) {
+	dir := t.TempDir()
+	store, err := events.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	p := NewProcessor(store, NewStubManager(store), filepath.Join(dir, "workspaces"), "")
+
+	path := writeScript(t, `function workflow(prompt) {
+		run("coder", prompt);
+		for (let i = 0; i < 3; i++) {
+			if (run("reviewer", { prompt: "review", statuses: ["APPROVED", "CHANGES_REQUESTED"] }).status === "APPROVED") {
+				return;
+			}
+		}
+		stuck("never approved");
+	}`)
+
+	results := p.RunBatch(StartRunPayload{WorkflowPath: path, WorkflowName: "test"}, []string{"x", "x", "x"}, 2)
+	report := Bench(results)
+
+	if report.Runs != 3 || report.Complete != 3 {
+		t.Fatalf("expected 3 complete runs, got %+v (results %+v)", report, results)
+	}
+	if report.Total.N != 3 || report.Total.Max <= 0 {
+		t.Errorf("expected a total duration per run, got %+v", report.Total)
+	}
+	// The stub reviewer approves at once: one coder and one reviewer per run
+	for _, agent := range []string{"coder", "reviewer"} {
+		if report.Agents[agent].N != 3 {
+			t.Errorf("expected 3 %s executions timed, got %+v", agent, report.Agents[agent])
+		}
+	}
+}
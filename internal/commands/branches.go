@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/workspace"
+)
+
+// BranchPrune is what PlanBranchPrune decided for one run branch.
+type BranchPrune struct {
+	workspace.RunBranch
+	Delete bool
+	Reason string
+}
+
+// PlanBranchPrune decides which run branches can be deleted. A branch goes
+// if it is merged, or its run was deleted (or is unknown to the store) and
+// force is set. Branches checked out in a worktree, and those of runs that
+// haven't finished, are always kept, so unmerged work is only lost on
+// request.
+func PlanBranchPrune(store *events.Store, branches []workspace.RunBranch, force bool) ([]BranchPrune, error) {
+	var plan []BranchPrune
+	for _, b := range branches {
+		del, reason, err := pruneBranch(store, b, force)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, BranchPrune{RunBranch: b, Delete: del, Reason: reason})
+	}
+	return plan, nil
+}
+
+func pruneBranch(store *events.Store, b workspace.RunBranch, force bool) (bool, string, error) {
+	if b.Worktree != "" {
+		return false, "checked out at " + b.Worktree, nil
+	}
+
+	deleted := false
+	state, err := store.ProjectRunFromDB(b.RunID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		deleted = true
+	case err != nil:
+		return false, "", fmt.Errorf("load run %d: %w", b.RunID, err)
+	case state.Status == events.RunStatusDeleted:
+		deleted = true
+	case !state.Status.IsTerminal():
+		return false, fmt.Sprintf("run %d is %s", b.RunID, state.Status), nil
+	}
+
+	switch {
+	case b.Merged:
+		return true, "merged", nil
+	case b.Legacy:
+		// Its run ID says nothing about whose run made it
+		return false, "unmerged, from before instance IDs (maybe another install's; delete it by hand)", nil
+	case deleted && force:
+		return true, fmt.Sprintf("run %d deleted, unmerged", b.RunID), nil
+	case deleted:
+		return false, fmt.Sprintf("run %d deleted, but unmerged (--force deletes it)", b.RunID), nil
+	}
+	return false, "unmerged", nil
+}
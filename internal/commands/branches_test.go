@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/workspace"
+)
+
+func TestPlanBranchPrune(t *testing.T) {
+	_, store := tempProcessor(t)
+	started := events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test"})
+	complete := seedRun(t, store, started, events.MustNewEvent(0, events.EventRunCompleted, events.RunCompletedPayload{}))
+	deleted := seedRun(t, store, started, events.MustNewEvent(0, events.EventRunDeleted, events.RunDeletedPayload{}))
+	running := seedRun(t, store, started)
+	const unknown = 99
+
+	branches := []workspace.RunBranch{
+		{Name: "a", RunID: complete, Merged: true},
+		{Name: "b", RunID: complete},
+		{Name: "c", RunID: deleted, Merged: true},
+		{Name: "d", RunID: deleted},
+		{Name: "e", RunID: unknown},
+		{Name: "f", RunID: running, Merged: true},
+		{Name: "g", RunID: deleted, Merged: true, Worktree: "/ws/run-2/repo"},
+		{Name: "h", RunID: unknown, Legacy: true},
+		{Name: "i", RunID: unknown, Merged: true, Legacy: true},
+	}
+	for _, tc := range []struct {
+		force bool
+		want  string // branches to delete
+	}{
+		{false, "aci"},
+		{true, "acdei"},
+	} {
+		plan, err := PlanBranchPrune(store, branches, tc.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for _, p := range plan {
+			if p.Delete {
+				got += p.Name
+			}
+			if p.Reason == "" {
+				t.Errorf("expected a reason for %s", p.Name)
+			}
+		}
+		if got != tc.want {
+			t.Errorf("force=%v: expected to delete %q, got %q (%+v)", tc.force, tc.want, got, plan)
+		}
+	}
+}
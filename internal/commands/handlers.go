@@ -3,6 +3,7 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,55 +20,159 @@ func (p *Processor) handleStartRun(runID int64, cmd events.CommandRow) error {
 	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
 		return err
 	}
+	if !payload.AllowEmpty {
+		if err := ValidatePrompt(payload.InitialPrompt); err != nil {
+			return err
+		}
+	}
+	if err := ValidateMetadata(payload.Metadata); err != nil {
+		return err
+	}
+
+	// Capture the script so later edits don't affect this run
+	source := []byte(payload.WorkflowSource)
+	var err error
+	if payload.WorkflowSource == "" {
+		if source, err = os.ReadFile(payload.WorkflowPath); err != nil {
+			return fmt.Errorf("read workflow: %w", err)
+		}
+	}
 
-	// Create workspace
-	ws, err := workspace.Create(p.workspacesDir, runID, payload.SourceRepo)
+	// Create workspace, or take over the workflow's shared one. Scripts whose
+	// settings can't be read here get a fresh workspace and fail on execute.
+	var ws *workspace.Workspace
+	if payload.ParentRunID != 0 {
+		ws, err = p.parentWorkspace(payload.ParentRunID)
+	} else if settings, _ := workflow.LoadSettings(string(source)); settings.ReuseWorkspace && payload.SourceRepo != "" {
+		ws, err = workspace.Reuse(p.workspacesDir, p.instanceID, runID, payload.WorkflowName, payload.SourceRepo, p.runInUse)
+	} else {
+		ws, err = workspace.Create(p.workspacesDir, p.instanceID, runID, payload.SourceRepo)
+	}
 	if err != nil {
 		return fmt.Errorf("create workspace: %w", err)
 	}
 
+	// An inline script gets a file in the workspace, so the run has a
+	// workflow path to show and edit like any other
+	workflowPath := payload.WorkflowPath
+	if payload.WorkflowSource != "" {
+		workflowPath = filepath.Join(ws.Path, "workflow.js")
+		if err := os.WriteFile(workflowPath, source, 0644); err != nil {
+			return fmt.Errorf("write workflow: %w", err)
+		}
+	}
+
 	// Emit RunStarted
 	evt, _ := events.NewEvent(runID, events.EventRunStarted, events.RunStartedPayload{
-		WorkflowPath:  payload.WorkflowPath,
-		WorkflowName:  payload.WorkflowName,
-		InitialPrompt: payload.InitialPrompt,
-		WorkspacePath: ws.Path,
+		WorkflowPath:   workflowPath,
+		WorkflowName:   payload.WorkflowName,
+		InitialPrompt:  payload.InitialPrompt,
+		WorkspacePath:  ws.Path,
+		Branch:         ws.Branch,
+		BaseCommit:     ws.BaseCommit,
+		WorkflowSource: string(source),
+
+		ParentRunID:     payload.ParentRunID,
+		ParentCallIndex: payload.ParentCallIndex,
+		KeepGoing:       payload.KeepGoing,
+		Priority:        payload.Priority,
 	})
-	if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+	evts := []events.Event{evt}
+	if len(payload.Metadata) > 0 {
+		meta, _ := events.NewEvent(runID, events.EventRunMetadataSet, events.RunMetadataSetPayload{Metadata: payload.Metadata})
+		evts = append(evts, meta)
+	}
+	if _, err := p.appendEvents(runID, evts); err != nil {
 		return err
 	}
 
 	// Submit ExecuteWorkflow
-	return p.submitInternalCommand(runID, CmdExecuteWorkflow, ExecuteWorkflowPayload{})
+	return p.submitInternalCommand(runID, CmdExecuteWorkflow, ExecuteWorkflowPayload{Until: payload.Until})
 }
 
 func (p *Processor) handleExecuteWorkflow(runID int64, cmd events.CommandRow) error {
+	var payload ExecuteWorkflowPayload
+	json.Unmarshal(cmd.Payload, &payload)
+
 	// Load current projection
 	state, err := p.store.ProjectRunFromDB(runID)
 	if err != nil {
 		return err
 	}
 
+	// Make sure the workspace survived since the run last executed, and that
+	// no later run has taken it over
+	if state.WorkspacePath != "" {
+		_, err := workspace.OpenPath(state.WorkspacePath, runID)
+		if err == nil {
+			err = checkSharedOwner(state)
+		}
+		if err != nil {
+			evt, _ := events.NewEvent(runID, events.EventRunFailed, events.RunFailedPayload{
+				Error: err.Error(),
+			})
+			_, appendErr := p.appendEvents(runID, []events.Event{evt})
+			return appendErr
+		}
+	}
+
 	// Create workflow runtime with deps
 	deps := workflow.RuntimeDeps{
-		Store:          p.store,
-		State:          state,
-		ProcessManager: p.processManager,
-		WorkspacePath:  state.WorkspacePath,
-		RepoPath:       filepath.Join(state.WorkspacePath, "repo"),
+		Store:           p.store,
+		State:           state,
+		ProcessManager:  p.processManager,
+		WorkspacePath:   state.WorkspacePath,
+		RepoPath:        filepath.Join(state.WorkspacePath, "repo"),
+		Until:           payload.Until,
+		Strict:          payload.Strict,
+		LibDir:          libDir(state.WorkflowPath),
+		InstructionDirs: instructionDirs(state),
 		EmitEvents: func(evts []events.Event) ([]events.Event, error) {
 			return p.appendEvents(runID, evts)
 		},
 		DrainCommands: func() error {
 			return p.drainPendingCommands(runID)
 		},
-		WriteMCPConfig: func(callIndex int, statuses []string) error {
-			return WriteMCPConfig(state.WorkspacePath, p.store.DBPath(), runID, callIndex, statuses)
+		WriteMCPConfig: func(agent string, callIndex int, statuses []string) error {
+			return WriteMCPConfig(state.WorkspacePath, p.store.DBPath(), runID, agent, callIndex, statuses)
+		},
+		StartWorkflow: func(name, prompt string, callIndex int) (int64, error) {
+			return p.startChildRun(state, name, prompt, callIndex)
+		},
+		AwaitRun: func(childID int64) (*events.RunState, error) {
+			<-p.ProcessRunSync(childID)
+			return p.store.ProjectRunFromDB(childID)
 		},
 	}
+	if p.replayProgress != nil {
+		deps.Progress = func(progress workflow.ReplayProgress) {
+			p.replayProgress(runID, progress)
+		}
+	}
 
 	rt := workflow.NewRuntime(deps)
-	err = rt.Execute(state.WorkflowPath, state.InitialPrompt)
+	if state.WorkflowSource != "" {
+		err = rt.ExecuteSource(state.WorkflowSource, state.InitialPrompt)
+	} else {
+		err = rt.Execute(state.WorkflowPath, state.InitialPrompt)
+	}
+
+	// A kill from another process already settled the run; don't let the
+	// runtime's outcome (usually the killed agent's failure) replace it
+	if current, stateErr := p.store.ProjectRunFromDB(runID); stateErr == nil && current.Status == events.RunStatusKilled {
+		return nil
+	}
+
+	if err == workflow.ErrPaused {
+		info := rt.GetPauseInfo()
+		evt, _ := events.NewEvent(runID, events.EventRunPaused, events.RunPausedPayload{
+			AgentName: info.Agent,
+			CallIndex: info.CallIndex,
+			Reason:    info.Reason,
+		})
+		_, appendErr := p.appendEvents(runID, []events.Event{evt})
+		return appendErr
+	}
 
 	if err == workflow.ErrWaitingHuman {
 		info := rt.GetWaitingInfo()
@@ -82,25 +187,161 @@ func (p *Processor) handleExecuteWorkflow(runID int64, cmd events.CommandRow) er
 		return nil
 	}
 
-	if err != nil {
-		if rt.IsStuck() {
-			evt, _ := events.NewEvent(runID, events.EventRunStuck, events.RunStuckPayload{
-				Reason: rt.StuckReason(),
-			})
-			p.appendEvents(runID, []events.Event{evt})
-			return nil
+	if rt.IsStuck() {
+		evt, _ := events.NewEvent(runID, events.EventRunStuck, events.RunStuckPayload{
+			Reason: rt.StuckReason(),
+		})
+		if _, err := p.appendEvents(runID, []events.Event{evt}); err == nil {
+			p.summarize(runID)
 		}
+		return nil
+	}
+
+	if err != nil {
 		evt, _ := events.NewEvent(runID, events.EventRunFailed, events.RunFailedPayload{
 			Error: err.Error(),
 		})
-		p.appendEvents(runID, []events.Event{evt})
+		if _, err := p.appendEvents(runID, []events.Event{evt}); err == nil {
+			p.summarize(runID)
+		}
 		return nil
 	}
 
 	// Success
 	evt, _ := events.NewEvent(runID, events.EventRunCompleted, events.RunCompletedPayload{})
-	_, appendErr := p.appendEvents(runID, []events.Event{evt})
-	return appendErr
+	if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+		return err
+	}
+	p.summarize(runID)
+	p.cleanupOnSuccess(runID)
+	return nil
+}
+
+// startChildRun creates and submits the run of workflow name that a
+// run_workflow() call in parent makes at callIndex. The script is looked up
+// beside the parent's, like use() libraries.
+func (p *Processor) startChildRun(parent *events.RunState, name, prompt string, callIndex int) (int64, error) {
+	path := filepath.Join(filepath.Dir(parent.WorkflowPath), name+".js")
+	if _, err := os.Stat(path); parent.WorkflowPath == "" || err != nil {
+		return 0, fmt.Errorf("workflow %s.js not found beside %s", name, parent.WorkflowPath)
+	}
+	childID, err := p.store.CreateChildRun(parent.ID)
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := NewCommand(childID, CmdStartRun, StartRunPayload{
+		WorkflowPath:    path,
+		WorkflowName:    name,
+		InitialPrompt:   prompt,
+		AllowEmpty:      true,
+		ParentRunID:     parent.ID,
+		ParentCallIndex: callIndex,
+		KeepGoing:       parent.KeepGoing,
+		Priority:        parent.Priority,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return childID, p.SubmitCommand(cmd)
+}
+
+// parentWorkspace is the workspace a child run shares with its parent.
+func (p *Processor) parentWorkspace(parentID int64) (*workspace.Workspace, error) {
+	parent, err := p.store.ProjectRunFromDB(parentID)
+	if err != nil {
+		return nil, fmt.Errorf("parent run %d: %w", parentID, err)
+	}
+	ws, err := workspace.OpenPath(parent.WorkspacePath, parentID)
+	if err != nil {
+		return nil, err
+	}
+	ws.Branch, ws.BaseCommit = parent.Branch, parent.BaseCommit
+	return ws, nil
+}
+
+// runInUse reports whether a run may still need its workspace: anything
+// short of a terminal status, or a run that can't be read.
+func (p *Processor) runInUse(runID int64) bool {
+	state, err := p.store.ProjectRunFromDB(runID)
+	return err != nil || !state.Status.IsTerminal()
+}
+
+func isShared(workspacePath string) bool {
+	_, ok := workspace.SharedOwner(workspacePath)
+	return ok
+}
+
+// checkSharedOwner fails if the run's workspace is shared and another run
+// has claimed it since; its repo no longer holds this run's work.
+func checkSharedOwner(state *events.RunState) error {
+	if owner, ok := workspace.SharedOwner(state.WorkspacePath); ok && owner != state.ID {
+		return fmt.Errorf("shared workspace %s has since been reused by run %d", state.WorkspacePath, owner)
+	}
+	return nil
+}
+
+// summarize records the digest of a finished run's agent summaries and
+// writes it to summary.md in the workspace. Failures are only logged: the
+// run's outcome is already recorded.
+func (p *Processor) summarize(runID int64) {
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		log.Printf("processor: summarize run %d: %v", runID, err)
+		return
+	}
+	summary := state.AgentSummaries()
+
+	evt, _ := events.NewEvent(runID, events.EventRunSummarized, events.RunSummarizedPayload{Summary: summary})
+	if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+		log.Printf("processor: summarize run %d: %v", runID, err)
+		return
+	}
+
+	if state.WorkspacePath == "" {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Run #%d: %s\n\n", state.ID, state.WorkflowName)
+	fmt.Fprintf(&b, "**Status:** %s\n", state.Status)
+	if state.Error != "" {
+		fmt.Fprintf(&b, "**Error:** %s\n", state.Error)
+	} else if state.Status == events.RunStatusStuck && state.WaitingReason != "" {
+		fmt.Fprintf(&b, "**Reason:** %s\n", state.WaitingReason)
+	}
+	fmt.Fprintf(&b, "**Task:** %s\n\n## Agents\n\n", state.InitialPrompt)
+	if summary == "" {
+		b.WriteString("No agent reported a summary.\n")
+	}
+	for _, line := range strings.Split(summary, "\n") {
+		if line != "" {
+			b.WriteString("- " + line + "\n")
+		}
+	}
+	if err := os.WriteFile(filepath.Join(state.WorkspacePath, "summary.md"), []byte(b.String()), 0644); err != nil {
+		log.Printf("processor: write summary for run %d: %v", runID, err)
+	}
+}
+
+// libDir is where a workflow's use() libraries live: lib/ beside the script.
+func libDir(workflowPath string) string {
+	if workflowPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(workflowPath), "lib")
+}
+
+// instructionDirs are where agents' role instructions are looked up:
+// .shop/agents in the run's worktree, then an agents directory next to the
+// workflows directory the script came from (e.g. ~/.shop/agents).
+func instructionDirs(state *events.RunState) []string {
+	var dirs []string
+	if state.WorkspacePath != "" {
+		dirs = append(dirs, filepath.Join(state.WorkspacePath, "repo", ".shop", "agents"))
+	}
+	if wfDir := filepath.Dir(state.WorkflowPath); state.WorkflowPath != "" && filepath.Base(wfDir) == "workflows" {
+		dirs = append(dirs, filepath.Join(filepath.Dir(wfDir), "agents"))
+	}
+	return dirs
 }
 
 func (p *Processor) handleReportSignal(runID int64, cmd events.CommandRow) error {
@@ -120,6 +361,23 @@ func (p *Processor) handleReportSignal(runID int64, cmd events.CommandRow) error
 		}
 	}
 
+	// A session left over from before a handoff, reset or replay mismatch
+	// must not overwrite the signal of the agent now at its call index
+	if payload.AgentName != "" {
+		state, err := p.store.ProjectRunFromDB(runID)
+		if err != nil {
+			return err
+		}
+		if mismatch := signalMismatch(state, payload.AgentName, payload.CallIndex); mismatch != "" {
+			refused := fmt.Errorf("ignored %s signal from %s: %s", payload.Status, payload.AgentName, mismatch)
+			logEvt, _ := events.NewEvent(runID, events.EventLogMessage, events.LogMessagePayload{Message: refused.Error(), Level: events.LogLevelWarn})
+			if _, err := p.appendEvents(runID, []events.Event{logEvt}); err != nil {
+				return err
+			}
+			return refused
+		}
+	}
+
 	evt, _ := events.NewEvent(runID, events.EventSignalReceived, events.SignalReceivedPayload{
 		CallIndex: payload.CallIndex,
 		Signal:    signal,
@@ -128,29 +386,130 @@ func (p *Processor) handleReportSignal(runID int64, cmd events.CommandRow) error
 	return err
 }
 
+// signalMismatch says why agent may not report a signal for callIndex: the
+// call has no execution, or another agent now runs it. Empty if it may.
+func signalMismatch(state *events.RunState, agent string, callIndex int) string {
+	exec := state.GetExecutionByCallIndex(callIndex)
+	switch {
+	case exec == nil:
+		return fmt.Sprintf("call %d has no current execution", callIndex)
+	case exec.AgentName != agent:
+		return fmt.Sprintf("call %d is now run by %s", callIndex, exec.AgentName)
+	}
+	return ""
+}
+
 func (p *Processor) handleResumeRun(runID int64, cmd events.CommandRow) error {
+	var payload ResumeRunPayload
+	json.Unmarshal(cmd.Payload, &payload)
+
+	evts := []events.Event{}
+	if payload.Repo != "" {
+		state, err := p.store.ProjectRunFromDB(runID)
+		if err != nil {
+			return err
+		}
+		if state.WorkspacePath == "" || state.Branch == "" {
+			return fmt.Errorf("run %d has no worktree to relink", runID)
+		}
+		if err := workspace.Relink(state.WorkspacePath, payload.Repo, state.Branch); err != nil {
+			return fmt.Errorf("relink workspace: %w", err)
+		}
+		logEvt, _ := events.NewEvent(runID, events.EventLogMessage, events.LogMessagePayload{
+			Message: fmt.Sprintf("worktree relinked to %s", payload.Repo),
+		})
+		evts = append(evts, logEvt)
+	}
+
 	evt, _ := events.NewEvent(runID, events.EventRunResumed, events.RunResumedPayload{})
-	if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+	if _, err := p.appendEvents(runID, append(evts, evt)); err != nil {
 		return err
 	}
-	return p.submitInternalCommand(runID, CmdExecuteWorkflow, ExecuteWorkflowPayload{})
+	return p.submitInternalCommand(runID, CmdExecuteWorkflow, ExecuteWorkflowPayload{Until: payload.Until, Strict: payload.Strict})
 }
 
 func (p *Processor) handleKillRun(runID int64, cmd events.CommandRow) error {
+	var payload KillRunPayload
+	json.Unmarshal(cmd.Payload, &payload)
+
 	state, err := p.store.ProjectRunFromDB(runID)
 	if err != nil {
 		return err
 	}
+	if state.Status.IsTerminal() {
+		return fmt.Errorf("run %d has already finished (status: %s)", runID, state.Status)
+	}
 
 	if pid := state.ActivePID(); pid > 0 {
 		p.processManager.Kill(pid)
 	}
 
-	evt, _ := events.NewEvent(runID, events.EventRunKilled, events.RunKilledPayload{})
+	reason := payload.Reason
+	if reason == "" {
+		reason = "Killed by user"
+	}
+
+	evt, _ := events.NewEvent(runID, events.EventRunKilled, events.RunKilledPayload{Reason: reason})
+	_, err = p.appendEvents(runID, []events.Event{evt})
+	return err
+}
+
+// handlePauseRun records a pause request; the runtime honours it before the
+// next fresh agent run, so the current agent is never interrupted.
+func (p *Processor) handlePauseRun(runID int64, cmd events.CommandRow) error {
+	var payload PauseRunPayload
+	json.Unmarshal(cmd.Payload, &payload)
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != events.RunStatusRunning {
+		return fmt.Errorf("run %d is not running (status: %s)", runID, state.Status)
+	}
+	if state.PauseRequested {
+		return nil
+	}
+
+	evt, _ := events.NewEvent(runID, events.EventPauseRequested, events.PauseRequestedPayload{Reason: payload.Reason})
+	_, err = p.appendEvents(runID, []events.Event{evt})
+	return err
+}
+
+// handleSetRunMetadata records metadata on a run in any state but deleted.
+// It is applied mid-agent too, so a running run's metadata can be set.
+func (p *Processor) handleSetRunMetadata(runID int64, cmd events.CommandRow) error {
+	var payload SetRunMetadataPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+	if err := ValidateMetadata(payload.Metadata); err != nil {
+		return err
+	}
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status == events.RunStatusDeleted {
+		return fmt.Errorf("run %d is deleted", runID)
+	}
+
+	evt, _ := events.NewEvent(runID, events.EventRunMetadataSet, events.RunMetadataSetPayload{Metadata: payload.Metadata})
 	_, err = p.appendEvents(runID, []events.Event{evt})
 	return err
 }
 
+// ValidateMetadata rejects metadata with an empty or padded key.
+func ValidateMetadata(metadata map[string]string) error {
+	for k := range metadata {
+		if k == "" || strings.TrimSpace(k) != k {
+			return fmt.Errorf("invalid metadata key %q", k)
+		}
+	}
+	return nil
+}
+
 func (p *Processor) handleStopRun(runID int64, cmd events.CommandRow) error {
 	var payload StopRunPayload
 	json.Unmarshal(cmd.Payload, &payload)
@@ -179,26 +538,8 @@ func (p *Processor) handleDeleteRun(runID int64, cmd events.CommandRow) error {
 		return err
 	}
 
-	// Clean up workspace
-	if state.WorkspacePath != "" {
-		repoPath := state.WorkspacePath + "/repo"
-		branchName := fmt.Sprintf("shop/run-%d", runID)
-
-		sourceRepo := findSourceRepo(repoPath)
-		if sourceRepo != "" {
-			gitCmd := exec.Command("git", "worktree", "remove", "--force", repoPath)
-			gitCmd.Dir = sourceRepo
-			gitCmd.CombinedOutput()
-
-			gitCmd = exec.Command("git", "branch", "-D", branchName)
-			gitCmd.Dir = sourceRepo
-			gitCmd.CombinedOutput()
-		}
-
-		trashCmd := exec.Command("trash", state.WorkspacePath)
-		if err := trashCmd.Run(); err != nil {
-			os.RemoveAll(state.WorkspacePath)
-		}
+	if ws := ownedWorkspace(state); ws != nil {
+		ws.Remove()
 	}
 
 	evt, _ := events.NewEvent(runID, events.EventRunDeleted, events.RunDeletedPayload{})
@@ -206,6 +547,52 @@ func (p *Processor) handleDeleteRun(runID int64, cmd events.CommandRow) error {
 	return err
 }
 
+// ownedWorkspace returns the workspace that is the run's own to clean up,
+// or nil: a shared one outlives its runs, and a child run's belongs to its
+// parent.
+func ownedWorkspace(state *events.RunState) *workspace.Workspace {
+	if state.WorkspacePath == "" || isShared(state.WorkspacePath) || state.ParentRunID != 0 {
+		return nil
+	}
+	branch := state.Branch
+	if branch == "" {
+		// Runs started before branches were recorded used the un-namespaced name
+		branch = workspace.BranchName("", state.ID)
+	}
+	return &workspace.Workspace{
+		Path:     state.WorkspacePath,
+		RepoPath: filepath.Join(state.WorkspacePath, "repo"),
+		Branch:   branch,
+	}
+}
+
+// cleanupOnSuccess removes a completed run's worktree when its script sets
+// cleanup_on_success. The branch, summary and scratchpads stay.
+func (p *Processor) cleanupOnSuccess(runID int64) {
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		log.Printf("processor: cleanup run %d: %v", runID, err)
+		return
+	}
+	if settings, _ := workflow.LoadSettings(state.WorkflowSource); !settings.CleanupOnSuccess {
+		return
+	}
+	ws := ownedWorkspace(state)
+	if ws == nil || state.Branch == "" {
+		return
+	}
+
+	msg := fmt.Sprintf("cleanup_on_success: removed worktree %s; branch %s kept", ws.RepoPath, ws.Branch)
+	level := events.LogLevelInfo
+	if err := ws.RemoveWorktree(); err != nil {
+		msg, level = fmt.Sprintf("cleanup_on_success: %v", err), events.LogLevelWarn
+	}
+	evt, _ := events.NewEvent(runID, events.EventLogMessage, events.LogMessagePayload{Message: msg, Level: level})
+	if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+		log.Printf("processor: cleanup run %d: %v", runID, err)
+	}
+}
+
 func (p *Processor) handleProvideHumanInput(runID int64, cmd events.CommandRow) error {
 	var payload ProvideHumanInputPayload
 	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
@@ -231,26 +618,450 @@ func (p *Processor) handleProvideHumanInput(runID int64, cmd events.CommandRow)
 	return p.submitInternalCommand(runID, CmdResumeRun, ResumeRunPayload{})
 }
 
-// ContinueRun returns session ID and work dir for a waiting run.
-func (p *Processor) ContinueRun(runID int64) (sessionID string, workDir string, err error) {
+func (p *Processor) handleRecoverRun(runID int64, cmd events.CommandRow) error {
+	var payload RecoverRunPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+
 	state, err := p.store.ProjectRunFromDB(runID)
 	if err != nil {
-		return "", "", err
+		return err
+	}
+	if state.Status != events.RunStatusStuck && state.Status != events.RunStatusFailed {
+		return fmt.Errorf("run %d is not stuck or failed (status: %s)", runID, state.Status)
+	}
+
+	last := state.LastWorkflowExecution()
+
+	switch payload.Action {
+	case RecoverRetry:
+		if last != nil {
+			evt, _ := events.NewEvent(runID, events.EventReplayInvalidated, events.ReplayInvalidatedPayload{
+				FromCallIndex: last.CallIndex,
+				Reason:        fmt.Sprintf("retry %s requested", last.AgentName),
+			})
+			if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+				return err
+			}
+		}
+		return p.submitInternalCommand(runID, CmdResumeRun, ResumeRunPayload{})
+
+	case RecoverSignal:
+		if last == nil {
+			return fmt.Errorf("run %d has no execution to signal", runID)
+		}
+		if payload.Agent != "" && last.AgentName != payload.Agent {
+			return fmt.Errorf("run %d: last execution is %s, not %s", runID, last.AgentName, payload.Agent)
+		}
+		if _, ok := payload.Signal["status"].(string); !ok {
+			return fmt.Errorf("signal must include a string status")
+		}
+		evt, _ := events.NewEvent(runID, events.EventHumanInputReceived, events.HumanInputReceivedPayload{
+			CallIndex: last.CallIndex,
+			Signal:    payload.Signal,
+		})
+		if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+			return err
+		}
+		return p.submitInternalCommand(runID, CmdResumeRun, ResumeRunPayload{})
+
+	case RecoverComplete:
+		evt, _ := events.NewEvent(runID, events.EventRunCompleted, events.RunCompletedPayload{})
+		if _, err := p.appendEvents(runID, []events.Event{evt}); err != nil {
+			return err
+		}
+		p.summarize(runID)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown recover action: %q", payload.Action)
+	}
+}
+
+// handleHandoffRun abandons the execution a waiting run is blocked on and
+// re-enters the workflow with a different agent at that call_index.
+func (p *Processor) handleHandoffRun(runID int64, cmd events.CommandRow) error {
+	var payload HandoffRunPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != events.RunStatusWaitingHuman {
+		return fmt.Errorf("run %d is not waiting for human input (status: %s)", runID, state.Status)
+	}
+
+	var waiting *events.ExecutionState
+	for i := range state.Executions {
+		if state.Executions[i].Status == events.ExecStatusWaitingHuman {
+			waiting = &state.Executions[i]
+		}
+	}
+	if waiting == nil {
+		return fmt.Errorf("run %d has no waiting execution to hand off", runID)
+	}
+	if waiting.AgentName == "_checkpoint" {
+		return fmt.Errorf("run %d is paused at a checkpoint; use 'shop continue' to resolve it", runID)
+	}
+
+	return p.reroute(state, waiting, payload.Agent, "handoff")
+}
+
+// handleStepRun manually forces the agent for a stuck or waiting run's last
+// call, bypassing the script's choice once, and resumes the workflow there.
+func (p *Processor) handleStepRun(runID int64, cmd events.CommandRow) error {
+	var payload StepRunPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != events.RunStatusStuck && state.Status != events.RunStatusWaitingHuman {
+		return fmt.Errorf("run %d is not stuck or waiting (status: %s)", runID, state.Status)
+	}
+
+	last := state.LastWorkflowExecution()
+	if last == nil {
+		return fmt.Errorf("run %d has no execution to step from", runID)
+	}
+
+	return p.reroute(state, last, payload.Agent, "manual step")
+}
+
+// reroute records an AgentHandedOff for exec's call_index and resumes the
+// run so the workflow re-enters that call with agent.
+func (p *Processor) reroute(state *events.RunState, exec *events.ExecutionState, agent, reason string) error {
+	if err := ValidateAgent(state, agent); err != nil {
+		return err
+	}
+
+	evt, _ := events.NewEvent(state.ID, events.EventAgentHandedOff, events.AgentHandedOffPayload{
+		CallIndex: exec.CallIndex,
+		FromAgent: exec.AgentName,
+		ToAgent:   agent,
+		Reason:    reason,
+	})
+	if _, err := p.appendEvents(state.ID, []events.Event{evt}); err != nil {
+		return err
+	}
+	return p.submitInternalCommand(state.ID, CmdResumeRun, ResumeRunPayload{})
+}
+
+// handleResetRun discards a run's executions so the next resume starts the
+// workflow from its first call, keeping the run and its workspace.
+func (p *Processor) handleResetRun(runID int64, cmd events.CommandRow) error {
+	var payload ResetRunPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case state.Status == events.RunStatusDeleted:
+		return fmt.Errorf("run %d is deleted", runID)
+	case state.Status == events.RunStatusRunning || state.ActivePID() > 0:
+		return fmt.Errorf("run %d is running; kill it before resetting", runID)
+	case state.WorkflowPath == "":
+		return fmt.Errorf("run %d has not started", runID)
+	}
+
+	if payload.Hard && state.WorkspacePath != "" {
+		ws, err := workspace.OpenPath(state.WorkspacePath, runID)
+		if err != nil {
+			return err
+		}
+		if err := checkSharedOwner(state); err != nil {
+			return err
+		}
+		ws.Branch = state.Branch
+		ws.BaseCommit = state.BaseCommit
+		if err := ws.Reset(); err != nil {
+			return fmt.Errorf("reset workspace: %w", err)
+		}
+		scratch := filepath.Join(state.WorkspacePath, "scratchpad")
+		if err := os.RemoveAll(scratch); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(scratch, 0755); err != nil {
+			return err
+		}
+	}
+
+	evt, _ := events.NewEvent(runID, events.EventRunReset, events.RunResetPayload{Hard: payload.Hard})
+	_, err = p.appendEvents(runID, []events.Event{evt})
+	return err
+}
+
+func (p *Processor) handleAdoptRun(runID int64, cmd events.CommandRow) error {
+	var payload AdoptRunPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return err
+	}
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != events.RunStatusPending || state.WorkspacePath != "" {
+		return fmt.Errorf("run %d has already started", runID)
+	}
+
+	var source []byte
+	if payload.WorkflowPath != "" {
+		if source, err = os.ReadFile(payload.WorkflowPath); err != nil {
+			return fmt.Errorf("read workflow: %w", err)
+		}
+	}
+
+	ws, err := workspace.Adopt(p.workspacesDir, p.instanceID, runID, payload.SourceRepo, payload.Branch)
+	if err != nil {
+		return fmt.Errorf("adopt workspace: %w", err)
+	}
+
+	// The earlier executions are unknown, so leave the run stuck for a
+	// human to recover, step or continue by hand
+	started, _ := events.NewEvent(runID, events.EventRunStarted, events.RunStartedPayload{
+		WorkflowPath:   payload.WorkflowPath,
+		WorkflowName:   payload.WorkflowName,
+		InitialPrompt:  payload.InitialPrompt,
+		WorkspacePath:  ws.Path,
+		Branch:         ws.Branch,
+		WorkflowSource: string(source),
+	})
+	stuck, _ := events.NewEvent(runID, events.EventRunStuck, events.RunStuckPayload{
+		Reason: fmt.Sprintf("adopted from branch %s; earlier history is not available", ws.Branch),
+	})
+	_, err = p.appendEvents(runID, []events.Event{started, stuck})
+	return err
+}
+
+// ValidatePrompt rejects empty and whitespace-only prompts: the first agent
+// would be started with nothing to do.
+func ValidatePrompt(prompt string) error {
+	if strings.TrimSpace(prompt) == "" {
+		return fmt.Errorf("the prompt is empty; describe the task (or pass --allow-empty)")
+	}
+	return nil
+}
+
+// ValidateAgent rejects empty and reserved ("_"-prefixed) agent names, and
+// names with no .claude/agents/{name}.md when the worktree defines agents.
+func ValidateAgent(state *events.RunState, agent string) error {
+	if agent == "" {
+		return fmt.Errorf("an agent name is required")
+	}
+	if strings.HasPrefix(agent, "_") || strings.ContainsAny(agent, `/\`) {
+		return fmt.Errorf("invalid agent name %q", agent)
+	}
+	if state.WorkspacePath == "" {
+		return nil
+	}
+	agentsDir := filepath.Join(state.WorkspacePath, "repo", ".claude", "agents")
+	if _, err := os.Stat(agentsDir); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(agentsDir, agent+".md")); err != nil {
+		return fmt.Errorf("unknown agent %q: no %s.md in %s", agent, agent, agentsDir)
+	}
+	return nil
+}
+
+// KillRun kills a run that hasn't finished and waits for the kill to be
+// processed.
+func (p *Processor) KillRun(runID int64, reason string) error {
+	cmd, err := NewCommand(runID, CmdKillRun, KillRunPayload{Reason: reason})
+	if err != nil {
+		return err
+	}
+	if err := p.SubmitCommand(cmd); err != nil {
+		return err
+	}
+	<-p.ProcessRunSync(runID)
+
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != events.RunStatusKilled {
+		return fmt.Errorf("run %d was not killed (status: %s)", runID, state.Status)
+	}
+	return nil
+}
+
+// DeleteRun deletes a run and waits for the delete to be processed. With
+// children, the runs it spawned (and theirs) are deleted first.
+func (p *Processor) DeleteRun(runID int64, children bool) error {
+	if children {
+		kids, err := p.store.ListChildRuns(runID)
+		if err != nil {
+			return err
+		}
+		for _, kid := range kids {
+			if err := p.DeleteRun(kid.ID, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	cmd, err := NewCommand(runID, CmdDeleteRun, DeleteRunPayload{})
+	if err != nil {
+		return err
+	}
+	if err := p.SubmitCommand(cmd); err != nil {
+		return err
+	}
+	<-p.ProcessRunSync(runID)
+	return nil
+}
+
+// ContinueSession is how to open a Claude session for a waiting run: by
+// resuming the waiting agent's session or, when it left none (it never
+// reported one, or it wasn't recorded), by starting a fresh session seeded
+// with what the agent asked and the run's context.
+type ContinueSession struct {
+	Agent     string
+	WorkDir   string
+	SessionID string // empty when starting fresh
+	Prompt    string // seeds a fresh session; empty when resuming
+	MCPConfig string // report_signal for the waiting call
+
+	// SkipPermissions is the workflow's skip_permissions setting, which
+	// headless answers need: nobody is there to grant permissions.
+	SkipPermissions bool
+}
+
+// Fresh reports whether there is no session to resume.
+func (s *ContinueSession) Fresh() bool { return s.SessionID == "" }
+
+// Args returns the claude arguments that open the session interactively.
+func (s *ContinueSession) Args() []string {
+	if !s.Fresh() {
+		return []string{"--resume", s.SessionID}
+	}
+	return append(s.agentArgs(), s.Prompt)
+}
+
+// HeadlessArgs returns the claude arguments that send message to the
+// session with -p. Nobody is there to grant permissions, so they're
+// skipped, and a workflow that keeps permission prompts is refused.
+func (s *ContinueSession) HeadlessArgs(message string) ([]string, error) {
+	if !s.SkipPermissions {
+		return nil, fmt.Errorf("the workflow sets skip_permissions: false, so %s's session can't run headless; answer it interactively with 'shop continue' in a terminal", s.Agent)
+	}
+	if !s.Fresh() {
+		prompt := message + "\n\nWhen you have finished, call the `report_signal` tool again with your updated status."
+		return []string{"--resume", s.SessionID, "-p", prompt, "--dangerously-skip-permissions", "--mcp-config", s.MCPConfig}, nil
+	}
+	prompt := s.Prompt + "\n\n## The human's answer\n\n" + message
+	return append(s.agentArgs(), "-p", prompt, "--dangerously-skip-permissions"), nil
+}
+
+func (s *ContinueSession) agentArgs() []string {
+	var args []string
+	if !strings.HasPrefix(s.Agent, "_") { // pause() checkpoints have no agent definition
+		args = append(args, "--agent", s.Agent)
+	}
+	return append(args, "--mcp-config", s.MCPConfig)
+}
+
+// ContinueRun returns how to open a Claude session for a waiting run.
+func (p *Processor) ContinueRun(runID int64) (*ContinueSession, error) {
+	state, err := p.store.ProjectRunFromDB(runID)
+	if err != nil {
+		return nil, err
 	}
 	if state.Status != events.RunStatusWaitingHuman {
-		return "", "", fmt.Errorf("run %d is not waiting for human input (status: %s)", runID, state.Status)
+		return nil, fmt.Errorf("run %d is not waiting for human input (status: %s)", runID, state.Status)
+	}
+	if !workspace.Exists(state.WorkspacePath) {
+		return nil, fmt.Errorf("workspace %s of run %d no longer exists; 'shop delete %d' removes the run", state.WorkspacePath, runID, runID)
+	}
+	agent := state.CurrentAgent
+	if waiting := state.WaitingExecution(); waiting != nil {
+		agent = waiting.AgentName
+	}
+	settings, _ := workflow.LoadSettings(state.WorkflowSource)
+	s := &ContinueSession{
+		Agent:           agent,
+		WorkDir:         filepath.Join(state.WorkspacePath, "repo"),
+		SessionID:       state.WaitingSessionID,
+		MCPConfig:       filepath.Join(state.WorkspacePath, "mcp.json"),
+		SkipPermissions: settings.SkipsPermissions(),
+	}
+	if s.Fresh() {
+		s.Prompt = freshSessionPrompt(state, agent)
+	}
+	return s, nil
+}
+
+// freshSessionPrompt briefs a new session on the waiting agent, whose own
+// session can't be resumed: the task it was sent and what it asked.
+func freshSessionPrompt(state *events.RunState, agent string) string {
+	task := state.InitialPrompt
+	if e := state.WaitingExecution(); e != nil {
+		if e.SentPrompt != "" {
+			task = e.SentPrompt
+		} else if e.Prompt != "" {
+			task = e.Prompt
+		}
 	}
-	if state.WaitingSessionID == "" {
-		return "", "", fmt.Errorf("run %d has no session ID to resume", runID)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are taking over from a session of the %s agent in run #%d. It stopped to ask a human for input, but its session can't be resumed.\n\n", agent, state.ID)
+	fmt.Fprintf(&b, "## Its task\n\n%s\n\n", task)
+	fmt.Fprintf(&b, "## What it asked\n\n%s\n\n", state.WaitingReason)
+	if state.ContextHeader != "" && !strings.Contains(task, state.ContextHeader) {
+		fmt.Fprintf(&b, "## Run context\n\n%s\n\n", strings.TrimSpace(state.ContextHeader))
 	}
-	return state.WaitingSessionID, filepath.Join(state.WorkspacePath, "repo"), nil
+	b.WriteString("Check the repository for the work done so far, resolve the question with the human, then call the `report_signal` tool with your status.")
+	return b.String()
 }
 
 // TryResumeAfterHuman checks if a waiting run's signal changed and auto-resumes.
 func (p *Processor) TryResumeAfterHuman(runID int64) error {
+	cmd, ok, err := p.humanInputCommand(runID)
+	if err != nil || !ok {
+		return err
+	}
+	p.ensureRunGoroutine(runID)
+	return p.SubmitCommand(cmd)
+}
+
+// ResumeAfterHumanSync is like TryResumeAfterHuman but processes the run in
+// the caller's process. It returns a channel that closes when the run
+// settles, or false if the agent hasn't reported a new signal yet.
+func (p *Processor) ResumeAfterHumanSync(runID int64) (<-chan struct{}, bool, error) {
+	// No run goroutine has seen the signal the agent reported during the
+	// session yet, so apply it before checking.
+	if err := p.drainPendingCommands(runID); err != nil {
+		return nil, false, err
+	}
+	cmd, ok, err := p.humanInputCommand(runID)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if err := p.SubmitCommand(cmd); err != nil {
+		return nil, false, err
+	}
+	return p.ProcessRunSync(runID), true, nil
+}
+
+// humanInputCommand builds the ProvideHumanInput command for a waiting run
+// whose agent has reported a non-STUCK signal since pausing.
+func (p *Processor) humanInputCommand(runID int64) (Command, bool, error) {
 	state, err := p.store.ProjectRunFromDB(runID)
 	if err != nil || state.Status != events.RunStatusWaitingHuman {
-		return nil
+		return Command{}, false, nil
 	}
 
 	// Find the waiting execution
@@ -264,17 +1075,16 @@ func (p *Processor) TryResumeAfterHuman(runID int64) error {
 						Signal:    exec.Signal,
 					})
 					if err != nil {
-						return err
+						return Command{}, false, err
 					}
-					p.ensureRunGoroutine(runID)
-					return p.SubmitCommand(cmd)
+					return cmd, true, nil
 				}
 			}
 			break
 		}
 	}
 
-	return nil
+	return Command{}, false, nil
 }
 
 // ResumeSession opens a Claude session in interactive mode.
@@ -286,25 +1096,6 @@ func ResumeSession(sessionID string) error {
 	return cmd.Start()
 }
 
-// findSourceRepo extracts the main repo path from a worktree's .git file.
-func findSourceRepo(worktreePath string) string {
-	gitFile := filepath.Join(worktreePath, ".git")
-	data, err := os.ReadFile(gitFile)
-	if err != nil {
-		return ""
-	}
-	content := string(data)
-	if !strings.HasPrefix(content, "gitdir: ") {
-		return ""
-	}
-	gitDir := strings.TrimSpace(content[8:])
-	idx := strings.LastIndex(gitDir, "/.git/")
-	if idx == -1 {
-		return ""
-	}
-	return gitDir[:idx]
-}
-
 // GetStore returns the underlying event store.
 func (p *Processor) GetStore() *events.Store {
 	return p.store
@@ -0,0 +1,293 @@
+package commands
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+func tempProcessor(t *testing.T) (*Processor, *events.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := events.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return NewProcessor(store, nil, filepath.Join(dir, "workspaces"), ""), store
+}
+
+// seedRun creates a run and appends the given events to it.
+func seedRun(t *testing.T, store *events.Store, evts ...events.Event) int64 {
+	t.Helper()
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range evts {
+		evts[i].RunID = runID
+	}
+	if _, err := store.AppendEvents(runID, 0, evts); err != nil {
+		t.Fatal(err)
+	}
+	return runID
+}
+
+func commandRow(t *testing.T, runID int64, cmdType CommandType, payload any) events.CommandRow {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.CommandRow{ID: events.NewID(), RunID: runID, CommandType: string(cmdType), Payload: data, CreatedAt: time.Now()}
+}
+
+func failedRunEvents() []events.Event {
+	return []events.Event{
+		events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test"}),
+		events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+		events.MustNewEvent(0, events.EventAgentFailed, events.AgentFailedPayload{AgentName: "coder", CallIndex: 1, Error: "no signal"}),
+		events.MustNewEvent(0, events.EventRunFailed, events.RunFailedPayload{Error: "agent coder failed"}),
+	}
+}
+
+func pendingTypes(t *testing.T, store *events.Store, runID int64) []string {
+	t.Helper()
+	cmds, err := store.GetPendingCommands(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, c := range cmds {
+		types = append(types, c.CommandType)
+	}
+	return types
+}
+
+func TestRecoverRetryInvalidatesLastExecution(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store, failedRunEvents()...)
+
+	err := p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{Action: RecoverRetry}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := store.ProjectRunFromDB(runID)
+	if state.Executions[0].Status != events.ExecStatusInvalidated {
+		t.Fatalf("expected invalidated execution, got %s", state.Executions[0].Status)
+	}
+	if got := pendingTypes(t, store, runID); len(got) != 1 || got[0] != string(CmdResumeRun) {
+		t.Fatalf("expected a pending ResumeRun, got %v", got)
+	}
+}
+
+func TestRecoverSignalCompletesLastExecution(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store, failedRunEvents()...)
+
+	err := p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{
+		Action: RecoverSignal,
+		Signal: map[string]any{"status": "DONE", "summary": "fixed by hand"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := store.ProjectRunFromDB(runID)
+	exec := state.GetExecutionByCallIndex(1)
+	if exec.Status != events.ExecStatusCompleted || exec.Signal["status"] != "DONE" {
+		t.Fatalf("expected completed DONE execution, got %s %v", exec.Status, exec.Signal)
+	}
+	if got := pendingTypes(t, store, runID); len(got) != 1 || got[0] != string(CmdResumeRun) {
+		t.Fatalf("expected a pending ResumeRun, got %v", got)
+	}
+
+	// A signal without a status is rejected
+	runID = seedRun(t, store, failedRunEvents()...)
+	err = p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{
+		Action: RecoverSignal, Signal: map[string]any{"summary": "no status"},
+	}))
+	if err == nil {
+		t.Fatal("expected error for signal without status")
+	}
+}
+
+func TestRecoverSignalChecksAgent(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store, failedRunEvents()...)
+
+	err := p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{
+		Action: RecoverSignal, Agent: "reviewer", Signal: map[string]any{"status": "DONE"},
+	}))
+	if err == nil || !strings.Contains(err.Error(), "last execution is coder") {
+		t.Fatalf("expected agent mismatch error, got %v", err)
+	}
+	if got := pendingTypes(t, store, runID); len(got) != 0 {
+		t.Fatalf("expected nothing submitted, got %v", got)
+	}
+}
+
+func TestReportSignalRefusesStaleAgent(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store,
+		events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test"}),
+		events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+		events.MustNewEvent(0, events.EventAgentHandedOff, events.AgentHandedOffPayload{CallIndex: 1, FromAgent: "coder", ToAgent: "senior"}),
+		events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "senior", CallIndex: 1}),
+	)
+	report := func(agent string, callIndex int) error {
+		return p.handleReportSignal(runID, commandRow(t, runID, CmdReportSignal, ReportSignalPayload{
+			CallIndex: callIndex, AgentName: agent, Status: "DONE", Signal: map[string]any{"status": "DONE", "summary": agent},
+		}))
+	}
+
+	// The handed-off coder's session still reports for call 1
+	if err := report("coder", 1); err == nil || !strings.Contains(err.Error(), "call 1 is now run by senior") {
+		t.Fatalf("expected the stale signal to be refused, got %v", err)
+	}
+	if err := report("senior", 2); err == nil || !strings.Contains(err.Error(), "call 2 has no current execution") {
+		t.Fatalf("expected a signal for an unknown call to be refused, got %v", err)
+	}
+	state, _ := store.ProjectRunFromDB(runID)
+	if state.Executions[1].Signal != nil {
+		t.Fatalf("expected senior's execution untouched, got %v", state.Executions[1].Signal)
+	}
+	if len(state.LogMessages) != 2 || state.LogMessages[0].Level != events.LogLevelWarn {
+		t.Fatalf("expected each refusal logged as a warning, got %+v", state.LogMessages)
+	}
+
+	if err := report("senior", 1); err != nil {
+		t.Fatal(err)
+	}
+	state, _ = store.ProjectRunFromDB(runID)
+	if state.Executions[1].Signal["summary"] != "senior" {
+		t.Fatalf("expected senior's own signal recorded, got %v", state.Executions[1].Signal)
+	}
+}
+
+func TestRecoverComplete(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store, failedRunEvents()...)
+
+	err := p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{Action: RecoverComplete}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := store.ProjectRunFromDB(runID)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s", state.Status)
+	}
+	if state.Error != "" {
+		t.Fatalf("expected the failure cleared once complete, got %q", state.Error)
+	}
+}
+
+func TestRecoverRejectsActiveRun(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store,
+		events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test"}),
+	)
+
+	err := p.handleRecoverRun(runID, commandRow(t, runID, CmdRecoverRun, RecoverRunPayload{Action: RecoverRetry}))
+	if err == nil {
+		t.Fatal("expected error recovering a running run")
+	}
+}
+
+func TestContinueRunWithoutSessionStartsFresh(t *testing.T) {
+	p, store := tempProcessor(t)
+	ws := t.TempDir()
+	waiting := func(sessionID string) int64 {
+		return seedRun(t, store,
+			events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test", InitialPrompt: "add a flag", WorkspacePath: ws}),
+			events.MustNewEvent(0, events.EventContextInitialized, events.ContextInitializedPayload{Content: "# Run Context\nFollow STYLE.md"}),
+			events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1, SessionID: sessionID, SentPrompt: "implement --verbose"}),
+			// As callAgent records a STUCK signal: the agent completes before the run waits
+			events.MustNewEvent(0, events.EventAgentCompleted, events.AgentCompletedPayload{AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "STUCK", "reason": "which flag name?"}}),
+			events.MustNewEvent(0, events.EventRunWaitingHuman, events.RunWaitingHumanPayload{Reason: "which flag name?", CallIndex: 1, SessionID: sessionID}),
+		)
+	}
+
+	session, err := p.ContinueRun(waiting("s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Fresh() || strings.Join(session.Args(), " ") != "--resume s1" {
+		t.Fatalf("expected the recorded session to be resumed, got %q", session.Args())
+	}
+
+	session, err = p.ContinueRun(waiting(""))
+	if err != nil {
+		t.Fatalf("expected a fallback for a missing session, got %v", err)
+	}
+	if !session.Fresh() || session.WorkDir != filepath.Join(ws, "repo") {
+		t.Fatalf("expected a fresh session in the workspace, got %+v", session)
+	}
+	for _, want := range []string{"coder agent", "implement --verbose", "which flag name?", "Follow STYLE.md", "report_signal"} {
+		if !strings.Contains(session.Prompt, want) {
+			t.Errorf("expected the seed prompt to contain %q:\n%s", want, session.Prompt)
+		}
+	}
+	args := session.Args()
+	if args[0] != "--agent" || args[1] != "coder" || args[len(args)-1] != session.Prompt {
+		t.Fatalf("expected the agent started with the seed prompt, got %q", args)
+	}
+	if headless, err := session.HeadlessArgs("call it --loud"); err != nil || !strings.Contains(headless[len(headless)-2], "call it --loud") {
+		t.Fatalf("expected the answer in the headless prompt, got %q (%v)", headless, err)
+	}
+}
+
+func TestContinueRunHeadlessKeepsPermissionPrompts(t *testing.T) {
+	p, store := tempProcessor(t)
+	runID := seedRun(t, store,
+		events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{
+			WorkflowName: "test", WorkspacePath: t.TempDir(),
+			WorkflowSource: `const settings = { skip_permissions: false }; function workflow(prompt) { run("coder"); }`,
+		}),
+		events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1, SessionID: "s1"}),
+		events.MustNewEvent(0, events.EventRunWaitingHuman, events.RunWaitingHumanPayload{Reason: "which flag name?", CallIndex: 1, SessionID: "s1"}),
+	)
+
+	session, err := p.ContinueRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.HeadlessArgs("call it --loud"); err == nil || !strings.Contains(err.Error(), "skip_permissions: false") {
+		t.Fatalf("expected a headless answer refused, got %v", err)
+	}
+	if strings.Join(session.Args(), " ") != "--resume s1" {
+		t.Fatalf("expected the session still open interactively, got %q", session.Args())
+	}
+}
+
+func TestRunWithMissingWorkspace(t *testing.T) {
+	p, store := tempProcessor(t)
+	ws := filepath.Join(t.TempDir(), "run-1")
+	runID := seedRun(t, store,
+		events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{WorkflowName: "test", WorkspacePath: ws}),
+		events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1, SessionID: "s1"}),
+		events.MustNewEvent(0, events.EventRunWaitingHuman, events.RunWaitingHumanPayload{Reason: "which flag name?", CallIndex: 1, SessionID: "s1"}),
+	)
+
+	_, err := p.ContinueRun(runID)
+	if err == nil || !strings.Contains(err.Error(), "no longer exists") || !strings.Contains(err.Error(), "shop delete") {
+		t.Fatalf("expected an error pointing at shop delete, got %v", err)
+	}
+
+	if err := p.handleDeleteRun(runID, commandRow(t, runID, CmdDeleteRun, DeleteRunPayload{})); err != nil {
+		t.Fatalf("expected the run deleted despite its missing workspace: %v", err)
+	}
+	state, err := store.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != events.RunStatusDeleted {
+		t.Fatalf("expected deleted, got %s", state.Status)
+	}
+}
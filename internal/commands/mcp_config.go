@@ -9,7 +9,7 @@ import (
 )
 
 // WriteMCPConfig writes mcp.json to the workspace root.
-func WriteMCPConfig(workspacePath, dbPath string, runID int64, callIndex int, statuses []string) error {
+func WriteMCPConfig(workspacePath, dbPath string, runID int64, agent string, callIndex int, statuses []string) error {
 	shopBin, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("find shop binary: %w", err)
@@ -23,7 +23,7 @@ func WriteMCPConfig(workspacePath, dbPath string, runID int64, callIndex int, st
 		"mcpServers": map[string]any{
 			"shop": map[string]any{
 				"command": shopBin,
-				"args": mcpServerArgs(dbPath, runID, callIndex, statuses),
+				"args": mcpServerArgs(dbPath, runID, agent, callIndex, statuses),
 			},
 		},
 	}
@@ -36,11 +36,12 @@ func WriteMCPConfig(workspacePath, dbPath string, runID int64, callIndex int, st
 	return os.WriteFile(filepath.Join(workspacePath, "mcp.json"), data, 0644)
 }
 
-func mcpServerArgs(dbPath string, runID int64, callIndex int, statuses []string) []string {
+func mcpServerArgs(dbPath string, runID int64, agent string, callIndex int, statuses []string) []string {
 	args := []string{
 		"mcp-server",
 		"--db", dbPath,
 		"--run-id", fmt.Sprintf("%d", runID),
+		"--agent", agent,
 		"--call-index", fmt.Sprintf("%d", callIndex),
 	}
 	if len(statuses) > 0 {
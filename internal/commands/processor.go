@@ -8,6 +8,7 @@ import (
 
 	"github.com/mpataki/shop/internal/events"
 	"github.com/mpataki/shop/internal/process"
+	"github.com/mpataki/shop/internal/workflow"
 )
 
 // Processor handles commands for all runs.
@@ -15,18 +16,23 @@ type Processor struct {
 	store          *events.Store
 	processManager process.Manager
 	workspacesDir  string
+	instanceID     string
 
 	mu          sync.Mutex
 	activeRuns  map[int64]chan struct{} // notify channels per run
 	subscribers []chan events.Event     // fan-out event subscribers
+
+	replayProgress func(runID int64, progress workflow.ReplayProgress)
 }
 
-// NewProcessor creates a command processor.
-func NewProcessor(store *events.Store, pm process.Manager, workspacesDir string) *Processor {
+// NewProcessor creates a command processor. instanceID namespaces the
+// workspaces and branches it creates (see config.Config.InstanceID).
+func NewProcessor(store *events.Store, pm process.Manager, workspacesDir, instanceID string) *Processor {
 	return &Processor{
 		store:          store,
 		processManager: pm,
 		workspacesDir:  workspacesDir,
+		instanceID:     instanceID,
 		activeRuns:     make(map[int64]chan struct{}),
 	}
 }
@@ -42,6 +48,13 @@ func (p *Processor) Subscribe() <-chan events.Event {
 	return ch
 }
 
+// OnReplayProgress sets fn to be told how far each script executed from
+// here has got replaying its history (see workflow.ReplayProgress). Set it
+// before submitting commands.
+func (p *Processor) OnReplayProgress(fn func(runID int64, progress workflow.ReplayProgress)) {
+	p.replayProgress = fn
+}
+
 // emit sends an event to all subscribers without blocking.
 func (p *Processor) emit(e events.Event) {
 	p.mu.Lock()
@@ -146,7 +159,7 @@ func (p *Processor) waitForTerminal(runID int64) {
 		if err != nil {
 			return
 		}
-		if state.Status.IsTerminal() || state.Status == events.RunStatusWaitingHuman {
+		if isSettled(state.Status) {
 			return
 		}
 	}
@@ -188,17 +201,26 @@ func (p *Processor) processRun(runID int64, notify chan struct{}) {
 			}
 		}
 
-		// Check if run is terminal — if so, exit goroutine
+		// Check if run is settled — if so, exit goroutine once nothing
+		// chained (e.g. a ResumeRun submitted by a handler) is left pending
 		state, err := p.store.ProjectRunFromDB(runID)
 		if err != nil {
 			return
 		}
-		if state.Status.IsTerminal() || state.Status == events.RunStatusWaitingHuman {
-			return
+		if isSettled(state.Status) {
+			if pending, err := p.store.GetPendingCommands(runID); err != nil || len(pending) == 0 {
+				return
+			}
 		}
 	}
 }
 
+// isSettled reports whether a run has nothing left to do until another
+// command arrives: terminal, suspended, or pending (never started, or reset).
+func isSettled(s events.RunStatus) bool {
+	return s.IsTerminal() || s.IsSuspended() || s == events.RunStatusPending
+}
+
 func (p *Processor) handleCommand(runID int64, cmd events.CommandRow) error {
 	cmdType := CommandType(cmd.CommandType)
 	switch cmdType {
@@ -218,6 +240,20 @@ func (p *Processor) handleCommand(runID int64, cmd events.CommandRow) error {
 		return p.handleDeleteRun(runID, cmd)
 	case CmdProvideHumanInput:
 		return p.handleProvideHumanInput(runID, cmd)
+	case CmdRecoverRun:
+		return p.handleRecoverRun(runID, cmd)
+	case CmdHandoffRun:
+		return p.handleHandoffRun(runID, cmd)
+	case CmdStepRun:
+		return p.handleStepRun(runID, cmd)
+	case CmdResetRun:
+		return p.handleResetRun(runID, cmd)
+	case CmdAdoptRun:
+		return p.handleAdoptRun(runID, cmd)
+	case CmdPauseRun:
+		return p.handlePauseRun(runID, cmd)
+	case CmdSetRunMetadata:
+		return p.handleSetRunMetadata(runID, cmd)
 	default:
 		return fmt.Errorf("unknown command type: %s", cmdType)
 	}
@@ -253,13 +289,22 @@ func (p *Processor) drainPendingCommands(runID int64) error {
 	}
 	for _, cmd := range cmds {
 		cmdType := CommandType(cmd.CommandType)
-		// Only drain signal reports during agent execution
-		if cmdType == CmdReportSignal {
-			if err := p.handleReportSignal(runID, cmd); err != nil {
-				p.store.MarkCommandFailed(cmd.ID, err.Error())
-			} else {
-				p.store.MarkCommandProcessed(cmd.ID)
-			}
+		// Only drain signal reports, pause requests and metadata during agent execution
+		var err error
+		switch cmdType {
+		case CmdReportSignal:
+			err = p.handleReportSignal(runID, cmd)
+		case CmdPauseRun:
+			err = p.handlePauseRun(runID, cmd)
+		case CmdSetRunMetadata:
+			err = p.handleSetRunMetadata(runID, cmd)
+		default:
+			continue
+		}
+		if err != nil {
+			p.store.MarkCommandFailed(cmd.ID, err.Error())
+		} else {
+			p.store.MarkCommandProcessed(cmd.ID)
 		}
 	}
 	return nil
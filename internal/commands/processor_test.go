@@ -0,0 +1,2268 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/process"
+	"github.com/mpataki/shop/internal/workflow"
+	"github.com/mpataki/shop/internal/workspace"
+)
+
+// fakeManager stands in for the Claude CLI. Each started agent writes the
+// files configured for it into its working directory, reports its signal
+// (via a ReportSignal command, like the MCP server does) and exits
+// immediately. Agents without a configured signal exit without reporting one.
+type fakeManager struct {
+	store *events.Store
+
+	mu      sync.Mutex
+	signals map[string]map[string]any
+	files   map[string]map[string]string // agent → repo-relative path → content
+	started []process.AgentOpts
+	killed  []int
+
+	// onStart runs as each agent starts, e.g. to act on the run mid-agent
+	onStart func(opts process.AgentOpts)
+}
+
+func (m *fakeManager) StartAgent(ctx context.Context, opts process.AgentOpts) (string, int, <-chan process.ProcessResult, error) {
+	m.mu.Lock()
+	m.started = append(m.started, opts)
+	signal := m.signals[opts.SignalAgent]
+	files := m.files[opts.SignalAgent]
+	sessionID := "session-" + strconv.Itoa(len(m.started))
+	onStart := m.onStart
+	m.mu.Unlock()
+
+	if onStart != nil {
+		onStart(opts)
+	}
+
+	for path, content := range files {
+		full := filepath.Join(opts.WorkDir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return "", 0, nil, err
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			return "", 0, nil, err
+		}
+	}
+
+	if signal != nil {
+		runID, callIndex, err := readMCPConfig(opts.MCPConfigPath)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		copied := make(map[string]any, len(signal))
+		for k, v := range signal {
+			copied[k] = v
+		}
+		cmd, _ := NewCommand(runID, CmdReportSignal, ReportSignalPayload{CallIndex: callIndex, Signal: copied})
+		if err := m.store.SubmitCommand(cmd.ID, cmd.RunID, string(cmd.Type), cmd.Payload); err != nil {
+			return "", 0, nil, err
+		}
+	}
+
+	done := make(chan process.ProcessResult, 1)
+	done <- process.ProcessResult{SessionID: sessionID}
+	close(done)
+	return sessionID, 0, done, nil
+}
+
+func (m *fakeManager) Kill(pid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.killed = append(m.killed, pid)
+	return nil
+}
+
+// startedAgents returns the SignalAgent of every StartAgent call in order.
+func (m *fakeManager) startedAgents() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for _, o := range m.started {
+		names = append(names, o.SignalAgent)
+	}
+	return names
+}
+
+// readMCPConfig recovers the run ID and call index the runtime wrote to mcp.json.
+func readMCPConfig(path string) (int64, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var cfg struct {
+		MCPServers map[string]struct {
+			Args []string `json:"args"`
+		} `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return 0, 0, err
+	}
+	var runID int64
+	var callIndex int
+	args := cfg.MCPServers["shop"].Args
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--run-id":
+			runID, _ = strconv.ParseInt(args[i+1], 10, 64)
+		case "--call-index":
+			callIndex, _ = strconv.Atoi(args[i+1])
+		}
+	}
+	return runID, callIndex, nil
+}
+
+func fakeProcessor(t *testing.T, signals map[string]map[string]any) (*Processor, *events.Store, *fakeManager) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := events.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	fm := &fakeManager{store: store, signals: signals}
+	return NewProcessor(store, fm, filepath.Join(dir, "workspaces"), ""), store, fm
+}
+
+func initRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	return dir
+}
+
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wf.js")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startRun submits a StartRun for payload and waits for the run to settle.
+func startRun(t *testing.T, p *Processor, store *events.Store, payload StartRunPayload) *events.RunState {
+	t.Helper()
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.WorkflowName == "" {
+		payload.WorkflowName = "test"
+	}
+	if payload.InitialPrompt == "" {
+		payload.InitialPrompt = "do the thing"
+	}
+	cmd, err := NewCommand(runID, CmdStartRun, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SubmitCommand(cmd); err != nil {
+		t.Fatal(err)
+	}
+	return waitRun(t, p, store, runID)
+}
+
+// runScript starts a run of script and waits for it to settle.
+func runScript(t *testing.T, p *Processor, store *events.Store, script string) *events.RunState {
+	t.Helper()
+	return startRun(t, p, store, StartRunPayload{WorkflowPath: writeScript(t, script)})
+}
+
+// submitAndWait submits a command for an existing run and waits for it to settle.
+func submitAndWait(t *testing.T, p *Processor, store *events.Store, runID int64, cmdType CommandType, payload any) *events.RunState {
+	t.Helper()
+	cmd, err := NewCommand(runID, cmdType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SubmitCommand(cmd); err != nil {
+		t.Fatal(err)
+	}
+	return waitRun(t, p, store, runID)
+}
+
+func waitRun(t *testing.T, p *Processor, store *events.Store, runID int64) *events.RunState {
+	t.Helper()
+	select {
+	case <-p.ProcessRunSync(runID):
+	case <-time.After(10 * time.Second):
+		t.Fatalf("run %d did not settle", runID)
+	}
+	state, err := store.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func done(summary string) map[string]any {
+	return map[string]any{"status": "DONE", "summary": summary}
+}
+
+func TestRunScriptCompletes(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 1 || got[0] != "coder" {
+		t.Fatalf("expected coder to run once, got %v", got)
+	}
+}
+
+func TestStuckScriptIsRecordedAsStuck(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+
+	state := runScript(t, p, store, `function workflow(prompt) { stuck("cannot proceed"); }`)
+
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s", state.Status)
+	}
+	if state.WaitingReason != "cannot proceed" {
+		t.Fatalf("expected stuck reason, got %q", state.WaitingReason)
+	}
+}
+
+func countAgent(names []string, agent string) int {
+	n := 0
+	for _, name := range names {
+		if name == agent {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFinallyAgentRunsOnEveryOutcome(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   events.RunStatus
+	}{
+		{"complete", `run("coder");`, events.RunStatusComplete},
+		{"stuck", `stuck("giving up");`, events.RunStatusStuck},
+		{"failed", `run("silent");`, events.RunStatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, store, fm := fakeProcessor(t, map[string]map[string]any{
+				"coder":    done("wrote it"),
+				"reporter": done("reported"),
+			})
+
+			state := runScript(t, p, store, `
+				const settings = { finally: "reporter" };
+				function workflow(prompt) { `+tc.script+` }`)
+
+			if state.Status != tc.want {
+				t.Fatalf("expected %s, got %s (%s)", tc.want, state.Status, state.Error)
+			}
+			if n := countAgent(fm.startedAgents(), "reporter"); n != 1 {
+				t.Fatalf("expected finally agent to run once, ran %d times", n)
+			}
+			last := state.LastExecution()
+			if last.AgentName != "reporter" {
+				t.Fatalf("expected reporter to run last, got %s", last.AgentName)
+			}
+			if want := `finished with status "` + string(tc.want) + `"`; !strings.Contains(last.Prompt, want) {
+				t.Fatalf("expected finally prompt to contain %q, got %q", want, last.Prompt)
+			}
+		})
+	}
+}
+
+func TestFinallyAgentDoesNotDecideOutcome(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"reviewer": {"status": "APPROVED"},
+		"reporter": done("reported"),
+	})
+
+	state := runScript(t, p, store, `
+		const settings = { finally: "reporter", success_statuses: ["APPROVED"] };
+		function workflow(prompt) { run("reviewer", { statuses: ["APPROVED"] }); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if last := state.LastExecution(); last.AgentName != "reporter" || !last.Finishing {
+		t.Fatalf("expected the finally agent's execution marked finishing, got %+v", last)
+	}
+	if got := state.FinalStatus(); got != "APPROVED" {
+		t.Fatalf("expected the reviewer's APPROVED as the final status, got %q", got)
+	}
+	if got := workflow.RunOutcome(state); got != "success" {
+		t.Fatalf("expected the outcome the run ended with, got %q", got)
+	}
+}
+
+func TestFinallyAgentFailureKeepsOutcome(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := runScript(t, p, store, `
+		const settings = { finally: "silent" };
+		function workflow(prompt) { run("coder"); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete despite finally failure, got %s (%s)", state.Status, state.Error)
+	}
+}
+
+func TestCleanupCommandRunsOnEveryOutcome(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   events.RunStatus
+	}{
+		{"complete", `run("coder");`, events.RunStatusComplete},
+		{"failed", `throw new Error("boom");`, events.RunStatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, store, _ := fakeProcessor(t, map[string]map[string]any{
+				"coder": done("wrote it"),
+			})
+
+			state := runScript(t, p, store, `
+				const settings = { cleanup_command: "echo $SHOP_RUN_STATUS > cleaned.txt && echo dropped test db" };
+				function workflow(prompt) { `+tc.script+` }`)
+
+			if state.Status != tc.want {
+				t.Fatalf("expected %s, got %s (%s)", tc.want, state.Status, state.Error)
+			}
+			data, err := os.ReadFile(filepath.Join(state.WorkspacePath, "repo", "cleaned.txt"))
+			if err != nil || strings.TrimSpace(string(data)) != tc.name {
+				t.Fatalf("expected the cleanup to run in the repo with the outcome, got %q (%v)", data, err)
+			}
+			last := state.LogMessages[len(state.LogMessages)-1]
+			if !strings.Contains(last.Message, "cleanup_command finished\ndropped test db") {
+				t.Fatalf("expected the cleanup output logged, got %+v", state.LogMessages)
+			}
+		})
+	}
+}
+
+func TestCleanupCommandOutputCutOnRuneBoundary(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+
+	// 3-byte runes, so a cut at the last 4000 bytes lands mid-rune
+	state := runScript(t, p, store, `
+		const settings = { cleanup_command: "i=0; while [ $i -lt 2001 ]; do printf '€'; i=$((i+1)); done" };
+		function workflow(prompt) {}`)
+
+	last := state.LogMessages[len(state.LogMessages)-1].Message
+	if !strings.HasPrefix(last, "cleanup_command finished\n…") {
+		t.Fatalf("expected the output cut from the front, got %.60q", last)
+	}
+	// Split runes would be stored as U+FFFD
+	if !utf8.ValidString(last) || strings.ContainsRune(last, utf8.RuneError) {
+		t.Fatalf("expected no split rune in the logged output, got %.60q", last)
+	}
+}
+
+func TestCleanupCommandFailureKeepsOutcome(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := runScript(t, p, store, `
+		const settings = { cleanup_command: "echo no container; exit 3" };
+		function workflow(prompt) { run("coder"); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete despite the cleanup failing, got %s (%s)", state.Status, state.Error)
+	}
+	last := state.LogMessages[len(state.LogMessages)-1]
+	if last.Level != events.LogLevelWarn || !strings.Contains(last.Message, "exit status 3\nno container") {
+		t.Fatalf("expected the failure logged as a warning, got %+v", last)
+	}
+}
+
+func TestRunErrorSurvivesReopeningStore(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+	failed := runScript(t, p, store, `function workflow(prompt) { throw new Error("migration 12 did not apply"); }`)
+	stuck := runScript(t, p, store, `function workflow(prompt) { stuck("needs a staging database"); }`)
+
+	reopened, err := events.NewStore(store.DBPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	state, err := reopened.ProjectRunFromDB(failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "migration 12 did not apply") {
+		t.Fatalf("expected the failure reason back, got %s %q", state.Status, state.Error)
+	}
+	state, err = reopened.ProjectRunFromDB(stuck.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != events.RunStatusStuck || state.WaitingReason != "needs a staging database" {
+		t.Fatalf("expected the stuck reason back, got %s %q", state.Status, state.WaitingReason)
+	}
+}
+
+func TestOnFinishHookReceivesOutcome(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			on_finish(function (outcome) { log("finished: " + outcome.status + " / " + outcome.reason); });
+			on_finish(function () { throw new Error("hook broke"); });
+			stuck("nope");
+		}`)
+
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s", state.Status)
+	}
+	var found bool
+	for _, l := range state.LogMessages {
+		if l.Message == "finished: stuck / nope" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected on_finish log, got %+v", state.LogMessages)
+	}
+}
+
+func TestHandoffReroutesWaitingRun(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":  {"status": "STUCK", "reason": "need a senior"},
+		"senior": done("fixed it"),
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			const r = run("coder");
+			log("got " + r.summary);
+		}`)
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected waiting_human, got %s (%s)", state.Status, state.Error)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdHandoffRun, HandoffRunPayload{Agent: "senior"})
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after handoff, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 2 || got[1] != "senior" {
+		t.Fatalf("expected senior to take over, got %v", got)
+	}
+	if state.Executions[0].Status != events.ExecStatusInvalidated {
+		t.Fatalf("expected coder's execution to be invalidated, got %s", state.Executions[0].Status)
+	}
+	if state.Handoffs[1] != "senior" {
+		t.Fatalf("expected handoff recorded at call 1, got %v", state.Handoffs)
+	}
+	if len(state.LogMessages) == 0 || state.LogMessages[0].Message != "got fixed it" {
+		t.Fatalf("expected script to receive senior's signal, got %+v", state.LogMessages)
+	}
+}
+
+func TestKeepGoingHandsStuckSignalToScript(t *testing.T) {
+	signals := map[string]map[string]any{
+		"coder":    {"status": "STUCK", "reason": "flaky test"},
+		"fallback": done("worked around it"),
+	}
+	script := writeScript(t, `
+		function workflow(prompt) {
+			const r = run("coder");
+			if (r.status === "STUCK") {
+				run("fallback");
+			}
+		}`)
+
+	p, store, _ := fakeProcessor(t, signals)
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: script})
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected a stuck agent to suspend the run by default, got %s", state.Status)
+	}
+
+	p, store, fm := fakeProcessor(t, signals)
+	state = startRun(t, p, store, StartRunPayload{WorkflowPath: script, KeepGoing: true})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete under keep-going, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 2 || got[1] != "fallback" {
+		t.Fatalf("expected the script to move on to fallback, got %v", got)
+	}
+	if len(state.LogMessages) == 0 || !strings.Contains(state.LogMessages[0].Message, "keep-going: coder is stuck (flaky test)") ||
+		state.LogMessages[0].Level != events.LogLevelWarn {
+		t.Fatalf("expected the skip to be logged as a warning, got %+v", state.LogMessages)
+	}
+}
+
+func TestRunMetadataRoundTrip(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := startRun(t, p, store, StartRunPayload{
+		WorkflowPath: writeScript(t, `function workflow(prompt) { run("coder"); }`),
+		Metadata:     map[string]string{"pr": "12", "ticket": "ENG-4"},
+	})
+	if state.Metadata["pr"] != "12" || state.Metadata["ticket"] != "ENG-4" {
+		t.Fatalf("expected metadata from the start command, got %v", state.Metadata)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdSetRunMetadata, SetRunMetadataPayload{
+		Metadata: map[string]string{"pr": "13", "ticket": ""},
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected setting metadata to leave the run complete, got %s", state.Status)
+	}
+	if len(state.Metadata) != 1 || state.Metadata["pr"] != "13" {
+		t.Fatalf("expected pr updated and ticket removed, got %v", state.Metadata)
+	}
+
+	runs, err := store.ListRunsWithSummary(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Metadata["pr"] != "13" {
+		t.Fatalf("expected metadata on the run list, got %+v", runs)
+	}
+
+	if err := ValidateMetadata(map[string]string{" pr": "1"}); err == nil {
+		t.Fatal("expected a padded key to be rejected")
+	}
+}
+
+func TestRunPriorityReachesAgents(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := startRun(t, p, store, StartRunPayload{
+		WorkflowPath: writeScript(t, `function workflow(prompt) { run("coder"); }`),
+		Priority:     3,
+	})
+	if state.Status != events.RunStatusComplete || state.Priority != 3 {
+		t.Fatalf("expected a complete run with priority 3, got %s priority %d", state.Status, state.Priority)
+	}
+	if fm.started[0].Priority != 3 {
+		t.Fatalf("expected the agent to queue at the run's priority, got %d", fm.started[0].Priority)
+	}
+}
+
+func TestHandoffRequiresWaitingRun(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("wrote it"),
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	state = submitAndWait(t, p, store, state.ID, CmdHandoffRun, HandoffRunPayload{Agent: "senior"})
+
+	if state.Status != events.RunStatusComplete || len(state.Handoffs) != 0 {
+		t.Fatalf("expected handoff to be rejected, got %s %v", state.Status, state.Handoffs)
+	}
+}
+
+func TestNowIsStableAcrossReplays(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder":  {"status": "STUCK", "reason": "need help"},
+		"senior": done("fixed it"),
+	})
+
+	script := `
+		function workflow(prompt) {
+			log("now: " + now().getTime());
+			run("coder");
+		}`
+	state := runScript(t, p, store, script)
+	time.Sleep(5 * time.Millisecond)
+	state = submitAndWait(t, p, store, state.ID, CmdHandoffRun, HandoffRunPayload{Agent: "senior"})
+
+	want := "now: " + strconv.FormatInt(state.StartedAt.UnixMilli(), 10)
+	var seen int
+	for _, l := range state.LogMessages {
+		if strings.HasPrefix(l.Message, "now: ") {
+			seen++
+			if l.Message != want {
+				t.Fatalf("expected %q, got %q", want, l.Message)
+			}
+		}
+	}
+	if seen != 2 {
+		t.Fatalf("expected now() to be logged by both executions, got %d", seen)
+	}
+}
+
+func TestContextNamesWorkflow(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+	path := writeScript(t, `function workflow(prompt) {
+		const ctx = context();
+		ctx.workflow_name = "changed";
+		log(context().workflow_name + " " + ctx.workflow_path);
+	}`)
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path, WorkflowName: "review-loop"})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if want := "review-loop " + path; len(state.LogMessages) != 1 || state.LogMessages[0].Message != want {
+		t.Fatalf("expected log %q, got %+v", want, state.LogMessages)
+	}
+}
+
+func TestStepForcesNextAgent(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":  done("meh"),
+		"senior": done("great"),
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			const r = run("coder");
+			if (r.summary !== "great") stuck("not great");
+		}`)
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s (%s)", state.Status, state.Error)
+	}
+
+	agentsDir := filepath.Join(state.WorkspacePath, "repo", ".claude", "agents")
+	if err := os.MkdirAll(agentsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(agentsDir, "senior.md"), []byte("senior"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdStepRun, StepRunPayload{Agent: "senior"})
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after step, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 2 || got[1] != "senior" {
+		t.Fatalf("expected senior to run, got %v", got)
+	}
+
+	evts, err := store.GetEvents(state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded bool
+	for _, e := range evts {
+		if e.EventType == events.EventAgentHandedOff {
+			p, _ := events.DecodePayload[events.AgentHandedOffPayload](e)
+			recorded = p.Reason == "manual step" && p.FromAgent == "coder" && p.ToAgent == "senior"
+		}
+	}
+	if !recorded {
+		t.Fatal("expected the manual step to be recorded in the event log")
+	}
+}
+
+func TestStepRejectsInvalidTargets(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("meh"),
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); stuck("halt"); }`)
+	agentsDir := filepath.Join(state.WorkspacePath, "repo", ".claude", "agents")
+	if err := os.MkdirAll(agentsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, agent := range []string{"", "_checkpoint", "../coder", "ghost"} {
+		state = submitAndWait(t, p, store, state.ID, CmdStepRun, StepRunPayload{Agent: agent})
+		if state.Status != events.RunStatusStuck || len(state.Handoffs) != 0 {
+			t.Fatalf("expected step to %q to be rejected, got %s %v", agent, state.Status, state.Handoffs)
+		}
+	}
+
+	// Completed runs can't be stepped either
+	finished := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	finished = submitAndWait(t, p, store, finished.ID, CmdStepRun, StepRunPayload{Agent: "coder"})
+	if finished.Status != events.RunStatusComplete || len(finished.Handoffs) != 0 {
+		t.Fatalf("expected step on complete run to be rejected, got %s %v", finished.Status, finished.Handoffs)
+	}
+}
+
+func TestArtifactsAreValidatedAndRecorded(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder": {
+			"status":    "DONE",
+			"summary":   "wrote a report",
+			"artifacts": []any{"docs/report.md", "missing.md", "../outside.md"},
+		},
+	})
+	fm.files = map[string]map[string]string{
+		"coder": {"docs/report.md": "# Report"},
+	}
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	got := state.Executions[0].Artifacts
+	if len(got) != 1 || got[0] != "docs/report.md" {
+		t.Fatalf("expected only the existing in-repo artifact, got %v", got)
+	}
+	var warnings int
+	for _, l := range state.LogMessages {
+		if strings.Contains(l.Message, "ignoring") {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Fatalf("expected a warning per rejected artifact, got %+v", state.LogMessages)
+	}
+}
+
+func TestArtifactsRejectDirectoriesAndOutsideLinks(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder": {"status": "DONE", "artifacts": []any{"docs", "secret.txt", "docs/report.md"}},
+	})
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("not the repo's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fm.files = map[string]map[string]string{"coder": {"docs/report.md": "# Report"}}
+	fm.onStart = func(opts process.AgentOpts) {
+		os.Symlink(outside, filepath.Join(opts.WorkDir, "secret.txt"))
+	}
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+
+	if got := state.Executions[0].Artifacts; len(got) != 1 || got[0] != "docs/report.md" {
+		t.Fatalf("expected only the file artifact, got %v", got)
+	}
+	var warnings []string
+	for _, l := range state.LogMessages {
+		if strings.Contains(l.Message, "ignoring") {
+			warnings = append(warnings, l.Message)
+		}
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "a directory") || !strings.Contains(warnings[1], "links outside the repo") {
+		t.Fatalf("expected the directory and the outside link rejected, got %q", warnings)
+	}
+}
+
+func TestResumeAfterHumanSyncAppliesReportedSignal(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": {"status": "STUCK", "reason": "which database?"},
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			const r = run("coder");
+			log("got " + r.summary);
+		}`)
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected waiting_human, got %s (%s)", state.Status, state.Error)
+	}
+
+	if _, ok, err := p.ResumeAfterHumanSync(state.ID); err != nil || ok {
+		t.Fatalf("expected no resume before a new signal, got ok=%v err=%v", ok, err)
+	}
+
+	// The agent reports again from the resumed session, as the MCP server would
+	cmd, _ := NewCommand(state.ID, CmdReportSignal, ReportSignalPayload{CallIndex: 1, Signal: done("used sqlite")})
+	if err := store.SubmitCommand(cmd.ID, cmd.RunID, string(cmd.Type), cmd.Payload); err != nil {
+		t.Fatal(err)
+	}
+
+	settled, ok, err := p.ResumeAfterHumanSync(state.ID)
+	if err != nil || !ok {
+		t.Fatalf("expected resume, got ok=%v err=%v", ok, err)
+	}
+	select {
+	case <-settled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not settle")
+	}
+
+	state, err = store.ProjectRunFromDB(state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if len(state.LogMessages) == 0 || state.LogMessages[0].Message != "got used sqlite" {
+		t.Fatalf("expected script to receive the new signal, got %+v", state.LogMessages)
+	}
+}
+
+const untilScript = `
+	function workflow(prompt) {
+		run("architect");
+		for (let i = 0; i < 2; i++) {
+			run("coder");
+		}
+		run("reviewer");
+	}`
+
+func TestUntilPausesBeforeAgent(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: writeScript(t, untilScript), Until: "coder"})
+
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 1 || got[0] != "architect" {
+		t.Fatalf("expected only architect to run before the breakpoint, got %v", got)
+	}
+	if state.PausedCallIndex != 2 || state.WaitingReason != "breakpoint before coder" {
+		t.Fatalf("unexpected pause: call %d, %q", state.PausedCallIndex, state.WaitingReason)
+	}
+}
+
+func TestResumePastBreakpoint(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: writeScript(t, untilScript), Until: "coder"})
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+
+	// Resuming with the same breakpoint runs the coder it stopped before,
+	// then stops before the next one
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{Until: "coder"})
+	if state.Status != events.RunStatusPaused || state.PausedCallIndex != 3 {
+		t.Fatalf("expected pause before the second coder, got %s at call %d", state.Status, state.PausedCallIndex)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	want := []string{"architect", "coder", "coder", "reviewer"}
+	if got := fm.startedAgents(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestContextTemplateSeedsContext(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `
+		const settings = {
+			context_template: "# {{.Workflow}} brief (run {{.RunID}})\nTask: {{.Prompt}}\nStandards: https://example.com/style",
+		};
+		function workflow(prompt) { run("coder"); }`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	want := "# test brief (run " + strconv.FormatInt(state.ID, 10) + ")\nTask: do the thing\nStandards: https://example.com/style"
+	if state.ContextHeader != want {
+		t.Fatalf("expected %q, got %q", want, state.ContextHeader)
+	}
+}
+
+func TestContextTemplateDefaultsAndErrors(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	if want := "# Run Context\n\n**Workflow:** test\n**Task:** do the thing\n"; state.ContextHeader != want {
+		t.Fatalf("expected default header %q, got %q", want, state.ContextHeader)
+	}
+
+	state = runScript(t, p, store, `
+		const settings = { context_template: "{{.Spec}}" };
+		function workflow(prompt) { run("coder"); }`)
+	if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "invalid context_template") {
+		t.Fatalf("expected context_template error, got %s (%s)", state.Status, state.Error)
+	}
+}
+
+func TestResetStartsRunOver(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    done("coded"),
+		"reviewer": done("approved"),
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			run("coder");
+			run("reviewer");
+			log("finished");
+		}`)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdResetRun, ResetRunPayload{})
+	if state.Status != events.RunStatusPending {
+		t.Fatalf("expected pending after reset, got %s", state.Status)
+	}
+	if len(state.Executions) != 0 || len(state.LogMessages) != 0 {
+		t.Fatalf("expected executions and logs cleared, got %d, %d", len(state.Executions), len(state.LogMessages))
+	}
+	if state.WorkspacePath == "" || state.InitialPrompt != "do the thing" {
+		t.Fatalf("expected run record and workspace kept, got %+v", state)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after resume, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 4 {
+		t.Fatalf("expected both agents to run again, got %v", got)
+	}
+}
+
+func TestResetRejectsDeletedRun(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	state = submitAndWait(t, p, store, state.ID, CmdDeleteRun, DeleteRunPayload{})
+
+	state = submitAndWait(t, p, store, state.ID, CmdResetRun, ResetRunPayload{})
+	if state.Status != events.RunStatusDeleted {
+		t.Fatalf("expected reset of a deleted run to be refused, got %s", state.Status)
+	}
+}
+
+func TestOutputFormatIsRecorded(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder":    done("coded"),
+		"reviewer": done("approved"),
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			run("coder", { output_format: "stream-json" });
+			run("reviewer");
+		}`)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := state.Executions[0].OutputFormat; got != "stream-json" {
+		t.Fatalf("expected stream-json for coder, got %q", got)
+	}
+	if got := state.Executions[1].OutputFormat; got != "json" {
+		t.Fatalf("expected default json for reviewer, got %q", got)
+	}
+
+	state = runScript(t, p, store, `function workflow(prompt) { run("coder", { output_format: "yaml" }); }`)
+	if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "output_format") {
+		t.Fatalf("expected invalid output_format to fail the run, got %s (%s)", state.Status, state.Error)
+	}
+}
+
+func TestResumeUsesScriptCapturedAtStart(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    {"status": "STUCK", "reason": "need input"},
+		"reviewer": done("approved"),
+		"tester":   done("tested"),
+	})
+
+	path := writeScript(t, `
+		function workflow(prompt) {
+			run("coder");
+			run("reviewer");
+		}`)
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected waiting_human, got %s (%s)", state.Status, state.Error)
+	}
+	if !strings.Contains(state.WorkflowSource, `run("reviewer")`) {
+		t.Fatalf("expected the script to be captured on the run, got %q", state.WorkflowSource)
+	}
+
+	// Editing the file mid-run must not change what the run does
+	if err := os.WriteFile(path, []byte(`function workflow(prompt) { run("coder"); run("tester"); }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdProvideHumanInput, ProvideHumanInputPayload{
+		CallIndex: 1, Signal: done("answered"),
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); countAgent(got, "tester") != 0 || countAgent(got, "reviewer") != 1 {
+		t.Fatalf("expected the captured script to run reviewer, got %v", got)
+	}
+}
+
+func TestResumeAfterScriptDeleted(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    {"status": "STUCK", "reason": "need input"},
+		"reviewer": done("approved"),
+	})
+
+	path := writeScript(t, `
+		function workflow(prompt) {
+			run("coder");
+			run("reviewer");
+		}`)
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected waiting_human, got %s (%s)", state.Status, state.Error)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdProvideHumanInput, ProvideHumanInputPayload{
+		CallIndex: 1, Signal: done("answered"),
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected the run to finish from its captured script, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); countAgent(got, "reviewer") != 1 {
+		t.Fatalf("expected reviewer to run, got %v", got)
+	}
+}
+
+func TestResumeInNewProcessFindsScript(t *testing.T) {
+	signals := map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	}
+	p, store, _ := fakeProcessor(t, signals)
+	path := writeScript(t, untilScript)
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path, Until: "coder"})
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+
+	// Another shop process: its own store connection and processor
+	reopened, err := events.NewStore(store.DBPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	fm := &fakeManager{store: reopened, signals: signals}
+	p2 := NewProcessor(reopened, fm, filepath.Join(t.TempDir(), "workspaces"), "")
+
+	state, err = reopened.ProjectRunFromDB(state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.WorkflowPath != path || state.WorkflowSource == "" {
+		t.Fatalf("expected the script's path and source recorded, got %q (%d bytes)", state.WorkflowPath, len(state.WorkflowSource))
+	}
+	state = submitAndWait(t, p2, reopened, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected the resumed run to complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); countAgent(got, "reviewer") != 1 {
+		t.Fatalf("expected the new process to run the rest of the script, got %v", got)
+	}
+}
+
+func TestPauseLoopIsDetected(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"_checkpoint": {"status": "CONTINUE"},
+	})
+
+	state := runScript(t, p, store, `
+		const settings = { max_consecutive_pauses: 3 };
+		function workflow(prompt) {
+			while (pause("Keep going?").continue) {}
+		}`)
+
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s (%s)", state.Status, state.Error)
+	}
+	if !strings.Contains(state.WaitingReason, "pause loop detected") {
+		t.Fatalf("expected pause loop reason, got %q", state.WaitingReason)
+	}
+	if n := countAgent(fm.startedAgents(), "_checkpoint"); n != 3 {
+		t.Fatalf("expected 3 checkpoints before the guard fired, got %d", n)
+	}
+}
+
+func TestRunResetsPauseLoopGuard(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"_checkpoint": {"status": "CONTINUE"},
+		"coder":       done("coded"),
+	})
+
+	state := runScript(t, p, store, `
+		const settings = { max_consecutive_pauses: 2 };
+		function workflow(prompt) {
+			for (let i = 0; i < 3; i++) {
+				pause("Review?");
+				pause("Sure?");
+				run("coder");
+			}
+		}`)
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected pauses separated by run() to be allowed, got %s (%s)", state.Status, state.WaitingReason)
+	}
+}
+
+func TestCheckpointAgentAndPrompt(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"_checkpoint": {"status": "CONTINUE"},
+	})
+
+	state := runScript(t, p, store, `
+		const settings = {
+			checkpoint_agent: "gatekeeper",
+			checkpoint_prompt: "Gate for run {{.RunID}} ({{.Workflow}}): {{.Message}}",
+		};
+		function workflow(prompt) {
+			pause("Ship it?");
+		}`)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+
+	started := fm.started[0]
+	if started.ClaudeAgent != "gatekeeper" || started.SignalAgent != "_checkpoint" {
+		t.Fatalf("expected the checkpoint run with agent gatekeeper, got %+v", started)
+	}
+	want := "Gate for run " + strconv.FormatInt(state.ID, 10) + " (test): Ship it?"
+	if !strings.HasPrefix(started.Prompt, want) || !strings.Contains(started.Prompt, `report_signal(status="CONTINUE"`) {
+		t.Fatalf("expected the custom prompt followed by the signal instructions, got:\n%s", started.Prompt)
+	}
+
+	// Without settings the built-in prompt runs without an agent
+	p, store, fm = fakeProcessor(t, map[string]map[string]any{
+		"_checkpoint": {"status": "CONTINUE"},
+	})
+	runScript(t, p, store, `function workflow(prompt) { pause("Ship it?"); }`)
+	if started := fm.started[0]; started.ClaudeAgent != "" || !strings.Contains(started.Prompt, "**Checkpoint:** Ship it?") {
+		t.Fatalf("expected the default checkpoint, got %+v", started)
+	}
+}
+
+func TestAgentInstructionsFile(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    {"status": "DONE"},
+		"reviewer": {"status": "DONE"},
+	})
+
+	shopDir := filepath.Join(t.TempDir(), ".shop")
+	for _, dir := range []string{"workflows", "agents"} {
+		if err := os.MkdirAll(filepath.Join(shopDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(shopDir, "workflows", "wf.js")
+	script := `function workflow(prompt) { run("coder", prompt); run("reviewer", "review it"); }`
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(shopDir, "agents", "coder.md"), []byte("You write Go.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.started[0].Prompt; !strings.HasPrefix(got, "You write Go.\n\n---\n\ndo the thing") {
+		t.Fatalf("expected coder's instructions ahead of its prompt, got:\n%s", got)
+	}
+	if got := fm.started[1].Prompt; !strings.HasPrefix(got, "review it") {
+		t.Fatalf("expected reviewer's prompt unchanged without a file, got:\n%s", got)
+	}
+}
+
+func TestSkillFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "conventions.md"), []byte("Commit with conventional commit messages.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{"extend", "replace"} {
+		p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+		path := filepath.Join(dir, mode+".js")
+		script := `const settings = { skill_file: "conventions.md", skill_mode: "` + mode + `" };
+			function workflow(prompt) { run("coder", prompt); }`
+		if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+		if state.Status != events.RunStatusComplete {
+			t.Fatalf("%s: expected complete, got %s (%s)", mode, state.Status, state.Error)
+		}
+
+		prompt := fm.started[0].Prompt
+		if !strings.Contains(prompt, "Commit with conventional commit messages.") || !strings.Contains(prompt, "call the `report_signal` tool") {
+			t.Errorf("%s: expected the skill file and the signal requirement, got:\n%s", mode, prompt)
+		}
+		if builtIn := strings.Contains(prompt, "You are the 'coder' agent"); builtIn != (mode == "extend") {
+			t.Errorf("%s: expected the built-in instructions only when extending, got:\n%s", mode, prompt)
+		}
+	}
+
+	if _, err := workflow.LoadSettings(`const settings = { skill_mode: "merge" };`); err == nil || !strings.Contains(err.Error(), "skill_mode") {
+		t.Fatalf("expected an unknown skill_mode rejected, got %v", err)
+	}
+}
+
+func TestRunWorkflowComposesChildRun(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    {"status": "DONE", "summary": "implemented"},
+		"reviewer": {"status": "APPROVED"},
+		"blocker":  {"status": "STUCK", "reason": "which API?"},
+	})
+
+	dir := t.TempDir()
+	for name, script := range map[string]string{
+		"parent.js":  `function workflow(prompt) { const r = run_workflow("impl", "build: " + prompt); run("reviewer", r.summary); }`,
+		"impl.js":    `function workflow(prompt) { run("coder", prompt); }`,
+		"blocked.js": `function workflow(prompt) { run_workflow("waits"); }`,
+		"waits.js":   `function workflow(prompt) { run("blocker", prompt); }`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parent := startRun(t, p, store, StartRunPayload{WorkflowPath: filepath.Join(dir, "parent.js")})
+	if parent.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", parent.Status, parent.Error)
+	}
+	call := parent.GetExecutionByCallIndex(1)
+	if call.AgentName != "workflow:impl" || call.ChildRunID == 0 || call.Signal["status"] != "DONE" {
+		t.Fatalf("expected call 1 to be the impl child's DONE, got %+v", call)
+	}
+	child, err := store.ProjectRunFromDB(call.ChildRunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.ParentRunID != parent.ID || child.ParentCallIndex != 1 || child.WorkspacePath != parent.WorkspacePath {
+		t.Fatalf("expected the child linked to call 1 in the parent's workspace, got %+v", child)
+	}
+	if child.InitialPrompt != "build: do the thing" || child.Status != events.RunStatusComplete {
+		t.Fatalf("expected the child to run the given prompt to completion, got %s %q", child.Status, child.InitialPrompt)
+	}
+	if got := fm.started[len(fm.started)-1]; got.SignalAgent != "reviewer" || !strings.HasPrefix(got.Prompt, "implemented") {
+		t.Fatalf("expected the reviewer to get the child's summary, got %+v", got)
+	}
+
+	if kids, err := store.ListChildRuns(parent.ID); err != nil || len(kids) != 1 || kids[0].ID != child.ID {
+		t.Fatalf("expected run %d listed as the only child, got %+v (%v)", child.ID, kids, err)
+	}
+
+	// A child that ends up waiting fails the call, naming the run to finish
+	blocked := startRun(t, p, store, StartRunPayload{WorkflowPath: filepath.Join(dir, "blocked.js")})
+	if blocked.Status != events.RunStatusFailed || !strings.Contains(blocked.Error, "waiting_human; see it through") {
+		t.Fatalf("expected the parent to fail on its waiting child, got %s (%s)", blocked.Status, blocked.Error)
+	}
+
+	// Deleting with children takes the child along
+	if err := p.DeleteRun(parent.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{parent.ID, child.ID} {
+		if state, _ := store.ProjectRunFromDB(id); state.Status != events.RunStatusDeleted {
+			t.Errorf("expected run %d deleted, got %s", id, state.Status)
+		}
+	}
+}
+
+func TestRunWorkflowResultIgnoresChildFinallyAgent(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"reviewer": {"status": "APPROVED", "summary": "lgtm"},
+		"reporter": done("reported"),
+	})
+
+	dir := t.TempDir()
+	for name, script := range map[string]string{
+		"parent.js": `function workflow(prompt) {
+			const r = run_workflow("review");
+			if (r.status !== "APPROVED") { stuck("child returned " + r.status); }
+		}`,
+		"review.js": `const settings = { finally: "reporter" };
+			function workflow(prompt) { run("reviewer", { statuses: ["APPROVED"] }); }`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parent := startRun(t, p, store, StartRunPayload{WorkflowPath: filepath.Join(dir, "parent.js")})
+	if parent.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s %s)", parent.Status, parent.Error, parent.WaitingReason)
+	}
+	call := parent.GetExecutionByCallIndex(1)
+	if call.Signal["status"] != "APPROVED" || call.Signal["summary"] != "lgtm" {
+		t.Fatalf("expected the child's reviewer signal, not its finally agent's, got %v", call.Signal)
+	}
+}
+
+func TestAdoptRunFromBranch(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+
+	repo := initRepo(t)
+	if out, err := exec.Command("git", "-C", repo, "branch", "shop/run-12").CombinedOutput(); err != nil {
+		t.Fatalf("git branch: %s", out)
+	}
+
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := submitAndWait(t, p, store, runID, CmdAdoptRun, AdoptRunPayload{
+		SourceRepo:    repo,
+		Branch:        "shop/run-12",
+		WorkflowPath:  writeScript(t, `function workflow(prompt) { run("coder"); }`),
+		WorkflowName:  "test",
+		InitialPrompt: "recovered",
+	})
+
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected adopted run to be stuck, got %s (%s)", state.Status, state.Error)
+	}
+	if state.Branch != "shop/run-12" || state.InitialPrompt != "recovered" || state.WorkflowSource == "" {
+		t.Fatalf("adopted run missing details: %+v", state)
+	}
+	if _, err := os.Stat(filepath.Join(state.WorkspacePath, "repo", ".git")); err != nil {
+		t.Fatalf("expected a worktree for the branch: %v", err)
+	}
+}
+
+func TestPromptSizeLimit(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	script := func(limit int) string {
+		return `const settings = { max_prompt_chars: ` + strconv.Itoa(limit) + ` };
+			function workflow(prompt) { run("coder", "` + strings.Repeat("x", 500) + `"); }`
+	}
+
+	state := runScript(t, p, store, script(0))
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete under the default limit, got %s (%s)", state.Status, state.Error)
+	}
+	size := len(fm.started[0].Prompt)
+
+	state = runScript(t, p, store, script(size))
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected a prompt exactly at the limit to be sent, got %s (%s)", state.Status, state.Error)
+	}
+
+	state = runScript(t, p, store, script(size-1))
+	if state.Status != events.RunStatusFailed {
+		t.Fatalf("expected a prompt over the limit to fail the run, got %s", state.Status)
+	}
+	if !strings.Contains(state.Error, "max_prompt_chars") || !strings.Contains(state.Error, "run "+strconv.FormatInt(state.ID, 10)) {
+		t.Fatalf("expected error naming the run and setting, got %q", state.Error)
+	}
+	if n := countAgent(fm.startedAgents(), "coder"); n != 2 {
+		t.Fatalf("expected the oversized prompt not to start Claude, got %d starts", n)
+	}
+}
+
+func TestReuseWorkspaceAcrossRuns(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	repo := initRepo(t)
+	path := writeScript(t, `const settings = { reuse_workspace: true };
+		function workflow(prompt) { run("coder"); }`)
+
+	first := startRun(t, p, store, StartRunPayload{WorkflowPath: path, SourceRepo: repo})
+	second := startRun(t, p, store, StartRunPayload{WorkflowPath: path, SourceRepo: repo})
+	for _, state := range []*events.RunState{first, second} {
+		if state.Status != events.RunStatusComplete {
+			t.Fatalf("run %d: expected complete, got %s (%s)", state.ID, state.Status, state.Error)
+		}
+	}
+	if first.WorkspacePath == "" || first.WorkspacePath != second.WorkspacePath {
+		t.Fatalf("expected both runs in one workspace, got %q and %q", first.WorkspacePath, second.WorkspacePath)
+	}
+
+	// The first run's work is gone from the workspace, so it can't be reset there
+	state := submitAndWait(t, p, store, first.ID, CmdResetRun, ResetRunPayload{Hard: true})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected reset of a run whose workspace was reused to be refused, got %s", state.Status)
+	}
+
+	submitAndWait(t, p, store, first.ID, CmdDeleteRun, DeleteRunPayload{})
+	if _, err := os.Stat(filepath.Join(second.WorkspacePath, "repo")); err != nil {
+		t.Fatalf("expected deleting a run to keep the shared workspace: %v", err)
+	}
+}
+
+func TestCleanupOnSuccessRemovesOnlyCompletedWorktrees(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	repo := initRepo(t)
+	branchExists := func(branch string) bool {
+		cmd := exec.Command("git", "rev-parse", "--verify", "-q", "refs/heads/"+branch)
+		cmd.Dir = repo
+		return cmd.Run() == nil
+	}
+
+	completed := startRun(t, p, store, StartRunPayload{SourceRepo: repo, WorkflowPath: writeScript(t, `const settings = { cleanup_on_success: true };
+		function workflow(prompt) { run("coder"); }`)})
+	if completed.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", completed.Status, completed.Error)
+	}
+	if _, err := os.Stat(filepath.Join(completed.WorkspacePath, "repo")); !os.IsNotExist(err) {
+		t.Fatalf("expected the completed run's worktree removed, got %v", err)
+	}
+	if !branchExists(completed.Branch) {
+		t.Fatalf("expected branch %s kept", completed.Branch)
+	}
+	if last := completed.LogMessages[len(completed.LogMessages)-1]; !strings.Contains(last.Message, "removed worktree") {
+		t.Fatalf("expected the removal logged, got %q", last.Message)
+	}
+
+	for _, body := range []string{`run("coder"); throw new Error("boom");`, `run("coder"); stuck("blocked");`} {
+		state := startRun(t, p, store, StartRunPayload{SourceRepo: repo, WorkflowPath: writeScript(t, `const settings = { cleanup_on_success: true };
+			function workflow(prompt) { `+body+` }`)})
+		if state.Status == events.RunStatusComplete {
+			t.Fatalf("expected the run not to complete, got %s", state.Status)
+		}
+		if _, err := os.Stat(filepath.Join(state.WorkspacePath, "repo", ".git")); err != nil {
+			t.Fatalf("expected a %s run's worktree kept: %v", state.Status, err)
+		}
+	}
+
+	// Opt-in: without the setting a completed run keeps its worktree
+	kept := startRun(t, p, store, StartRunPayload{SourceRepo: repo, WorkflowPath: writeScript(t, `function workflow(prompt) { run("coder"); }`)})
+	if _, err := os.Stat(filepath.Join(kept.WorkspacePath, "repo", ".git")); err != nil {
+		t.Fatalf("expected the worktree kept without cleanup_on_success: %v", err)
+	}
+
+	// Deleting the cleaned-up run still finds the branch's repo
+	submitAndWait(t, p, store, completed.ID, CmdDeleteRun, DeleteRunPayload{})
+	if branchExists(completed.Branch) {
+		t.Fatalf("expected branch %s deleted with the run", completed.Branch)
+	}
+}
+
+func TestResumeReportsReplayProgress(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+	var got []workflow.ReplayProgress
+	p.OnReplayProgress(func(runID int64, progress workflow.ReplayProgress) {
+		got = append(got, progress)
+	})
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: writeScript(t, untilScript), Until: "reviewer"})
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no progress for a run with nothing to replay, got %+v", got)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	want := []workflow.ReplayProgress{
+		{CallIndex: 1, Agent: "architect", Replayed: 1, Cached: 3},
+		{CallIndex: 2, Agent: "coder", Replayed: 2, Cached: 3},
+		{CallIndex: 3, Agent: "coder", Replayed: 3, Cached: 3},
+		{CallIndex: 4, Agent: "reviewer", Replayed: 3, Cached: 3, Live: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected progress %+v, got %+v", want, got)
+	}
+
+	// The finally agent's cached execution is neither counted nor reported
+	got = nil
+	state = runScript(t, p, store, `
+		const settings = { finally: "reviewer" };
+		function workflow(prompt) { run("architect"); stuck("blocked"); }`)
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s (%s)", state.Status, state.Error)
+	}
+	submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	want = []workflow.ReplayProgress{{CallIndex: 1, Agent: "architect", Replayed: 1, Cached: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected progress %+v, got %+v", want, got)
+	}
+}
+
+func TestResumeIntoMovedRepo(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+
+	repo := initRepo(t)
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: writeScript(t, untilScript), SourceRepo: repo, Until: "coder"})
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := os.Rename(repo, moved); err != nil {
+		t.Fatal(err)
+	}
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{Repo: moved})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after relinking, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 4 {
+		t.Fatalf("expected the run to finish its agents, got %v", got)
+	}
+	if source := workspace.SourceRepo(filepath.Join(state.WorkspacePath, "repo")); source != moved {
+		t.Fatalf("expected the worktree relinked to %s, got %s", moved, source)
+	}
+}
+
+func TestInlineWorkflowSource(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowName: "stdin", WorkflowSource: untilScript, Until: "coder"})
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+	if state.WorkflowSource != untilScript || state.WorkflowPath != filepath.Join(state.WorkspacePath, "workflow.js") {
+		t.Fatalf("expected the script kept on the run and in its workspace, got path %q", state.WorkflowPath)
+	}
+
+	// Resuming replays the recorded script, not the file
+	if err := os.Remove(state.WorkflowPath); err != nil {
+		t.Fatal(err)
+	}
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	want := []string{"architect", "coder", "coder", "reviewer"}
+	if got := fm.startedAgents(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSkippedPermissionsAreRecorded(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	for _, tc := range []struct {
+		settings string
+		want     bool
+	}{
+		{``, true},
+		{`const settings = { skip_permissions: true };`, true},
+		{`const settings = { skip_permissions: false };`, false},
+	} {
+		state := runScript(t, p, store, tc.settings+`
+			function workflow(prompt) { run("coder", "go"); }`)
+		if state.Status != events.RunStatusComplete {
+			t.Fatalf("%q: expected complete, got %s (%s)", tc.settings, state.Status, state.Error)
+		}
+		if got := state.Executions[0].SkippedPermissions; got != tc.want {
+			t.Errorf("%q: execution skipped_permissions = %v, want %v", tc.settings, got, tc.want)
+		}
+		if got := fm.started[len(fm.started)-1].SkipPermissions; got != tc.want {
+			t.Errorf("%q: agent started with SkipPermissions = %v, want %v", tc.settings, got, tc.want)
+		}
+	}
+}
+
+func TestOnErrorRoutesFailedAgent(t *testing.T) {
+	// coder exits without reporting a signal
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"fixer":    {"status": "FIXED", "summary": "finished coder's work"},
+		"reviewer": {"status": "STUCK", "reason": "looks odd"},
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) {
+		const r = run("coder", { prompt: "add a flag", statuses: ["FIXED"], on_error: "fixer" });
+		run("reviewer", "review: " + r.status);
+		log("after review");
+	}`)
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected the run to reach reviewer, got %s (%s)", state.Status, state.Error)
+	}
+	// coder is reminded once to report a signal before it counts as failed
+	if got := fm.startedAgents(); strings.Join(got, ",") != "coder,coder,fixer,reviewer" {
+		t.Fatalf("expected coder's failure to route to fixer, got %v", got)
+	}
+	if fixer := fm.started[2].Prompt; !strings.Contains(fixer, "coder agent failed") || !strings.Contains(fixer, "add a flag") {
+		t.Fatalf("expected fixer briefed on coder's failure, got %q", fixer)
+	}
+	if reviewer := state.Executions[2]; reviewer.Prompt != "review: FIXED" {
+		t.Fatalf("expected run() to return fixer's signal, got prompt %q", reviewer.Prompt)
+	}
+
+	// Replaying after the human answers takes the route again without
+	// re-running either agent
+	state = submitAndWait(t, p, store, state.ID, CmdProvideHumanInput, ProvideHumanInputPayload{
+		CallIndex: 3, Signal: done("fine"),
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); len(got) != 4 {
+		t.Fatalf("expected no agent to re-run on replay, got %v", got)
+	}
+}
+
+func TestAgentRemindedToReportSignal(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"reviewer": done("ok")})
+	// coder only reports its signal once reminded
+	fm.onStart = func(opts process.AgentOpts) {
+		if opts.SignalAgent != "coder" || opts.ResumeSessionID == "" {
+			return
+		}
+		runID, callIndex, err := readMCPConfig(opts.MCPConfigPath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cmd, _ := NewCommand(runID, CmdReportSignal, ReportSignalPayload{CallIndex: callIndex, Signal: done("coded")})
+		if err := store.SubmitCommand(cmd.ID, cmd.RunID, string(cmd.Type), cmd.Payload); err != nil {
+			t.Error(err)
+		}
+	}
+
+	state := runScript(t, p, store, `function workflow(prompt) {
+		const r = run("coder", "go");
+		run("reviewer", "review: " + r.summary);
+	}`)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := fm.startedAgents(); strings.Join(got, ",") != "coder,coder,reviewer" {
+		t.Fatalf("expected coder reminded once, got %v", got)
+	}
+	coder, reviewer := state.Executions[0], state.Executions[1]
+	if reminder := fm.started[1]; reminder.ResumeSessionID != coder.SessionID || !strings.Contains(reminder.Prompt, "report_signal") {
+		t.Fatalf("expected the reminder to resume coder's session, got %+v", reminder)
+	}
+	if coder.SignalReminders != 1 || reviewer.SignalReminders != 0 {
+		t.Fatalf("expected one reminder, for coder; got coder %d, reviewer %d", coder.SignalReminders, reviewer.SignalReminders)
+	}
+	if reviewer.Prompt != "review: coded" {
+		t.Fatalf("expected run() to return the reminded signal, got prompt %q", reviewer.Prompt)
+	}
+}
+
+func TestFailedAgentWithoutOnErrorFailsRun(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"fixer": done("fixed")})
+
+	state := runScript(t, p, store, `function workflow(prompt) {
+		run("coder", { on_error: "fixer" });
+		run("tester");
+	}`)
+	if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "tester") {
+		t.Fatalf("expected tester's failure, with no route, to fail the run, got %s (%s)", state.Status, state.Error)
+	}
+	if n := countAgent(fm.startedAgents(), "fixer"); n != 1 {
+		t.Fatalf("expected fixer to run once, for coder, got %d", n)
+	}
+}
+
+func TestFinalSignalClassifiesRun(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"approver": {"status": "APPROVED"},
+		"rejecter": {"status": "REJECTED"},
+		"shrugger": {"status": "UNSURE"},
+	})
+
+	for _, tc := range []struct {
+		settings, agent string
+		want            events.RunStatus
+		err             string
+		final, outcome  string
+	}{
+		{``, "rejecter", events.RunStatusComplete, "", "REJECTED", ""},
+		{`failure_statuses: ["REJECTED"]`, "approver", events.RunStatusComplete, "", "APPROVED", "success"},
+		{`failure_statuses: ["REJECTED"]`, "rejecter", events.RunStatusFailed, "REJECTED is one of failure_statuses", "REJECTED", "failure"},
+		{`success_statuses: ["APPROVED"]`, "approver", events.RunStatusComplete, "", "APPROVED", "success"},
+		{`success_statuses: ["APPROVED"]`, "shrugger", events.RunStatusFailed, "UNSURE is not one of success_statuses", "UNSURE", "failure"},
+		{`success_statuses: ["APPROVED"], failure_statuses: ["REJECTED"]`, "rejecter", events.RunStatusFailed, "failure_statuses", "REJECTED", "failure"},
+	} {
+		state := runScript(t, p, store, `const settings = { `+tc.settings+` };
+			function workflow(prompt) {
+				run("approver", { statuses: ["APPROVED"] });
+				run("`+tc.agent+`", { statuses: ["APPROVED", "REJECTED", "UNSURE"] });
+			}`)
+		if state.Status != tc.want || !strings.Contains(state.Error, tc.err) {
+			t.Errorf("{%s} ending with %s: expected %s (%q), got %s (%q)", tc.settings, tc.agent, tc.want, tc.err, state.Status, state.Error)
+		}
+		if final, outcome := state.FinalStatus(), workflow.RunOutcome(state); final != tc.final || outcome != tc.outcome {
+			t.Errorf("{%s} ending with %s: expected final %s (%q), got %s (%q)", tc.settings, tc.agent, tc.final, tc.outcome, final, outcome)
+		}
+	}
+}
+
+func TestFixSignalForAgentThatNeverReported(t *testing.T) {
+	// coder exits without reporting a signal
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"reviewer": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) {
+		const r = run("coder", "go");
+		run("reviewer", "review: " + r.summary);
+	}`)
+	if state.Status != events.RunStatusFailed {
+		t.Fatalf("expected failed run, got %s", state.Status)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdRecoverRun, RecoverRunPayload{
+		Action: RecoverSignal,
+		Agent:  "coder",
+		Signal: map[string]any{"status": "DONE", "summary": "fixed by hand"},
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after fixing the signal, got %s (%s)", state.Status, state.Error)
+	}
+	if state.Error != "" {
+		t.Fatalf("expected the old failure cleared, got %q", state.Error)
+	}
+	coder := state.Executions[0]
+	if coder.Status != events.ExecStatusCompleted || coder.Error != "" {
+		t.Fatalf("expected coder completed without error, got %s %q", coder.Status, coder.Error)
+	}
+	// Its one start and the reminder to report a signal
+	if n := countAgent(fm.startedAgents(), "coder"); n != 2 {
+		t.Fatalf("expected coder not to be re-run, got %d starts", n)
+	}
+	last := fm.started[len(fm.started)-1]
+	if last.SignalAgent != "reviewer" || !strings.Contains(last.Prompt, "review: fixed by hand") {
+		t.Fatalf("expected reviewer to get the hand-written signal, got %s: %q", last.SignalAgent, last.Prompt)
+	}
+}
+
+func TestRecoverAndStepSkipFinallyAgent(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"reviewer": done("ok"),
+		"senior":   done("great"),
+		"reporter": done("reported"),
+	})
+
+	// coder never reports; the finally agent runs after it fails
+	state := runScript(t, p, store, `
+		const settings = { finally: "reporter" };
+		function workflow(prompt) {
+			const r = run("coder", "go");
+			run("reviewer", "review: " + r.summary);
+		}`)
+	if state.Status != events.RunStatusFailed {
+		t.Fatalf("expected failed run, got %s (%s)", state.Status, state.Error)
+	}
+	if last := state.LastWorkflowExecution(); last == nil || last.AgentName != "coder" {
+		t.Fatalf("expected coder as the last workflow execution, got %+v", last)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdRecoverRun, RecoverRunPayload{
+		Action: RecoverSignal,
+		Agent:  "coder",
+		Signal: map[string]any{"status": "DONE", "summary": "fixed by hand"},
+	})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after fixing coder's signal, got %s (%s)", state.Status, state.Error)
+	}
+	if state.Error != "" {
+		t.Fatalf("expected the old failure cleared, got %q", state.Error)
+	}
+	if n := countAgent(fm.startedAgents(), "coder"); n != 2 {
+		t.Fatalf("expected coder not to be re-run, got %d starts", n)
+	}
+
+	// A stuck run steps from its last workflow agent, not from reporter
+	state = runScript(t, p, store, `
+		const settings = { finally: "reporter" };
+		function workflow(prompt) {
+			const r = run("reviewer");
+			if (r.summary !== "great") stuck("not great");
+		}`)
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s (%s)", state.Status, state.Error)
+	}
+	agentsDir := filepath.Join(state.WorkspacePath, "repo", ".claude", "agents")
+	if err := os.MkdirAll(agentsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(agentsDir, "senior.md"), []byte("senior"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdStepRun, StepRunPayload{Agent: "senior"})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete after step, got %s (%s)", state.Status, state.Error)
+	}
+	evts, err := store.GetEvents(state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var from string
+	for _, e := range evts {
+		if e.EventType == events.EventAgentHandedOff {
+			p, _ := events.DecodePayload[events.AgentHandedOffPayload](e)
+			from = p.FromAgent
+		}
+	}
+	if from != "reviewer" {
+		t.Fatalf("expected the step to hand off from reviewer, got %q", from)
+	}
+}
+
+func TestRunSummaryAggregatesAgentSummaries(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"planner":  done("planned the change"),
+		"coder":    done("implemented it"),
+		"reviewer": {"status": "BLOCKED", "summary": "needs a product decision"},
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) {
+		run("planner", "plan");
+		run("coder", "code");
+		const r = run("reviewer", "review");
+		if (r.status === "BLOCKED") { stuck("reviewer is blocked"); }
+	}`)
+	if state.Status != events.RunStatusStuck {
+		t.Fatalf("expected stuck, got %s (%s)", state.Status, state.Error)
+	}
+
+	want := "planner: planned the change\ncoder: implemented it\nreviewer: needs a product decision"
+	if state.Summary != want {
+		t.Fatalf("expected summary %q, got %q", want, state.Summary)
+	}
+
+	data, err := os.ReadFile(filepath.Join(state.WorkspacePath, "summary.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"**Status:** stuck", "**Reason:** reviewer is blocked", "- planner: planned the change\n- coder: implemented it\n- reviewer: needs"} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("summary.md missing %q:\n%s", s, data)
+		}
+	}
+}
+
+func TestSentPromptIsRecorded(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder", "write the parser"); }`)
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+
+	exec := state.Executions[0]
+	if exec.Prompt != "write the parser" {
+		t.Fatalf("expected the script's prompt, got %q", exec.Prompt)
+	}
+	if exec.SentPrompt != fm.started[0].Prompt {
+		t.Fatalf("expected the prompt Claude received to be recorded\nsent:     %q\nrecorded: %q", fm.started[0].Prompt, exec.SentPrompt)
+	}
+	if !strings.Contains(exec.SentPrompt, "write the parser") || len(exec.SentPrompt) <= len(exec.Prompt) {
+		t.Fatalf("expected the sent prompt to wrap the script's prompt, got %q", exec.SentPrompt)
+	}
+}
+
+func TestKillRunningRun(t *testing.T) {
+	p, store, fm := fakeProcessor(t, nil)
+
+	// A run whose agent is still executing (in another process)
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AppendEvents(runID, 0, []events.Event{
+		events.MustNewEvent(runID, events.EventRunStarted, events.RunStartedPayload{WorkflowPath: "wf.js", WorkflowName: "test"}),
+		events.MustNewEvent(runID, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1, PID: 4242}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	state := submitAndWait(t, p, store, runID, CmdKillRun, KillRunPayload{Reason: "wrong approach"})
+
+	if state.Status != events.RunStatusKilled || state.Error != "wrong approach" {
+		t.Fatalf("expected killed with reason, got %s (%q)", state.Status, state.Error)
+	}
+	if len(fm.killed) != 1 || fm.killed[0] != 4242 {
+		t.Fatalf("expected agent process 4242 to be killed, got %v", fm.killed)
+	}
+	if exec := state.Executions[0]; exec.Status != events.ExecStatusFailed || exec.CompletedAt == nil {
+		t.Fatalf("expected running execution to be closed out as failed, got %s", exec.Status)
+	}
+	if state.ActivePID() != 0 {
+		t.Fatal("expected no active PID after kill")
+	}
+}
+
+func TestKillWaitingRun(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder": {"status": "STUCK", "summary": "which database?"},
+	})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	if state.Status != events.RunStatusWaitingHuman {
+		t.Fatalf("expected waiting_human, got %s", state.Status)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdKillRun, KillRunPayload{})
+
+	if state.Status != events.RunStatusKilled || state.Error != "Killed by user" {
+		t.Fatalf("expected killed with default reason, got %s (%q)", state.Status, state.Error)
+	}
+	if state.WaitingReason != "" || state.WaitingSessionID != "" {
+		t.Fatalf("expected waiting state to be cleared, got %q / %q", state.WaitingReason, state.WaitingSessionID)
+	}
+	if exec := state.Executions[0]; exec.Status != events.ExecStatusFailed {
+		t.Fatalf("expected waiting execution to be marked failed, got %s", exec.Status)
+	}
+	if len(fm.killed) != 0 {
+		t.Fatalf("expected no process to kill, got %v", fm.killed)
+	}
+}
+
+func TestKillPendingRun(t *testing.T) {
+	p, store, _ := fakeProcessor(t, nil)
+
+	runID, err := store.CreateRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := submitAndWait(t, p, store, runID, CmdKillRun, KillRunPayload{Reason: "not needed"})
+
+	if state.Status != events.RunStatusKilled {
+		t.Fatalf("expected pending run to be killed, got %s", state.Status)
+	}
+}
+
+func TestKillRejectsFinishedRun(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	state = submitAndWait(t, p, store, state.ID, CmdKillRun, KillRunPayload{})
+
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected a complete run to stay complete, got %s", state.Status)
+	}
+}
+
+// writeWorkflowWithLibs writes script and lib/<name>.js files into one
+// workflow directory and returns the script's path.
+func writeWorkflowWithLibs(t *testing.T, script string, libs map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range libs {
+		if err := os.WriteFile(filepath.Join(dir, "lib", name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "wf.js")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUseLoadsLibrary(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    done("coded"),
+		"reviewer": done("approved"),
+	})
+
+	path := writeWorkflowWithLibs(t, `
+		const review = use("review");
+		function workflow(prompt) {
+			review.loop("coder", use("review.js").reviewer);
+		}`, map[string]string{
+		"review.js": `
+			exports.reviewer = "reviewer";
+			exports.loop = function(author, reviewer) {
+				run(author);
+				return run(reviewer);
+			};`,
+	})
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected complete, got %s (%s)", state.Status, state.Error)
+	}
+	if got := strings.Join(fm.startedAgents(), ","); got != "coder,reviewer" {
+		t.Fatalf("expected library to run coder then reviewer, got %s", got)
+	}
+}
+
+func TestUseStaysInLibDir(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.js")
+	if err := os.WriteFile(outside, []byte(`exports.x = 1;`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../wf", "sub/helper", "/etc/passwd", "linked", "missing"} {
+		t.Run(name, func(t *testing.T) {
+			p, store, _ := fakeProcessor(t, nil)
+			path := writeWorkflowWithLibs(t, `
+				function workflow(prompt) { use("`+name+`"); }`, nil)
+			if err := os.Symlink(outside, filepath.Join(filepath.Dir(path), "lib", "linked.js")); err != nil {
+				t.Fatal(err)
+			}
+
+			state := startRun(t, p, store, StartRunPayload{WorkflowPath: path})
+			if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "use(") {
+				t.Fatalf("expected use(%q) to fail the run, got %s (%q)", name, state.Status, state.Error)
+			}
+		})
+	}
+}
+
+func TestRetryCountsAttemptsOnce(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"coder": done("coded"),
+		// reviewer reports nothing, so it fails
+	})
+
+	state := runScript(t, p, store, `
+		function workflow(prompt) {
+			run("coder");
+			run("reviewer");
+		}`)
+	if state.Status != events.RunStatusFailed {
+		t.Fatalf("expected failed, got %s", state.Status)
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdRecoverRun, RecoverRunPayload{Action: RecoverRetry})
+	state = submitAndWait(t, p, store, state.ID, CmdRecoverRun, RecoverRunPayload{Action: RecoverRetry})
+
+	var coder, reviewer []int
+	for _, e := range state.Executions {
+		switch e.AgentName {
+		case "coder":
+			coder = append(coder, e.Attempt)
+		case "reviewer":
+			reviewer = append(reviewer, e.Attempt)
+		}
+	}
+	// coder is replayed on each retry, so it ran (and counted) once
+	if len(coder) != 1 || coder[0] != 1 {
+		t.Fatalf("expected replayed coder to keep a single attempt, got %v", coder)
+	}
+	if len(reviewer) != 3 || reviewer[2] != 3 {
+		t.Fatalf("expected reviewer attempts 1..3, got %v", reviewer)
+	}
+}
+
+func TestStartRunRejectsEmptyPrompt(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+	script := writeScript(t, `function workflow(prompt) { run("coder"); }`)
+
+	state := startRun(t, p, store, StartRunPayload{WorkflowPath: script, InitialPrompt: " \n\t "})
+	if state.Status != events.RunStatusPending || state.WorkspacePath != "" {
+		t.Fatalf("expected a whitespace prompt to be refused, got %s", state.Status)
+	}
+	if len(fm.startedAgents()) != 0 {
+		t.Fatal("expected no agent to start")
+	}
+
+	// What `shop run --prompt-file` reads from an empty file is caught too
+	promptFile := filepath.Join(t.TempDir(), "prompt.md")
+	if err := os.WriteFile(promptFile, []byte("\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(promptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePrompt(string(data)); err == nil || !strings.Contains(err.Error(), "prompt is empty") {
+		t.Fatalf("expected empty prompt file to be rejected, got %v", err)
+	}
+
+	state = startRun(t, p, store, StartRunPayload{WorkflowPath: script, InitialPrompt: " ", AllowEmpty: true})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected AllowEmpty to start the run, got %s (%s)", state.Status, state.Error)
+	}
+}
+
+func TestPauseRequestHonouredAtNextAgent(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{
+		"coder":    done("coded"),
+		"reviewer": done("approved"),
+	})
+	// `shop pause` arrives while coder is working
+	fm.onStart = func(opts process.AgentOpts) {
+		if opts.SignalAgent != "coder" {
+			return
+		}
+		runID, _, err := readMCPConfig(opts.MCPConfigPath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cmd, _ := NewCommand(runID, CmdPauseRun, PauseRunPayload{Reason: "lunch"})
+		if err := store.SubmitCommand(cmd.ID, cmd.RunID, string(cmd.Type), cmd.Payload); err != nil {
+			t.Error(err)
+		}
+	}
+
+	script := `function workflow(prompt) { run("coder"); run("reviewer"); }`
+	state := runScript(t, p, store, script)
+
+	if state.Status != events.RunStatusPaused {
+		t.Fatalf("expected paused, got %s (%s)", state.Status, state.Error)
+	}
+	if !strings.Contains(state.WaitingReason, "pause requested before reviewer: lunch") {
+		t.Fatalf("unexpected pause reason %q", state.WaitingReason)
+	}
+	if got := strings.Join(fm.startedAgents(), ","); got != "coder" {
+		t.Fatalf("expected coder to finish and reviewer not to start, got %s", got)
+	}
+	if state.Executions[0].Status != events.ExecStatusCompleted {
+		t.Fatalf("expected coder to complete, got %s", state.Executions[0].Status)
+	}
+	if state.PauseRequested {
+		t.Fatal("expected the request to be consumed by pausing")
+	}
+
+	state = submitAndWait(t, p, store, state.ID, CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected resume to complete the run, got %s (%s)", state.Status, state.Error)
+	}
+	if got := strings.Join(fm.startedAgents(), ","); got != "coder,reviewer" {
+		t.Fatalf("expected only reviewer to run on resume, got %s", got)
+	}
+}
+
+func TestPauseRejectsRunThatIsNotRunning(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{"coder": done("ok")})
+
+	state := runScript(t, p, store, `function workflow(prompt) { run("coder"); }`)
+	state = submitAndWait(t, p, store, state.ID, CmdPauseRun, PauseRunPayload{})
+
+	if state.Status != events.RunStatusComplete || state.PauseRequested {
+		t.Fatalf("expected pause of a finished run to be refused, got %s (requested=%v)", state.Status, state.PauseRequested)
+	}
+}
+
+func TestStrictReplayFailsOnDeterminismViolation(t *testing.T) {
+	p, store, fm := fakeProcessor(t, map[string]map[string]any{"writer": done("wrote")})
+
+	// History ran coder at call 1, but the script now asks for writer there
+	diverged := func(script string) int64 {
+		ws := t.TempDir()
+		if err := os.Mkdir(filepath.Join(ws, "repo"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return seedRun(t, store,
+			events.MustNewEvent(0, events.EventRunStarted, events.RunStartedPayload{
+				WorkflowName: "test", WorkflowPath: writeScript(t, script), WorkflowSource: script, WorkspacePath: ws,
+			}),
+			events.MustNewEvent(0, events.EventAgentStarted, events.AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+			events.MustNewEvent(0, events.EventAgentCompleted, events.AgentCompletedPayload{AgentName: "coder", CallIndex: 1, Signal: done("coded")}),
+			events.MustNewEvent(0, events.EventRunStuck, events.RunStuckPayload{Reason: "interrupted"}),
+		)
+	}
+	const script = `function workflow(prompt) { run("writer"); }`
+
+	state := submitAndWait(t, p, store, diverged(script), CmdResumeRun, ResumeRunPayload{Strict: true})
+	if state.Status != events.RunStatusFailed || !strings.Contains(state.Error, "determinism violation at call 1") {
+		t.Fatalf("expected strict resume to fail on the violation, got %s (%s)", state.Status, state.Error)
+	}
+
+	state = submitAndWait(t, p, store, diverged(`const settings = { strict_replay: true };`+script), CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusFailed {
+		t.Fatalf("expected strict_replay to fail the resume, got %s (%s)", state.Status, state.Error)
+	}
+	if n := len(fm.startedAgents()); n != 0 {
+		t.Fatalf("expected no agent started by a strict resume, got %d", n)
+	}
+
+	// By default the violation is logged and the call re-run
+	state = submitAndWait(t, p, store, diverged(script), CmdResumeRun, ResumeRunPayload{})
+	if state.Status != events.RunStatusComplete {
+		t.Fatalf("expected the default resume to re-run the call, got %s (%s)", state.Status, state.Error)
+	}
+	warned := false
+	for _, l := range state.LogMessages {
+		warned = warned || strings.Contains(l.Message, "determinism violation")
+	}
+	if !warned || countAgent(fm.startedAgents(), "writer") != 1 {
+		t.Fatalf("expected a warning and writer re-run, got logs %+v and agents %v", state.LogMessages, fm.startedAgents())
+	}
+}
+
+func TestKillRunsOfWorkflow(t *testing.T) {
+	p, store, _ := fakeProcessor(t, map[string]map[string]any{
+		"architect": done("planned"),
+		"coder":     done("coded"),
+		"reviewer":  done("approved"),
+	})
+	path := writeScript(t, untilScript)
+
+	var paused []int64
+	for i := 0; i < 2; i++ {
+		state := startRun(t, p, store, StartRunPayload{WorkflowPath: path, WorkflowName: "feature", Until: "coder"})
+		paused = append(paused, state.ID)
+	}
+	finished := startRun(t, p, store, StartRunPayload{WorkflowPath: path, WorkflowName: "feature"})
+	other := startRun(t, p, store, StartRunPayload{WorkflowPath: path, WorkflowName: "other", Until: "coder"})
+
+	runs, err := store.ListRunsByWorkflow("feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].ID != paused[0] || runs[2].ID != finished.ID {
+		t.Fatalf("expected the 3 feature runs, oldest first, got %+v", runs)
+	}
+
+	for _, state := range runs {
+		if state.Status.IsTerminal() {
+			continue
+		}
+		if err := p.KillRun(state.ID, "cleaning up"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range paused {
+		if state, _ := store.ProjectRunFromDB(id); state.Status != events.RunStatusKilled || state.Error != "cleaning up" {
+			t.Fatalf("expected run %d killed, got %s (%s)", id, state.Status, state.Error)
+		}
+	}
+	if state, _ := store.ProjectRunFromDB(other.ID); state.Status != events.RunStatusPaused {
+		t.Fatalf("expected the other workflow's run left alone, got %s", state.Status)
+	}
+
+	if err := p.KillRun(finished.ID, ""); err == nil {
+		t.Fatal("expected an error killing a finished run")
+	}
+}
@@ -21,6 +21,13 @@ const (
 	CmdKillRun           CommandType = "KillRun"
 	CmdStopRun           CommandType = "StopRun"
 	CmdDeleteRun         CommandType = "DeleteRun"
+	CmdRecoverRun        CommandType = "RecoverRun"
+	CmdHandoffRun        CommandType = "HandoffRun"
+	CmdStepRun           CommandType = "StepRun"
+	CmdResetRun          CommandType = "ResetRun"
+	CmdAdoptRun          CommandType = "AdoptRun"
+	CmdPauseRun          CommandType = "PauseRun"
+	CmdSetRunMetadata    CommandType = "SetRunMetadata"
 )
 
 // CommandStatus represents the processing state of a command.
@@ -65,11 +72,27 @@ func NewCommand(runID int64, cmdType CommandType, payload any) (Command, error)
 type StartRunPayload struct {
 	WorkflowPath string `json:"workflow_path"`
 	WorkflowName string `json:"workflow_name"`
+	WorkflowSource string `json:"workflow_source,omitempty"` // script given inline (shop run -) instead of WorkflowPath
 	InitialPrompt string `json:"initial_prompt"`
 	SourceRepo   string `json:"source_repo"`
+	Until        string `json:"until,omitempty"` // pause before this agent's first fresh run
+	AllowEmpty   bool   `json:"allow_empty,omitempty"` // accept an empty or whitespace-only prompt
+	KeepGoing    bool   `json:"keep_going,omitempty"`  // hand STUCK signals back to the script instead of suspending
+	Priority     int    `json:"priority,omitempty"`    // agents of higher-priority runs get a free slot first
+
+	// Metadata is attached to the run as it starts (see SetRunMetadataPayload).
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// ParentRunID and ParentCallIndex start the run as the child of another
+	// run's run_workflow() call, working in the parent's workspace.
+	ParentRunID     int64 `json:"parent_run_id,omitempty"`
+	ParentCallIndex int   `json:"parent_call_index,omitempty"`
 }
 
-type ExecuteWorkflowPayload struct{}
+type ExecuteWorkflowPayload struct {
+	Until  string `json:"until,omitempty"`
+	Strict bool   `json:"strict,omitempty"`
+}
 
 type ExecuteAgentPayload struct {
 	AgentName string `json:"agent_name"`
@@ -80,6 +103,7 @@ type ExecuteAgentPayload struct {
 
 type ReportSignalPayload struct {
 	CallIndex int            `json:"call_index"`
+	AgentName string         `json:"agent_name,omitempty"` // the reporting agent; a signal for a call now running another agent is refused
 	Status    string         `json:"status"`
 	Summary   string         `json:"summary,omitempty"`
 	Reason    string         `json:"reason,omitempty"`
@@ -96,12 +120,65 @@ type ProvideHumanInputPayload struct {
 	Signal    map[string]any `json:"signal"`
 }
 
-type ResumeRunPayload struct{}
+type ResumeRunPayload struct {
+	Until  string `json:"until,omitempty"`
+	Repo   string `json:"repo,omitempty"`   // the source repo's new location, to relink the worktree to first
+	Strict bool   `json:"strict,omitempty"` // fail on a determinism violation instead of re-running the call
+}
 
-type KillRunPayload struct{}
+type KillRunPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
 
 type StopRunPayload struct {
 	Reason string `json:"reason,omitempty"`
 }
 
 type DeleteRunPayload struct{}
+
+// RecoverAction selects how RecoverRun gets a stuck or failed run moving again.
+type RecoverAction string
+
+const (
+	RecoverRetry    RecoverAction = "retry"    // re-run the last execution
+	RecoverSignal   RecoverAction = "signal"   // replace the last execution's signal
+	RecoverComplete RecoverAction = "complete" // mark the run complete as-is
+)
+
+type RecoverRunPayload struct {
+	Action RecoverAction  `json:"action"`
+	Signal map[string]any `json:"signal,omitempty"`
+	Agent  string         `json:"agent,omitempty"` // with signal: the agent the last execution must belong to
+}
+
+type HandoffRunPayload struct {
+	Agent string `json:"agent"`
+}
+
+type StepRunPayload struct {
+	Agent string `json:"agent"`
+}
+
+type ResetRunPayload struct {
+	Hard bool `json:"hard,omitempty"` // also git reset the worktree and clear scratchpads
+}
+
+// AdoptRunPayload rebuilds a run around a branch left by a run whose
+// history was lost. The workflow and prompt are optional.
+type AdoptRunPayload struct {
+	SourceRepo    string `json:"source_repo"`
+	Branch        string `json:"branch"`
+	WorkflowPath  string `json:"workflow_path,omitempty"`
+	WorkflowName  string `json:"workflow_name,omitempty"`
+	InitialPrompt string `json:"initial_prompt,omitempty"`
+}
+
+type PauseRunPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// SetRunMetadataPayload merges key/value pairs into a run's metadata; an
+// empty value removes the key.
+type SetRunMetadataPayload struct {
+	Metadata map[string]string `json:"metadata"`
+}
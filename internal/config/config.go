@@ -1,8 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
 )
 
 type Config struct {
@@ -10,8 +16,22 @@ type Config struct {
 	DBPath             string
 	UserWorkflowDir    string
 	ProjectWorkflowDir string
+
+	// InstanceID namespaces workspace paths and branch names (shop/<instance>/run-N)
+	// so several shop installs can share a source repo. Set via SHOP_INSTANCE_ID,
+	// otherwise generated once and stored in DataDir.
+	InstanceID string
+
+	// Theme names the TUI's colour theme (SHOP_THEME); empty is the default.
+	Theme string
+
+	// MaxConcurrentClaude caps the agents running at once across every shop
+	// process on the host (SHOP_MAX_CONCURRENT_CLAUDE); 0 means no limit.
+	MaxConcurrentClaude int
 }
 
+var validInstanceID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 func New() (*Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -25,6 +45,25 @@ func New() (*Config, error) {
 		DBPath:             filepath.Join(dataDir, "shop.db"),
 		UserWorkflowDir:    filepath.Join(dataDir, "workflows"),
 		ProjectWorkflowDir: ".shop/workflows",
+		InstanceID:         os.Getenv("SHOP_INSTANCE_ID"),
+		Theme:              os.Getenv("SHOP_THEME"),
+	}
+
+	if c.InstanceID == "" {
+		if data, err := os.ReadFile(c.instanceIDPath()); err == nil {
+			c.InstanceID = strings.TrimSpace(string(data))
+		}
+	}
+	if c.InstanceID != "" && !validInstanceID.MatchString(c.InstanceID) {
+		return nil, fmt.Errorf("invalid instance ID %q: use letters, digits, '-' or '_'", c.InstanceID)
+	}
+
+	if v := os.Getenv("SHOP_MAX_CONCURRENT_CLAUDE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid SHOP_MAX_CONCURRENT_CLAUDE %q: use a number of agents, or 0 for no limit", v)
+		}
+		c.MaxConcurrentClaude = n
 	}
 
 	return c, nil
@@ -37,13 +76,34 @@ func (c *Config) EnsureDataDir() error {
 	if err := os.MkdirAll(c.UserWorkflowDir, 0755); err != nil {
 		return err
 	}
+	if c.InstanceID == "" {
+		id := uuid.New().String()[:8]
+		if err := os.WriteFile(c.instanceIDPath(), []byte(id+"\n"), 0644); err != nil {
+			return err
+		}
+		c.InstanceID = id
+	}
 	return nil
 }
 
+func (c *Config) instanceIDPath() string {
+	return filepath.Join(c.DataDir, "instance_id")
+}
+
 func (c *Config) WorkspacesDir() string {
 	return filepath.Join(c.DataDir, "workspaces")
 }
 
+// ClaudeSlotsDir holds the lock files that enforce MaxConcurrentClaude.
+func (c *Config) ClaudeSlotsDir() string {
+	return filepath.Join(c.DataDir, "claude-slots")
+}
+
+// RepoCacheDir holds clones of remote repos given to --repo.
+func (c *Config) RepoCacheDir() string {
+	return filepath.Join(c.DataDir, "repos")
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -53,59 +113,104 @@ func getEnv(key, defaultValue string) string {
 
 // WorkflowInfo contains metadata about a discovered workflow
 type WorkflowInfo struct {
-	Name   string // Display name (filename without extension)
-	Path   string // Full path to the workflow file
-	Source string // "project" or "user"
+	Name        string // Display name (filename without extension)
+	Path        string // Full path to the workflow file
+	Source      string // "project" or "user"
+	Description string // From a top-level `description` string or the leading // comment
+}
+
+// workflowDirs lists the directories workflows are discovered in, in
+// precedence order: a project workflow shadows a user one of the same name.
+func (c *Config) workflowDirs() []struct{ dir, source string } {
+	return []struct{ dir, source string }{
+		{c.ProjectWorkflowDir, "project"},
+		{c.UserWorkflowDir, "user"},
+	}
 }
 
 // ListWorkflows returns all available workflows from both project and user directories
 func (c *Config) ListWorkflows() ([]WorkflowInfo, error) {
 	var workflows []WorkflowInfo
+	seen := map[string]bool{}
 
-	// Check project directory first (.shop/workflows/)
-	if entries, err := os.ReadDir(c.ProjectWorkflowDir); err == nil {
+	for _, d := range c.workflowDirs() {
+		entries, err := os.ReadDir(d.dir)
+		if err != nil {
+			continue
+		}
 		for _, entry := range entries {
-			if entry.IsDir() {
+			name := entry.Name()
+			if entry.IsDir() || filepath.Ext(name) != ".js" {
 				continue
 			}
-			name := entry.Name()
-			if filepath.Ext(name) == ".js" {
-				workflows = append(workflows, WorkflowInfo{
-					Name:   name[:len(name)-3], // Remove .js extension
-					Path:   filepath.Join(c.ProjectWorkflowDir, name),
-					Source: "project",
-				})
+			baseName := strings.TrimSuffix(name, ".js")
+			if seen[baseName] {
+				continue
 			}
+			seen[baseName] = true
+			path := filepath.Join(d.dir, name)
+			workflows = append(workflows, WorkflowInfo{
+				Name:        baseName,
+				Path:        path,
+				Source:      d.source,
+				Description: ReadDescription(path),
+			})
 		}
 	}
 
-	// Check user directory (~/.shop/workflows/)
-	if entries, err := os.ReadDir(c.UserWorkflowDir); err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			name := entry.Name()
-			if filepath.Ext(name) == ".js" {
-				// Skip if already added from project dir
-				baseName := name[:len(name)-3]
-				exists := false
-				for _, w := range workflows {
-					if w.Name == baseName {
-						exists = true
-						break
-					}
-				}
-				if !exists {
-					workflows = append(workflows, WorkflowInfo{
-						Name:   baseName,
-						Path:   filepath.Join(c.UserWorkflowDir, name),
-						Source: "user",
-					})
-				}
-			}
+	return workflows, nil
+}
+
+// FindWorkflow resolves a workflow by name (with or without .js) using the
+// same directories and precedence as ListWorkflows.
+func (c *Config) FindWorkflow(name string) (WorkflowInfo, bool) {
+	baseName := strings.TrimSuffix(name, ".js")
+	for _, d := range c.workflowDirs() {
+		path := filepath.Join(d.dir, baseName+".js")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return WorkflowInfo{
+				Name:        baseName,
+				Path:        path,
+				Source:      d.source,
+				Description: ReadDescription(path),
+			}, true
 		}
 	}
+	return WorkflowInfo{}, false
+}
+
+var descriptionGlobal = regexp.MustCompile(`(?m)^(?:const|let|var)\s+description\s*=\s*(?:"([^"]*)"|'([^']*)'|` + "`([^`]*)`" + `)`)
+
+// ReadDescription extracts a workflow's description without running it: a
+// top-level `const description = "..."` string wins, otherwise the leading
+// block of // comments (ignoring a line that only names the file).
+func ReadDescription(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	src := string(data)
 
-	return workflows, nil
+	if m := descriptionGlobal.FindStringSubmatch(src); m != nil {
+		return strings.TrimSpace(m[1] + m[2] + m[3])
+	}
+
+	var lines []string
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" && len(lines) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		if text == filepath.Base(path) {
+			continue
+		}
+		if text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, " ")
 }
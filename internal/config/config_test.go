@@ -0,0 +1,82 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDescription(t *testing.T) {
+	cases := []struct {
+		name, src, want string
+	}{
+		{"global", "// ignored\nconst description = \"Review loop\";\nfunction workflow() {}", "Review loop"},
+		{"single quotes", "let description = 'Ship it'\n", "Ship it"},
+		{"leading comment", "\n// code-review.js\n// Architect, then code\n// until approved.\n\nfunction workflow() {}", "Architect, then code until approved."},
+		{"none", "function workflow() {}\n// trailing", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "code-review.js")
+			if err := os.WriteFile(path, []byte(tc.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := ReadDescription(path); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWorkflowDiscoveryAcrossDirectories(t *testing.T) {
+	project, user := t.TempDir(), t.TempDir()
+	c := &Config{ProjectWorkflowDir: project, UserWorkflowDir: user}
+
+	write := func(dir, name, src string) {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(project, "review.js", "// Project review\n")
+	write(project, "notes.md", "not a workflow")
+	write(project, "lib/helpers.js", "exports.x = 1;")
+	write(user, "review.js", "// User review\n")
+	write(user, "deploy.js", "// Deploy\n")
+	if err := os.Mkdir(filepath.Join(user, "dir.js"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	workflows, err := c.ListWorkflows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]WorkflowInfo{}
+	for _, w := range workflows {
+		got[w.Name] = w
+	}
+	if len(got) != 2 || len(workflows) != 2 {
+		t.Fatalf("expected review and deploy only, got %+v", workflows)
+	}
+	if w := got["review"]; w.Source != "project" || w.Description != "Project review" {
+		t.Fatalf("expected the project review to shadow the user one, got %+v", w)
+	}
+	if w := got["deploy"]; w.Source != "user" || w.Path != filepath.Join(user, "deploy.js") {
+		t.Fatalf("unexpected deploy workflow: %+v", w)
+	}
+
+	for _, name := range []string{"review", "review.js", "deploy"} {
+		w, ok := c.FindWorkflow(name)
+		if !ok || w.Path != got[w.Name].Path {
+			t.Errorf("FindWorkflow(%q) = %+v, %v; want the listed workflow", name, w, ok)
+		}
+	}
+	for _, name := range []string{"notes", "helpers", "dir", "missing"} {
+		if w, ok := c.FindWorkflow(name); ok {
+			t.Errorf("FindWorkflow(%q) found %s", name, w.Path)
+		}
+	}
+}
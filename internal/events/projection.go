@@ -1,6 +1,10 @@
 package events
 
-import "time"
+import (
+	"regexp"
+	"strings"
+	"time"
+)
 
 // RunStatus represents the current status of a run, derived from events.
 type RunStatus string
@@ -12,6 +16,7 @@ const (
 	RunStatusFailed       RunStatus = "failed"
 	RunStatusStuck        RunStatus = "stuck"
 	RunStatusWaitingHuman RunStatus = "waiting_human"
+	RunStatusPaused       RunStatus = "paused"
 	RunStatusKilled       RunStatus = "killed"
 	RunStatusDeleted      RunStatus = "deleted"
 )
@@ -25,6 +30,12 @@ func (s RunStatus) IsTerminal() bool {
 	return false
 }
 
+// IsSuspended returns true if the run is idle until a human acts on it:
+// waiting on an agent's question, or paused at a breakpoint.
+func (s RunStatus) IsSuspended() bool {
+	return s == RunStatusWaitingHuman || s == RunStatusPaused
+}
+
 // ExecStatus represents the status of an agent execution.
 type ExecStatus string
 
@@ -33,42 +44,119 @@ const (
 	ExecStatusCompleted    ExecStatus = "completed"
 	ExecStatusFailed       ExecStatus = "failed"
 	ExecStatusWaitingHuman ExecStatus = "waiting_human"
+	ExecStatusInvalidated  ExecStatus = "invalidated"
 )
 
 // RunState is the in-memory projection of a run, built by folding events.
 type RunState struct {
 	ID        int64
 	CreatedAt time.Time
+	StartedAt time.Time // when RunStarted was recorded; stable across resumes
 	Version   int
 
+	// FinishedAt is when the run last completed, failed, got stuck or was
+	// killed; nil while it is pending, running, waiting or paused.
+	FinishedAt *time.Time
+
 	// Derived from events
 	Status           RunStatus
 	WorkflowPath     string
 	WorkflowName     string
+	WorkflowSource   string // captured at start; empty for runs that predate it
 	InitialPrompt    string
 	WorkspacePath    string
+	Branch           string
+	BaseCommit       string // commit the branch was created from; empty if unknown
 	Error            string
 	WaitingReason    string
 	WaitingSessionID string
 	CurrentAgent     string
 
+	// WaitingSince is when the run last started waiting for a human; nil
+	// once it resumes, is killed, stopped or reset.
+	WaitingSince *time.Time
+
+	// ContextHeader is the rendered brief heading get_context (see
+	// ContextInitialized); empty for runs that predate it.
+	ContextHeader string
+
+	// ParentRunID is the run whose run_workflow() call started this one,
+	// at ParentCallIndex; 0 for runs started directly. A child run works
+	// in its parent's workspace.
+	ParentRunID     int64
+	ParentCallIndex int
+
+	// KeepGoing is set for runs started with --keep-going: a STUCK agent
+	// doesn't suspend the run, the script gets its signal and carries on.
+	KeepGoing bool
+
+	// Priority ranks the run's agents for a free agent slot; 0 by default.
+	Priority int
+
+	// Summary is the digest of agent summaries recorded when the run last
+	// finished (see RunSummarized); cleared when it resumes.
+	Summary string
+
+	// PausedCallIndex is the call a breakpoint last paused the run before;
+	// resuming runs that call even if it matches the breakpoint again.
+	PausedCallIndex int
+
+	// PauseRequested is set by `shop pause` until the run pauses at the
+	// next agent boundary (or is resumed); PauseReason says why.
+	PauseRequested bool
+	PauseReason    string
+
+	// Metadata holds key/value pairs attached by other systems (a PR
+	// number, a ticket ID) to correlate the run with their own records.
+	Metadata map[string]string
+
 	// Execution history
 	Executions []ExecutionState
 
+	// Handoffs maps a call_index to the agent that replaces the script's
+	// choice when that call is re-run (see AgentHandedOff).
+	Handoffs map[int]string
+
 	// Log
 	LogMessages []LogEntry
 }
 
 // ExecutionState represents a single agent execution within a run.
 type ExecutionState struct {
-	AgentName   string
-	CallIndex   int
-	SessionID   string
-	PID         int
-	Status      ExecStatus
-	Signal      map[string]any
-	Prompt      string
-	Model       string
+	AgentName    string
+	CallIndex    int
+	SessionID    string
+	PID          int
+	Status       ExecStatus
+	Attempt      int // 1 for the first run of this agent at CallIndex, 2 for its first retry, ...
+	Signal       map[string]any
+	Artifacts    []string
+	Result       *AgentResult // nil when Claude's JSON output wasn't captured
+	Prompt       string
+	SentPrompt   string // the full prompt Claude received; empty for runs that predate it
+	Model        string
+	OutputFormat string
+	Error        string
+
+	// SkippedPermissions records that Claude ran with
+	// --dangerously-skip-permissions, for auditing.
+	SkippedPermissions bool
+
+	// SignalReminders counts the times the agent finished without a signal
+	// and was reminded to report one.
+	SignalReminders int
+
+	// ChildRunID is the run a run_workflow() call started; 0 for agents.
+	ChildRunID int64
+
+	// Finishing is set for calls made after the workflow returned (the
+	// finally agent, on_finish hooks).
+	Finishing bool
+
+	// WaitingReason is why this execution asked for a human; unlike
+	// RunState.WaitingReason it survives the run resuming.
+	WaitingReason string
+
 	StartedAt   time.Time
 	CompletedAt *time.Time
 }
@@ -76,9 +164,40 @@ type ExecutionState struct {
 // LogEntry represents a log message emitted during workflow execution.
 type LogEntry struct {
 	Message   string
+	Level     string // LogLevelInfo or LogLevelWarn
+	Agent     string // the agent that ran last before the line was logged
 	CreatedAt time.Time
 }
 
+// LogFilter selects log entries; zero fields match everything.
+type LogFilter struct {
+	Pattern *regexp.Regexp // matched against the message
+	Level   string         // minimum level: LogLevelWarn keeps only warnings
+	Agent   string
+}
+
+// Match reports whether e passes every filter that is set.
+func (f LogFilter) Match(e LogEntry) bool {
+	if f.Level == LogLevelWarn && e.Level != LogLevelWarn {
+		return false
+	}
+	if f.Agent != "" && e.Agent != f.Agent {
+		return false
+	}
+	return f.Pattern == nil || f.Pattern.MatchString(e.Message)
+}
+
+// FilterLogs returns the entries f matches, in order.
+func FilterLogs(entries []LogEntry, f LogFilter) []LogEntry {
+	var out []LogEntry
+	for _, e := range entries {
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
 // ProjectRun folds a sequence of events into a RunState.
 func ProjectRun(id int64, createdAt time.Time, eventList []Event) *RunState {
 	state := &RunState{
@@ -95,73 +214,171 @@ func ProjectRun(id int64, createdAt time.Time, eventList []Event) *RunState {
 	return state
 }
 
+// Apply folds further events into s. Events at or below s.Version have
+// already been applied and are skipped.
+func (s *RunState) Apply(eventList []Event) {
+	for _, e := range eventList {
+		if e.Version <= s.Version {
+			continue
+		}
+		s.Version = e.Version
+		applyEvent(s, e)
+	}
+}
+
 func applyEvent(state *RunState, e Event) {
 	switch e.EventType {
 	case EventRunStarted:
 		p, _ := DecodePayload[RunStartedPayload](e)
 		state.Status = RunStatusRunning
+		state.StartedAt = e.CreatedAt
 		state.WorkflowPath = p.WorkflowPath
 		state.WorkflowName = p.WorkflowName
+		state.WorkflowSource = p.WorkflowSource
 		state.InitialPrompt = p.InitialPrompt
 		state.WorkspacePath = p.WorkspacePath
+		state.Branch = p.Branch
+		state.BaseCommit = p.BaseCommit
+		state.ParentRunID = p.ParentRunID
+		state.ParentCallIndex = p.ParentCallIndex
+		state.KeepGoing = p.KeepGoing
+		state.Priority = p.Priority
 
 	case EventRunResumed:
 		state.Status = RunStatusRunning
+		state.Error = ""
+		state.FinishedAt = nil
 		state.WaitingReason = ""
 		state.WaitingSessionID = ""
+		state.WaitingSince = nil
+		state.PauseRequested = false
+		state.PauseReason = ""
+		state.Summary = ""
 
 	case EventRunCompleted:
 		state.Status = RunStatusComplete
+		state.Error = ""
 		state.CurrentAgent = ""
+		state.FinishedAt = eventTime(e)
 
 	case EventRunFailed:
 		p, _ := DecodePayload[RunFailedPayload](e)
 		state.Status = RunStatusFailed
 		state.Error = p.Error
 		state.CurrentAgent = ""
+		state.FinishedAt = eventTime(e)
 
 	case EventRunStuck:
 		p, _ := DecodePayload[RunStuckPayload](e)
 		state.Status = RunStatusStuck
 		state.WaitingReason = p.Reason
 		state.CurrentAgent = ""
+		state.FinishedAt = eventTime(e)
 
 	case EventRunWaitingHuman:
 		p, _ := DecodePayload[RunWaitingHumanPayload](e)
 		state.Status = RunStatusWaitingHuman
 		state.WaitingReason = p.Reason
 		state.WaitingSessionID = p.SessionID
+		state.WaitingSince = eventTime(e)
 		// Update the execution status at this call_index
 		if exec := getExecution(state, p.CallIndex); exec != nil {
 			exec.Status = ExecStatusWaitingHuman
+			exec.WaitingReason = p.Reason
+		}
+
+	case EventRunPaused:
+		p, _ := DecodePayload[RunPausedPayload](e)
+		state.Status = RunStatusPaused
+		state.WaitingReason = p.Reason
+		state.PausedCallIndex = p.CallIndex
+		state.CurrentAgent = ""
+		state.PauseRequested = false
+		state.PauseReason = ""
+
+	case EventPauseRequested:
+		p, _ := DecodePayload[PauseRequestedPayload](e)
+		state.PauseRequested = true
+		state.PauseReason = p.Reason
+
+	case EventRunMetadataSet:
+		p, _ := DecodePayload[RunMetadataSetPayload](e)
+		for k, v := range p.Metadata {
+			if v == "" {
+				delete(state.Metadata, k)
+				continue
+			}
+			if state.Metadata == nil {
+				state.Metadata = map[string]string{}
+			}
+			state.Metadata[k] = v
 		}
 
 	case EventRunKilled:
+		p, _ := DecodePayload[RunKilledPayload](e)
 		state.Status = RunStatusKilled
+		state.Error = p.Reason
+		state.WaitingReason = ""
+		state.WaitingSessionID = ""
+		state.WaitingSince = nil
 		state.CurrentAgent = ""
+		state.FinishedAt = eventTime(e)
+		// Nothing is left running: close out unfinished executions
+		for i := range state.Executions {
+			switch state.Executions[i].Status {
+			case ExecStatusStarted, ExecStatusWaitingHuman:
+				state.Executions[i].Status = ExecStatusFailed
+				state.Executions[i].Error = "killed"
+				now := e.CreatedAt
+				state.Executions[i].CompletedAt = &now
+			}
+		}
 
 	case EventRunStopped:
 		p, _ := DecodePayload[RunStoppedPayload](e)
 		state.Status = RunStatusStuck
 		state.WaitingReason = p.Reason
 		state.WaitingSessionID = ""
+		state.WaitingSince = nil
 		state.CurrentAgent = ""
+		state.FinishedAt = eventTime(e)
 
 	case EventRunDeleted:
 		state.Status = RunStatusDeleted
 
+	case EventRunReset:
+		state.Status = RunStatusPending
+		state.Executions = nil
+		state.Handoffs = nil
+		state.LogMessages = nil
+		state.Error = ""
+		state.WaitingReason = ""
+		state.WaitingSessionID = ""
+		state.WaitingSince = nil
+		state.CurrentAgent = ""
+		state.PausedCallIndex = 0
+		state.Summary = ""
+		state.FinishedAt = nil
+
 	case EventAgentStarted:
 		p, _ := DecodePayload[AgentStartedPayload](e)
 		state.CurrentAgent = p.AgentName
 		state.Executions = append(state.Executions, ExecutionState{
-			AgentName: p.AgentName,
-			CallIndex: p.CallIndex,
-			SessionID: p.SessionID,
-			PID:       p.PID,
-			Status:    ExecStatusStarted,
-			Prompt:    p.Prompt,
-			Model:     p.Model,
-			StartedAt: e.CreatedAt,
+			AgentName:    p.AgentName,
+			CallIndex:    p.CallIndex,
+			SessionID:    p.SessionID,
+			PID:          p.PID,
+			Status:       ExecStatusStarted,
+			Attempt:      nextAttempt(state, p.CallIndex, p.AgentName),
+			Prompt:       p.Prompt,
+			SentPrompt:   p.SentPrompt,
+			Model:        p.Model,
+			OutputFormat: p.OutputFormat,
+			ChildRunID:   p.ChildRunID,
+			Finishing:    p.Finishing,
+			StartedAt:    e.CreatedAt,
+
+			SkippedPermissions: p.SkippedPermissions,
 		})
 
 	case EventAgentCompleted:
@@ -169,6 +386,8 @@ func applyEvent(state *RunState, e Event) {
 		if exec := getExecution(state, p.CallIndex); exec != nil {
 			exec.Status = ExecStatusCompleted
 			exec.Signal = p.Signal
+			exec.Artifacts = p.Artifacts
+			exec.Result = p.Result
 			now := e.CreatedAt
 			exec.CompletedAt = &now
 		}
@@ -178,6 +397,8 @@ func applyEvent(state *RunState, e Event) {
 		p, _ := DecodePayload[AgentFailedPayload](e)
 		if exec := getExecution(state, p.CallIndex); exec != nil {
 			exec.Status = ExecStatusFailed
+			exec.Error = p.Error
+			exec.Result = p.Result
 			now := e.CreatedAt
 			exec.CompletedAt = &now
 		}
@@ -189,16 +410,39 @@ func applyEvent(state *RunState, e Event) {
 			exec.Signal = p.Signal
 		}
 
+	case EventAgentHandedOff:
+		p, _ := DecodePayload[AgentHandedOffPayload](e)
+		for i := range state.Executions {
+			if state.Executions[i].CallIndex >= p.CallIndex {
+				state.Executions[i].Status = ExecStatusInvalidated
+			}
+		}
+		if state.Handoffs == nil {
+			state.Handoffs = make(map[int]string)
+		}
+		state.Handoffs[p.CallIndex] = p.ToAgent
+
+	case EventSignalReminded:
+		p, _ := DecodePayload[SignalRemindedPayload](e)
+		if exec := getExecution(state, p.CallIndex); exec != nil {
+			exec.SignalReminders++
+			exec.PID = p.PID
+		}
+
 	case EventCheckpointStarted:
 		p, _ := DecodePayload[CheckpointStartedPayload](e)
 		state.CurrentAgent = "_checkpoint"
 		state.Executions = append(state.Executions, ExecutionState{
-			AgentName: "_checkpoint",
-			CallIndex: p.CallIndex,
-			SessionID: p.SessionID,
-			Status:    ExecStatusStarted,
-			Prompt:    p.Message,
-			StartedAt: e.CreatedAt,
+			AgentName:  "_checkpoint",
+			CallIndex:  p.CallIndex,
+			SessionID:  p.SessionID,
+			Status:     ExecStatusStarted,
+			Attempt:    nextAttempt(state, p.CallIndex, "_checkpoint"),
+			Prompt:     p.Message,
+			SentPrompt: p.SentPrompt,
+			StartedAt:  e.CreatedAt,
+
+			SkippedPermissions: p.SkippedPermissions,
 		})
 
 	case EventCheckpointCompleted:
@@ -216,26 +460,66 @@ func applyEvent(state *RunState, e Event) {
 		if exec := getExecution(state, p.CallIndex); exec != nil {
 			exec.Signal = p.Signal
 			exec.Status = ExecStatusCompleted
+			exec.Error = ""
 			now := e.CreatedAt
 			exec.CompletedAt = &now
 		}
 
 	case EventReplayInvalidated:
-		// Informational; no projection change needed
+		p, _ := DecodePayload[ReplayInvalidatedPayload](e)
+		for i := range state.Executions {
+			if state.Executions[i].CallIndex >= p.FromCallIndex {
+				state.Executions[i].Status = ExecStatusInvalidated
+			}
+		}
+
+	case EventContextInitialized:
+		p, _ := DecodePayload[ContextInitializedPayload](e)
+		state.ContextHeader = p.Content
+
+	case EventRunSummarized:
+		p, _ := DecodePayload[RunSummarizedPayload](e)
+		state.Summary = p.Summary
 
 	case EventLogMessage:
 		p, _ := DecodePayload[LogMessagePayload](e)
-		state.LogMessages = append(state.LogMessages, LogEntry{
+		entry := LogEntry{
 			Message:   p.Message,
+			Level:     p.Level,
 			CreatedAt: e.CreatedAt,
-		})
+		}
+		if entry.Level == "" {
+			entry.Level = LogLevelInfo
+		}
+		if last := state.LastExecution(); last != nil {
+			entry.Agent = last.AgentName
+		}
+		state.LogMessages = append(state.LogMessages, entry)
 	}
 }
 
+// nextAttempt numbers a new execution of agent at callIndex. Earlier runs of
+// the same call (failed, invalidated, or replaced after a determinism
+// violation) stay in Executions, so counting them gives the attempt; calls
+// satisfied from replay start no execution and never count.
+func nextAttempt(state *RunState, callIndex int, agent string) int {
+	n := 1
+	for _, e := range state.Executions {
+		if e.CallIndex == callIndex && e.AgentName == agent {
+			n++
+		}
+	}
+	return n
+}
+
 // getExecution returns a pointer to the execution at callIndex, or nil.
 // Searches backwards since the most recent match is usually desired.
+// Invalidated executions are skipped so replay re-runs that call.
 func getExecution(state *RunState, callIndex int) *ExecutionState {
 	for i := len(state.Executions) - 1; i >= 0; i-- {
+		if state.Executions[i].Status == ExecStatusInvalidated {
+			continue
+		}
 		if state.Executions[i].CallIndex == callIndex {
 			return &state.Executions[i]
 		}
@@ -243,11 +527,150 @@ func getExecution(state *RunState, callIndex int) *ExecutionState {
 	return nil
 }
 
+// WaitingExecution returns the execution waiting for a human, or nil. Use
+// its AgentName for the waiting agent: CurrentAgent is cleared once an
+// agent that reported STUCK completes.
+func (s *RunState) WaitingExecution() *ExecutionState {
+	for i := len(s.Executions) - 1; i >= 0; i-- {
+		if s.Executions[i].Status == ExecStatusWaitingHuman {
+			return &s.Executions[i]
+		}
+	}
+	return nil
+}
+
 // GetExecutionByCallIndex returns the execution at callIndex, or nil.
 func (s *RunState) GetExecutionByCallIndex(callIndex int) *ExecutionState {
 	return getExecution(s, callIndex)
 }
 
+// eventTime returns a pointer to a copy of e's timestamp.
+func eventTime(e Event) *time.Time {
+	t := e.CreatedAt
+	return &t
+}
+
+// FinalSignal returns the signal the workflow ended on: that of its most
+// recent completed execution that hasn't been invalidated, or nil if none
+// has completed. As when the runtime classifies the run, pause()
+// checkpoints (and other "_" executions) and calls made while finishing
+// don't count.
+func (s *RunState) FinalSignal() map[string]any {
+	for i := len(s.Executions) - 1; i >= 0; i-- {
+		exec := s.Executions[i]
+		if exec.Status == ExecStatusCompleted && !exec.Finishing && !strings.HasPrefix(exec.AgentName, "_") {
+			return exec.Signal
+		}
+	}
+	return nil
+}
+
+// FinalStatus returns the status of FinalSignal, or "" if there is none.
+func (s *RunState) FinalStatus() string {
+	status, _ := s.FinalSignal()["status"].(string)
+	return status
+}
+
+// LastExecution returns the most recent execution that hasn't been
+// invalidated, or nil.
+func (s *RunState) LastExecution() *ExecutionState {
+	for i := len(s.Executions) - 1; i >= 0; i-- {
+		if s.Executions[i].Status != ExecStatusInvalidated {
+			return &s.Executions[i]
+		}
+	}
+	return nil
+}
+
+// LastWorkflowExecution is LastExecution without the calls made after the
+// workflow returned (the finally agent, on_finish hooks): the execution a
+// failed or stuck run stopped at, which recovery acts on.
+func (s *RunState) LastWorkflowExecution() *ExecutionState {
+	for i := len(s.Executions) - 1; i >= 0; i-- {
+		if exec := &s.Executions[i]; exec.Status != ExecStatusInvalidated && !exec.Finishing {
+			return exec
+		}
+	}
+	return nil
+}
+
+// Progress is how far a run has got through its workflow, derived from its
+// current (non-invalidated) executions so it survives crashes and resumes.
+type Progress struct {
+	Step      int      // highest call index reached
+	Agents    []string // the agent of each call reached, in call order; a retried call counts once
+	LastAgent string   // agent of the most recent completed execution
+}
+
+// Progress returns how far the run has got.
+func (s *RunState) Progress() Progress {
+	var p Progress
+	byCall := map[int]string{}
+	for _, exec := range s.Executions {
+		if exec.Status == ExecStatusInvalidated {
+			continue
+		}
+		byCall[exec.CallIndex] = exec.AgentName
+		p.Step = max(p.Step, exec.CallIndex)
+		if exec.Status == ExecStatusCompleted {
+			p.LastAgent = exec.AgentName
+		}
+	}
+	for call := 1; call <= p.Step; call++ {
+		if agent, ok := byCall[call]; ok {
+			p.Agents = append(p.Agents, agent)
+		}
+	}
+	return p
+}
+
+// RunSummary is a run's state plus counts over its executions, for run lists.
+type RunSummary struct {
+	*RunState
+	ExecutionCount int        // executions that haven't been invalidated
+	FailedCount    int        // of those, how many failed
+	LastAgent      string     // agent of the most recent execution
+	LastStatus     ExecStatus // status of the most recent execution
+}
+
+// Summarize counts the run's current (non-invalidated) executions.
+func (s *RunState) Summarize() RunSummary {
+	sum := RunSummary{RunState: s}
+	for _, exec := range s.Executions {
+		if exec.Status == ExecStatusInvalidated {
+			continue
+		}
+		sum.ExecutionCount++
+		if exec.Status == ExecStatusFailed {
+			sum.FailedCount++
+		}
+	}
+	if last := s.LastExecution(); last != nil {
+		sum.LastAgent = last.AgentName
+		sum.LastStatus = last.Status
+	}
+	return sum
+}
+
+// AgentSummaries lists the summary field of each current execution's
+// signal, in call order, as "agent: summary" lines. Executions without a
+// summary are skipped.
+func (s *RunState) AgentSummaries() string {
+	var lines []string
+	for _, exec := range s.Executions {
+		if exec.Status == ExecStatusInvalidated {
+			continue
+		}
+		summary, _ := exec.Signal["summary"].(string)
+		summary = strings.Join(strings.Fields(summary), " ")
+		if summary == "" {
+			continue
+		}
+		lines = append(lines, exec.AgentName+": "+summary)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // ActivePID returns the PID of the currently running agent, or 0.
 func (s *RunState) ActivePID() int {
 	for i := len(s.Executions) - 1; i >= 0; i-- {
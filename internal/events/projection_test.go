@@ -1,6 +1,8 @@
 package events
 
 import (
+	"fmt"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -171,6 +173,83 @@ func TestProjectRunAgentStuckThenResume(t *testing.T) {
 	if exec.Signal["status"] != "DONE" {
 		t.Fatalf("expected DONE signal, got %v", exec.Signal["status"])
 	}
+	if state.FinalStatus() != "DONE" {
+		t.Fatalf("expected final status DONE, got %q", state.FinalStatus())
+	}
+}
+
+func TestFinalSignalIsLastCompletedExecution(t *testing.T) {
+	now := time.Now()
+	evts := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+	}
+	if state := ProjectRun(1, now, evts); state.FinalSignal() != nil || state.FinalStatus() != "" {
+		t.Fatalf("expected no final signal while the first agent runs, got %v", state.FinalSignal())
+	}
+
+	evts = append(evts,
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"},
+		}), 3, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{AgentName: "reviewer", CallIndex: 2, Error: "exit 1"}), 5, now),
+		withVersion(MustNewEvent(1, EventRunFailed, RunFailedPayload{Error: "reviewer failed"}), 6, now),
+	)
+	// A failed agent has no signal; the run's final signal is the coder's
+	if got := ProjectRun(1, now, evts).FinalStatus(); got != "DONE" {
+		t.Fatalf("expected DONE from the last completed execution, got %q", got)
+	}
+
+	evts = append(evts,
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 7, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "reviewer", CallIndex: 2, Signal: map[string]any{"status": "APPROVED", "summary": "lgtm"},
+		}), 8, now),
+		withVersion(MustNewEvent(1, EventRunCompleted, RunCompletedPayload{}), 9, now),
+	)
+	state := ProjectRun(1, now, evts)
+	if state.FinalStatus() != "APPROVED" || state.FinalSignal()["summary"] != "lgtm" {
+		t.Fatalf("expected the reviewer's APPROVED signal, got %v", state.FinalSignal())
+	}
+
+	// Neither a trailing pause() nor the finally agent is what the workflow ended on
+	evts = append(evts,
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "_checkpoint", CallIndex: 3}), 10, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "_checkpoint", CallIndex: 3, Signal: map[string]any{"status": "CONTINUE"},
+		}), 11, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reporter", CallIndex: 4, Finishing: true}), 12, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "reporter", CallIndex: 4, Signal: map[string]any{"status": "DONE"},
+		}), 13, now),
+	)
+	if got := ProjectRun(1, now, evts).FinalStatus(); got != "APPROVED" {
+		t.Fatalf("expected the reviewer's APPROVED past the checkpoint and finally agent, got %q", got)
+	}
+}
+
+func TestProjectRunFinishedAt(t *testing.T) {
+	start := time.Now()
+	stuckAt, doneAt := start.Add(time.Minute), start.Add(time.Hour)
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, start),
+		withVersion(MustNewEvent(1, EventRunStuck, RunStuckPayload{Reason: "help"}), 2, stuckAt),
+	}
+	state := ProjectRun(1, start, events)
+	if state.FinishedAt == nil || !state.FinishedAt.Equal(stuckAt) {
+		t.Fatalf("expected finished when stuck, got %v", state.FinishedAt)
+	}
+
+	events = append(events, withVersion(MustNewEvent(1, EventRunResumed, RunResumedPayload{}), 3, doneAt))
+	if state := ProjectRun(1, start, events); state.FinishedAt != nil {
+		t.Fatalf("expected a resumed run not to be finished, got %v", state.FinishedAt)
+	}
+
+	events = append(events, withVersion(MustNewEvent(1, EventRunCompleted, RunCompletedPayload{}), 4, doneAt))
+	if state := ProjectRun(1, start, events); state.FinishedAt == nil || !state.FinishedAt.Equal(doneAt) {
+		t.Fatalf("expected finished when complete, got %v", state.FinishedAt)
+	}
 }
 
 func TestProjectRunKilled(t *testing.T) {
@@ -232,6 +311,47 @@ func TestProjectRunLogMessages(t *testing.T) {
 	}
 }
 
+func TestFilterLogs(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventLogMessage, LogMessagePayload{Message: "starting"}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 3, now),
+		withVersion(MustNewEvent(1, EventLogMessage, LogMessagePayload{Message: "coder wrote 3 files"}), 4, now),
+		withVersion(MustNewEvent(1, EventLogMessage, LogMessagePayload{Message: "artifact missing", Level: LogLevelWarn}), 5, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 6, now),
+		withVersion(MustNewEvent(1, EventLogMessage, LogMessagePayload{Message: "reviewer wrote 1 files"}), 7, now),
+	}
+	logs := ProjectRun(1, now, events).LogMessages
+
+	if logs[0].Agent != "" || logs[0].Level != LogLevelInfo || logs[1].Agent != "coder" {
+		t.Fatalf("expected lines attributed to the agent that ran last, at info by default, got %+v", logs)
+	}
+
+	messages := func(entries []LogEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Message)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name   string
+		filter LogFilter
+		want   []string
+	}{
+		{"none", LogFilter{}, []string{"starting", "coder wrote 3 files", "artifact missing", "reviewer wrote 1 files"}},
+		{"grep", LogFilter{Pattern: regexp.MustCompile(`wrote \d+ files`)}, []string{"coder wrote 3 files", "reviewer wrote 1 files"}},
+		{"level", LogFilter{Level: LogLevelWarn}, []string{"artifact missing"}},
+		{"agent", LogFilter{Agent: "coder"}, []string{"coder wrote 3 files", "artifact missing"}},
+		{"combined", LogFilter{Agent: "coder", Pattern: regexp.MustCompile("wrote")}, []string{"coder wrote 3 files"}},
+	} {
+		if got := messages(FilterLogs(logs, tc.filter)); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
 func TestProjectRunCheckpoint(t *testing.T) {
 	now := time.Now()
 	events := []Event{
@@ -285,6 +405,122 @@ func TestProjectRunAgentFailed(t *testing.T) {
 	}
 }
 
+func TestProjectRunAgentResult(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"},
+			Result: &AgentResult{Subtype: "success", Result: "All tests pass.", NumTurns: 4, StopReason: "end_turn"},
+		}), 3, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{
+			AgentName: "reviewer", CallIndex: 2, Error: "no signal (exit 1)", ExitCode: 1,
+		}), 5, now),
+	}
+
+	state := ProjectRun(1, now, events)
+
+	r := state.GetExecutionByCallIndex(1).Result
+	if r == nil || r.Result != "All tests pass." || r.NumTurns != 4 || r.StopReason != "end_turn" {
+		t.Fatalf("expected coder's result to be recorded, got %+v", r)
+	}
+	if r := state.GetExecutionByCallIndex(2).Result; r != nil {
+		t.Fatalf("expected no result when none was captured, got %+v", r)
+	}
+}
+
+func TestProjectRunReset(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test", InitialPrompt: "p", WorkspacePath: "/ws"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{AgentName: "coder", CallIndex: 1, Error: "boom"}), 3, now),
+		withVersion(MustNewEvent(1, EventRunFailed, RunFailedPayload{Error: "boom"}), 4, now),
+		withVersion(MustNewEvent(1, EventRunReset, RunResetPayload{}), 5, now),
+	}
+
+	state := ProjectRun(1, now, events)
+
+	if state.Status != RunStatusPending || state.Error != "" {
+		t.Fatalf("expected clean pending run, got %s (%q)", state.Status, state.Error)
+	}
+	if len(state.Executions) != 0 {
+		t.Fatalf("expected executions cleared, got %d", len(state.Executions))
+	}
+	if state.WorkspacePath != "/ws" || state.InitialPrompt != "p" {
+		t.Fatalf("expected run details kept, got %+v", state)
+	}
+}
+
+func TestProjectRunMetadata(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test", InitialPrompt: "p"}), 1, now),
+		withVersion(MustNewEvent(1, EventRunMetadataSet, RunMetadataSetPayload{
+			Metadata: map[string]string{"pr": "12", "ticket": "ENG-4"},
+		}), 2, now),
+		withVersion(MustNewEvent(1, EventRunReset, RunResetPayload{}), 3, now),
+		withVersion(MustNewEvent(1, EventRunMetadataSet, RunMetadataSetPayload{
+			Metadata: map[string]string{"pr": "13", "ticket": ""},
+		}), 4, now),
+	}
+
+	state := ProjectRun(1, now, events)
+
+	if len(state.Metadata) != 1 || state.Metadata["pr"] != "13" {
+		t.Fatalf("expected pr updated, ticket removed and metadata kept across a reset, got %v", state.Metadata)
+	}
+}
+
+func TestProjectRunReplayInvalidated(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"},
+		}), 3, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{
+			AgentName: "reviewer", CallIndex: 2, Error: "no signal (exit 1): boom", ExitCode: 1,
+		}), 5, now),
+		withVersion(MustNewEvent(1, EventReplayInvalidated, ReplayInvalidatedPayload{FromCallIndex: 2}), 6, now),
+	}
+
+	state := ProjectRun(1, now, events)
+
+	if state.Executions[1].Status != ExecStatusInvalidated {
+		t.Fatalf("expected invalidated, got %s", state.Executions[1].Status)
+	}
+	if state.Executions[1].Error != "no signal (exit 1): boom" {
+		t.Fatalf("expected failure error to be kept, got %q", state.Executions[1].Error)
+	}
+	if exec := state.GetExecutionByCallIndex(2); exec != nil {
+		t.Fatalf("expected invalidated call to be skipped, got %+v", exec)
+	}
+	if exec := state.GetExecutionByCallIndex(1); exec == nil || exec.Status != ExecStatusCompleted {
+		t.Fatal("expected call 1 to be unaffected")
+	}
+	if last := state.LastExecution(); last == nil || last.AgentName != "coder" {
+		t.Fatalf("expected last execution to be coder, got %+v", last)
+	}
+
+	// A re-run at the same call index is found again
+	events = append(events,
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 7, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "reviewer", CallIndex: 2, Signal: map[string]any{"status": "APPROVED"},
+		}), 8, now),
+	)
+	state = ProjectRun(1, now, events)
+	exec := state.GetExecutionByCallIndex(2)
+	if exec == nil || exec.Status != ExecStatusCompleted {
+		t.Fatalf("expected re-run at call 2 to be completed, got %+v", exec)
+	}
+}
+
 func TestIsTerminal(t *testing.T) {
 	terminal := []RunStatus{RunStatusComplete, RunStatusFailed, RunStatusStuck, RunStatusKilled, RunStatusDeleted}
 	for _, s := range terminal {
@@ -342,3 +578,96 @@ func withVersion(e Event, version int, ts time.Time) Event {
 	e.CreatedAt = ts
 	return e
 }
+
+func TestProjectRunCountsAttempts(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{AgentName: "coder", CallIndex: 1, Error: "boom"}), 3, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"},
+		}), 5, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 2}), 6, now),
+		withVersion(MustNewEvent(1, EventAgentHandedOff, AgentHandedOffPayload{CallIndex: 2, FromAgent: "reviewer", ToAgent: "senior"}), 7, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "senior", CallIndex: 2}), 8, now),
+	}
+
+	state := ProjectRun(1, now, events)
+
+	got := []int{}
+	for _, e := range state.Executions {
+		got = append(got, e.Attempt)
+	}
+	if want := []int{1, 2, 1, 1}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected attempts %v, got %v", want, got)
+	}
+}
+
+func TestAgentSummariesFollowCallOrder(t *testing.T) {
+	now := time.Now()
+	completed := func(agent string, call int, summary string) AgentCompletedPayload {
+		return AgentCompletedPayload{AgentName: agent, CallIndex: call, Signal: map[string]any{"status": "DONE", "summary": summary}}
+	}
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, completed("coder", 1, "first try")), 3, now),
+		withVersion(MustNewEvent(1, EventReplayInvalidated, ReplayInvalidatedPayload{FromCallIndex: 1}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 5, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, completed("coder", 1, "wrote\n  the code")), 6, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "tester", CallIndex: 2}), 7, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, completed("tester", 2, "")), 8, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 3}), 9, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, completed("reviewer", 3, "approved")), 10, now),
+	}
+
+	state := ProjectRun(1, now, events)
+	if got, want := state.AgentSummaries(), "coder: wrote the code\nreviewer: approved"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	events = append(events,
+		withVersion(MustNewEvent(1, EventRunCompleted, RunCompletedPayload{}), 11, now),
+		withVersion(MustNewEvent(1, EventRunSummarized, RunSummarizedPayload{Summary: "digest"}), 12, now),
+	)
+	if state = ProjectRun(1, now, events); state.Summary != "digest" {
+		t.Fatalf("expected summary recorded, got %q", state.Summary)
+	}
+
+	events = append(events, withVersion(MustNewEvent(1, EventRunResumed, RunResumedPayload{}), 13, now))
+	if state = ProjectRun(1, now, events); state.Summary != "" {
+		t.Fatalf("expected resume to clear the summary, got %q", state.Summary)
+	}
+}
+
+func TestProgressFollowsExecutionHistory(t *testing.T) {
+	now := time.Now()
+	events := []Event{
+		withVersion(MustNewEvent(1, EventRunStarted, RunStartedPayload{WorkflowName: "test"}), 1, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 2, now),
+		withVersion(MustNewEvent(1, EventAgentFailed, AgentFailedPayload{AgentName: "coder", CallIndex: 1, Error: "boom"}), 3, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}), 4, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"}}), 5, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "tester", CallIndex: 2}), 6, now),
+		withVersion(MustNewEvent(1, EventAgentCompleted, AgentCompletedPayload{AgentName: "tester", CallIndex: 2, Signal: map[string]any{"status": "DONE"}}), 7, now),
+		withVersion(MustNewEvent(1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 3}), 8, now),
+	}
+
+	progress := ProjectRun(1, now, events).Progress()
+	if progress.Step != 3 || progress.LastAgent != "tester" || fmt.Sprint(progress.Agents) != "[coder tester reviewer]" {
+		t.Fatalf("expected step 3 after tester with the retry counted once, got %+v", progress)
+	}
+
+	// A replay from call 2 takes the run back to step 1
+	events = append(events, withVersion(MustNewEvent(1, EventReplayInvalidated, ReplayInvalidatedPayload{FromCallIndex: 2}), 9, now))
+	progress = ProjectRun(1, now, events).Progress()
+	if progress.Step != 1 || progress.LastAgent != "coder" || fmt.Sprint(progress.Agents) != "[coder]" {
+		t.Fatalf("expected step 1 after the replay, got %+v", progress)
+	}
+
+	if progress := ProjectRun(1, now, events[:1]).Progress(); progress.Step != 0 || progress.LastAgent != "" {
+		t.Fatalf("expected no progress before any agent, got %+v", progress)
+	}
+}
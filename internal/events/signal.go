@@ -30,3 +30,16 @@ func MergeStatuses(custom []string) []string {
 	}
 	return out
 }
+
+// SignalArtifacts returns the string entries of a signal's "artifacts" array:
+// repo-relative paths of files the agent produced for later steps.
+func SignalArtifacts(signal map[string]any) []string {
+	raw, _ := signal["artifacts"].([]any)
+	var paths []string
+	for _, v := range raw {
+		if s, ok := v.(string); ok && s != "" {
+			paths = append(paths, s)
+		}
+	}
+	return paths
+}
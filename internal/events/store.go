@@ -4,6 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -17,14 +22,16 @@ type Store struct {
 
 // NewStore opens (or creates) the event-sourced database.
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	// Pragmas go in the DSN so every pooled connection gets them; the CLI,
+	// TUI, MCP servers and `shop serve` all share this file concurrently.
+	// Transactions take the write lock up front: a deferred one that reads
+	// first fails with SQLITE_BUSY, without waiting, if another writer got
+	// in before it upgraded.
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
 
-	db.Exec("PRAGMA journal_mode=WAL")
-	db.Exec("PRAGMA busy_timeout=5000")
-
 	s := &Store{db: db, dbPath: dbPath}
 	if err := s.migrate(); err != nil {
 		db.Close()
@@ -76,7 +83,26 @@ func (s *Store) migrate() error {
 	CREATE INDEX IF NOT EXISTS idx_commands_run ON commands(run_id);
 	`
 
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	return s.addParentRunID()
+}
+
+// addParentRunID adds runs.parent_run_id to databases that predate it,
+// filling it in from the RunStarted events of child runs already recorded.
+func (s *Store) addParentRunID() error {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('runs') WHERE name = 'parent_run_id'`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err := s.db.Exec(`
+		ALTER TABLE runs ADD COLUMN parent_run_id INTEGER;
+		UPDATE runs SET parent_run_id = (
+			SELECT json_extract(payload, '$.parent_run_id') FROM events
+			WHERE events.run_id = runs.id AND event_type = 'RunStarted'
+		);
+		CREATE INDEX IF NOT EXISTS idx_runs_parent ON runs(parent_run_id) WHERE parent_run_id IS NOT NULL;`)
 	return err
 }
 
@@ -89,6 +115,15 @@ func (s *Store) CreateRun() (int64, error) {
 	return result.LastInsertId()
 }
 
+// CreateChildRun inserts a run row spawned by parentID and returns its ID.
+func (s *Store) CreateChildRun(parentID int64) (int64, error) {
+	result, err := s.db.Exec(`INSERT INTO runs (version, parent_run_id) VALUES (0, ?)`, parentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 // AppendEvents atomically appends events to a run's event stream.
 // Returns ErrVersionConflict if the expected version doesn't match.
 func (s *Store) AppendEvents(runID int64, expectedVersion int, newEvents []Event) ([]Event, error) {
@@ -204,6 +239,172 @@ func (s *Store) GetRun(id int64) (*RunInfo, error) {
 	return &r, nil
 }
 
+// GetRunByWorkspace finds the run whose workspace contains path: the
+// workspace directory itself or anything inside it (e.g. a subdirectory of
+// its repo). Deleted runs are skipped. If several runs match, a run that
+// isn't a child wins over run_workflow() children sharing its workspace,
+// then the newest (an adopted workspace).
+func (s *Store) GetRunByWorkspace(path string) (*RunState, error) {
+	path = filepath.Clean(path)
+	rows, err := s.db.Query(`
+		SELECT run_id, json_extract(payload, '$.workspace_path') FROM events
+		WHERE event_type = ? ORDER BY run_id DESC`, string(EventRunStarted))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []int64
+	for rows.Next() {
+		var runID int64
+		var workspace sql.NullString
+		if err := rows.Scan(&runID, &workspace); err != nil {
+			return nil, err
+		}
+		if !workspace.Valid || workspace.String == "" {
+			continue
+		}
+		ws := filepath.Clean(workspace.String)
+		if path == ws || strings.HasPrefix(path, ws+string(filepath.Separator)) {
+			candidates = append(candidates, runID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var child *RunState
+	for _, runID := range candidates {
+		state, err := s.ProjectRunFromDB(runID)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status == RunStatusDeleted {
+			continue
+		}
+		if state.ParentRunID == 0 {
+			return state, nil
+		}
+		if child == nil {
+			child = state
+		}
+	}
+	if child != nil {
+		return child, nil
+	}
+	return nil, fmt.Errorf("no run has a workspace at %s", path)
+}
+
+// ListRunsByWorkflow projects every run of the named workflow, oldest
+// first. Deleted runs are skipped.
+func (s *Store) ListRunsByWorkflow(name string) ([]*RunState, error) {
+	rows, err := s.db.Query(`
+		SELECT DISTINCT run_id FROM events
+		WHERE event_type = ? AND json_extract(payload, '$.workflow_name') = ?
+		ORDER BY run_id`, string(EventRunStarted), name)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var runs []*RunState
+	for _, id := range ids {
+		state, err := s.ProjectRunFromDB(id)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status != RunStatusDeleted {
+			runs = append(runs, state)
+		}
+	}
+	return runs, nil
+}
+
+// ListChildRuns projects the runs spawned by parentID, oldest first.
+// Deleted runs are skipped.
+func (s *Store) ListChildRuns(parentID int64) ([]*RunState, error) {
+	rows, err := s.db.Query(`SELECT id FROM runs WHERE parent_run_id = ? ORDER BY id`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var runs []*RunState
+	for _, id := range ids {
+		state, err := s.ProjectRunFromDB(id)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status != RunStatusDeleted {
+			runs = append(runs, state)
+		}
+	}
+	return runs, nil
+}
+
+// ListWaitingRuns projects every run waiting for human input, longest
+// waiting first. Only runs that have ever waited are projected.
+func (s *Store) ListWaitingRuns() ([]*RunState, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT run_id FROM events WHERE event_type = ? ORDER BY run_id`,
+		string(EventRunWaitingHuman))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var waiting []*RunState
+	for _, id := range ids {
+		state, err := s.ProjectRunFromDB(id)
+		if err != nil {
+			return nil, err
+		}
+		if state.Status == RunStatusWaitingHuman {
+			waiting = append(waiting, state)
+		}
+	}
+	sort.SliceStable(waiting, func(i, j int) bool {
+		a, b := waiting[i].WaitingSince, waiting[j].WaitingSince
+		return a != nil && (b == nil || a.Before(*b))
+	})
+	return waiting, nil
+}
+
 // ListRunIDs returns all run IDs ordered by creation time (newest first).
 func (s *Store) ListRunIDs(limit int) ([]RunInfo, error) {
 	rows, err := s.db.Query(`SELECT id, created_at, version FROM runs ORDER BY id DESC LIMIT ?`, limit)
@@ -223,6 +424,73 @@ func (s *Store) ListRunIDs(limit int) ([]RunInfo, error) {
 	return runs, rows.Err()
 }
 
+// ListRunsWithSummary projects the most recent runs, newest first, with
+// their execution summaries. All events are loaded in a single query.
+func (s *Store) ListRunsWithSummary(limit int) ([]RunSummary, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.created_at, e.id, e.event_type, e.payload, e.version, e.created_at
+		FROM (SELECT id, created_at FROM runs ORDER BY id DESC LIMIT ?) r
+		LEFT JOIN events e ON e.run_id = r.id
+		ORDER BY r.id DESC, e.version`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		summaries []RunSummary
+		runID     int64
+		runAt     time.Time
+		runEvents []Event
+	)
+	flush := func() {
+		if runID != 0 {
+			summaries = append(summaries, ProjectRun(runID, runAt, runEvents).Summarize())
+		}
+	}
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		var eventID sql.NullInt64
+		var eventType, payload sql.NullString
+		var version sql.NullInt64
+		var eventAt sql.NullTime
+		if err := rows.Scan(&id, &createdAt, &eventID, &eventType, &payload, &version, &eventAt); err != nil {
+			return nil, err
+		}
+		if id != runID {
+			flush()
+			runID, runAt, runEvents = id, createdAt, nil
+		}
+		if eventID.Valid {
+			runEvents = append(runEvents, Event{
+				ID:        eventID.Int64,
+				RunID:     id,
+				EventType: EventType(eventType.String),
+				Payload:   json.RawMessage(payload.String),
+				Version:   int(version.Int64),
+				CreatedAt: eventAt.Time,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return summaries, nil
+}
+
+// CatchUp applies the events recorded for state's run since state.Version,
+// so a long-lived projection stays current without reloading the log.
+func (s *Store) CatchUp(state *RunState) error {
+	events, err := s.GetEventsSince(state.ID, state.Version)
+	if err != nil {
+		return err
+	}
+	state.Apply(events)
+	return nil
+}
+
 // ProjectRunFromDB loads events and projects state for a run.
 func (s *Store) ProjectRunFromDB(runID int64) (*RunState, error) {
 	info, err := s.GetRun(runID)
@@ -339,3 +607,168 @@ func FormatTimeAgo(t time.Time) string {
 		return t.Format("Jan 2")
 	}
 }
+
+// ── Maintenance ───────────────────────────────────────────────────────────────
+
+// compactSignalKeys are kept when a signal is compacted: enough for
+// get_context, `shop status` and resuming from a session.
+var compactSignalKeys = []string{"status", "summary", "reason", "_session_id"}
+
+// CompactSignals strips verbose fields from signals recorded before the
+// cutoff, keeping status, summary and reason. Only complete, killed and
+// deleted runs are compacted: a stuck or failed run can still be resumed,
+// and replay hands its recorded signals back to the script in full. It
+// returns the number of events rewritten.
+func (s *Store) CompactSignals(before time.Time) (int, error) {
+	rows, err := s.db.Query(
+		`SELECT id, run_id, payload, created_at FROM events WHERE event_type IN (?, ?, ?, ?)`,
+		string(EventAgentCompleted), string(EventSignalReceived),
+		string(EventHumanInputReceived), string(EventCheckpointCompleted),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type candidate struct {
+		id, runID int64
+		payload   map[string]any
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		var payload string
+		var createdAt time.Time
+		if err := rows.Scan(&c.id, &c.runID, &payload, &createdAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !createdAt.Before(before) || json.Unmarshal([]byte(payload), &c.payload) != nil {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	settled := make(map[int64]bool)
+	compacted := 0
+	for _, c := range candidates {
+		isSettled, ok := settled[c.runID]
+		if !ok {
+			state, err := s.ProjectRunFromDB(c.runID)
+			if err != nil {
+				return compacted, err
+			}
+			switch state.Status {
+			case RunStatusComplete, RunStatusKilled, RunStatusDeleted:
+				isSettled = true
+			}
+			settled[c.runID] = isSettled
+		}
+		if !isSettled {
+			continue
+		}
+
+		signal, _ := c.payload["signal"].(map[string]any)
+		if signal == nil || signal["_compacted"] == true {
+			continue
+		}
+		kept := map[string]any{"_compacted": true}
+		for _, k := range compactSignalKeys {
+			if v, ok := signal[k]; ok {
+				kept[k] = v
+			}
+		}
+		if len(kept) == len(signal)+1 {
+			continue // nothing verbose to drop
+		}
+		c.payload["signal"] = kept
+
+		data, err := json.Marshal(c.payload)
+		if err != nil {
+			return compacted, err
+		}
+		if _, err := s.db.Exec(`UPDATE events SET payload = ? WHERE id = ?`, string(data), c.id); err != nil {
+			return compacted, err
+		}
+		compacted++
+	}
+	return compacted, nil
+}
+
+// Vacuum rebuilds the database file to release free pages and returns the
+// number of bytes reclaimed.
+func (s *Store) Vacuum() (int64, error) {
+	before, err := s.size()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return 0, err
+	}
+	// In WAL mode the rebuilt pages sit in the -wal file until checkpointed
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return 0, err
+	}
+	after, err := s.size()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// ErrMaintenanceLocked is returned by LockMaintenance while another
+// process holds the maintenance lock.
+var ErrMaintenanceLocked = fmt.Errorf("maintenance lock held")
+
+// LockMaintenance takes the database's maintenance lock for op (e.g.
+// "vacuum"), so only one shop process at a time rewrites the database or
+// prunes what runs left behind. It doesn't wait: while another process
+// holds the lock it fails with ErrMaintenanceLocked, naming the holder.
+// The lock is a flock on a file beside the database, so it is freed if
+// its holder dies. Runs and read-only commands don't take it. Who holds it
+// is in a second file, written by rename so a refused process never reads
+// it half-written.
+func (s *Store) LockMaintenance(op string) (unlock func(), err error) {
+	path := s.dbPath + ".maintenance.lock"
+	holderPath := s.dbPath + ".maintenance"
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("failed to take maintenance lock: %w", err)
+		}
+		// Missing until the holder has written it
+		holder, _ := os.ReadFile(holderPath)
+		if h := strings.TrimSpace(string(holder)); h != "" {
+			return nil, fmt.Errorf("%w: %s is already running", ErrMaintenanceLocked, h)
+		}
+		return nil, fmt.Errorf("%w: another shop process is running maintenance", ErrMaintenanceLocked)
+	}
+
+	holder := fmt.Sprintf("%s (pid %d, since %s)\n", op, os.Getpid(), time.Now().Format(time.DateTime))
+	if err := os.WriteFile(holderPath+".tmp", []byte(holder), 0644); err == nil {
+		os.Rename(holderPath+".tmp", holderPath)
+	}
+	return func() {
+		os.Remove(holderPath)
+		f.Close()
+	}, nil
+}
+
+// size returns the database size in bytes (page_count × page_size).
+func (s *Store) size() (int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
@@ -1,12 +1,16 @@
 package events
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func tempStore(t *testing.T) *Store {
+func tempStore(t testing.TB) *Store {
 	t.Helper()
 	dir := t.TempDir()
 	s, err := NewStore(filepath.Join(dir, "test.db"))
@@ -241,3 +245,542 @@ func TestStoreDBFile(t *testing.T) {
 		t.Fatalf("db file not created: %v", err)
 	}
 }
+
+func appendOrFatal(t testing.TB, s *Store, runID int64, evts ...Event) {
+	t.Helper()
+	info, err := s.GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AppendEvents(runID, info.Version, evts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// populate creates n runs whose coder reported a large signal; the last
+// run is left running.
+func populate(t *testing.T, s *Store, n int) []int64 {
+	t.Helper()
+	verbose := strings.Repeat("diff --git a/main.go b/main.go\n", 2000)
+	var ids []int64
+	for i := 0; i < n; i++ {
+		runID, err := s.CreateRun()
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendOrFatal(t, s, runID,
+			MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "wf"}),
+			MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+			MustNewEvent(runID, EventAgentCompleted, AgentCompletedPayload{
+				AgentName: "coder", CallIndex: 1,
+				Signal: map[string]any{"status": "DONE", "summary": "coded", "_session_id": "s1", "patch": verbose},
+			}),
+		)
+		if i < n-1 {
+			appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunCompleted, RunCompletedPayload{}))
+		}
+		ids = append(ids, runID)
+	}
+	return ids
+}
+
+func TestCompactSignals(t *testing.T) {
+	s := tempStore(t)
+	ids := populate(t, s, 3)
+
+	if n, err := s.CompactSignals(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing older than the cutoff, got %d, %v", n, err)
+	}
+
+	n, err := s.CompactSignals(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected the 2 finished runs to be compacted, got %d", n)
+	}
+
+	state, err := s.ProjectRunFromDB(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := state.Executions[0].Signal
+	if sig["status"] != "DONE" || sig["summary"] != "coded" || sig["_session_id"] != "s1" {
+		t.Fatalf("expected status/summary kept, got %v", sig)
+	}
+	if _, ok := sig["patch"]; ok {
+		t.Fatal("expected verbose field dropped")
+	}
+
+	running, err := s.ProjectRunFromDB(ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := running.Executions[0].Signal["patch"]; !ok {
+		t.Fatal("expected running run's signal left intact")
+	}
+
+	if n, _ := s.CompactSignals(time.Now().Add(time.Minute)); n != 0 {
+		t.Fatalf("expected compaction to be idempotent, got %d", n)
+	}
+}
+
+func TestCompactSignalsSparesResumableRuns(t *testing.T) {
+	s := tempStore(t)
+	// The last of populate's runs is still running; settle it as stuck
+	stuck := populate(t, s, 1)[0]
+	appendOrFatal(t, s, stuck, MustNewEvent(stuck, EventRunStuck, RunStuckPayload{Reason: "blocked"}))
+
+	if n, err := s.CompactSignals(time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected the stuck run left alone, got %d, %v", n, err)
+	}
+	state, err := s.ProjectRunFromDB(stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != RunStatusStuck {
+		t.Fatalf("expected stuck, got %s", state.Status)
+	}
+	if sig := state.Executions[0].Signal; sig["patch"] == nil || sig["_compacted"] != nil {
+		t.Fatalf("expected the stuck run's signal untouched, got %v", sig["_compacted"])
+	}
+}
+
+func TestVacuumReclaimsSpace(t *testing.T) {
+	s := tempStore(t)
+	populate(t, s, 4)
+
+	if _, err := s.CompactSignals(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	reclaimed, err := s.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed <= 0 {
+		t.Fatalf("expected vacuum to reclaim space after compaction, got %d bytes", reclaimed)
+	}
+}
+
+func TestListRunsWithSummary(t *testing.T) {
+	s := tempStore(t)
+
+	id1, _ := s.CreateRun()
+	appendOrFatal(t, s, id1,
+		MustNewEvent(id1, EventRunStarted, RunStartedPayload{WorkflowName: "build"}),
+		MustNewEvent(id1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+		MustNewEvent(id1, EventAgentFailed, AgentFailedPayload{AgentName: "coder", CallIndex: 1, Error: "boom"}),
+		MustNewEvent(id1, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 2}),
+		MustNewEvent(id1, EventAgentCompleted, AgentCompletedPayload{AgentName: "coder", CallIndex: 2, Signal: map[string]any{"status": "DONE"}}),
+		MustNewEvent(id1, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 3}),
+	)
+	id2, _ := s.CreateRun() // never started: no events
+	id3, _ := s.CreateRun()
+	appendOrFatal(t, s, id3, MustNewEvent(id3, EventRunStarted, RunStartedPayload{WorkflowName: "deploy"}))
+
+	runs, err := s.ListRunsWithSummary(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].ID != id3 || runs[1].ID != id2 || runs[2].ID != id1 {
+		t.Fatalf("expected runs %d,%d,%d newest first, got %+v", id3, id2, id1, runs)
+	}
+	if runs[1].Status != RunStatusPending || runs[1].ExecutionCount != 0 {
+		t.Fatalf("expected empty pending run, got %+v", runs[1])
+	}
+
+	r := runs[2]
+	if r.WorkflowName != "build" || r.ExecutionCount != 3 || r.FailedCount != 1 {
+		t.Fatalf("unexpected counts: %+v", r)
+	}
+	if r.LastAgent != "reviewer" || r.LastStatus != ExecStatusStarted {
+		t.Fatalf("expected last execution reviewer/started, got %s/%s", r.LastAgent, r.LastStatus)
+	}
+
+	if runs, _ := s.ListRunsWithSummary(1); len(runs) != 1 || runs[0].ID != id3 {
+		t.Fatalf("expected limit to apply to runs, got %+v", runs)
+	}
+}
+
+func TestExecutionWaitingReasonsRoundTrip(t *testing.T) {
+	s := tempStore(t)
+	runID, _ := s.CreateRun()
+
+	appendOrFatal(t, s, runID,
+		MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "wf"}),
+		MustNewEvent(runID, EventCheckpointStarted, CheckpointStartedPayload{CallIndex: 1, Message: "Approve the plan?"}),
+		MustNewEvent(runID, EventRunWaitingHuman, RunWaitingHumanPayload{CallIndex: 1, Reason: "Approve the plan?"}),
+		MustNewEvent(runID, EventHumanInputReceived, HumanInputReceivedPayload{CallIndex: 1, Signal: map[string]any{"status": "CONTINUE"}}),
+		MustNewEvent(runID, EventRunResumed, RunResumedPayload{}),
+		MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 2}),
+		MustNewEvent(runID, EventAgentCompleted, AgentCompletedPayload{
+			AgentName: "coder", CallIndex: 2, Signal: map[string]any{"status": "STUCK", "summary": "which database?"},
+		}),
+		MustNewEvent(runID, EventRunWaitingHuman, RunWaitingHumanPayload{CallIndex: 2, Reason: "which database?"}),
+	)
+
+	state, err := s.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.WaitingReason != "which database?" {
+		t.Fatalf("expected run to wait on the latest reason, got %q", state.WaitingReason)
+	}
+	if got := state.Executions[0].WaitingReason; got != "Approve the plan?" {
+		t.Fatalf("expected checkpoint to keep its reason after resuming, got %q", got)
+	}
+	if got := state.Executions[1].WaitingReason; got != "which database?" {
+		t.Fatalf("expected coder's reason, got %q", got)
+	}
+}
+
+func TestWaitingStateSurvivesReopen(t *testing.T) {
+	s := tempStore(t)
+	runID, _ := s.CreateRun()
+	appendOrFatal(t, s, runID,
+		MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "wf"}),
+		MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1, SessionID: "s1"}),
+		MustNewEvent(runID, EventRunWaitingHuman, RunWaitingHumanPayload{CallIndex: 1, Reason: "which database?", SessionID: "s1"}),
+	)
+
+	// A fresh process opens the database anew
+	reopened, err := NewStore(s.DBPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	state, err := reopened.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != RunStatusWaitingHuman || state.WaitingReason != "which database?" || state.WaitingSessionID != "s1" {
+		t.Fatalf("expected the waiting reason and session back, got %s %q %q", state.Status, state.WaitingReason, state.WaitingSessionID)
+	}
+	waiting, err := reopened.ListWaitingRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 1 || waiting[0].WaitingSessionID != "s1" {
+		t.Fatalf("expected the run listed as waiting with its session, got %+v", waiting)
+	}
+}
+
+func TestExecutionCallIndexAndPromptSurviveReopen(t *testing.T) {
+	s := tempStore(t)
+	runID, _ := s.CreateRun()
+	appendOrFatal(t, s, runID,
+		MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "wf"}),
+		MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1, Prompt: "write it"}),
+		MustNewEvent(runID, EventAgentCompleted, AgentCompletedPayload{AgentName: "coder", CallIndex: 1, Signal: map[string]any{"status": "DONE"}}),
+		MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "reviewer", CallIndex: 7, Prompt: "review it"}),
+		MustNewEvent(runID, EventAgentCompleted, AgentCompletedPayload{AgentName: "reviewer", CallIndex: 7, Signal: map[string]any{"status": "APPROVED"}}),
+	)
+
+	reopened, err := NewStore(s.DBPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	state, err := reopened.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := state.GetExecutionByCallIndex(7)
+	if exec == nil || exec.AgentName != "reviewer" || exec.Prompt != "review it" || exec.Status != ExecStatusCompleted {
+		t.Fatalf("expected reviewer's call 7 back with its prompt, got %+v", exec)
+	}
+	if state.GetExecutionByCallIndex(2) != nil {
+		t.Fatal("expected no execution at a call index that never ran")
+	}
+}
+
+func TestGetRunByWorkspace(t *testing.T) {
+	s := tempStore(t)
+
+	start := func(workspace string) int64 {
+		runID, _ := s.CreateRun()
+		appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunStarted, RunStartedPayload{
+			WorkflowName: "wf", WorkspacePath: workspace,
+		}))
+		return runID
+	}
+	run1 := start("/ws/run-1")
+	run10 := start("/ws/run-10")
+
+	for path, want := range map[string]int64{
+		"/ws/run-1":                 run1,
+		"/ws/run-1/":                run1,
+		"/ws/run-1/repo/internal/x": run1,
+		"/ws/run-10/repo":           run10,
+	} {
+		state, err := s.GetRunByWorkspace(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if state.ID != want {
+			t.Fatalf("%s: expected run %d, got %d", path, want, state.ID)
+		}
+	}
+
+	for _, path := range []string{"/ws", "/ws/run-100", "/elsewhere"} {
+		if _, err := s.GetRunByWorkspace(path); err == nil {
+			t.Fatalf("%s: expected no run", path)
+		}
+	}
+
+	// A run_workflow() child shares its parent's workspace; the parent owns it
+	kid, _ := s.CreateChildRun(run1)
+	appendOrFatal(t, s, kid, MustNewEvent(kid, EventRunStarted, RunStartedPayload{
+		WorkflowName: "impl", WorkspacePath: "/ws/run-1", ParentRunID: run1, ParentCallIndex: 1,
+	}))
+	if state, err := s.GetRunByWorkspace("/ws/run-1/repo"); err != nil || state.ID != run1 {
+		t.Fatalf("expected the parent run %d over its child, got %+v (%v)", run1, state, err)
+	}
+
+	// A deleted run no longer owns its workspace
+	appendOrFatal(t, s, run10, MustNewEvent(run10, EventRunDeleted, RunDeletedPayload{}))
+	if _, err := s.GetRunByWorkspace("/ws/run-10/repo"); err == nil {
+		t.Fatal("expected deleted run to be skipped")
+	}
+}
+
+// agentCalls appends n completed agent executions to runID, starting at
+// call index from.
+func agentCalls(t testing.TB, s *Store, runID int64, from, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		appendOrFatal(t, s, runID,
+			MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: i, Prompt: "go"}),
+			MustNewEvent(runID, EventAgentCompleted, AgentCompletedPayload{
+				AgentName: "coder", CallIndex: i, Signal: map[string]any{"status": "DONE", "summary": strings.Repeat("x", 500)},
+			}),
+		)
+	}
+}
+
+func TestCatchUpMatchesFullProjection(t *testing.T) {
+	s := tempStore(t)
+	runID, _ := s.CreateRun()
+	appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "test"}))
+	agentCalls(t, s, runID, 1, 3)
+
+	state, err := s.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentCalls(t, s, runID, 4, 2)
+	appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunCompleted, RunCompletedPayload{}))
+	if err := s.CatchUp(state); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing new: a second catch-up changes nothing
+	if err := s.CatchUp(state); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := s.ProjectRunFromDB(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != want.Version || state.Status != RunStatusComplete || len(state.Executions) != len(want.Executions) {
+		t.Fatalf("expected version %d, complete, %d executions; got %d, %s, %d",
+			want.Version, len(want.Executions), state.Version, state.Status, len(state.Executions))
+	}
+	for i, exec := range state.Executions {
+		if exec.CallIndex != i+1 || exec.Status != ExecStatusCompleted || exec.Attempt != 1 {
+			t.Fatalf("execution %d: unexpected %+v", i, exec)
+		}
+	}
+}
+
+// BenchmarkRefreshState compares re-projecting a run with many executions
+// against catching up a kept projection, as the runtime does after each
+// agent.
+func BenchmarkRefreshState(b *testing.B) {
+	s := tempStore(b)
+	runID, _ := s.CreateRun()
+	appendOrFatal(b, s, runID, MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "test"}))
+	agentCalls(b, s, runID, 1, 200)
+
+	b.Run("ProjectRunFromDB", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.ProjectRunFromDB(runID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("CatchUp", func(b *testing.B) {
+		state, err := s.ProjectRunFromDB(runID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.CatchUp(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestListWaitingRuns(t *testing.T) {
+	s := tempStore(t)
+	newRun := func() int64 {
+		runID, err := s.CreateRun()
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendOrFatal(t, s, runID,
+			MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "test"}),
+			MustNewEvent(runID, EventAgentStarted, AgentStartedPayload{AgentName: "coder", CallIndex: 1}),
+		)
+		return runID
+	}
+	wait := func(runID int64, reason string) {
+		appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunWaitingHuman, RunWaitingHumanPayload{Reason: reason, CallIndex: 1}))
+	}
+
+	answered, later, earlier := newRun(), newRun(), newRun()
+	newRun() // still running
+	wait(answered, "which flag?")
+	appendOrFatal(t, s, answered, MustNewEvent(answered, EventRunResumed, RunResumedPayload{}))
+	wait(earlier, "first question")
+	wait(later, "second question")
+
+	waiting, err := s.ListWaitingRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waiting) != 2 || waiting[0].ID != earlier || waiting[1].ID != later {
+		t.Fatalf("expected runs %d and %d, longest waiting first, got %+v", earlier, later, waiting)
+	}
+	if w := waiting[0]; w.WaitingReason != "first question" || w.CurrentAgent != "coder" || w.WaitingSince == nil {
+		t.Fatalf("expected the reason, agent and time of the wait, got %+v", w)
+	}
+
+	state, err := s.ProjectRunFromDB(answered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.WaitingSince != nil {
+		t.Fatalf("expected resuming to clear WaitingSince, got %v", state.WaitingSince)
+	}
+}
+
+func TestListChildRuns(t *testing.T) {
+	s := tempStore(t)
+	start := func(runID, parentID int64) {
+		appendOrFatal(t, s, runID, MustNewEvent(runID, EventRunStarted, RunStartedPayload{WorkflowName: "test", ParentRunID: parentID}))
+	}
+
+	parent, _ := s.CreateRun()
+	start(parent, 0)
+	var kids []int64
+	for i := 0; i < 3; i++ {
+		kid, err := s.CreateChildRun(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start(kid, parent)
+		kids = append(kids, kid)
+	}
+	grandchild, _ := s.CreateChildRun(kids[0])
+	start(grandchild, kids[0])
+	appendOrFatal(t, s, kids[2], MustNewEvent(kids[2], EventRunDeleted, RunDeletedPayload{}))
+
+	got, err := s.ListChildRuns(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != kids[0] || got[1].ID != kids[1] {
+		t.Fatalf("expected children %v oldest first without the deleted one, got %+v", kids[:2], got)
+	}
+	if got, _ := s.ListChildRuns(kids[0]); len(got) != 1 || got[0].ID != grandchild {
+		t.Fatalf("expected grandchild %d under %d, got %+v", grandchild, kids[0], got)
+	}
+	if got, _ := s.ListChildRuns(grandchild); len(got) != 0 {
+		t.Fatalf("expected no children of a leaf run, got %+v", got)
+	}
+}
+
+func TestParentRunIDBackfilledFromEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database from before the column, holding a child run
+	if _, err := s.db.Exec(`DROP INDEX idx_runs_parent; ALTER TABLE runs DROP COLUMN parent_run_id`); err != nil {
+		t.Fatal(err)
+	}
+	parent, _ := s.CreateRun()
+	child, _ := s.CreateRun()
+	appendOrFatal(t, s, parent, MustNewEvent(parent, EventRunStarted, RunStartedPayload{WorkflowName: "test"}))
+	appendOrFatal(t, s, child, MustNewEvent(child, EventRunStarted, RunStartedPayload{WorkflowName: "test", ParentRunID: parent}))
+	s.Close()
+
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.ListChildRuns(parent); err != nil || len(got) != 1 || got[0].ID != child {
+		t.Fatalf("expected run %d backfilled as %d's child, got %+v (%v)", child, parent, got, err)
+	}
+}
+
+func TestMaintenanceLockAdmitsOneAtATime(t *testing.T) {
+	s := tempStore(t)
+	// Each store stands in for a separate shop process on the same database
+	stores := []*Store{s}
+	for i := 0; i < 3; i++ {
+		other, err := NewStore(s.DBPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		stores = append(stores, other)
+	}
+
+	var wg sync.WaitGroup
+	unlocks := make(chan func(), len(stores))
+	refusals := make(chan error, len(stores))
+	for _, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := store.LockMaintenance("shop vacuum")
+			if err != nil {
+				refusals <- err
+				return
+			}
+			unlocks <- unlock
+		}()
+	}
+	wg.Wait()
+	close(unlocks)
+	close(refusals)
+
+	if len(unlocks) != 1 {
+		t.Fatalf("expected exactly one holder, got %d", len(unlocks))
+	}
+	// A refusal racing the holder's write may not know who holds the lock yet
+	for err := range refusals {
+		if !errors.Is(err, ErrMaintenanceLocked) ||
+			!strings.Contains(err.Error(), "shop vacuum (pid ") && !strings.Contains(err.Error(), "another shop process is running maintenance") {
+			t.Fatalf("expected a maintenance refusal, got %v", err)
+		}
+	}
+	if _, err := stores[1].LockMaintenance("shop vacuum"); err == nil || !strings.Contains(err.Error(), "shop vacuum (pid ") {
+		t.Fatalf("expected a refusal naming the holder, got %v", err)
+	}
+
+	(<-unlocks)()
+	unlock, err := stores[1].LockMaintenance("shop prune-branches")
+	if err != nil {
+		t.Fatalf("expected the lock free once released: %v", err)
+	}
+	unlock()
+}
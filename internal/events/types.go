@@ -19,15 +19,21 @@ const (
 	EventRunFailed       EventType = "RunFailed"
 	EventRunStuck        EventType = "RunStuck"
 	EventRunWaitingHuman EventType = "RunWaitingHuman"
+	EventRunPaused       EventType = "RunPaused"
+	EventPauseRequested  EventType = "PauseRequested"
 	EventRunKilled       EventType = "RunKilled"
 	EventRunStopped      EventType = "RunStopped"
 	EventRunDeleted      EventType = "RunDeleted"
+	EventRunReset        EventType = "RunReset"
+	EventRunMetadataSet  EventType = "RunMetadataSet"
 
 	// Agent lifecycle
 	EventAgentStarted   EventType = "AgentStarted"
 	EventAgentCompleted EventType = "AgentCompleted"
 	EventAgentFailed    EventType = "AgentFailed"
 	EventSignalReceived EventType = "SignalReceived"
+	EventAgentHandedOff EventType = "AgentHandedOff"
+	EventSignalReminded EventType = "SignalReminded"
 
 	// Checkpoint lifecycle
 	EventCheckpointStarted   EventType = "CheckpointStarted"
@@ -35,8 +41,10 @@ const (
 	EventHumanInputReceived  EventType = "HumanInputReceived"
 
 	// Workflow runtime
-	EventReplayInvalidated EventType = "ReplayInvalidated"
-	EventLogMessage        EventType = "LogMessage"
+	EventReplayInvalidated  EventType = "ReplayInvalidated"
+	EventLogMessage         EventType = "LogMessage"
+	EventContextInitialized EventType = "ContextInitialized"
+	EventRunSummarized      EventType = "RunSummarized"
 )
 
 // Event is an immutable fact stored in the event log.
@@ -90,6 +98,26 @@ type RunStartedPayload struct {
 	WorkflowName  string `json:"workflow_name"`
 	InitialPrompt string `json:"initial_prompt"`
 	WorkspacePath string `json:"workspace_path"`
+	Branch        string `json:"branch,omitempty"`
+	BaseCommit    string `json:"base_commit,omitempty"` // source HEAD the branch was created from
+
+	// WorkflowSource is the script as it was when the run started. Every
+	// execution of the run uses it, so editing the file can't change a
+	// run midway.
+	WorkflowSource string `json:"workflow_source,omitempty"`
+
+	// ParentRunID and ParentCallIndex link a run started by another run's
+	// run_workflow() call back to that call.
+	ParentRunID     int64 `json:"parent_run_id,omitempty"`
+	ParentCallIndex int   `json:"parent_call_index,omitempty"`
+
+	// KeepGoing hands an agent's STUCK signal back to the script like any
+	// other status instead of suspending the run for a human.
+	KeepGoing bool `json:"keep_going,omitempty"`
+
+	// Priority orders the run's agents against other runs' for a free
+	// agent slot when SHOP_MAX_CONCURRENT_CLAUDE is set: higher first.
+	Priority int `json:"priority,omitempty"`
 }
 
 type RunResumedPayload struct{}
@@ -110,7 +138,29 @@ type RunWaitingHumanPayload struct {
 	SessionID string `json:"session_id"`
 }
 
-type RunKilledPayload struct{}
+// RunPausedPayload records a breakpoint hit: the run suspended before
+// starting AgentName at CallIndex.
+type RunPausedPayload struct {
+	AgentName string `json:"agent_name"`
+	CallIndex int    `json:"call_index"`
+	Reason    string `json:"reason"`
+}
+
+// PauseRequestedPayload asks a running workflow to pause before its next
+// fresh agent run.
+type PauseRequestedPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// RunMetadataSetPayload merges key/value pairs into a run's metadata; an
+// empty value removes the key.
+type RunMetadataSetPayload struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+type RunKilledPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
 
 type RunStoppedPayload struct {
 	Reason string `json:"reason"`
@@ -118,26 +168,62 @@ type RunStoppedPayload struct {
 
 type RunDeletedPayload struct{}
 
+// RunResetPayload discards a run's executions so it starts over from the
+// first call on resume. Hard means the worktree was reset too.
+type RunResetPayload struct {
+	Hard bool `json:"hard,omitempty"`
+}
+
 type AgentStartedPayload struct {
-	AgentName string `json:"agent_name"`
-	CallIndex int    `json:"call_index"`
-	SessionID string `json:"session_id"`
-	PID       int    `json:"pid"`
-	Prompt    string `json:"prompt,omitempty"`
-	Model     string `json:"model,omitempty"`
+	AgentName    string `json:"agent_name"`
+	CallIndex    int    `json:"call_index"`
+	SessionID    string `json:"session_id"`
+	PID          int    `json:"pid"`
+	Prompt       string `json:"prompt,omitempty"` // as the script passed it
+	Model        string `json:"model,omitempty"`
+	OutputFormat string `json:"output_format,omitempty"` // claude --output-format used
+
+	// SentPrompt is the full prompt Claude received: Prompt plus the
+	// context and signalling instructions shop adds.
+	SentPrompt string `json:"sent_prompt,omitempty"`
+
+	SkippedPermissions bool `json:"skipped_permissions,omitempty"` // ran with --dangerously-skip-permissions
+
+	ChildRunID int64 `json:"child_run_id,omitempty"` // the run a run_workflow() call started
+
+	// Finishing marks a call made after the workflow returned: the finally
+	// agent, or a run() in an on_finish hook. It doesn't decide the outcome.
+	Finishing bool `json:"finishing,omitempty"`
 }
 
 type AgentCompletedPayload struct {
 	AgentName string         `json:"agent_name"`
 	CallIndex int            `json:"call_index"`
 	Signal    map[string]any `json:"signal"`
+	Artifacts []string       `json:"artifacts,omitempty"` // validated repo-relative paths
+	Result    *AgentResult   `json:"result,omitempty"`
 }
 
 type AgentFailedPayload struct {
-	AgentName string `json:"agent_name"`
-	CallIndex int    `json:"call_index"`
-	Error     string `json:"error"`
-	ExitCode  int    `json:"exit_code,omitempty"`
+	AgentName string       `json:"agent_name"`
+	CallIndex int          `json:"call_index"`
+	Error     string       `json:"error"`
+	ExitCode  int          `json:"exit_code,omitempty"`
+	Result    *AgentResult `json:"result,omitempty"`
+}
+
+// AgentResult is the final JSON object `claude -p --output-format json`
+// prints. Fields Claude omits are left zero.
+type AgentResult struct {
+	Subtype      string  `json:"subtype,omitempty"` // "success", "error_max_turns", ...
+	IsError      bool    `json:"is_error,omitempty"`
+	Result       string  `json:"result,omitempty"` // final assistant text
+	NumTurns     int     `json:"num_turns,omitempty"`
+	StopReason   string  `json:"stop_reason,omitempty"`
+	DurationMS   int64   `json:"duration_ms,omitempty"`
+	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
+	InputTokens  int     `json:"input_tokens,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
 }
 
 type SignalReceivedPayload struct {
@@ -145,10 +231,27 @@ type SignalReceivedPayload struct {
 	Signal    map[string]any `json:"signal"`
 }
 
-type CheckpointStartedPayload struct {
+type AgentHandedOffPayload struct {
 	CallIndex int    `json:"call_index"`
-	Message   string `json:"message"`
-	SessionID string `json:"session_id"`
+	FromAgent string `json:"from_agent"`
+	ToAgent   string `json:"to_agent"`
+	Reason    string `json:"reason,omitempty"` // "handoff" or "manual step"
+}
+
+// SignalRemindedPayload records that an agent finished without a signal
+// and was resumed, as process PID, with a reminder to report one.
+type SignalRemindedPayload struct {
+	AgentName string `json:"agent_name"`
+	CallIndex int    `json:"call_index"`
+	PID       int    `json:"pid,omitempty"`
+}
+
+type CheckpointStartedPayload struct {
+	CallIndex          int    `json:"call_index"`
+	Message            string `json:"message"`
+	SessionID          string `json:"session_id"`
+	SkippedPermissions bool   `json:"skipped_permissions,omitempty"`
+	SentPrompt         string `json:"sent_prompt,omitempty"`
 }
 
 type CheckpointCompletedPayload struct {
@@ -168,4 +271,24 @@ type ReplayInvalidatedPayload struct {
 
 type LogMessagePayload struct {
 	Message string `json:"message"`
+	Level   string `json:"level,omitempty"` // LogLevelInfo when empty
+}
+
+// Log levels: log() writes info lines, the runtime's own notices are
+// warnings.
+const (
+	LogLevelInfo = "info"
+	LogLevelWarn = "warn"
+)
+
+// ContextInitializedPayload holds the rendered brief that heads the context
+// agents read through get_context.
+type ContextInitializedPayload struct {
+	Content string `json:"content"`
+}
+
+// RunSummarizedPayload holds the digest written when a run settles
+// complete, stuck or failed: one "agent: summary" line per execution.
+type RunSummarizedPayload struct {
+	Summary string `json:"summary"`
 }
@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/mpataki/shop/internal/commands"
 	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/workflow"
 )
 
 // Server implements a minimal MCP server over stdio.
@@ -16,14 +16,16 @@ import (
 type Server struct {
 	dbPath    string
 	runID     int64
+	agent     string // the agent the call runs; empty for configs that predate it
 	callIndex int
 	statuses  []string // merged reserved + custom statuses
 }
 
-func NewServer(dbPath string, runID int64, callIndex int, customStatuses []string) *Server {
+func NewServer(dbPath string, runID int64, agent string, callIndex int, customStatuses []string) *Server {
 	return &Server{
 		dbPath:    dbPath,
 		runID:     runID,
+		agent:     agent,
 		callIndex: callIndex,
 		statuses:  events.MergeStatuses(customStatuses),
 	}
@@ -133,16 +135,26 @@ func (s *Server) handleToolsList() map[string]any {
 							"type":        "string",
 							"description": "Reason, if status is STUCK or STOP",
 						},
+						"artifacts": map[string]any{
+							"type":        "array",
+							"items":       map[string]any{"type": "string"},
+							"description": "Repo-relative paths of files you produced that later agents need (reports, diffs, etc.)",
+						},
 					},
 					"required": []string{"status", "summary"},
 				},
 			},
 			{
 				"name":        "get_context",
-				"description": "Get context from previous agents in this workflow run, including their summaries and statuses.",
+				"description": "Get context from previous agents in this workflow run, including their summaries and statuses. In long runs older entries are condensed; pass full to read them all.",
 				"inputSchema": map[string]any{
-					"type":       "object",
-					"properties": map[string]any{},
+					"type": "object",
+					"properties": map[string]any{
+						"full": map[string]any{
+							"type":        "boolean",
+							"description": "Return every entry in full instead of condensing older ones",
+						},
+					},
 				},
 			},
 			{
@@ -171,7 +183,8 @@ func (s *Server) handleToolsCall(params json.RawMessage, store *events.Store) ma
 	case "report_signal":
 		return s.handleReportSignal(call.Arguments, store)
 	case "get_context":
-		return s.handleGetContext(store)
+		full, _ := call.Arguments["full"].(bool)
+		return s.handleGetContext(store, full)
 	case "get_run_info":
 		return s.handleGetRunInfo(store)
 	default:
@@ -192,6 +205,7 @@ func (s *Server) handleReportSignal(args map[string]any, store *events.Store) ma
 	// Submit a ReportSignal command
 	cmd, err := commands.NewCommand(s.runID, commands.CmdReportSignal, commands.ReportSignalPayload{
 		CallIndex: s.callIndex,
+		AgentName: s.agent,
 		Status:    statusStr,
 		Signal:    args,
 	})
@@ -213,7 +227,7 @@ func (s *Server) handleReportSignal(args map[string]any, store *events.Store) ma
 	}
 }
 
-func (s *Server) handleGetContext(store *events.Store) map[string]any {
+func (s *Server) handleGetContext(store *events.Store, full bool) map[string]any {
 	if store == nil {
 		return toolError("MCP server not connected to database")
 	}
@@ -223,38 +237,43 @@ func (s *Server) handleGetContext(store *events.Store) map[string]any {
 		return toolError("failed to get run: " + err.Error())
 	}
 
-	var sb strings.Builder
-	fmt.Fprintf(&sb, "# Run Context\n\n**Workflow:** %s\n**Task:** %s\n\n---\n\n", state.WorkflowName, state.InitialPrompt)
-
-	for _, exec := range state.Executions {
-		if exec.CallIndex == s.callIndex {
-			continue // skip current execution
-		}
-		if exec.Signal == nil {
-			continue
-		}
-		agentStatus, _ := exec.Signal["status"].(string)
-		if agentStatus == "" {
-			continue
-		}
-		if summary, ok := exec.Signal["summary"].(string); ok && summary != "" {
-			fmt.Fprintf(&sb, "## %s\n\n**Status:** %s\n\n%s\n\n---\n\n", exec.AgentName, agentStatus, summary)
-		} else {
-			signalJSON, _ := json.MarshalIndent(exec.Signal, "", "  ")
-			fmt.Fprintf(&sb, "## %s\n\n**Status:** %s\n\n```json\n%s\n```\n\n---\n\n", exec.AgentName, agentStatus, string(signalJSON))
-		}
+	// Skip the current execution
+	include := func(exec events.ExecutionState) bool {
+		return exec.CallIndex != s.callIndex
+	}
+	var text string
+	if full {
+		text = workflow.AgentContext(state, include)
+	} else {
+		maxChars, keep := runSettings(state).ContextLimits()
+		text = workflow.BoundedContext(state, include, maxChars, keep)
 	}
 
 	return map[string]any{
 		"content": []map[string]any{
 			{
 				"type": "text",
-				"text": sb.String(),
+				"text": text,
 			},
 		},
 	}
 }
 
+// runSettings returns the settings of the run's workflow script, or the
+// defaults if it can't be read.
+func runSettings(state *events.RunState) workflow.Settings {
+	script := state.WorkflowSource
+	if script == "" {
+		data, err := os.ReadFile(state.WorkflowPath)
+		if err != nil {
+			return workflow.Settings{}
+		}
+		script = string(data)
+	}
+	settings, _ := workflow.LoadSettings(script)
+	return settings
+}
+
 func (s *Server) handleGetRunInfo(store *events.Store) map[string]any {
 	if store == nil {
 		return toolError("MCP server not connected to database")
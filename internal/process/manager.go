@@ -9,6 +9,7 @@ import (
 	"syscall"
 
 	"github.com/google/uuid"
+	"github.com/mpataki/shop/internal/events"
 )
 
 // AgentOpts configures a Claude agent invocation.
@@ -17,8 +18,21 @@ type AgentOpts struct {
 	SignalAgent   string // name used for MCP signal identification
 	Prompt        string
 	Model         string
+	OutputFormat  string // claude --output-format: json (default), stream-json or text
 	WorkDir       string // working directory for the process
 	MCPConfigPath string // path to mcp.json
+
+	// SkipPermissions passes --dangerously-skip-permissions so the agent
+	// never stops for a permission prompt.
+	SkipPermissions bool
+
+	// ResumeSessionID continues an earlier session with Prompt instead of
+	// starting a new one.
+	ResumeSessionID string
+
+	// Priority orders agents waiting for a slot under Throttle: higher
+	// first, then the longest waiting.
+	Priority int
 }
 
 // ProcessResult holds the outcome of a completed agent process.
@@ -28,6 +42,63 @@ type ProcessResult struct {
 	ExitCode    int
 	Stderr      string
 	ErrorResult string // extracted from Claude's JSON output when is_error is true
+	Result      *events.AgentResult
+}
+
+// Output formats accepted by `claude --output-format`.
+const (
+	OutputJSON       = "json"
+	OutputStreamJSON = "stream-json"
+	OutputText       = "text"
+)
+
+// ValidOutputFormat reports whether f is a format shop can run claude with.
+func ValidOutputFormat(f string) bool {
+	return f == OutputJSON || f == OutputStreamJSON || f == OutputText
+}
+
+// OutputFormatOrDefault returns f, or json when it is empty.
+func OutputFormatOrDefault(f string) string {
+	if f == "" {
+		return OutputJSON
+	}
+	return f
+}
+
+// ParseResult decodes Claude's `--output-format json` output, or the final
+// "result" line of stream-json output. It returns nil for anything else,
+// including text output.
+func ParseResult(stdout []byte) *events.AgentResult {
+	if result := parseResultObject(stdout); result != nil {
+		return result
+	}
+	lines := bytes.Split(bytes.TrimSpace(stdout), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var line struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(lines[i], &line) == nil && line.Type == "result" {
+			return parseResultObject(lines[i])
+		}
+	}
+	return nil
+}
+
+func parseResultObject(data []byte) *events.AgentResult {
+	var output struct {
+		events.AgentResult
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(data, &output) != nil {
+		return nil
+	}
+	result := output.AgentResult
+	result.InputTokens = output.Usage.InputTokens
+	result.OutputTokens = output.Usage.OutputTokens
+	return &result
 }
 
 // Manager abstracts starting and killing agent processes.
@@ -45,19 +116,32 @@ func NewCLIManager() *CLIManager {
 
 func (m *CLIManager) StartAgent(ctx context.Context, opts AgentOpts) (string, int, <-chan ProcessResult, error) {
 	sessionID := uuid.New().String()
+	sessionFlag := "--session-id"
+	if opts.ResumeSessionID != "" {
+		sessionID, sessionFlag = opts.ResumeSessionID, "--resume"
+	}
 
+	format := OutputFormatOrDefault(opts.OutputFormat)
 	args := []string{
 		"-p", opts.Prompt,
-		"--output-format", "json",
-		"--dangerously-skip-permissions",
+		"--output-format", format,
 		"--max-turns", "10",
-		"--session-id", sessionID,
+		sessionFlag, sessionID,
+	}
+
+	if opts.SkipPermissions {
+		args = append(args, "--dangerously-skip-permissions")
 	}
 
 	if opts.MCPConfigPath != "" {
 		args = append(args, "--mcp-config", opts.MCPConfigPath)
 	}
 
+	if format == OutputStreamJSON {
+		// claude requires --verbose to stream JSON in print mode
+		args = append(args, "--verbose")
+	}
+
 	if opts.Model != "" {
 		args = append(args, "--model", opts.Model)
 	}
@@ -93,15 +177,10 @@ func (m *CLIManager) StartAgent(ctx context.Context, opts AgentOpts) (string, in
 		}
 		result.Stderr = stderr.String()
 
-		// Parse JSON output for errors
-		if stdout.Len() > 0 {
-			var output struct {
-				IsError bool   `json:"is_error"`
-				Result  string `json:"result"`
-			}
-			if json.Unmarshal(stdout.Bytes(), &output) == nil && output.IsError {
-				result.ErrorResult = output.Result
-			}
+		// Parse JSON output for the result and errors
+		result.Result = ParseResult(stdout.Bytes())
+		if result.Result != nil && result.Result.IsError {
+			result.ErrorResult = result.Result.Result
 		}
 
 		if err != nil {
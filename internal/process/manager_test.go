@@ -0,0 +1,53 @@
+package process
+
+import "testing"
+
+func TestParseResult(t *testing.T) {
+	out := []byte(`{
+		"type": "result", "subtype": "success", "is_error": false,
+		"result": "Done: added fib()", "num_turns": 6, "stop_reason": "end_turn",
+		"duration_ms": 41234, "total_cost_usd": 0.0812, "session_id": "abc",
+		"usage": {"input_tokens": 1200, "output_tokens": 340}
+	}`)
+	r := ParseResult(out)
+	if r == nil {
+		t.Fatal("expected a result")
+	}
+	if r.Subtype != "success" || r.Result != "Done: added fib()" || r.NumTurns != 6 || r.StopReason != "end_turn" {
+		t.Fatalf("unexpected result fields: %+v", r)
+	}
+	if r.DurationMS != 41234 || r.TotalCostUSD != 0.0812 || r.InputTokens != 1200 || r.OutputTokens != 340 {
+		t.Fatalf("unexpected usage fields: %+v", r)
+	}
+}
+
+func TestParseResultAbsentFields(t *testing.T) {
+	r := ParseResult([]byte(`{"type": "result", "is_error": true, "result": "Credit balance is too low"}`))
+	if r == nil || !r.IsError || r.Result != "Credit balance is too low" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r.NumTurns != 0 || r.StopReason != "" || r.InputTokens != 0 {
+		t.Fatalf("expected absent fields to be zero, got %+v", r)
+	}
+
+	for _, out := range []string{"", "claude: command failed\n", "[1, 2]"} {
+		if r := ParseResult([]byte(out)); r != nil {
+			t.Fatalf("expected nil for %q, got %+v", out, r)
+		}
+	}
+}
+
+func TestParseResultStreamJSON(t *testing.T) {
+	out := []byte(`{"type":"system","subtype":"init","session_id":"abc"}
+{"type":"assistant","message":{"content":[{"type":"text","text":"working"}]}}
+{"type":"result","subtype":"success","result":"Streamed done","num_turns":3}
+`)
+	r := ParseResult(out)
+	if r == nil || r.Result != "Streamed done" || r.NumTurns != 3 {
+		t.Fatalf("expected the result line to be parsed, got %+v", r)
+	}
+
+	if r := ParseResult([]byte("Just some text output\n")); r != nil {
+		t.Fatalf("expected nil for text output, got %+v", r)
+	}
+}
@@ -0,0 +1,243 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Limiter bounds how many agents run at once. Acquire blocks until a slot
+// is free (or ctx is done) and returns the function that frees it. Waiters
+// with a higher priority get a freed slot first; among equal priorities the
+// one that has waited longest does.
+type Limiter interface {
+	Acquire(ctx context.Context, priority int) (release func(), err error)
+}
+
+// Throttle returns a Manager that holds one of limiter's slots from the
+// moment each agent starts until its process exits.
+func Throttle(m Manager, limiter Limiter) Manager {
+	return &throttled{Manager: m, limiter: limiter}
+}
+
+type throttled struct {
+	Manager
+	limiter Limiter
+}
+
+func (t *throttled) StartAgent(ctx context.Context, opts AgentOpts) (string, int, <-chan ProcessResult, error) {
+	release, err := t.limiter.Acquire(ctx, opts.Priority)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("wait for a free agent slot: %w", err)
+	}
+	sessionID, pid, done, err := t.Manager.StartAgent(ctx, opts)
+	if err != nil {
+		release()
+		return "", 0, nil, err
+	}
+
+	out := make(chan ProcessResult, 1)
+	go func() {
+		defer close(out)
+		result, ok := <-done
+		release()
+		if ok {
+			out <- result
+		}
+	}()
+	return sessionID, pid, out, nil
+}
+
+// NewSemaphore returns a Limiter allowing n holders within this process.
+func NewSemaphore(n int) Limiter {
+	return &semaphore{free: n}
+}
+
+type semaphore struct {
+	mu      sync.Mutex
+	free    int
+	waiters []*semWaiter // in arrival order
+}
+
+type semWaiter struct {
+	priority int
+	granted  chan struct{}
+}
+
+func (s *semaphore) Acquire(ctx context.Context, priority int) (func(), error) {
+	s.mu.Lock()
+	if s.free > 0 && len(s.waiters) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	w := &semWaiter{priority: priority, granted: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, other := range s.waiters {
+			if other == w {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// Granted as ctx ended: pass the slot on
+		s.handOff()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handOff()
+}
+
+// handOff gives a freed slot to the first waiter in line, or frees it.
+func (s *semaphore) handOff() {
+	if len(s.waiters) == 0 {
+		s.free++
+		return
+	}
+	best := 0
+	for i, w := range s.waiters {
+		if w.priority > s.waiters[best].priority {
+			best = i
+		}
+	}
+	w := s.waiters[best]
+	s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+	close(w.granted)
+}
+
+// slotPoll is how often SlotLimiter.Acquire retries while every slot is held.
+const slotPoll = 200 * time.Millisecond
+
+// SlotLimiter is a Limiter shared by every shop process on the host: its n
+// slots are files in dir, held with flock, so a slot is freed even if the
+// process holding it dies. Each waiter holds a queue file in dir naming its
+// priority and when it began waiting, and leaves a free slot alone while a
+// live waiter ahead of it is queued.
+type SlotLimiter struct {
+	dir string
+	n   int
+}
+
+func NewSlotLimiter(dir string, n int) *SlotLimiter {
+	return &SlotLimiter{dir: dir, n: n}
+}
+
+func (l *SlotLimiter) Acquire(ctx context.Context, priority int) (func(), error) {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	me := queueTicket{priority: priority, since: time.Now().UnixNano()}
+	name := fmt.Sprintf("wait.%d.%d.%d", me.priority, me.since, os.Getpid())
+	// Locked before it is given its queue name, so no one takes it for dead
+	queued, err := os.OpenFile(filepath.Join(l.dir, name+".new"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(queued.Fd()), syscall.LOCK_EX)
+	if err == nil {
+		err = os.Rename(queued.Name(), filepath.Join(l.dir, name))
+	}
+	if err != nil {
+		os.Remove(queued.Name())
+		queued.Close()
+		return nil, err
+	}
+	defer func() {
+		os.Remove(filepath.Join(l.dir, name))
+		queued.Close()
+	}()
+
+	for {
+		if !l.queuedAhead(me, name) {
+			for i := 0; i < l.n; i++ {
+				f, err := os.OpenFile(filepath.Join(l.dir, fmt.Sprintf("slot-%d", i)), os.O_RDWR|os.O_CREATE, 0644)
+				if err != nil {
+					return nil, err
+				}
+				if syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+					return func() { f.Close() }, nil
+				}
+				f.Close()
+			}
+		}
+
+		select {
+		case <-time.After(slotPoll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// queueTicket is a SlotLimiter waiter's place in line.
+type queueTicket struct {
+	priority int
+	since    int64
+}
+
+func (t queueTicket) ahead(o queueTicket) bool {
+	if t.priority != o.priority {
+		return t.priority > o.priority
+	}
+	return t.since < o.since
+}
+
+// queuedAhead reports whether a live waiter other than self is ahead of me.
+// Queue files whose waiter died (their lock is free) are removed.
+func (l *SlotLimiter) queuedAhead(me queueTicket, self string) bool {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		other, ok := parseQueueFile(e.Name())
+		if !ok || e.Name() == self || !other.ahead(me) {
+			continue
+		}
+		path := filepath.Join(l.dir, e.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		alive := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) != nil
+		if !alive {
+			os.Remove(path)
+		}
+		f.Close()
+		if alive {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQueueFile reads the ticket from a wait.<priority>.<since>.<pid> name.
+func parseQueueFile(name string) (queueTicket, bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 4 || parts[0] != "wait" {
+		return queueTicket{}, false
+	}
+	priority, err1 := strconv.Atoi(parts[1])
+	since, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return queueTicket{}, false
+	}
+	return queueTicket{priority: priority, since: since}, true
+}
@@ -0,0 +1,152 @@
+package process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowManager runs each agent for a fixed time and records the most agents
+// it ever had running at once.
+type slowManager struct {
+	mu          sync.Mutex
+	running     int
+	maxRunning  int
+	runDuration time.Duration
+}
+
+func (m *slowManager) StartAgent(ctx context.Context, opts AgentOpts) (string, int, <-chan ProcessResult, error) {
+	m.mu.Lock()
+	m.running++
+	m.maxRunning = max(m.maxRunning, m.running)
+	m.mu.Unlock()
+
+	done := make(chan ProcessResult, 1)
+	go func() {
+		time.Sleep(m.runDuration)
+		m.mu.Lock()
+		m.running--
+		m.mu.Unlock()
+		done <- ProcessResult{ExitCode: 0}
+		close(done)
+	}()
+	return "session", 0, done, nil
+}
+
+func (m *slowManager) Kill(pid int) error { return nil }
+
+func TestThrottleLimitsConcurrentAgents(t *testing.T) {
+	for name, limiter := range map[string]Limiter{
+		"semaphore": NewSemaphore(2),
+		"slots":     NewSlotLimiter(t.TempDir(), 2),
+	} {
+		inner := &slowManager{runDuration: 20 * time.Millisecond}
+		m := Throttle(inner, limiter)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, done, err := m.StartAgent(context.Background(), AgentOpts{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				<-done
+			}()
+		}
+		wg.Wait()
+
+		if inner.maxRunning != 2 {
+			t.Errorf("%s: expected at most 2 agents at once, and 2 reached; got %d", name, inner.maxRunning)
+		}
+	}
+}
+
+func TestSlotLimiterIsSharedAcrossLimiters(t *testing.T) {
+	// Two limiters on one directory stand in for two shop processes
+	dir := t.TempDir()
+	a, b := NewSlotLimiter(dir, 1), NewSlotLimiter(dir, 1)
+
+	release, err := a.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(ctx, 0); err == nil {
+		t.Fatal("expected the second limiter to wait while the slot is held")
+	}
+
+	release()
+	release, err = b.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("expected the slot once released: %v", err)
+	}
+	release()
+}
+
+func TestLimiterServesHigherPriorityFirst(t *testing.T) {
+	for name, limiter := range map[string]Limiter{
+		"semaphore": NewSemaphore(1),
+		"slots":     NewSlotLimiter(t.TempDir(), 1),
+	} {
+		release, err := limiter.Acquire(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The low-priority waiter queues first; the high-priority one later
+		var mu sync.Mutex
+		var order []string
+		var wg sync.WaitGroup
+		wait := func(who string, priority int) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := limiter.Acquire(context.Background(), priority)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				order = append(order, who)
+				mu.Unlock()
+				release()
+			}()
+		}
+		wait("low", 0)
+		time.Sleep(50 * time.Millisecond)
+		wait("high", 5)
+		time.Sleep(50 * time.Millisecond)
+
+		release()
+		wg.Wait()
+		if len(order) != 2 || order[0] != "high" {
+			t.Errorf("%s: expected the higher-priority waiter served first, got %v", name, order)
+		}
+	}
+}
+
+func TestSlotLimiterSkipsDeadWaiters(t *testing.T) {
+	// A queue file left by a process that died while waiting
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wait.9.1.1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, err := NewSlotLimiter(dir, 1).Acquire(ctx, 0)
+	if err != nil {
+		t.Fatalf("expected the dead waiter not to hold up the queue: %v", err)
+	}
+	release()
+	if _, err := os.Stat(filepath.Join(dir, "wait.9.1.1")); !os.IsNotExist(err) {
+		t.Fatal("expected the dead waiter's queue file removed")
+	}
+}
@@ -2,13 +2,16 @@ package tui
 
 import (
 	"bufio"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
@@ -38,6 +41,7 @@ type App struct {
 	selectedIdx     int
 	selectedRun     *events.RunState
 	selectedExecIdx int
+	showSignal      bool // signal panel toggled in the run detail view
 	outputContent   string
 
 	workflows           []config.WorkflowInfo
@@ -51,10 +55,19 @@ type App struct {
 	width   int
 	height  int
 	err     error
+
+	// loadErr is why the last reload failed (e.g. the DB was locked); runs
+	// and selectedRun keep what last loaded until a retry succeeds
+	loadErr error
+
+	openRun int64 // run to show in detail on start, see OpenRun
 }
 
 const maxLogs = 6
 
+// reloadRetry is how often a failed reload is retried.
+const reloadRetry = 2 * time.Second
+
 func NewApp(proc *commands.Processor, store *events.Store, cfg *config.Config) *App {
 	ti := textarea.New()
 	ti.Placeholder = "what should the agents do?"
@@ -63,7 +76,7 @@ func NewApp(proc *commands.Processor, store *events.Store, cfg *config.Config) *
 	ti.ShowLineNumbers = false
 	ti.Prompt = "  "
 	ti.FocusedStyle.CursorLine = lipgloss.NewStyle()
-	ti.FocusedStyle.Prompt = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	ti.FocusedStyle.Prompt = accentStyle
 	ti.FocusedStyle.Base = lipgloss.NewStyle()
 	ti.BlurredStyle.CursorLine = lipgloss.NewStyle()
 	ti.BlurredStyle.Prompt = lipgloss.NewStyle()
@@ -85,9 +98,29 @@ func NewApp(proc *commands.Processor, store *events.Store, cfg *config.Config) *
 	}
 }
 
+// OpenRun makes the app start on run id's detail view instead of the run
+// list. If the run can't be loaded the list is shown with the error.
+func (a *App) OpenRun(id int64) {
+	a.openRun = id
+}
+
 func (a *App) Init() tea.Cmd {
 	a.reloadRuns()
-	return tea.Batch(a.waitForEvent(), a.spinner.Tick)
+	if a.openRun > 0 {
+		for i, run := range a.runs {
+			if run.ID == a.openRun {
+				a.selectedIdx = i
+			}
+		}
+		return tea.Batch(a.waitForEvent(), a.spinner.Tick, a.retryTick(), a.loadRunDetail(a.openRun))
+	}
+	return tea.Batch(a.waitForEvent(), a.spinner.Tick, a.retryTick())
+}
+
+type retryTickMsg struct{}
+
+func (a *App) retryTick() tea.Cmd {
+	return tea.Tick(reloadRetry, func(time.Time) tea.Msg { return retryTickMsg{} })
 }
 
 func (a *App) waitForEvent() tea.Cmd {
@@ -110,6 +143,13 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.spinner, cmd = a.spinner.Update(msg)
 		return a, cmd
 
+	case retryTickMsg:
+		if a.loadErr != nil {
+			a.reloadRuns()
+			a.refreshSelectedRun()
+		}
+		return a, a.retryTick()
+
 	case tea.KeyMsg:
 		return a.handleKey(msg)
 
@@ -131,8 +171,8 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Log-only — no data reload needed.
 		default:
 			a.reloadRuns()
-			if a.view == ViewRunDetail && a.selectedRun != nil && e.RunID == a.selectedRun.ID {
-				a.selectedRun, _ = a.store.ProjectRunFromDB(a.selectedRun.ID)
+			if a.selectedRun != nil && e.RunID == a.selectedRun.ID {
+				a.refreshSelectedRun()
 			}
 		}
 		return a, a.waitForEvent()
@@ -171,9 +211,7 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case runStoppedMsg:
 		a.err = msg.err
-		if a.view == ViewRunDetail && a.selectedRun != nil {
-			a.selectedRun, _ = a.store.ProjectRunFromDB(a.selectedRun.ID)
-		}
+		a.refreshSelectedRun()
 		a.reloadRuns()
 		return a, nil
 
@@ -262,8 +300,8 @@ func (a *App) handleRunListKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "c":
 		if len(a.runs) > 0 && a.selectedIdx < len(a.runs) {
 			run := a.runs[a.selectedIdx]
-			if run.Status == events.RunStatusWaitingHuman && run.WaitingSessionID != "" {
-				return a, a.continueSession(run.ID, run.WaitingSessionID, run.WorkspacePath+"/repo")
+			if run.Status == events.RunStatusWaitingHuman {
+				return a, a.continueSession(run.ID)
 			}
 		}
 	}
@@ -310,15 +348,14 @@ func (a *App) handleRunDetailKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 	case "c":
 		if a.selectedRun != nil && a.selectedRun.Status == events.RunStatusWaitingHuman {
-			if a.selectedRun.WaitingSessionID != "" {
-				workDir := a.selectedRun.WorkspacePath + "/repo"
-				return a, a.continueSession(a.selectedRun.ID, a.selectedRun.WaitingSessionID, workDir)
-			}
+			return a, a.continueSession(a.selectedRun.ID)
 		}
 	case "s":
 		if a.selectedRun != nil && a.selectedRun.Status == events.RunStatusWaitingHuman {
 			return a, a.stopRun(a.selectedRun.ID)
 		}
+	case "v":
+		a.showSignal = !a.showSignal
 	}
 	return a, nil
 }
@@ -481,10 +518,12 @@ type runStartedMsg struct {
 
 // ── Commands ──────────────────────────────────────────────────────────────────
 
+// reloadRuns reloads the run list. If the DB can't be read the list keeps
+// the runs that last loaded, and the retry tick tries again.
 func (a *App) reloadRuns() {
 	infos, err := a.store.ListRunIDs(20)
 	if err != nil {
-		a.err = err
+		a.loadErr = err
 		return
 	}
 
@@ -492,7 +531,8 @@ func (a *App) reloadRuns() {
 	for _, info := range infos {
 		evts, err := a.store.GetEvents(info.ID)
 		if err != nil {
-			continue
+			a.loadErr = err
+			return
 		}
 		state := events.ProjectRun(info.ID, info.CreatedAt, evts)
 		if state.Status != events.RunStatusDeleted {
@@ -501,14 +541,35 @@ func (a *App) reloadRuns() {
 	}
 
 	a.runs = runs
+	a.loadErr = nil
+}
+
+// refreshSelectedRun reloads the run shown in the detail view, keeping the
+// last loaded state if it can't be read.
+func (a *App) refreshSelectedRun() {
+	if a.view != ViewRunDetail || a.selectedRun == nil {
+		return
+	}
+	state, err := a.store.ProjectRunFromDB(a.selectedRun.ID)
+	if err != nil {
+		a.loadErr = err
+		return
+	}
+	a.selectedRun = state
 }
 
 func (a *App) loadRunDetail(id int64) tea.Cmd {
 	return func() tea.Msg {
 		state, err := a.store.ProjectRunFromDB(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return runDetailMsg{err: fmt.Errorf("run %d not found", id)}
+		}
 		if err != nil {
 			return runDetailMsg{err: err}
 		}
+		if state.Status == events.RunStatusDeleted {
+			return runDetailMsg{err: fmt.Errorf("run %d is deleted", id)}
+		}
 		return runDetailMsg{state: state}
 	}
 }
@@ -560,11 +621,21 @@ func (a *App) resumeSession(sessionID string, workDir string) tea.Cmd {
 	})
 }
 
-func (a *App) continueSession(runID int64, sessionID string, workDir string) tea.Cmd {
-	cmd := exec.Command("claude", "--resume", sessionID)
-	cmd.Dir = workDir
+// continueSession opens the waiting agent's session, or a fresh one seeded
+// with its question when it left none.
+func (a *App) continueSession(runID int64) tea.Cmd {
+	session, err := a.processor.ContinueRun(runID)
+	if err != nil {
+		a.err = err
+		return nil
+	}
+	if session.Fresh() {
+		a.appendLog(fmt.Sprintf("run %d: no session recorded for %s; starting a fresh one", runID, session.Agent))
+	}
+	cmd := exec.Command("claude", session.Args()...)
+	cmd.Dir = session.WorkDir
 	return tea.ExecProcess(cmd, func(err error) tea.Msg {
-		return sessionResumedMsg{sessionID: sessionID, runID: runID, err: err}
+		return sessionResumedMsg{sessionID: session.SessionID, runID: runID, err: err}
 	})
 }
 
@@ -649,6 +720,9 @@ func (a *App) startNewRun() tea.Cmd {
 		}
 		wf := a.workflows[a.selectedWorkflowIdx]
 		prompt := a.promptInput.Value()
+		if err := commands.ValidatePrompt(prompt); err != nil {
+			return runStartedMsg{err: err}
+		}
 
 		cwd, err := os.Getwd()
 		if err != nil {
@@ -4,43 +4,74 @@ import "github.com/charmbracelet/lipgloss"
 
 var (
 	// Title / branding
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("205"))
+	titleStyle lipgloss.Style
 
 	// Selection: accent cursor, no background
-	cursorStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("205"))
-
-	selectedRowStyle = lipgloss.NewStyle().
-				Bold(true).
-				Foreground(lipgloss.Color("255"))
+	cursorStyle      lipgloss.Style
+	selectedRowStyle lipgloss.Style
 
 	// Run / exec status
-	statusRunningStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
-	statusCompleteStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("84"))
-	statusFailedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
-	statusStuckStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("215"))
-	statusPendingStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-	statusWaitingStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("141"))
+	statusRunningStyle  lipgloss.Style
+	statusCompleteStyle lipgloss.Style
+	statusFailedStyle   lipgloss.Style
+	statusStuckStyle    lipgloss.Style
+	statusPendingStyle  lipgloss.Style
+	statusWaitingStyle  lipgloss.Style
 
 	// Signal status
-	signalApprovedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("84"))
-	signalBlockedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	signalApprovedStyle lipgloss.Style
+	signalBlockedStyle  lipgloss.Style
 
 	// Chrome
-	dimStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
-	labelStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
-	helpStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("238"))
-	sepStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("237"))
-	errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+	accentStyle lipgloss.Style
+	dimStyle    lipgloss.Style
+	labelStyle  lipgloss.Style
+	helpStyle   lipgloss.Style
+	sepStyle    lipgloss.Style
+	errorStyle  lipgloss.Style
 
 	// Sections
-	boxStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("237")).
-			Padding(0, 1)
+	boxStyle    lipgloss.Style
+	logBoxStyle lipgloss.Style
 
-	logEntryStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("243"))
+	logEntryStyle lipgloss.Style
 )
+
+func init() { setStyles(Themes["default"]) }
+
+// setStyles builds every style from a theme's palette.
+func setStyles(t Theme) {
+	fg := func(c lipgloss.TerminalColor) lipgloss.Style { return lipgloss.NewStyle().Foreground(c) }
+
+	titleStyle = fg(t.Accent).Bold(true)
+	cursorStyle = fg(t.Accent).Bold(true)
+	selectedRowStyle = fg(t.Selected).Bold(true)
+
+	statusRunningStyle = fg(t.Running)
+	statusCompleteStyle = fg(t.Complete)
+	statusFailedStyle = fg(t.Failed).Bold(t.BoldAlerts)
+	statusStuckStyle = fg(t.Stuck).Bold(t.BoldAlerts)
+	statusPendingStyle = fg(t.Pending)
+	statusWaitingStyle = fg(t.Waiting)
+
+	signalApprovedStyle = fg(t.SignalDone)
+	signalBlockedStyle = fg(t.SignalStuck).Bold(t.BoldAlerts)
+
+	accentStyle = fg(t.Accent)
+	dimStyle = fg(t.Dim)
+	labelStyle = fg(t.Label)
+	helpStyle = fg(t.Help)
+	sepStyle = fg(t.Border)
+	errorStyle = fg(t.Failed).Bold(t.BoldAlerts)
+
+	boxStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(t.Border).
+		Padding(0, 1)
+	logBoxStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(t.LogBorder).
+		Padding(0, 1)
+
+	logEntryStyle = fg(t.Log)
+}
@@ -0,0 +1,128 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is the palette the TUI draws statuses, signals and chrome with.
+type Theme struct {
+	Accent lipgloss.TerminalColor // title, cursor, prompt
+
+	// Run and execution statuses
+	Running  lipgloss.TerminalColor
+	Complete lipgloss.TerminalColor
+	Failed   lipgloss.TerminalColor
+	Stuck    lipgloss.TerminalColor
+	Pending  lipgloss.TerminalColor
+	Waiting  lipgloss.TerminalColor
+
+	// Signal statuses: DONE and STUCK
+	SignalDone  lipgloss.TerminalColor
+	SignalStuck lipgloss.TerminalColor
+
+	// Chrome
+	Selected  lipgloss.TerminalColor
+	Dim       lipgloss.TerminalColor
+	Label     lipgloss.TerminalColor
+	Help      lipgloss.TerminalColor
+	Border    lipgloss.TerminalColor
+	LogBorder lipgloss.TerminalColor
+	Log       lipgloss.TerminalColor
+
+	// BoldAlerts draws failed and stuck statuses bold, for themes that
+	// can't tell them apart by colour.
+	BoldAlerts bool
+}
+
+// Themes are the built-in themes, by name.
+var Themes = map[string]Theme{
+	"default": {
+		Accent:      lipgloss.Color("205"),
+		Running:     lipgloss.Color("214"),
+		Complete:    lipgloss.Color("84"),
+		Failed:      lipgloss.Color("203"),
+		Stuck:       lipgloss.Color("215"),
+		Pending:     lipgloss.Color("240"),
+		Waiting:     lipgloss.Color("141"),
+		SignalDone:  lipgloss.Color("84"),
+		SignalStuck: lipgloss.Color("203"),
+		Selected:    lipgloss.Color("255"),
+		Dim:         lipgloss.Color("241"),
+		Label:       lipgloss.Color("244"),
+		Help:        lipgloss.Color("238"),
+		Border:      lipgloss.Color("237"),
+		LogBorder:   lipgloss.Color("235"),
+		Log:         lipgloss.Color("243"),
+	},
+	// Bright colours and light grey chrome, with blue/orange rather than
+	// green/red for success/failure so they differ under red-green
+	// colour blindness.
+	"high-contrast": {
+		Accent:      lipgloss.Color("51"),
+		Running:     lipgloss.Color("226"),
+		Complete:    lipgloss.Color("39"),
+		Failed:      lipgloss.Color("208"),
+		Stuck:       lipgloss.Color("201"),
+		Pending:     lipgloss.Color("250"),
+		Waiting:     lipgloss.Color("51"),
+		SignalDone:  lipgloss.Color("39"),
+		SignalStuck: lipgloss.Color("208"),
+		Selected:    lipgloss.Color("231"),
+		Dim:         lipgloss.Color("250"),
+		Label:       lipgloss.Color("252"),
+		Help:        lipgloss.Color("248"),
+		Border:      lipgloss.Color("250"),
+		LogBorder:   lipgloss.Color("248"),
+		Log:         lipgloss.Color("252"),
+		BoldAlerts:  true,
+	},
+	// The terminal's own foreground; statuses are told apart by their
+	// symbols, with failures and stuck runs in bold.
+	"monochrome": {
+		Accent:      lipgloss.NoColor{},
+		Running:     lipgloss.NoColor{},
+		Complete:    lipgloss.NoColor{},
+		Failed:      lipgloss.NoColor{},
+		Stuck:       lipgloss.NoColor{},
+		Pending:     lipgloss.NoColor{},
+		Waiting:     lipgloss.NoColor{},
+		SignalDone:  lipgloss.NoColor{},
+		SignalStuck: lipgloss.NoColor{},
+		Selected:    lipgloss.NoColor{},
+		Dim:         lipgloss.NoColor{},
+		Label:       lipgloss.NoColor{},
+		Help:        lipgloss.NoColor{},
+		Border:      lipgloss.NoColor{},
+		LogBorder:   lipgloss.NoColor{},
+		Log:         lipgloss.NoColor{},
+		BoldAlerts:  true,
+	},
+}
+
+// ThemeNames returns the built-in theme names, sorted.
+func ThemeNames() []string {
+	names := make([]string, 0, len(Themes))
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTheme switches every TUI style to the named theme; "" keeps the
+// default.
+func SetTheme(name string) error {
+	if name == "" {
+		name = "default"
+	}
+	t, ok := Themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q: use %s", name, strings.Join(ThemeNames(), ", "))
+	}
+	setStyles(t)
+	return nil
+}
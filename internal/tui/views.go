@@ -1,12 +1,14 @@
 package tui
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/charmbracelet/lipgloss"
 	"github.com/mpataki/shop/internal/events"
+	"github.com/mpataki/shop/internal/workspace"
 )
 
 func (a *App) View() string {
@@ -26,7 +28,7 @@ func (a *App) View() string {
 func (a *App) viewRunList() string {
 	var b strings.Builder
 
-	b.WriteString(titleStyle.Render(" shop") + "\n\n")
+	b.WriteString(titleStyle.Render(" shop") + a.renderLoadErr() + "\n\n")
 
 	if a.err != nil {
 		b.WriteString(errorStyle.Render("  error: "+a.err.Error()) + "\n\n")
@@ -52,7 +54,11 @@ func (a *App) viewRunList() string {
 	b.WriteString(a.renderLogPanel())
 
 	// Help
-	b.WriteString(helpStyle.Render("  j/k ↕  l/↵ view  n new  c continue  x kill  d delete  q quit"))
+	if a.selectedIdx < len(a.runs) && a.runs[a.selectedIdx].Status == events.RunStatusWaitingHuman {
+		b.WriteString(helpStyle.Render("  j/k ↕  c continue waiting agent  l/↵ view  n new  x kill  d delete  q quit"))
+	} else {
+		b.WriteString(helpStyle.Render("  j/k ↕  l/↵ view  n new  x kill  d delete  q quit"))
+	}
 
 	return b.String()
 }
@@ -69,23 +75,28 @@ func (a *App) renderRunRow(i int, run *events.RunState) string {
 		run.Status == events.RunStatusFailed ||
 		run.Status == events.RunStatusPending
 
+	// A waiting run shows what it is waiting for instead of its prompt
+	detail := dimStyle.Render(truncate(run.InitialPrompt, 36))
+	if run.Status == events.RunStatusWaitingHuman && run.WaitingReason != "" {
+		detail = statusWaitingStyle.Render(truncate(run.WaitingReason, 36))
+	}
+
 	if selected {
 		return cursorStyle.Render("❯ ") +
 			selectedRowStyle.Render(id) + "  " +
 			selectedRowStyle.Render(workflow) + "  " +
 			status + "  " +
 			selectedRowStyle.Render(age) + "  " +
-			dimStyle.Render(truncate(run.InitialPrompt, 36))
+			detail
 	} else if isInactive {
 		return "  " + dimStyle.Render(id) + "  " +
 			dimStyle.Render(workflow) + "  " +
 			status + "  " +
 			dimStyle.Render(age) + "  " +
-			dimStyle.Render(truncate(run.InitialPrompt, 36))
+			detail
 	}
 
-	return "  " + id + "  " + workflow + "  " + status + "  " + age + "  " +
-		dimStyle.Render(truncate(run.InitialPrompt, 36))
+	return "  " + id + "  " + workflow + "  " + status + "  " + age + "  " + detail
 }
 
 func (a *App) viewRunDetail() string {
@@ -99,14 +110,17 @@ func (a *App) viewRunDetail() string {
 	// Header
 	b.WriteString(titleStyle.Render(fmt.Sprintf(" run #%d", run.ID)) + "  " +
 		dimStyle.Render(run.WorkflowName) + "  " +
-		a.formatStatus(run) + "\n\n")
+		a.formatStatus(run) + a.renderLoadErr() + "\n\n")
 
 	// Info section
 	var infoContent strings.Builder
 	infoContent.WriteString(run.InitialPrompt + "\n\n")
 	infoContent.WriteString(labelStyle.Render("workspace  ") + dimStyle.Render(run.WorkspacePath))
+	if run.WorkspacePath != "" && !workspace.Exists(run.WorkspacePath) {
+		infoContent.WriteString("  " + errorStyle.Render("workspace missing"))
+	}
 
-	if run.Status == events.RunStatusWaitingHuman && run.WaitingReason != "" {
+	if run.Status.IsSuspended() && run.WaitingReason != "" {
 		infoContent.WriteString("\n\n" + statusWaitingStyle.Render("⏸ "+run.WaitingReason))
 	}
 
@@ -114,6 +128,10 @@ func (a *App) viewRunDetail() string {
 		infoContent.WriteString("\n\n" + errorStyle.Render("✗ "+run.Error))
 	}
 
+	if run.Summary != "" {
+		infoContent.WriteString("\n\n" + labelStyle.Render("summary") + "\n" + dimStyle.Render(run.Summary))
+	}
+
 	infoBox := boxStyle.Width(a.contentWidth()).Render(infoContent.String())
 	b.WriteString(infoBox + "\n")
 
@@ -134,14 +152,19 @@ func (a *App) viewRunDetail() string {
 		labelStyle.Render("executions") + "\n" + execContent.String())
 	b.WriteString(execBox + "\n")
 
+	// Signal of the selected execution
+	if a.showSignal && a.selectedExecIdx < len(run.Executions) {
+		b.WriteString(a.renderSignalPanel(run.Executions[a.selectedExecIdx]))
+	}
+
 	// Activity log
 	b.WriteString(a.renderLogPanel())
 
 	// Help
 	if run.Status == events.RunStatusWaitingHuman {
-		b.WriteString(helpStyle.Render("  j/k ↕  c continue  s stop  o output  h/← back  q quit"))
+		b.WriteString(helpStyle.Render("  j/k ↕  c continue  s stop  o output  v signal  h/← back  q quit"))
 	} else {
-		b.WriteString(helpStyle.Render("  j/k ↕  l/↵ resume session  o output  h/← back  q quit"))
+		b.WriteString(helpStyle.Render("  j/k ↕  l/↵ resume session  o output  v signal  h/← back  q quit"))
 	}
 
 	return b.String()
@@ -151,7 +174,11 @@ func (a *App) renderExecRow(i int, exec events.ExecutionState) string {
 	selected := i == a.selectedExecIdx
 
 	num := fmt.Sprintf("%d.", i+1)
-	agent := padRight(exec.AgentName, 12)
+	agentName := exec.AgentName
+	if exec.Attempt > 1 {
+		agentName += fmt.Sprintf(" ×%d", exec.Attempt)
+	}
+	agent := padRight(agentName, 12)
 	status := a.formatExecStatus(exec)
 	duration := a.formatExecDuration(exec)
 	signal := a.formatSignalStatus(exec)
@@ -172,6 +199,48 @@ func (a *App) renderExecRow(i int, exec events.ExecutionState) string {
 	return "  " + num + "  " + agent + "  " + status + "  " + padRight(duration, 8) + "  " + signal + "  " + model
 }
 
+// renderSignalPanel pretty-prints an execution's signal: status first and
+// coloured, then the remaining keys sorted, with internal "_" keys dimmed.
+func (a *App) renderSignalPanel(exec events.ExecutionState) string {
+	var content strings.Builder
+	if len(exec.Signal) == 0 {
+		content.WriteString(dimStyle.Render("(no signal)"))
+	} else {
+		content.WriteString(labelStyle.Render(padRight("status", 12)) + a.formatSignalStatus(exec))
+
+		keys := make([]string, 0, len(exec.Signal))
+		for k := range exec.Signal {
+			if k != "status" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			value := formatSignalValue(exec.Signal[k])
+			if strings.HasPrefix(k, "_") {
+				content.WriteString("\n" + dimStyle.Render(padRight(k, 12)+value))
+			} else {
+				content.WriteString("\n" + labelStyle.Render(padRight(k, 12)) + value)
+			}
+		}
+	}
+
+	return boxStyle.Width(a.contentWidth()).Render(
+		labelStyle.Render("signal · "+exec.AgentName)+"\n"+content.String()) + "\n"
+}
+
+func formatSignalValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.MarshalIndent(v, "            ", "  ")
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
 func (a *App) formatExecStatus(exec events.ExecutionState) string {
 	switch exec.Status {
 	case events.ExecStatusCompleted:
@@ -237,6 +306,8 @@ func (a *App) formatStatus(run *events.RunState) string {
 		return statusStuckStyle.Render("⚠ stuck")
 	case events.RunStatusWaitingHuman:
 		return statusWaitingStyle.Render("⏸ waiting")
+	case events.RunStatusPaused:
+		return statusWaitingStyle.Render("⏸ paused")
 	case events.RunStatusKilled:
 		return statusFailedStyle.Render("✗ killed")
 	case events.RunStatusPending:
@@ -260,6 +331,14 @@ func (a *App) formatAge(t time.Time) string {
 	}
 }
 
+// renderLoadErr marks a view whose data couldn't be refreshed.
+func (a *App) renderLoadErr() string {
+	if a.loadErr == nil {
+		return ""
+	}
+	return "  " + statusWaitingStyle.Render("⚠ can't refresh: "+truncate(a.loadErr.Error(), 40)+"; retrying")
+}
+
 func (a *App) renderLogPanel() string {
 	if len(a.logs) == 0 {
 		return "\n"
@@ -273,10 +352,7 @@ func (a *App) renderLogPanel() string {
 		content.WriteString(logEntryStyle.Render("  " + line))
 	}
 
-	logBox := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("235")).
-		Padding(0, 1).
+	logBox := logBoxStyle.
 		Width(a.contentWidth()).
 		Render(labelStyle.Render("activity") + "\n" + content.String())
 
@@ -302,6 +378,9 @@ func (a *App) viewNewRun() string {
 			if wf.Source == "user" {
 				name += dimStyle.Render(" ~")
 			}
+			if room := a.contentWidth() - len(wf.Name) - 10; wf.Description != "" && room > 10 {
+				name += "  " + dimStyle.Render(truncate(wf.Description, room))
+			}
 			if i > 0 {
 				wfContent.WriteString("\n")
 			}
@@ -0,0 +1,133 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+// DefaultMaxContextChars is the get_context size past which older entries
+// are condensed, when a script doesn't set max_context_chars.
+const DefaultMaxContextChars = 40_000
+
+// DefaultContextKeep is how many of the latest entries a condensed
+// get_context keeps verbatim, when a script doesn't set context_keep.
+const DefaultContextKeep = 3
+
+// briefSummaryChars caps each condensed entry's summary.
+const briefSummaryChars = 200
+
+// contextEntry is one execution's part of the context: its full section
+// and the one-line brief it is condensed to.
+type contextEntry struct {
+	full, brief string
+}
+
+// AgentContext renders what get_context returns for state: the run's
+// context header, then one section per execution that has reported a
+// status, in the order they ran. Executions include returns false for are
+// left out.
+func AgentContext(state *events.RunState, include func(events.ExecutionState) bool) string {
+	var sb strings.Builder
+	sb.WriteString(contextHeader(state))
+	for _, e := range contextEntries(state, include) {
+		sb.WriteString(e.full)
+	}
+	return sb.String()
+}
+
+// BoundedContext is AgentContext kept to about maxChars: once the full
+// context is longer, all but the last keep entries are condensed to one
+// line each under a "Previously" heading. The full context stays available
+// (get_context with full: true, shop context).
+func BoundedContext(state *events.RunState, include func(events.ExecutionState) bool, maxChars, keep int) string {
+	header := contextHeader(state)
+	entries := contextEntries(state, include)
+
+	size := len(header)
+	for _, e := range entries {
+		size += len(e.full)
+	}
+	if size <= maxChars || len(entries) <= keep {
+		var sb strings.Builder
+		sb.WriteString(header)
+		for _, e := range entries {
+			sb.WriteString(e.full)
+		}
+		return sb.String()
+	}
+
+	older, recent := entries[:len(entries)-keep], entries[len(entries)-keep:]
+	var sb strings.Builder
+	sb.WriteString(header)
+	sb.WriteString("## Previously\n\n")
+	for _, e := range older {
+		sb.WriteString("- " + e.brief + "\n")
+	}
+	fmt.Fprintf(&sb, "\n(%d earlier entries condensed; call get_context with full: true to read them in full)\n\n---\n\n", len(older))
+	for _, e := range recent {
+		sb.WriteString(e.full)
+	}
+	return sb.String()
+}
+
+// contextHeader returns the context brief and the rule under it.
+func contextHeader(state *events.RunState) string {
+	header := state.ContextHeader
+	if header == "" {
+		header, _ = RenderContext("", ContextData{
+			RunID: state.ID, Workflow: state.WorkflowName, Prompt: state.InitialPrompt,
+		})
+	}
+	return strings.TrimRight(header, "\n") + "\n\n---\n\n"
+}
+
+func contextEntries(state *events.RunState, include func(events.ExecutionState) bool) []contextEntry {
+	var entries []contextEntry
+	for _, exec := range state.Executions {
+		if !include(exec) || exec.Signal == nil {
+			continue
+		}
+		agentStatus, _ := exec.Signal["status"].(string)
+		if agentStatus == "" {
+			continue
+		}
+
+		var full strings.Builder
+		brief := fmt.Sprintf("**%s** (%s)", exec.AgentName, agentStatus)
+		if summary, ok := exec.Signal["summary"].(string); ok && summary != "" {
+			fmt.Fprintf(&full, "## %s\n\n**Status:** %s\n\n%s\n\n", exec.AgentName, agentStatus, summary)
+			brief += ": " + truncateRunes(strings.Join(strings.Fields(summary), " "), briefSummaryChars)
+		} else {
+			signalJSON, _ := json.MarshalIndent(exec.Signal, "", "  ")
+			fmt.Fprintf(&full, "## %s\n\n**Status:** %s\n\n```json\n%s\n```\n\n", exec.AgentName, agentStatus, string(signalJSON))
+		}
+		if len(exec.Artifacts) > 0 {
+			fmt.Fprintf(&full, "**Artifacts:** %s\n\n", strings.Join(exec.Artifacts, ", "))
+			brief += " [artifacts: " + strings.Join(exec.Artifacts, ", ") + "]"
+		}
+		full.WriteString("---\n\n")
+		entries = append(entries, contextEntry{full: full.String(), brief: brief})
+	}
+	return entries
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with "…".
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// ContextBefore reconstructs the context the agent at callIndex was given:
+// that of the executions with a lower call index. Like get_context, it
+// keeps executions that a replay invalidated.
+func ContextBefore(state *events.RunState, callIndex int) string {
+	return AgentContext(state, func(exec events.ExecutionState) bool {
+		return exec.CallIndex < callIndex
+	})
+}
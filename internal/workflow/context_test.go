@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mpataki/shop/internal/events"
+)
+
+func TestContextBefore(t *testing.T) {
+	state := &events.RunState{
+		ID:            1,
+		ContextHeader: "# Run Context\n\n**Task:** add a flag\n",
+		Executions: []events.ExecutionState{
+			{AgentName: "architect", CallIndex: 1, Signal: map[string]any{"status": "DONE", "summary": "planned it"}},
+			{AgentName: "coder", CallIndex: 2, Signal: map[string]any{"status": "DONE"}, Artifacts: []string{"flag.go"}},
+			{AgentName: "reviewer", CallIndex: 3, Signal: map[string]any{"status": "APPROVED", "summary": "looks good"}},
+			{AgentName: "merger", CallIndex: 4},
+		},
+	}
+
+	got := ContextBefore(state, 3)
+	want := "# Run Context\n\n**Task:** add a flag\n\n---\n\n" +
+		"## architect\n\n**Status:** DONE\n\nplanned it\n\n---\n\n" +
+		"## coder\n\n**Status:** DONE\n\n```json\n{\n  \"status\": \"DONE\"\n}\n```\n\n**Artifacts:** flag.go\n\n---\n\n"
+	if got != want {
+		t.Fatalf("unexpected context before call 3:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := ContextBefore(state, 1); got != "# Run Context\n\n**Task:** add a flag\n\n---\n\n" {
+		t.Fatalf("expected only the header before the first call, got %q", got)
+	}
+
+	// The merger has no signal yet, so it adds nothing to the full context
+	all := ContextBefore(state, 5)
+	if !strings.HasPrefix(all, want) || !strings.HasSuffix(all, "## reviewer\n\n**Status:** APPROVED\n\nlooks good\n\n---\n\n") {
+		t.Fatalf("unexpected full context:\n%s", all)
+	}
+}
+
+func TestBoundedContext(t *testing.T) {
+	state := &events.RunState{ID: 1, ContextHeader: "# Run Context\n"}
+	for i, agent := range []string{"architect", "coder", "tester", "reviewer"} {
+		state.Executions = append(state.Executions, events.ExecutionState{
+			AgentName: agent, CallIndex: i + 1,
+			Signal:    map[string]any{"status": "DONE", "summary": agent + " did\n  its part." + strings.Repeat(" More detail.", 30)},
+			Artifacts: []string{agent + ".md"},
+		})
+	}
+	all := func(events.ExecutionState) bool { return true }
+	full := AgentContext(state, all)
+
+	// At the threshold the context is left alone
+	if got := BoundedContext(state, all, len(full), 2); got != full {
+		t.Fatalf("expected the full context at the threshold, got:\n%s", got)
+	}
+
+	got := BoundedContext(state, all, len(full)-1, 2)
+	brief := func(agent string) string {
+		summary := agent + " did its part." + strings.Repeat(" More detail.", 30)
+		return "- **" + agent + "** (DONE): " + string([]rune(summary)[:199]) + "… [artifacts: " + agent + ".md]\n"
+	}
+	want := "# Run Context\n\n---\n\n## Previously\n\n" + brief("architect") + brief("coder") +
+		"\n(2 earlier entries condensed; call get_context with full: true to read them in full)\n\n---\n\n"
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("expected the older entries condensed, got:\n%s", got)
+	}
+	if !strings.HasSuffix(full, got[len(want):]) || !strings.Contains(got, "## tester\n") || !strings.Contains(got, "## reviewer\n") {
+		t.Fatalf("expected the last 2 entries verbatim, got:\n%s", got)
+	}
+	if len(got) >= len(full) {
+		t.Fatalf("expected the condensed context to be shorter (%d >= %d)", len(got), len(full))
+	}
+
+	// Nothing to condense when there are no more entries than are kept
+	if got := BoundedContext(state, all, 10, 4); got != full {
+		t.Fatalf("expected the full context when every entry is kept, got:\n%s", got)
+	}
+}
@@ -0,0 +1,136 @@
+package workflow
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/mpataki/shop/internal/events"
+)
+
+// Validate reports why a script can't run: it doesn't parse, its top level
+// fails, its settings don't decode, or it defines no workflow function.
+func Validate(script string) error {
+	r, err := loadTopLevel(script)
+	if err != nil {
+		return err
+	}
+	if _, ok := goja.AssertFunction(r.vm.Get("workflow")); !ok {
+		return fmt.Errorf("script must define a 'workflow' function")
+	}
+	return nil
+}
+
+var (
+	statusesList = regexp.MustCompile(`\bstatuses\s*:\s*\[([^\]]*)\]`)
+	outcomeList  = regexp.MustCompile(`\b((?:success|failure)_statuses)\s*:\s*\[([^\]]*)\]`)
+	stringLit    = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+
+	// r.status === "X", "X" !== r.status, case "X":
+	statusCompare = regexp.MustCompile(`\.status\s*[!=]==?\s*(?:"([^"]*)"|'([^']*)')` +
+		`|(?:"([^"]*)"|'([^']*)')\s*[!=]==?\s*[\w.\]\[]*\.status\b` +
+		`|case\s+(?:"([^"]*)"|'([^']*)')\s*:`)
+)
+
+// Lint returns warnings for a script that validates but is probably wrong:
+// statuses it checks for that no agent can report, statuses it declares but
+// never checks, duplicated statuses, unknown settings, and limits set
+// suspiciously low or high. The checks read string literals, so statuses
+// built at runtime are not seen. Lint assumes Validate passed.
+func Lint(script string) []string {
+	var warnings []string
+
+	declared := map[string]bool{}
+	for _, s := range events.ReservedStatuses {
+		declared[s] = true
+	}
+	var custom []string
+	for _, m := range statusesList.FindAllStringSubmatch(script, -1) {
+		seen := map[string]bool{}
+		for _, lit := range stringLit.FindAllStringSubmatch(m[1], -1) {
+			status := lit[1] + lit[2]
+			if seen[status] {
+				warnings = append(warnings, fmt.Sprintf("status %q is listed twice in the same statuses list", status))
+			}
+			seen[status] = true
+			if !declared[status] {
+				custom = append(custom, status)
+			}
+			declared[status] = true
+		}
+	}
+
+	checked := map[string]bool{}
+	for _, m := range statusCompare.FindAllStringSubmatch(script, -1) {
+		status := strings.Join(m[1:], "")
+		if checked[status] {
+			continue
+		}
+		checked[status] = true
+		if !declared[status] {
+			warnings = append(warnings, fmt.Sprintf("the script checks for status %q, but no run() declares it in statuses, so agents can't report it", status))
+		}
+	}
+	for _, m := range outcomeList.FindAllStringSubmatch(script, -1) {
+		for _, lit := range stringLit.FindAllStringSubmatch(m[2], -1) {
+			status := lit[1] + lit[2]
+			if !declared[status] {
+				warnings = append(warnings, fmt.Sprintf("%s lists status %q, but no run() declares it in statuses, so agents can't report it", m[1], status))
+			}
+			checked[status] = true
+		}
+	}
+	for _, status := range custom {
+		if !checked[status] {
+			warnings = append(warnings, fmt.Sprintf("status %q is declared in statuses but the script never checks for it", status))
+		}
+	}
+
+	r, err := loadTopLevel(script)
+	if err != nil {
+		return warnings
+	}
+	warnings = append(warnings, unknownSettings(r)...)
+
+	s := r.settings
+	switch {
+	case s.MaxConsecutivePauses == 1:
+		warnings = append(warnings, "max_consecutive_pauses is 1: a single pause() will mark the run stuck")
+	case s.MaxConsecutivePauses > 50:
+		warnings = append(warnings, fmt.Sprintf("max_consecutive_pauses is %d: a pause loop will run that long before it is caught", s.MaxConsecutivePauses))
+	}
+	if s.MaxPromptChars > 0 && s.MaxPromptChars < 1000 {
+		warnings = append(warnings, fmt.Sprintf("max_prompt_chars is %d: most agent prompts, with the context instructions, are longer", s.MaxPromptChars))
+	}
+	return warnings
+}
+
+// unknownSettings names settings keys that Settings doesn't define,
+// usually typos that are otherwise silently ignored.
+func unknownSettings(r *Runtime) []string {
+	v := r.vm.Get("settings")
+	if v == nil {
+		return nil
+	}
+	obj, ok := v.Export().(map[string]any)
+	if !ok {
+		return nil
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+
+	var warnings []string
+	for key := range obj {
+		if !known[key] {
+			warnings = append(warnings, fmt.Sprintf("settings.%s is not a known setting and is ignored", key))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
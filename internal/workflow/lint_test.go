@@ -0,0 +1,78 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name, script, err string
+	}{
+		{"ok", `function workflow(prompt) { run("coder", prompt); }`, ""},
+		{"syntax error", `function workflow( {`, "failed to load script"},
+		{"no workflow", `const settings = {};`, "must define a 'workflow' function"},
+		{"api at top level", `run("coder", "x"); function workflow() {}`, "workflow API at the top level"},
+		{"bad settings", `const settings = { max_prompt_chars: "lots" }; function workflow() {}`, "invalid settings"},
+	} {
+		err := Validate(tc.script)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		name, script, want string
+	}{
+		{"undeclared status", `function workflow() {
+			const r = run("reviewer", { statuses: ["APPROVED"] });
+			if (r.status === "APPROVED") return;
+			if (r.status == 'REJECTED') stuck("no");
+		}`, `checks for status "REJECTED"`},
+		{"undeclared status in switch", `function workflow() {
+			switch (run("reviewer").status) { case "APPROVED": return; }
+		}`, `checks for status "APPROVED"`},
+		{"declared but unchecked", `function workflow() {
+			const r = run("reviewer", { statuses: ["APPROVED", "CHANGES_REQUESTED"] });
+			if ("APPROVED" === r.status) return;
+		}`, `"CHANGES_REQUESTED" is declared in statuses but the script never checks for it`},
+		{"duplicate status", `function workflow() {
+			const r = run("reviewer", { statuses: ["APPROVED", "APPROVED"] });
+			if (r.status === "APPROVED") return;
+		}`, `"APPROVED" is listed twice`},
+		{"unknown setting", `const settings = { max_prompt_char: 1000 };
+			function workflow() {}`, "settings.max_prompt_char is not a known setting"},
+		{"pause limit low", `const settings = { max_consecutive_pauses: 1 };
+			function workflow() {}`, "max_consecutive_pauses is 1"},
+		{"pause limit high", `const settings = { max_consecutive_pauses: 500 };
+			function workflow() {}`, "max_consecutive_pauses is 500"},
+		{"undeclared outcome status", `const settings = { failure_statuses: ["REJECTED"] };
+			function workflow() {
+				const r = run("reviewer", { statuses: ["APPROVED"] });
+				if (r.status === "APPROVED") return;
+			}`, `failure_statuses lists status "REJECTED"`},
+		{"prompt limit low", `const settings = { max_prompt_chars: 200 };
+			function workflow() {}`, "max_prompt_chars is 200"},
+	} {
+		warnings := Lint(tc.script)
+		if len(warnings) != 1 || !strings.Contains(warnings[0], tc.want) {
+			t.Errorf("%s: expected one warning containing %q, got %q", tc.name, tc.want, warnings)
+		}
+	}
+
+	clean := `const settings = { finally: "reporter", max_consecutive_pauses: 5, success_statuses: ["DONE", "APPROVED"], failure_statuses: ["REJECTED"] };
+		function workflow(prompt) {
+			const r = run("reviewer", { prompt, statuses: ["APPROVED", "CHANGES_REQUESTED", "REJECTED"] });
+			if (r.status === "STUCK" || r.status === "DONE") return;
+			if (r.status === "CHANGES_REQUESTED") run("coder", r.summary);
+			if (r.status !== "APPROVED") stuck("unexpected");
+		}`
+	if warnings := Lint(clean); len(warnings) != 0 {
+		t.Errorf("expected no warnings for a sound script, got %q", warnings)
+	}
+}
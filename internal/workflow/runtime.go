@@ -2,19 +2,32 @@ package workflow
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/dop251/goja"
 
 	"github.com/mpataki/shop/internal/events"
 	"github.com/mpataki/shop/internal/process"
+	"github.com/mpataki/shop/internal/workspace"
 )
 
 // ErrWaitingHuman is returned when the workflow is suspended waiting for human input.
 var ErrWaitingHuman = fmt.Errorf("waiting for human input")
 
+// ErrPaused is returned when the workflow is suspended at an --until breakpoint.
+var ErrPaused = fmt.Errorf("paused at breakpoint")
+
 // RuntimeDeps holds the dependencies injected into the workflow runtime.
 type RuntimeDeps struct {
 	Store          *events.Store
@@ -23,10 +36,268 @@ type RuntimeDeps struct {
 	WorkspacePath  string
 	RepoPath       string
 
+	// Until names an agent to break before: the run pauses instead of
+	// starting it fresh (replayed calls don't count).
+	Until string
+
+	// Strict fails the run when the script asks for a different agent than
+	// history ran at a call index, instead of warning and re-running it.
+	Strict bool
+
+	// LibDir is where use() loads shared libraries from, normally lib/
+	// next to the workflow script. Empty disables use().
+	LibDir string
+
+	// InstructionDirs are searched, in order, for <agent>.md role
+	// instructions that are put ahead of the agent's prompt.
+	InstructionDirs []string
+
 	// Callbacks
 	EmitEvents     func(evts []events.Event) ([]events.Event, error)
 	DrainCommands  func() error
-	WriteMCPConfig func(callIndex int, statuses []string) error
+	WriteMCPConfig func(agent string, callIndex int, statuses []string) error
+
+	// StartWorkflow creates the child run of the named workflow for the
+	// run_workflow() call at callIndex and returns its ID; AwaitRun runs it
+	// until it settles. A nil StartWorkflow disables run_workflow().
+	StartWorkflow func(name, prompt string, callIndex int) (int64, error)
+	AwaitRun      func(runID int64) (*events.RunState, error)
+
+	// Progress, when set, is told of each run() call answered from
+	// history and of the first one after them that runs live.
+	Progress func(ReplayProgress)
+}
+
+// ReplayProgress is how far a resumed script has got: call CallIndex, to
+// Agent, was the Replayed-th answered from history or is the first to run
+// live. Cached is how many workflow calls history has answers for; pause()
+// checkpoints and the finally agent aren't counted or reported.
+type ReplayProgress struct {
+	CallIndex int
+	Agent     string
+	Replayed  int
+	Cached    int
+	Live      bool
+}
+
+// Settings holds optional workflow configuration, read from a top-level
+// `settings` object in the script.
+type Settings struct {
+	// Finally names an agent that runs once after the workflow ends
+	// complete, stuck, or failed.
+	Finally string `json:"finally"`
+
+	// ContextTemplate is a text/template for the brief at the top of
+	// get_context, e.g. coding standards or links. See ContextData.
+	ContextTemplate string `json:"context_template"`
+
+	// MaxContextChars is the get_context size past which all but the
+	// latest ContextKeep entries are condensed to one line each. Default
+	// DefaultMaxContextChars and DefaultContextKeep.
+	MaxContextChars int `json:"max_context_chars"`
+	ContextKeep     int `json:"context_keep"`
+
+	// MaxConsecutivePauses is how many pause() calls may happen in a row
+	// without a run() between them before the run is marked stuck.
+	// Defaults to DefaultMaxConsecutivePauses.
+	MaxConsecutivePauses int `json:"max_consecutive_pauses"`
+
+	// MaxPromptChars caps the size of the prompt sent to an agent; a
+	// longer prompt fails the run before Claude is started. Defaults to
+	// DefaultMaxPromptChars.
+	MaxPromptChars int `json:"max_prompt_chars"`
+
+	// SkipPermissions runs Claude with --dangerously-skip-permissions.
+	// Defaults to true (nil); set it to false to keep permission prompts.
+	SkipPermissions *bool `json:"skip_permissions"`
+
+	// SuccessStatuses and FailureStatuses classify a run that returns from
+	// workflow() by the status of its last agent signal: a status in
+	// FailureStatuses, or one missing from a non-empty SuccessStatuses,
+	// fails the run instead of completing it.
+	SuccessStatuses []string `json:"success_statuses"`
+	FailureStatuses []string `json:"failure_statuses"`
+
+	// CheckpointAgent is the Claude agent (.claude/agents definition) that
+	// handles pause() checkpoints; empty runs Claude without one.
+	CheckpointAgent string `json:"checkpoint_agent"`
+
+	// CheckpointPrompt is a text/template for what a checkpoint is asked,
+	// rendered with CheckpointData. The CONTINUE/STOP instructions are
+	// always appended. Defaults to DefaultCheckpointPrompt.
+	CheckpointPrompt string `json:"checkpoint_prompt"`
+
+	// StrictReplay makes every resume of the workflow's runs strict (see
+	// RuntimeDeps.Strict).
+	StrictReplay bool `json:"strict_replay"`
+
+	// ReuseWorkspace runs in one worktree per workflow and repo, reset to
+	// the repo's HEAD for each run, instead of a new worktree per run. Runs
+	// needing it while another is unfinished fail to start.
+	ReuseWorkspace bool `json:"reuse_workspace"`
+
+	// SkillFile is a file of team conventions (commit style, testing
+	// expectations, ...) that every agent prompt carries after shop's own
+	// instructions; a relative path is from the script's directory. With
+	// SkillMode "replace" it takes the place of those instructions instead
+	// of extending them ("extend", the default); the report_signal
+	// requirement is kept either way.
+	SkillFile string `json:"skill_file"`
+	SkillMode string `json:"skill_mode"`
+
+	// CleanupCommand is a shell command run in the repo once the run ends
+	// (complete, failed or stuck), after the finally agent, to tear down
+	// what the run set up. It gets SHOP_RUN_ID and SHOP_RUN_STATUS; its
+	// output goes to the run log and a failure is only a warning.
+	CleanupCommand string `json:"cleanup_command"`
+
+	// CleanupOnSuccess removes the run's worktree once it completes,
+	// keeping its branch and the rest of the workspace. Failed and stuck
+	// runs keep theirs for inspection.
+	CleanupOnSuccess bool `json:"cleanup_on_success"`
+}
+
+// SkipsPermissions reports whether agents run with
+// --dangerously-skip-permissions.
+func (s Settings) SkipsPermissions() bool {
+	return s.SkipPermissions == nil || *s.SkipPermissions
+}
+
+// ContextLimits returns max_context_chars and context_keep, with defaults
+// filled in.
+func (s Settings) ContextLimits() (maxChars, keep int) {
+	maxChars, keep = s.MaxContextChars, s.ContextKeep
+	if maxChars <= 0 {
+		maxChars = DefaultMaxContextChars
+	}
+	if keep <= 0 {
+		keep = DefaultContextKeep
+	}
+	return maxChars, keep
+}
+
+// CheckFinalStatus returns why a run whose last agent reported status
+// failed, or nil if it succeeded. A run with no agent signal is left alone.
+func (s Settings) CheckFinalStatus(status string) error {
+	if status == "" {
+		return nil
+	}
+	if slices.Contains(s.FailureStatuses, status) {
+		return fmt.Errorf("final signal %s is one of failure_statuses", status)
+	}
+	if len(s.SuccessStatuses) > 0 && !slices.Contains(s.SuccessStatuses, status) {
+		return fmt.Errorf("final signal %s is not one of success_statuses", status)
+	}
+	return nil
+}
+
+// Outcome classifies a final signal status as "success" or "failure" by
+// success_statuses and failure_statuses. It is "" when the script lists
+// neither, or there is no status to classify.
+func (s Settings) Outcome(status string) string {
+	if status == "" || (len(s.SuccessStatuses) == 0 && len(s.FailureStatuses) == 0) {
+		return ""
+	}
+	if s.CheckFinalStatus(status) != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// RunOutcome is the Outcome of state's final signal under the settings of
+// the script the run started with.
+func RunOutcome(state *events.RunState) string {
+	settings, err := LoadSettings(state.WorkflowSource)
+	if err != nil {
+		return ""
+	}
+	return settings.Outcome(state.FinalStatus())
+}
+
+// DefaultMaxConsecutivePauses is the pause loop threshold when a script
+// doesn't set max_consecutive_pauses.
+const DefaultMaxConsecutivePauses = 5
+
+// DefaultMaxPromptChars is the prompt size limit when a script doesn't set
+// max_prompt_chars: about 100k tokens at ~4 characters per token.
+const DefaultMaxPromptChars = 400_000
+
+// DefaultContextTemplate is the get_context header used when a script
+// sets no context_template.
+const DefaultContextTemplate = "# Run Context\n\n**Workflow:** {{.Workflow}}\n**Task:** {{.Prompt}}\n"
+
+// ContextData is what a context_template is rendered with.
+type ContextData struct {
+	RunID    int64
+	Workflow string
+	Prompt   string
+}
+
+// RenderContext renders tmpl, or DefaultContextTemplate when it's empty.
+func RenderContext(tmpl string, data ContextData) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultContextTemplate
+	}
+	t, err := template.New("context").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// DefaultCheckpointPrompt is the checkpoint prompt used when a script sets
+// no checkpoint_prompt.
+const DefaultCheckpointPrompt = `The workflow has paused for human input.
+
+**Checkpoint:** {{.Message}}
+
+**What to do:**
+1. Review the workspace state
+2. Check recent changes and test results
+3. Decide whether to continue or stop
+`
+
+// checkpointInstructions follow every checkpoint prompt: the statuses
+// pause() understands.
+const checkpointInstructions = `When ready, call the report_signal tool with your decision:
+- To continue: report_signal(status="CONTINUE", summary="your note")
+- To stop: report_signal(status="STOP", summary="reason for stopping")
+
+Wait for the human to provide guidance before reporting your decision.`
+
+// CheckpointData is what a checkpoint_prompt is rendered with: the run's
+// context data and the message passed to pause().
+type CheckpointData struct {
+	ContextData
+	Message string
+}
+
+// RenderCheckpointPrompt renders tmpl, or DefaultCheckpointPrompt when
+// it's empty, followed by the CONTINUE/STOP instructions.
+func RenderCheckpointPrompt(tmpl string, data CheckpointData) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultCheckpointPrompt
+	}
+	t, err := template.New("checkpoint").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(sb.String(), "\n") + "\n\n" + checkpointInstructions, nil
+}
+
+// Outcome describes how the main workflow flow ended. It is passed to
+// on_finish() hooks and the finally agent.
+type Outcome struct {
+	Status string // complete, stuck, or failed
+	Reason string
 }
 
 // Runtime executes JavaScript workflow scripts in a sandboxed environment.
@@ -35,6 +306,26 @@ type Runtime struct {
 	vm        *goja.Runtime
 	callIndex int
 	logs      []string
+	settings  Settings
+
+	// on_finish() hooks
+	finishHooks []goja.Callable
+
+	// use() libraries loaded so far, by name
+	libs map[string]goja.Value
+
+	// pause() calls since the last run(), for pause loop detection
+	consecutivePauses int
+
+	// status of the last signal run() returned, for CheckFinalStatus
+	lastStatus string
+
+	// on_error routing: failedCall is the last call whose execution failed,
+	// and why; replayFailures makes callAgent replay such a failure instead
+	// of re-running the agent, so the route is taken again on replay
+	failedCall     int
+	failedReason   string
+	replayFailures bool
 
 	// stuck state
 	stuckReason string
@@ -46,6 +337,24 @@ type Runtime struct {
 	waitingSessionID string
 	waitingAgent     string
 	waitingCallIndex int
+
+	// breakpoint state
+	paused          bool
+	pausedAgent     string
+	pausedCallIndex int
+	pausedReason    string // set when paused by request rather than --until
+
+	// running on_finish hooks and the finally agent
+	finishing bool
+
+	// latest is the run's state as of the last latestState call, kept
+	// current from the events recorded since rather than re-projected
+	latest *events.RunState
+
+	// replayed counts the calls answered from history; wentLive is set
+	// once a call after them has run fresh
+	replayed int
+	wentLive bool
 }
 
 // NewRuntime creates a new JavaScript runtime for executing a workflow.
@@ -77,47 +386,232 @@ func (r *Runtime) GetWaitingInfo() *WaitingInfo {
 	}
 }
 
-// Execute runs the JavaScript workflow script.
+// GetPauseInfo returns the breakpoint the workflow paused at, if any.
+func (r *Runtime) GetPauseInfo() *WaitingInfo {
+	if !r.paused {
+		return nil
+	}
+	reason := r.pausedReason
+	if reason == "" {
+		reason = fmt.Sprintf("breakpoint before %s", r.pausedAgent)
+	}
+	return &WaitingInfo{
+		Reason:    reason,
+		Agent:     r.pausedAgent,
+		CallIndex: r.pausedCallIndex,
+	}
+}
+
+// Execute runs the JavaScript workflow script at scriptPath.
 func (r *Runtime) Execute(scriptPath, prompt string) error {
 	script, err := os.ReadFile(scriptPath)
 	if err != nil {
 		return fmt.Errorf("failed to read script: %w", err)
 	}
+	return r.ExecuteSource(string(script), prompt)
+}
 
+// ExecuteSource runs a JavaScript workflow script from its source.
+func (r *Runtime) ExecuteSource(script, prompt string) error {
 	r.vm = goja.New()
 	r.sandbox()
 	r.registerAPI()
 
-	if _, err := r.vm.RunString(string(script)); err != nil {
+	if _, err := r.vm.RunString(script); err != nil {
 		return fmt.Errorf("failed to load script: %w", err)
 	}
 
+	if err := r.readSettings(); err != nil {
+		return err
+	}
+	if err := r.initContext(); err != nil {
+		return err
+	}
+
 	workflowFn, ok := goja.AssertFunction(r.vm.Get("workflow"))
 	if !ok {
 		return fmt.Errorf("script must define a 'workflow' function")
 	}
 
-	_, err = workflowFn(goja.Undefined(), r.vm.ToValue(prompt))
+	_, err := workflowFn(goja.Undefined(), r.vm.ToValue(prompt))
+	if r.paused {
+		return ErrPaused
+	}
+	if r.waitingHuman {
+		return ErrWaitingHuman
+	}
+
+	switch {
+	case r.isStuck:
+		r.finish(Outcome{Status: "stuck", Reason: r.stuckReason})
+		return nil
+	case err != nil:
+		err = fmt.Errorf("workflow execution failed: %w", err)
+		r.finish(Outcome{Status: "failed", Reason: err.Error()})
+		return err
+	}
+
+	if err := r.settings.CheckFinalStatus(r.lastStatus); err != nil {
+		r.finish(Outcome{Status: "failed", Reason: err.Error()})
+		return err
+	}
+	r.finish(Outcome{Status: "complete"})
+	return nil
+}
+
+// Settings returns the settings read from the script.
+func (r *Runtime) Settings() Settings { return r.settings }
+
+// LoadSettings evaluates a script's top level, without calling workflow(),
+// and returns its settings. Scripts that call the workflow API at the top
+// level can't be inspected this way and return an error.
+func LoadSettings(script string) (Settings, error) {
+	r, err := loadTopLevel(script)
 	if err != nil {
-		if r.isStuck {
-			return nil
-		}
-		if r.waitingHuman {
-			return ErrWaitingHuman
+		return Settings{}, err
+	}
+	return r.settings, nil
+}
+
+// loadTopLevel evaluates a script's top level in a runtime with no run to
+// act on and reads its settings.
+func loadTopLevel(script string) (r *Runtime, err error) {
+	r = NewRuntime(RuntimeDeps{})
+	r.vm = goja.New()
+	r.sandbox()
+	r.registerAPI()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("script uses the workflow API at the top level: %v", p)
 		}
-		return fmt.Errorf("workflow execution failed: %w", err)
+	}()
+	if _, err := r.vm.RunString(script); err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
 	}
+	if err := r.readSettings(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
 
-	if r.isStuck {
+func (r *Runtime) readSettings() error {
+	v := r.vm.Get("settings")
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
 		return nil
 	}
-	if r.waitingHuman {
-		return ErrWaitingHuman
+	data, err := json.Marshal(v.Export())
+	if err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
 	}
+	if err := json.Unmarshal(data, &r.settings); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	if mode := r.settings.SkillMode; mode != "" && mode != "extend" && mode != "replace" {
+		return fmt.Errorf("invalid settings: skill_mode must be extend or replace, got %q", mode)
+	}
+	return nil
+}
 
+// initContext renders the context brief once per run, on its first execution.
+func (r *Runtime) initContext() error {
+	if r.deps.State.ContextHeader != "" {
+		return nil
+	}
+	content, err := RenderContext(r.settings.ContextTemplate, ContextData{
+		RunID:    r.deps.State.ID,
+		Workflow: r.deps.State.WorkflowName,
+		Prompt:   r.deps.State.InitialPrompt,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid context_template: %w", err)
+	}
+	evt, _ := events.NewEvent(r.deps.State.ID, events.EventContextInitialized, events.ContextInitializedPayload{
+		Content: content,
+	})
+	if _, err := r.deps.EmitEvents([]events.Event{evt}); err != nil {
+		return err
+	}
+	r.deps.State.ContextHeader = content
 	return nil
 }
 
+// finish runs on_finish() hooks and the finally agent. Their failures are
+// logged but never change the workflow's outcome.
+func (r *Runtime) finish(outcome Outcome) {
+	isStuck, stuckReason := r.isStuck, r.stuckReason
+	r.finishing = true
+	defer func() {
+		r.isStuck, r.stuckReason = isStuck, stuckReason
+		r.waitingHuman = false
+	}()
+
+	for _, hook := range r.finishHooks {
+		arg := r.vm.ToValue(map[string]any{"status": outcome.Status, "reason": outcome.Reason})
+		if _, err := hook(goja.Undefined(), arg); err != nil {
+			r.warn(fmt.Sprintf("on_finish hook failed: %v", err))
+		}
+	}
+
+	if r.settings.Finally != "" {
+		prompt := fmt.Sprintf("The workflow finished with status %q.", outcome.Status)
+		if outcome.Reason != "" {
+			prompt += "\nReason: " + outcome.Reason
+		}
+		prompt += "\n\nOriginal task: " + r.deps.State.InitialPrompt
+		if _, err := r.callAgent(r.settings.Finally, prompt, "", "", nil); err != nil {
+			r.warn(fmt.Sprintf("finally agent %s failed: %v", r.settings.Finally, err))
+		}
+	}
+
+	if r.settings.CleanupCommand != "" {
+		r.cleanup(outcome)
+	}
+}
+
+// cleanupTimeout bounds how long a cleanup_command may run.
+const cleanupTimeout = 5 * time.Minute
+
+// cleanupOutputChars is how much of a cleanup_command's output is logged,
+// from the end.
+const cleanupOutputChars = 4000
+
+// cleanup runs the cleanup_command and logs its output.
+func (r *Runtime) cleanup(outcome Outcome) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", r.settings.CleanupCommand)
+	cmd.Dir = r.deps.RepoPath
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("SHOP_RUN_ID=%d", r.deps.State.ID),
+		"SHOP_RUN_STATUS="+outcome.Status,
+	)
+	out, err := cmd.CombinedOutput()
+
+	output := strings.TrimSpace(string(out))
+	if len(output) > cleanupOutputChars {
+		cut := len(output) - cleanupOutputChars
+		for cut < len(output) && !utf8.RuneStart(output[cut]) {
+			cut++
+		}
+		output = "…" + output[cut:]
+	}
+	if err != nil {
+		msg := fmt.Sprintf("cleanup_command failed: %v", err)
+		if output != "" {
+			msg += "\n" + output
+		}
+		r.warn(msg)
+		return
+	}
+	msg := "cleanup_command finished"
+	if output != "" {
+		msg += "\n" + output
+	}
+	r.logs = append(r.logs, msg)
+	r.emitLog(msg, events.LogLevelInfo)
+}
+
 // IsStuck returns true if stuck() was called.
 func (r *Runtime) IsStuck() bool { return r.isStuck }
 
@@ -146,6 +640,10 @@ func (r *Runtime) registerAPI() {
 	r.vm.Set("context", r.jsContext)
 	r.vm.Set("log", r.jsLog)
 	r.vm.Set("pause", r.jsPause)
+	r.vm.Set("on_finish", r.jsOnFinish)
+	r.vm.Set("now", r.jsNow)
+	r.vm.Set("use", r.jsUse)
+	r.vm.Set("run_workflow", r.jsRunWorkflow)
 }
 
 // ── run() ─────────────────────────────────────────────────────────────────────
@@ -157,7 +655,7 @@ func (r *Runtime) jsRun(call goja.FunctionCall) goja.Value {
 	}
 	agent := arg0.String()
 
-	var prompt, model string
+	var prompt, model, outputFormat, onError string
 	var customStatuses []string
 	arg1 := call.Argument(1)
 	if !goja.IsUndefined(arg1) && !goja.IsNull(arg1) {
@@ -171,6 +669,12 @@ func (r *Runtime) jsRun(call goja.FunctionCall) goja.Value {
 			if m, ok := v["model"].(string); ok {
 				model = m
 			}
+			if f, ok := v["output_format"].(string); ok {
+				if !process.ValidOutputFormat(f) {
+					panic(r.vm.NewTypeError(fmt.Sprintf("run() output_format must be json, stream-json or text, got %q", f)))
+				}
+				outputFormat = f
+			}
 			if s, ok := v["statuses"].([]any); ok {
 				for _, item := range s {
 					if str, ok := item.(string); ok {
@@ -178,119 +682,281 @@ func (r *Runtime) jsRun(call goja.FunctionCall) goja.Value {
 					}
 				}
 			}
+			if e, ok := v["on_error"].(string); ok {
+				onError = e
+			}
 		default:
 			panic(r.vm.NewTypeError("run() second argument must be a string or object"))
 		}
 	}
 
+	r.replayFailures = onError != ""
+	signal, err := r.callAgent(agent, prompt, model, outputFormat, customStatuses)
+	r.replayFailures = false
+	if err != nil && onError != "" && r.failedCall == r.callIndex {
+		r.warn(fmt.Sprintf("%s failed; running %s (on_error)", agent, onError))
+		signal, err = r.callAgent(onError, r.recoveryPrompt(agent, prompt), "", "", customStatuses)
+	}
+	if err != nil {
+		panic(r.vm.NewGoError(err))
+	}
+
+	return r.vm.ToValue(signal)
+}
+
+// recoveryPrompt briefs an on_error agent on the execution that failed.
+func (r *Runtime) recoveryPrompt(agent, prompt string) string {
+	if prompt == "" {
+		prompt = r.deps.State.InitialPrompt
+	}
+	return fmt.Sprintf("The %s agent failed: %s\n\nIts task was:\n%s\n\n"+
+		"Check the repository for anything it left half done, finish or repair the work, and report the outcome in its place.",
+		agent, r.failedReason, prompt)
+}
+
+// callAgent assigns the next call index and returns the agent's signal,
+// from the projection when replaying or by running the agent fresh.
+func (r *Runtime) callAgent(agent, prompt, model, outputFormat string, customStatuses []string) (map[string]any, error) {
 	r.callIndex++
 	idx := r.callIndex
+	r.consecutivePauses = 0
+
+	// A human handed this call off to a different agent
+	if to, ok := r.deps.State.Handoffs[idx]; ok && to != agent {
+		if prompt == "" {
+			prompt = r.deps.State.InitialPrompt
+		}
+		prompt = fmt.Sprintf("This task was handed off to you from the %s agent.\n\n%s", agent, prompt)
+		agent = to
+	}
 
 	// ── 1. Replay: check projection for completed execution at this call_index ──
 	if exec := r.deps.State.GetExecutionByCallIndex(idx); exec != nil {
 		if exec.Status == events.ExecStatusCompleted && exec.Signal != nil {
 			// Determinism check
 			if exec.AgentName != agent {
-				msg := fmt.Sprintf("WARNING: determinism violation at call %d: cached agent=%s, script agent=%s; re-running",
-					idx, exec.AgentName, agent)
-				r.logs = append(r.logs, msg)
-				r.emitLog(msg)
+				if err := r.replayMismatch(idx, exec.AgentName, agent); err != nil {
+					return nil, err
+				}
 				// Fall through to fresh run
 			} else {
 				signal := exec.Signal
-				if status, _ := signal["status"].(string); status == string(events.SignalStuck) {
+				if status, _ := signal["status"].(string); status == string(events.SignalStuck) && !r.deps.State.KeepGoing {
 					r.setWaitingHuman(agent, idx, exec.SessionID, signal)
-					panic(r.vm.NewGoError(fmt.Errorf("stuck: %s", r.waitingReason)))
+					return nil, fmt.Errorf("stuck: %s", r.waitingReason)
 				}
-				return r.vm.ToValue(signal)
+				r.recordStatus(signal)
+				r.replayed++
+				r.progress(idx, agent, false)
+				return signal, nil
 			}
 		}
 		if exec.Status == events.ExecStatusWaitingHuman {
 			r.setWaitingHuman(agent, idx, exec.SessionID, exec.Signal)
-			panic(r.vm.NewGoError(fmt.Errorf("waiting for human: %s", r.waitingReason)))
+			return nil, fmt.Errorf("waiting for human: %s", r.waitingReason)
+		}
+		if exec.Status == events.ExecStatusFailed && r.replayFailures && exec.AgentName == agent {
+			r.failedCall, r.failedReason = idx, exec.Error
+			return nil, fmt.Errorf("failed to run agent: agent %s failed: %s", agent, exec.Error)
 		}
 	}
 
-	// ── 2. Run fresh ──
-	signal, err := r.runAgent(agent, prompt, model, idx, customStatuses)
+	// ── 2. Break before a fresh run of the --until agent ──
+	if r.paused {
+		return nil, fmt.Errorf("paused before %s", r.pausedAgent)
+	}
+	if r.deps.Until != "" && agent == r.deps.Until && idx != r.deps.State.PausedCallIndex {
+		r.paused = true
+		r.pausedAgent = agent
+		r.pausedCallIndex = idx
+		return nil, fmt.Errorf("paused before %s", agent)
+	}
+	if reason, ok := r.pauseRequested(); ok {
+		r.paused = true
+		r.pausedAgent = agent
+		r.pausedCallIndex = idx
+		r.pausedReason = fmt.Sprintf("pause requested before %s", agent)
+		if reason != "" {
+			r.pausedReason += ": " + reason
+		}
+		return nil, fmt.Errorf("paused before %s", agent)
+	}
+
+	// ── 3. Run fresh ──
+	if !r.wentLive {
+		r.wentLive = true
+		if r.replayed > 0 {
+			r.progress(idx, agent, true)
+		}
+	}
+	signal, err := r.runAgent(agent, prompt, model, outputFormat, idx, customStatuses)
 	if err != nil {
 		if r.waitingHuman {
-			panic(r.vm.NewGoError(fmt.Errorf("waiting for human: %s", r.waitingReason)))
+			return nil, fmt.Errorf("waiting for human: %s", r.waitingReason)
 		}
-		panic(r.vm.NewGoError(fmt.Errorf("failed to run agent: %v", err)))
+		return nil, fmt.Errorf("failed to run agent: %v", err)
 	}
 
-	return r.vm.ToValue(signal)
+	r.recordStatus(signal)
+	return signal, nil
+}
+
+// progress reports call idx to RuntimeDeps.Progress.
+func (r *Runtime) progress(idx int, agent string, live bool) {
+	if r.deps.Progress == nil || r.finishing {
+		return
+	}
+	cached := 0
+	for _, exec := range r.deps.State.Executions {
+		if exec.Status == events.ExecStatusCompleted && exec.Signal != nil && !exec.Finishing && !strings.HasPrefix(exec.AgentName, "_") {
+			cached++
+		}
+	}
+	r.deps.Progress(ReplayProgress{CallIndex: idx, Agent: agent, Replayed: r.replayed, Cached: cached, Live: live})
+}
+
+// replayMismatch handles history that ran a different agent at call idx
+// than the script asks for now: an error under strict replay, otherwise a
+// warning before the call runs fresh.
+func (r *Runtime) replayMismatch(idx int, ran, asked string) error {
+	if r.deps.Strict || r.settings.StrictReplay {
+		return fmt.Errorf("determinism violation at call %d: history ran %s, the script asks for %s (strict replay)",
+			idx, ran, asked)
+	}
+	r.warn(fmt.Sprintf("WARNING: determinism violation at call %d: cached agent=%s, script agent=%s; re-running",
+		idx, ran, asked))
+	return nil
+}
+
+// recordStatus remembers a workflow agent's signal status; the finally
+// agent's doesn't count.
+func (r *Runtime) recordStatus(signal map[string]any) {
+	if !r.finishing {
+		r.lastStatus, _ = signal["status"].(string)
+	}
+}
+
+// pauseRequested reports whether `shop pause` asked the run to stop before
+// its next agent, and why. Pending requests are applied first so one made
+// while the previous agent ran is seen here.
+func (r *Runtime) pauseRequested() (string, bool) {
+	if r.finishing || r.deps.Store == nil {
+		return "", false
+	}
+	if r.deps.DrainCommands != nil {
+		r.deps.DrainCommands()
+	}
+	state, err := r.latestState()
+	if err != nil {
+		return "", false
+	}
+	return state.PauseReason, state.PauseRequested
 }
 
-func (r *Runtime) runAgent(agent, prompt, model string, callIndex int, customStatuses []string) (map[string]any, error) {
+// latestState returns the run's current state. The first call projects it
+// from the store; later calls only apply the events recorded since, so
+// checking on the run after each agent doesn't reload its whole history.
+func (r *Runtime) latestState() (*events.RunState, error) {
+	if r.latest == nil {
+		state, err := r.deps.Store.ProjectRunFromDB(r.deps.State.ID)
+		if err != nil {
+			return nil, err
+		}
+		r.latest = state
+		return state, nil
+	}
+	if err := r.deps.Store.CatchUp(r.latest); err != nil {
+		return nil, err
+	}
+	return r.latest, nil
+}
+
+func (r *Runtime) runAgent(agent, prompt, model, outputFormat string, callIndex int, customStatuses []string) (map[string]any, error) {
 	// Create scratchpad
 	scratchDir := filepath.Join(r.deps.WorkspacePath, "scratchpad", agent)
 	os.MkdirAll(scratchDir, 0755)
 
 	// Write MCP config
-	if err := r.deps.WriteMCPConfig(callIndex, customStatuses); err != nil {
+	if err := r.deps.WriteMCPConfig(agent, callIndex, customStatuses); err != nil {
 		return nil, fmt.Errorf("write MCP config: %w", err)
 	}
 
 	agentPrompt := r.buildAgentPrompt(agent, prompt)
+	if err := r.checkPromptSize(agent, agentPrompt); err != nil {
+		return nil, err
+	}
 
 	// Start agent via ProcessManager
 	ctx := context.Background()
-	sessionID, pid, done, err := r.deps.ProcessManager.StartAgent(ctx, process.AgentOpts{
-		ClaudeAgent:   agent,
-		SignalAgent:   agent,
-		Prompt:        agentPrompt,
-		Model:         model,
-		WorkDir:       r.deps.RepoPath,
-		MCPConfigPath: filepath.Join(r.deps.WorkspacePath, "mcp.json"),
-	})
+	opts := process.AgentOpts{
+		ClaudeAgent:     agent,
+		SignalAgent:     agent,
+		Prompt:          agentPrompt,
+		Model:           model,
+		OutputFormat:    outputFormat,
+		WorkDir:         r.deps.RepoPath,
+		MCPConfigPath:   filepath.Join(r.deps.WorkspacePath, "mcp.json"),
+		SkipPermissions: r.settings.SkipsPermissions(),
+		Priority:        r.deps.State.Priority,
+	}
+	sessionID, pid, done, err := r.deps.ProcessManager.StartAgent(ctx, opts)
 	if err != nil {
 		return nil, fmt.Errorf("start agent: %w", err)
 	}
 
 	// Emit AgentStarted
 	startedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentStarted, events.AgentStartedPayload{
-		AgentName: agent,
-		CallIndex: callIndex,
-		SessionID: sessionID,
-		PID:       pid,
-		Prompt:    prompt,
-		Model:     model,
+		AgentName:    agent,
+		CallIndex:    callIndex,
+		SessionID:    sessionID,
+		PID:          pid,
+		Prompt:       prompt,
+		Model:        model,
+		OutputFormat: process.OutputFormatOrDefault(outputFormat),
+		SentPrompt:   agentPrompt,
+		Finishing:    r.finishing,
+
+		SkippedPermissions: r.settings.SkipsPermissions(),
 	})
 	r.deps.EmitEvents([]events.Event{startedEvt})
 
 	// Wait for agent to finish
 	result := <-done
-
-	// Drain pending commands (picks up ReportSignal from MCP)
-	if r.deps.DrainCommands != nil {
-		r.deps.DrainCommands()
+	signal, err := r.reportedSignal(callIndex)
+	if err != nil {
+		return nil, err
 	}
 
-	// Re-read state to get the signal written by MCP
-	freshEvents, err := r.deps.Store.GetEvents(r.deps.State.ID)
-	if err != nil {
-		return nil, fmt.Errorf("re-read events: %w", err)
+	// An agent that ends its turn cleanly without reporting has usually
+	// just forgotten; remind it once, in the same session, before failing it
+	if signal == nil && result.ErrorResult == "" && result.ExitCode == 0 {
+		opts.Prompt = signalReminder
+		opts.ResumeSessionID = sessionID
+		_, pid, done, err := r.deps.ProcessManager.StartAgent(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("start agent: %w", err)
+		}
+		remindedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventSignalReminded, events.SignalRemindedPayload{
+			AgentName: agent, CallIndex: callIndex, PID: pid,
+		})
+		r.deps.EmitEvents([]events.Event{remindedEvt})
+
+		result = <-done
+		if signal, err = r.reportedSignal(callIndex); err != nil {
+			return nil, err
+		}
 	}
-	info, _ := r.deps.Store.GetRun(r.deps.State.ID)
-	freshState := events.ProjectRun(info.ID, info.CreatedAt, freshEvents)
 
 	if result.ErrorResult != "" {
 		failEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentFailed, events.AgentFailedPayload{
 			AgentName: agent, CallIndex: callIndex, Error: result.ErrorResult, ExitCode: result.ExitCode,
+			Result: result.Result,
 		})
 		r.deps.EmitEvents([]events.Event{failEvt})
+		r.failedCall, r.failedReason = callIndex, result.ErrorResult
 		return nil, fmt.Errorf("agent %s failed (exit %d): %s", agent, result.ExitCode, result.ErrorResult)
 	}
 
-	// Find signal from projection
-	exec := freshState.GetExecutionByCallIndex(callIndex)
-	var signal map[string]any
-	if exec != nil && exec.Signal != nil {
-		signal = exec.Signal
-	}
-
 	if signal == nil {
 		errReason := fmt.Sprintf("no signal (exit %d)", result.ExitCode)
 		if result.Stderr != "" {
@@ -298,8 +964,10 @@ func (r *Runtime) runAgent(agent, prompt, model string, callIndex int, customSta
 		}
 		failEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentFailed, events.AgentFailedPayload{
 			AgentName: agent, CallIndex: callIndex, Error: errReason, ExitCode: result.ExitCode,
+			Result: result.Result,
 		})
 		r.deps.EmitEvents([]events.Event{failEvt})
+		r.failedCall, r.failedReason = callIndex, errReason
 		return nil, fmt.Errorf("agent %s failed: %s", agent, errReason)
 	}
 
@@ -308,12 +976,19 @@ func (r *Runtime) runAgent(agent, prompt, model string, callIndex int, customSta
 
 	// Emit AgentCompleted
 	completedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentCompleted, events.AgentCompletedPayload{
-		AgentName: agent, CallIndex: callIndex, Signal: signal,
+		AgentName: agent, CallIndex: callIndex, Signal: signal, Artifacts: r.collectArtifacts(agent, signal),
+		Result: result.Result,
 	})
 	r.deps.EmitEvents([]events.Event{completedEvt})
 
-	// Handle STUCK — suspend for human input
+	// Handle STUCK — suspend for human input, or under --keep-going record
+	// the skip and let the script decide what comes next
 	if status, ok := signal["status"].(string); ok && status == string(events.SignalStuck) {
+		if r.deps.State.KeepGoing {
+			reason, _ := signal["reason"].(string)
+			r.warn(fmt.Sprintf("keep-going: %s is stuck (%s); continuing", agent, reason))
+			return signal, nil
+		}
 		r.setWaitingHuman(agent, callIndex, sessionID, signal)
 		return nil, fmt.Errorf("agent %s is stuck: %s", agent, r.waitingReason)
 	}
@@ -321,6 +996,139 @@ func (r *Runtime) runAgent(agent, prompt, model string, callIndex int, customSta
 	return signal, nil
 }
 
+// signalReminder is the prompt that resumes an agent's session when it
+// finished without reporting a signal.
+const signalReminder = "You finished without calling the `report_signal` tool, so the workflow can't tell how your task went. " +
+	"Don't redo the work: call `report_signal` now with the status that describes the outcome."
+
+// reportedSignal applies pending commands, which include the ReportSignal
+// from the agent's MCP server, and returns the signal recorded for
+// callIndex, or nil if there is none.
+func (r *Runtime) reportedSignal(callIndex int) (map[string]any, error) {
+	if r.deps.DrainCommands != nil {
+		r.deps.DrainCommands()
+	}
+
+	state, err := r.latestState()
+	if err != nil {
+		return nil, fmt.Errorf("re-read events: %w", err)
+	}
+	if exec := state.GetExecutionByCallIndex(callIndex); exec != nil {
+		// A copy: the caller adds to it, and latest must stay as recorded
+		return maps.Clone(exec.Signal), nil
+	}
+	return nil, nil
+}
+
+// ── run_workflow() ────────────────────────────────────────────────────────────
+
+func (r *Runtime) jsRunWorkflow(call goja.FunctionCall) goja.Value {
+	arg0 := call.Argument(0)
+	if goja.IsUndefined(arg0) || goja.IsNull(arg0) {
+		panic(r.vm.NewTypeError("run_workflow() requires a workflow name"))
+	}
+	name := strings.TrimSuffix(arg0.String(), ".js")
+	if !libName.MatchString(name) {
+		panic(r.vm.NewTypeError(fmt.Sprintf("run_workflow(): invalid workflow name %q", arg0.String())))
+	}
+	var prompt string
+	if arg1 := call.Argument(1); !goja.IsUndefined(arg1) && !goja.IsNull(arg1) {
+		prompt = arg1.String()
+	}
+
+	signal, err := r.callWorkflow(name, prompt)
+	if err != nil {
+		panic(r.vm.NewGoError(err))
+	}
+	return r.vm.ToValue(signal)
+}
+
+// callWorkflow takes the next call index for a child run of the named
+// workflow and returns the child's final signal: from history when
+// replaying, otherwise by running the child to the end. The call shows in
+// the run's executions as "workflow:<name>".
+func (r *Runtime) callWorkflow(name, prompt string) (map[string]any, error) {
+	r.callIndex++
+	idx := r.callIndex
+	r.consecutivePauses = 0
+	agent := "workflow:" + name
+	if prompt == "" {
+		prompt = r.deps.State.InitialPrompt
+	}
+
+	if exec := r.deps.State.GetExecutionByCallIndex(idx); exec != nil {
+		switch {
+		case exec.AgentName != agent:
+			if exec.Status == events.ExecStatusCompleted && exec.Signal != nil {
+				if err := r.replayMismatch(idx, exec.AgentName, agent); err != nil {
+					return nil, err
+				}
+			}
+		case exec.Status == events.ExecStatusCompleted && exec.Signal != nil:
+			r.recordStatus(exec.Signal)
+			return exec.Signal, nil
+		case exec.ChildRunID != 0 && r.deps.Store != nil:
+			// A child that was waiting may have been seen through by hand since
+			if child, err := r.deps.Store.ProjectRunFromDB(exec.ChildRunID); err == nil && child.Status == events.RunStatusComplete {
+				return r.workflowResult(agent, idx, child)
+			}
+		}
+	}
+
+	if r.deps.StartWorkflow == nil {
+		return nil, fmt.Errorf("run_workflow(%q): sub-workflows can't run here", name)
+	}
+	childID, err := r.deps.StartWorkflow(name, prompt, idx)
+	if err != nil {
+		return nil, fmt.Errorf("run_workflow(%q): %w", name, err)
+	}
+	startedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentStarted, events.AgentStartedPayload{
+		AgentName: agent, CallIndex: idx, Prompt: prompt, ChildRunID: childID, Finishing: r.finishing,
+	})
+	r.deps.EmitEvents([]events.Event{startedEvt})
+
+	child, err := r.deps.AwaitRun(childID)
+	if err != nil {
+		return nil, fmt.Errorf("run_workflow(%q): %w", name, err)
+	}
+	return r.workflowResult(agent, idx, child)
+}
+
+// workflowResult records how the child run behind call idx ended. A
+// complete child's final signal (DONE if no agent reported one) becomes
+// the call's, with the child's ID as _run_id; any other end fails the call.
+func (r *Runtime) workflowResult(agent string, idx int, child *events.RunState) (map[string]any, error) {
+	if child.Status != events.RunStatusComplete {
+		reason := fmt.Sprintf("run %d ended %s", child.ID, child.Status)
+		if child.Status.IsSuspended() || child.Status == events.RunStatusPending {
+			reason = fmt.Sprintf("run %d is %s; see it through, then resume run %d", child.ID, child.Status, r.deps.State.ID)
+		}
+		if child.Error != "" {
+			reason += ": " + child.Error
+		} else if child.WaitingReason != "" {
+			reason += ": " + child.WaitingReason
+		}
+		failEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentFailed, events.AgentFailedPayload{
+			AgentName: agent, CallIndex: idx, Error: reason,
+		})
+		r.deps.EmitEvents([]events.Event{failEvt})
+		r.failedCall, r.failedReason = idx, reason
+		return nil, fmt.Errorf("%s failed: %s", agent, reason)
+	}
+
+	signal := maps.Clone(child.FinalSignal())
+	if signal == nil {
+		signal = map[string]any{"status": "DONE"}
+	}
+	signal["_run_id"] = child.ID
+	completedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventAgentCompleted, events.AgentCompletedPayload{
+		AgentName: agent, CallIndex: idx, Signal: signal,
+	})
+	r.deps.EmitEvents([]events.Event{completedEvt})
+	r.recordStatus(signal)
+	return signal, nil
+}
+
 // ── pause() ───────────────────────────────────────────────────────────────────
 
 func (r *Runtime) jsPause(call goja.FunctionCall) goja.Value {
@@ -330,6 +1138,18 @@ func (r *Runtime) jsPause(call goja.FunctionCall) goja.Value {
 	}
 	message := arg0.String()
 
+	// Counted on replay too, so the guard trips at the same call every time
+	r.consecutivePauses++
+	limit := r.settings.MaxConsecutivePauses
+	if limit <= 0 {
+		limit = DefaultMaxConsecutivePauses
+	}
+	if r.consecutivePauses > limit {
+		r.stuckReason = fmt.Sprintf("pause loop detected: %d pause() calls without a run() in between", limit)
+		r.isStuck = true
+		panic(r.vm.NewGoError(fmt.Errorf("stuck: %s", r.stuckReason)))
+	}
+
 	r.callIndex++
 	idx := r.callIndex
 
@@ -364,19 +1184,27 @@ func (r *Runtime) runCheckpoint(message string, callIndex int) (map[string]any,
 	os.MkdirAll(scratchDir, 0755)
 
 	// Write MCP config with checkpoint-specific statuses
-	if err := r.deps.WriteMCPConfig(callIndex, []string{"CONTINUE", "STOP"}); err != nil {
+	if err := r.deps.WriteMCPConfig(agent, callIndex, []string{"CONTINUE", "STOP"}); err != nil {
 		return nil, fmt.Errorf("write MCP config: %w", err)
 	}
 
-	checkpointPrompt := r.buildCheckpointPrompt(message)
+	checkpointPrompt, err := RenderCheckpointPrompt(r.settings.CheckpointPrompt, CheckpointData{
+		ContextData: ContextData{RunID: r.deps.State.ID, Workflow: r.deps.State.WorkflowName, Prompt: r.deps.State.InitialPrompt},
+		Message:     message,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint_prompt: %w", err)
+	}
 
 	ctx := context.Background()
 	sessionID, pid, done, err := r.deps.ProcessManager.StartAgent(ctx, process.AgentOpts{
-		ClaudeAgent:   "", // no agent mode for checkpoints
-		SignalAgent:   agent,
-		Prompt:        checkpointPrompt,
-		WorkDir:       r.deps.RepoPath,
-		MCPConfigPath: filepath.Join(r.deps.WorkspacePath, "mcp.json"),
+		ClaudeAgent:     r.settings.CheckpointAgent,
+		SignalAgent:     agent,
+		Prompt:          checkpointPrompt,
+		WorkDir:         r.deps.RepoPath,
+		MCPConfigPath:   filepath.Join(r.deps.WorkspacePath, "mcp.json"),
+		SkipPermissions: r.settings.SkipsPermissions(),
+		Priority:        r.deps.State.Priority,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("start checkpoint: %w", err)
@@ -385,31 +1213,17 @@ func (r *Runtime) runCheckpoint(message string, callIndex int) (map[string]any,
 	// Emit CheckpointStarted
 	startedEvt, _ := events.NewEvent(r.deps.State.ID, events.EventCheckpointStarted, events.CheckpointStartedPayload{
 		CallIndex: callIndex, Message: message, SessionID: sessionID,
+		SkippedPermissions: r.settings.SkipsPermissions(),
+		SentPrompt:         checkpointPrompt,
 	})
 	_ = pid
 	r.deps.EmitEvents([]events.Event{startedEvt})
 
 	// Wait
-	result := <-done
-	_ = result
-
-	// Drain commands
-	if r.deps.DrainCommands != nil {
-		r.deps.DrainCommands()
-	}
-
-	// Re-read state
-	freshEvents, err := r.deps.Store.GetEvents(r.deps.State.ID)
+	<-done
+	signal, err := r.reportedSignal(callIndex)
 	if err != nil {
-		return nil, fmt.Errorf("re-read events: %w", err)
-	}
-	info, _ := r.deps.Store.GetRun(r.deps.State.ID)
-	freshState := events.ProjectRun(info.ID, info.CreatedAt, freshEvents)
-
-	exec := freshState.GetExecutionByCallIndex(callIndex)
-	var signal map[string]any
-	if exec != nil && exec.Signal != nil {
-		signal = exec.Signal
+		return nil, err
 	}
 
 	if signal == nil {
@@ -458,20 +1272,115 @@ func (r *Runtime) jsStuck(call goja.FunctionCall) goja.Value {
 	r.stuckReason = reason
 	r.isStuck = true
 	panic(r.vm.NewGoError(fmt.Errorf("stuck: %s", reason)))
-	return goja.Undefined() // unreachable
+}
+
+// ── on_finish() ───────────────────────────────────────────────────────────────
+
+func (r *Runtime) jsOnFinish(call goja.FunctionCall) goja.Value {
+	fn, ok := goja.AssertFunction(call.Argument(0))
+	if !ok {
+		panic(r.vm.NewTypeError("on_finish() requires a function"))
+	}
+	r.finishHooks = append(r.finishHooks, fn)
+	return goja.Undefined()
 }
 
 // ── context() ─────────────────────────────────────────────────────────────────
 
 func (r *Runtime) jsContext(call goja.FunctionCall) goja.Value {
 	return r.vm.ToValue(map[string]any{
-		"run_id":    r.deps.State.ID,
-		"repo":      r.deps.RepoPath,
-		"iteration": r.callIndex,
-		"prompt":    r.deps.State.InitialPrompt,
+		"run_id":        r.deps.State.ID,
+		"repo":          r.deps.RepoPath,
+		"iteration":     r.callIndex,
+		"prompt":        r.deps.State.InitialPrompt,
+		"workflow_name": r.deps.State.WorkflowName,
+		"workflow_path": r.deps.State.WorkflowPath,
 	})
 }
 
+// ── now() ─────────────────────────────────────────────────────────────────────
+
+// jsNow returns the run's start time as a Date. It comes from the RunStarted
+// event rather than the wall clock, so every replay sees the same value.
+func (r *Runtime) jsNow(call goja.FunctionCall) goja.Value {
+	started := r.deps.State.StartedAt
+	if started.IsZero() {
+		started = r.deps.State.CreatedAt
+	}
+	date, err := r.vm.New(r.vm.Get("Date"), r.vm.ToValue(started.UnixMilli()))
+	if err != nil {
+		panic(r.vm.NewGoError(err))
+	}
+	return date
+}
+
+// ── use() ─────────────────────────────────────────────────────────────────────
+
+// libName is what use() accepts: a bare file name, so a library can't be
+// loaded from outside LibDir.
+var libName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// jsUse loads lib/<name>.js and returns its exports. The library runs once
+// per execution, in its own function scope, with an `exports` object to
+// fill in; it can call run(), pause() and the rest of the API.
+func (r *Runtime) jsUse(call goja.FunctionCall) goja.Value {
+	arg0 := call.Argument(0)
+	if goja.IsUndefined(arg0) || goja.IsNull(arg0) {
+		panic(r.vm.NewTypeError("use() requires a library name"))
+	}
+	name := strings.TrimSuffix(arg0.String(), ".js")
+	if !libName.MatchString(name) {
+		panic(r.vm.NewTypeError(fmt.Sprintf("use(): invalid library name %q", arg0.String())))
+	}
+	if lib, ok := r.libs[name]; ok {
+		return lib
+	}
+	if r.deps.LibDir == "" {
+		panic(r.vm.NewGoError(fmt.Errorf("use(%q): no library directory for this workflow", name)))
+	}
+
+	src, err := r.readLib(name)
+	if err != nil {
+		panic(r.vm.NewGoError(fmt.Errorf("use(%q): %w", name, err)))
+	}
+	fn, err := r.vm.RunScript(filepath.Join("lib", name+".js"), "(function(exports) {\n"+src+"\n; return exports; })")
+	if err != nil {
+		panic(r.vm.NewGoError(fmt.Errorf("use(%q): %w", name, err)))
+	}
+	load, _ := goja.AssertFunction(fn)
+	exports, err := load(goja.Undefined(), r.vm.NewObject())
+	if err != nil {
+		panic(err)
+	}
+
+	if r.libs == nil {
+		r.libs = make(map[string]goja.Value)
+	}
+	r.libs[name] = exports
+	return exports
+}
+
+// readLib reads <LibDir>/<name>.js, refusing anything (e.g. a symlink)
+// that resolves outside LibDir.
+func (r *Runtime) readLib(name string) (string, error) {
+	dir, err := filepath.EvalSymlinks(r.deps.LibDir)
+	if err != nil {
+		return "", fmt.Errorf("library directory %s not found", r.deps.LibDir)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, name+".js"))
+	if err != nil {
+		return "", fmt.Errorf("%s.js not found in %s", name, r.deps.LibDir)
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") || filepath.Dir(rel) != "." {
+		return "", fmt.Errorf("%s.js resolves outside %s", name, r.deps.LibDir)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // ── log() ─────────────────────────────────────────────────────────────────────
 
 func (r *Runtime) jsLog(call goja.FunctionCall) goja.Value {
@@ -481,14 +1390,51 @@ func (r *Runtime) jsLog(call goja.FunctionCall) goja.Value {
 	}
 	message := arg0.String()
 	r.logs = append(r.logs, message)
-	r.emitLog(message)
+	r.emitLog(message, events.LogLevelInfo)
 	return goja.Undefined()
 }
 
 // ── Helpers ───────────────────────────────────────────────────────────────────
 
-func (r *Runtime) emitLog(message string) {
-	evt, _ := events.NewEvent(r.deps.State.ID, events.EventLogMessage, events.LogMessagePayload{Message: message})
+// warn records a runtime warning in the run log.
+func (r *Runtime) warn(message string) {
+	r.logs = append(r.logs, message)
+	r.emitLog(message, events.LogLevelWarn)
+}
+
+// collectArtifacts returns the signal's artifact paths that are files inside
+// the repo, warning about any that are missing, directories, or point (or
+// link) outside it.
+func (r *Runtime) collectArtifacts(agent string, signal map[string]any) []string {
+	var valid []string
+	for _, path := range events.SignalArtifacts(signal) {
+		clean := filepath.Clean(path)
+		if !filepath.IsLocal(clean) {
+			r.warn(fmt.Sprintf("%s reported artifact %q outside the repo; ignoring", agent, path))
+			continue
+		}
+		full := filepath.Join(r.deps.RepoPath, clean)
+		info, err := os.Stat(full)
+		if err != nil {
+			r.warn(fmt.Sprintf("%s reported artifact %q that does not exist; ignoring", agent, path))
+			continue
+		}
+		// A symlink in the repo can still point out of it
+		if !workspace.Within(r.deps.RepoPath, full) {
+			r.warn(fmt.Sprintf("%s reported artifact %q that links outside the repo; ignoring", agent, path))
+			continue
+		}
+		if info.IsDir() {
+			r.warn(fmt.Sprintf("%s reported artifact %q, a directory; report its files instead; ignoring", agent, path))
+			continue
+		}
+		valid = append(valid, clean)
+	}
+	return valid
+}
+
+func (r *Runtime) emitLog(message, level string) {
+	evt, _ := events.NewEvent(r.deps.State.ID, events.EventLogMessage, events.LogMessagePayload{Message: message, Level: level})
 	r.deps.EmitEvents([]events.Event{evt})
 }
 
@@ -509,15 +1455,24 @@ func (r *Runtime) buildAgentPrompt(agent, prompt string) string {
 	if result == "" {
 		result = r.deps.State.InitialPrompt
 	}
-
-	if r.callIndex > 1 {
-		result += "\n\n---\n"
-		result += "IMPORTANT: Call the `get_context` tool to retrieve context and summaries from previous agents before starting work."
+	if instructions := r.agentInstructions(agent); instructions != "" {
+		result = instructions + "\n\n---\n\n" + result
 	}
 
-	result += fmt.Sprintf("\n\nYou are the '%s' agent in the '%s' workflow.", agent, r.deps.State.WorkflowName)
-	result += fmt.Sprintf("\nUse `%s` for drafts or intermediate work.",
-		filepath.Join(r.deps.WorkspacePath, "scratchpad", agent))
+	skill := r.skill()
+	if skill == "" || r.settings.SkillMode != "replace" {
+		if r.callIndex > 1 {
+			result += "\n\n---\n"
+			result += "IMPORTANT: Call the `get_context` tool to retrieve context and summaries from previous agents before starting work."
+		}
+
+		result += fmt.Sprintf("\n\nYou are the '%s' agent in the '%s' workflow.", agent, r.deps.State.WorkflowName)
+		result += fmt.Sprintf("\nUse `%s` for drafts or intermediate work.",
+			filepath.Join(r.deps.WorkspacePath, "scratchpad", agent))
+	}
+	if skill != "" {
+		result += "\n\n---\n\n" + skill
+	}
 
 	result += "\n\n---\n"
 	result += "IMPORTANT: When you have completed your task, you MUST call the `report_signal` tool to report your status.\n"
@@ -525,22 +1480,48 @@ func (r *Runtime) buildAgentPrompt(agent, prompt string) string {
 	return result
 }
 
-func (r *Runtime) buildCheckpointPrompt(message string) string {
-	return fmt.Sprintf(`The workflow has paused for human input.
-
-**Checkpoint:** %s
-
-**What to do:**
-1. Review the workspace state
-2. Check recent changes and test results
-3. Decide whether to continue or stop
+// skill returns the contents of the script's skill_file, or "" if it sets
+// none. A file that can't be read is warned about and left out.
+func (r *Runtime) skill() string {
+	path := r.settings.SkillFile
+	if path == "" {
+		return ""
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(r.deps.State.WorkflowPath), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.warn(fmt.Sprintf("skill_file: %v", err))
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
 
-When ready, call the report_signal tool with your decision:
-- To continue: report_signal(status="CONTINUE", summary="your note")
-- To stop: report_signal(status="STOP", summary="reason for stopping")
+// agentInstructions returns the first <agent>.md found in the instruction
+// dirs, or "" if there is none.
+func (r *Runtime) agentInstructions(agent string) string {
+	for _, dir := range r.deps.InstructionDirs {
+		data, err := os.ReadFile(filepath.Join(dir, agent+".md"))
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
 
-Wait for the human to provide guidance before reporting your decision.`,
-		message)
+// checkPromptSize rejects prompts over max_prompt_chars, which would
+// otherwise fail inside Claude with an unhelpful context length error.
+func (r *Runtime) checkPromptSize(agent, prompt string) error {
+	limit := r.settings.MaxPromptChars
+	if limit <= 0 {
+		limit = DefaultMaxPromptChars
+	}
+	if n := len(prompt); n > limit {
+		return fmt.Errorf("run %d: prompt for agent %s is %d characters (~%d tokens), over the %d character limit (max_prompt_chars)",
+			r.deps.State.ID, agent, n, n/4, limit)
+	}
+	return nil
 }
 
 // IsWorkflow checks if a file is a JavaScript workflow.
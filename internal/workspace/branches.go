@@ -0,0 +1,100 @@
+package workspace
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// RunBranch is a run's branch in a source repo.
+type RunBranch struct {
+	Name     string
+	RunID    int64
+	Merged   bool   // every commit on it is in the branch it was checked against
+	Worktree string // where it is checked out, or "" if nowhere
+
+	// Legacy marks an un-namespaced shop/run-N branch listed for an
+	// instance: its run ID may be another install's run.
+	Legacy bool
+}
+
+// RunBranches lists the run branches of instanceID in repo (shop/run-N or
+// shop/<instance>/run-N), marking those whose commits are all in into.
+// With an instance ID, un-namespaced shop/run-N branches are listed too,
+// marked Legacy: runs made them before every install had an instance ID.
+func RunBranches(repo, instanceID, into string) ([]RunBranch, error) {
+	branches, err := prefixedBranches(repo, BranchName(instanceID, 0), into)
+	if err != nil || instanceID == "" {
+		return branches, err
+	}
+	legacy, err := prefixedBranches(repo, BranchName("", 0), into)
+	if err != nil {
+		return nil, err
+	}
+	for i := range legacy {
+		legacy[i].Legacy = true
+	}
+	return append(legacy, branches...), nil
+}
+
+// prefixedBranches lists the branches named like runZero with another run
+// ID in place of its 0.
+func prefixedBranches(repo, runZero, into string) ([]RunBranch, error) {
+	prefix := strings.TrimSuffix(runZero, "0")
+
+	cmd := exec.Command("git", "for-each-ref", "--merged="+into, "--format=%(refname:short)", "refs/heads/"+prefix+"*")
+	cmd.Dir = repo
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git for-each-ref --merged=%s failed in %s: %w", into, repo, err)
+	}
+	merged := map[string]bool{}
+	for _, name := range strings.Fields(string(out)) {
+		merged[name] = true
+	}
+
+	cmd = exec.Command("git", "for-each-ref", "--format=%(refname:short) %(worktreepath)", "refs/heads/"+prefix+"*")
+	cmd.Dir = repo
+	if out, err = cmd.Output(); err != nil {
+		return nil, fmt.Errorf("git for-each-ref failed in %s: %w", repo, err)
+	}
+	var branches []RunBranch
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, worktree, _ := strings.Cut(line, " ")
+		runID, err := strconv.ParseInt(strings.TrimPrefix(name, prefix), 10, 64)
+		if err != nil || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		branches = append(branches, RunBranch{Name: name, RunID: runID, Merged: merged[name], Worktree: worktree})
+	}
+	return branches, nil
+}
+
+// DefaultBranch returns the branch work in repo is merged into: origin's
+// HEAD if it is known, else main or master, else HEAD.
+func DefaultBranch(repo string) string {
+	cmd := exec.Command("git", "symbolic-ref", "-q", "--short", "refs/remotes/origin/HEAD")
+	cmd.Dir = repo
+	if out, err := cmd.Output(); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	for _, branch := range []string{"main", "master"} {
+		cmd = exec.Command("git", "rev-parse", "--verify", "-q", "refs/heads/"+branch)
+		cmd.Dir = repo
+		if cmd.Run() == nil {
+			return branch
+		}
+	}
+	return "HEAD"
+}
+
+// DeleteBranch deletes branch from repo, merged or not.
+func DeleteBranch(repo, branch string) error {
+	cmd := exec.Command("git", "branch", "-D", branch)
+	cmd.Dir = repo
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %s", branch, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scpLike matches git's user@host:path remote syntax.
+var scpLike = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:`)
+
+// IsRemote reports whether repo is a git URL (https://, ssh://, file://,
+// git@host:path, ...) rather than a local directory.
+func IsRemote(repo string) bool {
+	return strings.Contains(repo, "://") || scpLike.MatchString(repo)
+}
+
+// ResolveRepo returns a local repository to create worktrees from. Local
+// paths are returned unchanged. A remote URL is cloned into cacheDir once
+// and the clone reused by later runs; refresh fast-forwards it to the
+// remote's current default branch first.
+func ResolveRepo(cacheDir, repo string, refresh bool) (string, error) {
+	if !IsRemote(repo) {
+		return repo, nil
+	}
+
+	path := filepath.Join(cacheDir, cacheName(repo))
+	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create repo cache: %w", err)
+		}
+		if err := cloneInto(cacheDir, repo, path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	if refresh {
+		cmd := exec.Command("git", "pull", "-q", "--ff-only")
+		cmd.Dir = path
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to refresh %s: %s", repo, strings.TrimSpace(string(output)))
+		}
+	}
+	return path, nil
+}
+
+// cloneInto clones repo into a temporary directory in cacheDir and renames
+// it to path, so no process sees, or removes, a clone another is still
+// writing. When two clone at once, the first rename wins and the other's
+// clone is dropped.
+func cloneInto(cacheDir, repo, path string) error {
+	tmp, err := os.MkdirTemp(cacheDir, ".clone-")
+	if err != nil {
+		return fmt.Errorf("failed to create repo cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	clone := filepath.Join(tmp, "repo")
+	cmd := exec.Command("git", "clone", "-q", "--", repo, clone)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clone %s: %s", repo, strings.TrimSpace(string(output)))
+	}
+	if err := os.Rename(clone, path); err != nil {
+		if _, statErr := os.Stat(filepath.Join(path, ".git")); statErr == nil {
+			return nil
+		}
+		// Left behind by a clone made in place, before clones were renamed
+		os.RemoveAll(path)
+		if err := os.Rename(clone, path); err != nil {
+			return fmt.Errorf("failed to move clone of %s into place: %w", repo, err)
+		}
+	}
+	return nil
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cacheName is a readable, collision-free directory name for a URL: its
+// last path element plus a hash of the whole URL.
+func cacheName(url string) string {
+	base := strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if i := strings.LastIndexAny(base, "/:"); i >= 0 {
+		base = base[i+1:]
+	}
+	base = strings.Trim(unsafeChars.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		base = "repo"
+	}
+	sum := sha256.Sum256([]byte(url))
+	return base + "-" + hex.EncodeToString(sum[:])[:12]
+}
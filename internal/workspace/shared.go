@@ -0,0 +1,166 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ownerFile holds the ID of the run using a shared workspace. Its presence
+// is also what marks a workspace as shared.
+const ownerFile = "owner"
+
+// SharedDir returns the directory of the workspace shared by runs of one
+// workflow against one repo; key comes from SharedKey.
+func SharedDir(baseDir, instanceID, key string) string {
+	if instanceID == "" {
+		return filepath.Join(baseDir, "shared-"+key)
+	}
+	return filepath.Join(baseDir, instanceID, "shared-"+key)
+}
+
+// SharedKey identifies the shared workspace for runs of workflowName
+// against sourceRepo: the workflow name plus a hash of both.
+func SharedKey(workflowName, sourceRepo string) string {
+	name := strings.Trim(unsafeChars.ReplaceAllString(workflowName, "-"), "-")
+	if name == "" {
+		name = "workflow"
+	}
+	sum := sha256.Sum256([]byte(workflowName + "\x00" + sourceRepo))
+	return name + "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// sharedBranch is the branch checked out in a shared workspace, named
+// like BranchName's.
+func sharedBranch(instanceID, key string) string {
+	if instanceID == "" {
+		return "shop/shared-" + key
+	}
+	return fmt.Sprintf("shop/%s/shared-%s", instanceID, key)
+}
+
+// Reuse returns the shared workspace for runs of workflowName against
+// sourceRepo, claimed for runID. The first run creates its worktree; later
+// runs get the same directory with the branch reset to sourceRepo's current
+// HEAD, untracked files cleaned (ignored ones, like build caches, are kept)
+// and the scratchpad emptied. Only one run may use it at a time: inUse
+// reports whether the run holding it still needs it.
+func Reuse(baseDir, instanceID string, runID int64, workflowName, sourceRepo string, inUse func(runID int64) bool) (*Workspace, error) {
+	if err := checkSourceRepo(baseDir, sourceRepo); err != nil {
+		return nil, err
+	}
+	absRepo, err := filepath.Abs(sourceRepo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repo path: %w", err)
+	}
+	base, err := repoHead(absRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	key := SharedKey(workflowName, absRepo)
+	path := SharedDir(baseDir, instanceID, key)
+	w := &Workspace{
+		Path:       path,
+		RepoPath:   filepath.Join(path, "repo"),
+		Branch:     sharedBranch(instanceID, key),
+		BaseCommit: base,
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+	if err := claim(path, runID, inUse); err != nil {
+		return nil, err
+	}
+	if err := w.prepare(absRepo); err != nil {
+		// Don't hold the workspace for a run that won't start
+		os.WriteFile(filepath.Join(path, ownerFile), nil, 0644)
+		return nil, err
+	}
+	return w, nil
+}
+
+// prepare creates the shared workspace's worktree, or resets an existing
+// one to w.BaseCommit, and empties the scratchpad.
+func (w *Workspace) prepare(absRepo string) error {
+	base := w.BaseCommit
+
+	if _, err := os.Stat(filepath.Join(w.RepoPath, ".git")); err != nil {
+		// Forget a worktree whose directory is gone; -B takes over its branch
+		cmd := exec.Command("git", "worktree", "prune")
+		cmd.Dir = absRepo
+		cmd.Run()
+		os.RemoveAll(w.RepoPath)
+
+		cmd = exec.Command("git", "worktree", "add", "-q", "-B", w.Branch, w.RepoPath, base)
+		cmd.Dir = absRepo
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create worktree: %s", string(output))
+		}
+	} else {
+		// An agent may have left another branch checked out
+		cmd := exec.Command("git", "checkout", "-q", "-f", "-B", w.Branch, base)
+		cmd.Dir = w.RepoPath
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to reset shared workspace: %s", strings.TrimSpace(string(output)))
+		}
+		if err := w.Reset(); err != nil {
+			return fmt.Errorf("failed to reset shared workspace: %w", err)
+		}
+	}
+
+	scratch := filepath.Join(w.Path, "scratchpad")
+	if err := os.RemoveAll(scratch); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		return fmt.Errorf("failed to create scratchpad directory: %w", err)
+	}
+	return nil
+}
+
+// claim records runID as the user of the shared workspace at path. The
+// owner file is locked while it is read and rewritten, so two runs can't
+// both take a workspace whose previous run has finished.
+func claim(path string, runID int64, inUse func(int64) bool) error {
+	f, err := os.OpenFile(filepath.Join(path, ownerFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open workspace lock: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock workspace: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if owner, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && owner != runID && inUse(owner) {
+		return fmt.Errorf("shared workspace %s is in use by run %d", path, owner)
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(strconv.FormatInt(runID, 10)+"\n"), 0)
+	return err
+}
+
+// SharedOwner returns the run that last claimed the workspace at path (0
+// if none holds it), and false if it isn't a shared workspace.
+func SharedOwner(path string) (int64, bool) {
+	data, err := os.ReadFile(filepath.Join(path, ownerFile))
+	if err != nil {
+		return 0, false
+	}
+	owner, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return owner, true
+}
@@ -5,15 +5,42 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 type Workspace struct {
 	Path     string
 	RepoPath string
+	Branch   string // empty when the repo is a plain directory rather than a worktree
+
+	// BaseCommit is the source repo commit the branch was created from;
+	// empty when unknown (plain directories, adopted branches).
+	BaseCommit string
 }
 
-func Create(baseDir string, runID int64, sourceRepo string) (*Workspace, error) {
-	path := filepath.Join(baseDir, fmt.Sprintf("run-%d", runID))
+// Dir returns the workspace directory for a run. A non-empty instance ID
+// namespaces it so installs sharing a workspaces directory don't collide.
+func Dir(baseDir, instanceID string, runID int64) string {
+	if instanceID == "" {
+		return filepath.Join(baseDir, fmt.Sprintf("run-%d", runID))
+	}
+	return filepath.Join(baseDir, instanceID, fmt.Sprintf("run-%d", runID))
+}
+
+// BranchName returns the worktree branch for a run: shop/<instance>/run-N,
+// or shop/run-N without an instance ID.
+func BranchName(instanceID string, runID int64) string {
+	if instanceID == "" {
+		return fmt.Sprintf("shop/run-%d", runID)
+	}
+	return fmt.Sprintf("shop/%s/run-%d", instanceID, runID)
+}
+
+func Create(baseDir, instanceID string, runID int64, sourceRepo string) (*Workspace, error) {
+	if err := checkSourceRepo(baseDir, sourceRepo); err != nil {
+		return nil, err
+	}
+	path := Dir(baseDir, instanceID, runID)
 
 	w := &Workspace{
 		Path:     path,
@@ -27,7 +54,7 @@ func Create(baseDir string, runID int64, sourceRepo string) (*Workspace, error)
 
 	// Create repo via git worktree if source repo provided
 	if sourceRepo != "" {
-		if err := w.createWorktree(sourceRepo, runID); err != nil {
+		if err := w.createWorktree(sourceRepo, BranchName(instanceID, runID)); err != nil {
 			return nil, err
 		}
 	} else {
@@ -45,44 +72,387 @@ func Create(baseDir string, runID int64, sourceRepo string) (*Workspace, error)
 	return w, nil
 }
 
-func (w *Workspace) createWorktree(sourceRepo string, runID int64) error {
+func (w *Workspace) createWorktree(sourceRepo, branchName string) error {
 	// Resolve to absolute path
 	absRepo, err := filepath.Abs(sourceRepo)
 	if err != nil {
 		return fmt.Errorf("failed to resolve repo path: %w", err)
 	}
 
-	// Verify it's a git repo
+	// Record the exact branch point so diffs don't have to guess it
+	base, err := repoHead(absRepo)
+	if err != nil {
+		return err
+	}
+
+	// An interrupted earlier add can leave an entry for this path behind;
+	// forget it, unless it is locked
+	cmd := exec.Command("git", "worktree", "prune")
+	cmd.Dir = absRepo
+	cmd.Run()
+	if locked, reason := lockedWorktree(absRepo, w.RepoPath); locked {
+		if reason != "" {
+			reason = " (" + reason + ")"
+		}
+		return fmt.Errorf("%s has a locked worktree entry for %s%s, left by an earlier run; "+
+			"clear it with 'git -C %s worktree unlock %s && git -C %s worktree prune' and retry",
+			absRepo, w.RepoPath, reason, absRepo, w.RepoPath, absRepo)
+	}
+
+	// Create worktree with new branch at that commit
+	cmd = exec.Command("git", "worktree", "add", "-b", branchName, w.RepoPath, base)
+	cmd.Dir = absRepo
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create worktree: %s", string(output))
+	}
+
+	w.Branch = branchName
+	w.BaseCommit = base
+	return nil
+}
+
+// checkSourceRepo refuses a source repo inside the workspaces directory:
+// worktrees of it would be created, and removed, among its own files.
+func checkSourceRepo(baseDir, sourceRepo string) error {
+	if sourceRepo != "" && Within(baseDir, sourceRepo) {
+		return fmt.Errorf("source repo %s is inside shop's workspaces directory %s; point --repo at the original repository", sourceRepo, baseDir)
+	}
+	return nil
+}
+
+// Within reports whether path is dir or inside it, after resolving both to
+// absolute paths without symlinks where they exist.
+func Within(dir, path string) bool {
+	resolve := func(p string) string {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			p = real
+		}
+		return p
+	}
+	rel, err := filepath.Rel(resolve(dir), resolve(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// lockedWorktree reports whether repo has a locked worktree entry for
+// path, and the lock's reason if one was given.
+func lockedWorktree(repo, path string) (bool, string) {
+	cmd := exec.Command("git", "worktree", "list", "--porcelain")
+	cmd.Dir = repo
+	out, err := cmd.Output()
+	if err != nil {
+		return false, ""
+	}
+
+	var current string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			current = strings.TrimPrefix(line, "worktree ")
+		case current == path && (line == "locked" || strings.HasPrefix(line, "locked ")):
+			return true, strings.TrimSpace(strings.TrimPrefix(line, "locked"))
+		}
+	}
+	return false, ""
+}
+
+// repoHead checks that repo is a git repository and returns its HEAD commit.
+func repoHead(repo string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--git-dir")
+	cmd.Dir = repo
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s is not a git repository", repo)
+	}
+
+	cmd = exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repo
+	head, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s has no commits to branch from", repo)
+	}
+	return strings.TrimSpace(string(head)), nil
+}
+
+// Adopt builds a workspace for runID around an existing branch of
+// sourceRepo. If the branch is already checked out in a shop workspace
+// (a worktree at <dir>/repo), that directory is reused as-is; otherwise a
+// new worktree for the branch is added at the run's usual location.
+func Adopt(baseDir, instanceID string, runID int64, sourceRepo, branch string) (*Workspace, error) {
+	absRepo, err := filepath.Abs(sourceRepo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repo path: %w", err)
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--verify", "-q", "refs/heads/"+branch)
+	cmd.Dir = absRepo
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("branch %q not found in %s", branch, absRepo)
+	}
+
+	// Forget worktrees whose directories are gone so the branch can be re-added
+	cmd = exec.Command("git", "worktree", "prune")
+	cmd.Dir = absRepo
+	cmd.Run()
+
+	existing, err := worktreeFor(absRepo, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	var w *Workspace
+	if existing != "" {
+		if filepath.Base(existing) != "repo" {
+			return nil, fmt.Errorf("branch %q is checked out at %s, which is not a shop workspace", branch, existing)
+		}
+		path := filepath.Dir(existing)
+		w = &Workspace{Path: path, RepoPath: existing, Branch: branch}
+	} else {
+		path := Dir(baseDir, instanceID, runID)
+		w = &Workspace{Path: path, RepoPath: filepath.Join(path, "repo"), Branch: branch}
+		if _, err := os.Stat(w.RepoPath); err == nil {
+			return nil, fmt.Errorf("%s already exists", w.RepoPath)
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+		}
+		cmd = exec.Command("git", "worktree", "add", w.RepoPath, branch)
+		cmd.Dir = absRepo
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to create worktree: %s", string(output))
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(w.Path, "scratchpad"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scratchpad directory: %w", err)
+	}
+	return w, nil
+}
+
+// Relink points the workspace at path back to its source repo after the
+// repo moved to sourceRepo. An intact worktree is repaired in place, so
+// uncommitted work survives; a missing one is added again from branch,
+// which must exist in sourceRepo.
+func Relink(path, sourceRepo, branch string) error {
+	absRepo, err := filepath.Abs(sourceRepo)
+	if err != nil {
+		return fmt.Errorf("failed to resolve repo path: %w", err)
+	}
+	if _, err := repoHead(absRepo); err != nil {
+		return err
+	}
+
+	repoPath := filepath.Join(path, "repo")
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
+		cmd := exec.Command("git", "worktree", "repair", repoPath)
+		cmd.Dir = absRepo
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to repair worktree: %s", strings.TrimSpace(string(output)))
+		}
+		cmd = exec.Command("git", "status", "--porcelain")
+		cmd.Dir = repoPath
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("worktree %s is not part of %s: %s", repoPath, absRepo, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	if branch == "" {
+		return fmt.Errorf("%s is missing and the run has no branch to recreate it from", repoPath)
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "-q", "refs/heads/"+branch)
 	cmd.Dir = absRepo
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%s is not a git repository", absRepo)
+		return fmt.Errorf("branch %q not found in %s", branch, absRepo)
 	}
 
-	// Create a new branch for this run
-	branchName := fmt.Sprintf("shop/run-%d", runID)
+	// The branch's old worktree entry is stale now that its directory is gone
+	cmd = exec.Command("git", "worktree", "prune")
+	cmd.Dir = absRepo
+	cmd.Run()
 
-	// Create worktree with new branch at current HEAD
-	cmd = exec.Command("git", "worktree", "add", "-b", branchName, w.RepoPath)
+	os.RemoveAll(repoPath)
+	if err := os.MkdirAll(filepath.Join(path, "scratchpad"), 0755); err != nil {
+		return fmt.Errorf("failed to create scratchpad directory: %w", err)
+	}
+	cmd = exec.Command("git", "worktree", "add", "-q", repoPath, branch)
 	cmd.Dir = absRepo
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to create worktree: %s", string(output))
+		return fmt.Errorf("failed to create worktree: %s", strings.TrimSpace(string(output)))
 	}
-
 	return nil
 }
 
-func Open(baseDir string, runID int64) (*Workspace, error) {
-	path := filepath.Join(baseDir, fmt.Sprintf("run-%d", runID))
+// worktreeFor returns the path of the worktree that has branch checked out,
+// or "" if none does.
+func worktreeFor(repo, branch string) (string, error) {
+	cmd := exec.Command("git", "worktree", "list", "--porcelain")
+	cmd.Dir = repo
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git worktree list failed: %w", err)
+	}
+
+	var path string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			path = strings.TrimPrefix(line, "worktree ")
+		case line == "branch refs/heads/"+branch:
+			return path, nil
+		}
+	}
+	return "", nil
+}
 
+// Exists reports whether the workspace directory at path is still there;
+// it is false for runs that never got one and for workspaces deleted by hand.
+func Exists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func Open(baseDir, instanceID string, runID int64) (*Workspace, error) {
+	return OpenPath(Dir(baseDir, instanceID, runID), runID)
+}
+
+// OpenPath opens the workspace at path and checks it is intact: repo/ must
+// exist and, if it is a git worktree, `git status` must succeed in it.
+func OpenPath(path string, runID int64) (*Workspace, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, fmt.Errorf("workspace for run %d does not exist", runID)
 	}
 
-	return &Workspace{
+	w := &Workspace{
 		Path:     path,
 		RepoPath: filepath.Join(path, "repo"),
-	}, nil
+	}
+
+	info, err := os.Stat(w.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("workspace for run %d is corrupt: %s is missing", runID, w.RepoPath)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("workspace for run %d is corrupt: %s is not a directory", runID, w.RepoPath)
+	}
+
+	if _, err := os.Stat(filepath.Join(w.RepoPath, ".git")); err == nil {
+		cmd := exec.Command("git", "status", "--porcelain")
+		cmd.Dir = w.RepoPath
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("workspace for run %d is corrupt: git status failed: %s",
+				runID, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return w, nil
+}
+
+// sourceRepoFile records, in the workspace directory, the source repo of a
+// worktree RemoveWorktree deleted: with repo/ gone, its .git file can't
+// say where the branch lives.
+const sourceRepoFile = "source-repo"
+
+// Remove deletes the workspace: its worktree and branch from the source
+// repo, then the directory itself (via `trash` when available).
+func (w *Workspace) Remove() {
+	sourceRepo := SourceRepo(w.RepoPath)
+	if sourceRepo == "" {
+		if data, err := os.ReadFile(filepath.Join(w.Path, sourceRepoFile)); err == nil {
+			sourceRepo = strings.TrimSpace(string(data))
+		}
+	}
+	if sourceRepo != "" {
+		gitCmd := exec.Command("git", "worktree", "remove", "--force", w.RepoPath)
+		gitCmd.Dir = sourceRepo
+		gitCmd.CombinedOutput()
+
+		if w.Branch != "" {
+			gitCmd = exec.Command("git", "branch", "-D", w.Branch)
+			gitCmd.Dir = sourceRepo
+			gitCmd.CombinedOutput()
+		}
+	}
+
+	trashCmd := exec.Command("trash", w.Path)
+	if err := trashCmd.Run(); err != nil {
+		os.RemoveAll(w.Path)
+	}
+}
+
+// RemoveWorktree deletes just the workspace's worktree from the source
+// repo, keeping its branch and the rest of the workspace directory.
+// Relink can add the worktree back from the branch.
+func (w *Workspace) RemoveWorktree() error {
+	sourceRepo := SourceRepo(w.RepoPath)
+	if sourceRepo == "" {
+		return fmt.Errorf("%s is not a git worktree", w.RepoPath)
+	}
+	// Remove needs it later to delete the branch
+	if err := os.WriteFile(filepath.Join(w.Path, sourceRepoFile), []byte(sourceRepo+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record source repo: %w", err)
+	}
+	gitCmd := exec.Command("git", "worktree", "remove", "--force", w.RepoPath)
+	gitCmd.Dir = sourceRepo
+	if output, err := gitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git worktree remove failed: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Reset discards everything agents did in the repo: commits on the run's
+// branch since BaseCommit (or, without one, since the branch was created),
+// uncommitted changes, and untracked files.
+// Ignored files (build caches, dependencies) are kept.
+func (w *Workspace) Reset() error {
+	target := "HEAD"
+	if w.BaseCommit != "" {
+		target = w.BaseCommit
+	} else if w.Branch != "" {
+		// The oldest reflog entry is where the branch was created
+		cmd := exec.Command("git", "reflog", "show", "--format=%H", w.Branch)
+		cmd.Dir = w.RepoPath
+		if out, err := cmd.Output(); err == nil {
+			if lines := strings.Fields(string(out)); len(lines) > 0 {
+				target = lines[len(lines)-1]
+			}
+		}
+	}
+
+	for _, args := range [][]string{
+		{"reset", "--hard", "-q", target},
+		{"clean", "-fdq"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = w.RepoPath
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// SourceRepo extracts the main repo path from a worktree's .git file.
+func SourceRepo(worktreePath string) string {
+	gitFile := filepath.Join(worktreePath, ".git")
+	data, err := os.ReadFile(gitFile)
+	if err != nil {
+		return ""
+	}
+	content := string(data)
+	if !strings.HasPrefix(content, "gitdir: ") {
+		return ""
+	}
+	gitDir := strings.TrimSpace(content[8:])
+	idx := strings.LastIndex(gitDir, "/.git/")
+	if idx == -1 {
+		return ""
+	}
+	return gitDir[:idx]
 }
 
 func (w *Workspace) CreateAgentScratchpad(agentName string) error {
@@ -0,0 +1,558 @@
+package workspace
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func initRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	return dir
+}
+
+func TestInstancesSharingRepoDoNotCollide(t *testing.T) {
+	repo := initRepo(t)
+
+	a, err := Create(t.TempDir(), "alpha", 5, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Create(t.TempDir(), "beta", 5, repo)
+	if err != nil {
+		t.Fatalf("second instance collided: %v", err)
+	}
+
+	if a.Branch != "shop/alpha/run-5" || b.Branch != "shop/beta/run-5" {
+		t.Fatalf("unexpected branches: %q, %q", a.Branch, b.Branch)
+	}
+	if SourceRepo(a.RepoPath) == "" || SourceRepo(b.RepoPath) == "" {
+		t.Fatal("expected both workspaces to be worktrees of the source repo")
+	}
+
+	a.Remove()
+	cmd := exec.Command("git", "rev-parse", "--verify", "-q", b.Branch)
+	cmd.Dir = repo
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("removing one instance's run deleted the other's branch: %v", err)
+	}
+	cmd = exec.Command("git", "rev-parse", "--verify", "-q", a.Branch)
+	cmd.Dir = repo
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected removed run's branch to be deleted")
+	}
+}
+
+func TestDirAndBranchWithoutInstance(t *testing.T) {
+	if got := Dir("/ws", "", 3); got != filepath.Join("/ws", "run-3") {
+		t.Fatalf("unexpected dir %q", got)
+	}
+	if got := BranchName("", 3); got != "shop/run-3" {
+		t.Fatalf("unexpected branch %q", got)
+	}
+}
+
+func TestOpenDetectsMissingRepo(t *testing.T) {
+	base := t.TempDir()
+	w, err := Create(base, "", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(base, "", 1); err != nil {
+		t.Fatalf("expected intact workspace to open: %v", err)
+	}
+
+	if err := os.RemoveAll(w.RepoPath); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(base, "", 1)
+	if err == nil || !strings.Contains(err.Error(), "workspace for run 1 is corrupt") {
+		t.Fatalf("expected corrupt workspace error, got %v", err)
+	}
+}
+
+func TestOpenDetectsBrokenWorktree(t *testing.T) {
+	repo := initRepo(t)
+	base := t.TempDir()
+	if _, err := Create(base, "", 2, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the source repo leaves the worktree's .git pointing nowhere
+	if err := os.RemoveAll(filepath.Join(repo, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Open(base, "", 2)
+	if err == nil || !strings.Contains(err.Error(), "git status failed") {
+		t.Fatalf("expected git status failure, got %v", err)
+	}
+}
+
+func TestRelinkAfterRepoMoved(t *testing.T) {
+	repo := initRepo(t)
+	base := t.TempDir()
+	ws, err := Create(base, "", 3, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws.RepoPath, "wip.txt"), []byte("wip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := os.Rename(repo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(base, "", 3); err == nil {
+		t.Fatal("expected the worktree to be broken after the move")
+	}
+
+	// Repaired in place, keeping uncommitted work
+	if err := Relink(ws.Path, moved, ws.Branch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(base, "", 3); err != nil {
+		t.Fatalf("expected the worktree to work again: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ws.RepoPath, "wip.txt")); err != nil {
+		t.Fatalf("expected uncommitted work kept: %v", err)
+	}
+
+	// Re-added from the branch when the worktree itself is gone
+	if err := os.RemoveAll(ws.RepoPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := Relink(ws.Path, moved, ws.Branch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(base, "", 3); err != nil {
+		t.Fatalf("expected the worktree recreated: %v", err)
+	}
+	if SourceRepo(ws.RepoPath) != moved {
+		t.Fatalf("expected the worktree to belong to %s, got %s", moved, SourceRepo(ws.RepoPath))
+	}
+
+	if err := Relink(ws.Path, t.TempDir(), ws.Branch); err == nil {
+		t.Fatal("expected an error for a directory that isn't a git repo")
+	}
+}
+
+func TestCreateClearsStaleWorktreeEntry(t *testing.T) {
+	repo := initRepo(t)
+	base := t.TempDir()
+	path := filepath.Join(Dir(base, "", 4), "repo")
+
+	// An earlier add for this path whose directory was then lost
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("worktree", "add", "-q", "-b", "interrupted", path)
+	git("worktree", "lock", "--reason", "adding", path)
+	if err := os.RemoveAll(Dir(base, "", 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Create(base, "", 4, repo)
+	if err == nil || !strings.Contains(err.Error(), "worktree unlock") || !strings.Contains(err.Error(), "(adding)") {
+		t.Fatalf("expected a locked entry to be reported with how to clear it, got %v", err)
+	}
+
+	// Once unlocked, the stale entry is pruned and the add goes ahead
+	git("worktree", "unlock", path)
+	if _, err := Create(base, "", 4, repo); err != nil {
+		t.Fatalf("expected the stale entry to be pruned: %v", err)
+	}
+}
+
+func TestResetDiscardsAgentWork(t *testing.T) {
+	repo := initRepo(t)
+	w, err := Create(t.TempDir(), "", 4, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = w.RepoPath
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(w.RepoPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("committed.txt", "agent commit")
+	git("add", "committed.txt")
+	git("commit", "-q", "-m", "agent work")
+	write("untracked.txt", "draft")
+
+	if err := w.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"committed.txt", "untracked.txt"} {
+		if _, err := os.Stat(filepath.Join(w.RepoPath, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be gone after reset", name)
+		}
+	}
+}
+
+func TestAdoptExistingBranch(t *testing.T) {
+	repo := initRepo(t)
+	base := t.TempDir()
+	old, err := Create(base, "", 7, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Branch still checked out in its old workspace: reuse it
+	w, err := Adopt(base, "", 1, repo, old.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Path != old.Path || w.Branch != "shop/run-7" {
+		t.Fatalf("expected old workspace %s to be reused, got %s (%s)", old.Path, w.Path, w.Branch)
+	}
+
+	// Workspace directory gone: a fresh worktree of the branch is added
+	if err := os.RemoveAll(old.Path); err != nil {
+		t.Fatal(err)
+	}
+	w, err = Adopt(base, "", 2, repo, old.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Path != Dir(base, "", 2) {
+		t.Fatalf("expected new workspace at %s, got %s", Dir(base, "", 2), w.Path)
+	}
+	if _, err := OpenPath(w.Path, 2); err != nil {
+		t.Fatalf("adopted workspace is not usable: %v", err)
+	}
+	if SourceRepo(w.RepoPath) == "" {
+		t.Fatal("expected adopted repo to be a worktree of the source repo")
+	}
+
+	if _, err := Adopt(base, "", 3, repo, "shop/run-99"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing branch error, got %v", err)
+	}
+}
+
+func TestCreateRecordsBaseCommit(t *testing.T) {
+	repo := initRepo(t)
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repo
+	head, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Create(t.TempDir(), "", 8, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(string(head)); w.BaseCommit != want {
+		t.Fatalf("expected base commit %s, got %q", want, w.BaseCommit)
+	}
+}
+
+func TestResolveRepoClonesRemoteOnce(t *testing.T) {
+	src := initRepo(t)
+	bare := filepath.Join(t.TempDir(), "project.git")
+	if out, err := exec.Command("git", "clone", "-q", "--bare", src, bare).CombinedOutput(); err != nil {
+		t.Fatalf("git clone --bare: %s", out)
+	}
+	url := "file://" + bare
+	cache := t.TempDir()
+
+	if got, err := ResolveRepo(cache, src, false); err != nil || got != src {
+		t.Fatalf("expected local path unchanged, got %q, %v", got, err)
+	}
+
+	clone, err := ResolveRepo(cache, url, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(clone, cache) || !strings.Contains(filepath.Base(clone), "project-") {
+		t.Fatalf("expected a clone named after the repo in the cache, got %s", clone)
+	}
+	w, err := Create(t.TempDir(), "", 1, clone)
+	if err != nil {
+		t.Fatalf("worktree from clone: %v", err)
+	}
+	first := w.BaseCommit
+
+	// A new commit upstream is only picked up with refresh
+	commit := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "more")
+	commit.Dir = src
+	if out, err := commit.CombinedOutput(); err != nil {
+		t.Fatalf("git commit: %s", out)
+	}
+	push := exec.Command("git", "push", "-q", bare, "HEAD")
+	push.Dir = src
+	if out, err := push.CombinedOutput(); err != nil {
+		t.Fatalf("git push: %s", out)
+	}
+
+	again, err := ResolveRepo(cache, url, false)
+	if err != nil || again != clone {
+		t.Fatalf("expected the cached clone to be reused, got %q, %v", again, err)
+	}
+	if w, err = Create(t.TempDir(), "", 2, again); err != nil || w.BaseCommit != first {
+		t.Fatalf("expected the cached commit without refresh, got %s, %v", w.BaseCommit, err)
+	}
+
+	if _, err := ResolveRepo(cache, url, true); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = Create(t.TempDir(), "", 3, clone); err != nil || w.BaseCommit == first {
+		t.Fatalf("expected refresh to fetch the new commit, got %s, %v", w.BaseCommit, err)
+	}
+}
+
+func TestResolveRepoConcurrentClones(t *testing.T) {
+	src := initRepo(t)
+	url := "file://" + src
+	cache := t.TempDir()
+
+	var wg sync.WaitGroup
+	paths := make([]string, 4)
+	errs := make([]error, len(paths))
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = ResolveRepo(cache, url, false)
+		}()
+	}
+	wg.Wait()
+
+	for i := range paths {
+		if errs[i] != nil {
+			t.Fatalf("clone %d: %v", i, errs[i])
+		}
+		if paths[i] != paths[0] {
+			t.Fatalf("expected every caller to get the same clone, got %q and %q", paths[0], paths[i])
+		}
+	}
+	if _, err := repoHead(paths[0]); err != nil {
+		t.Fatalf("expected a usable clone: %v", err)
+	}
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the clone left in the cache, got %v", entries)
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	for repo, want := range map[string]bool{
+		"https://github.com/org/repo.git": true,
+		"ssh://git@host/org/repo":         true,
+		"file:///srv/repo.git":            true,
+		"git@github.com:org/repo.git":     true,
+		".":                               false,
+		"/home/me/src/repo":               false,
+		"../repo":                         false,
+		"C:/src/repo":                     false,
+	} {
+		if got := IsRemote(repo); got != want {
+			t.Errorf("IsRemote(%q) = %v, want %v", repo, got, want)
+		}
+	}
+}
+
+func TestReuseResetsToBaseBetweenRuns(t *testing.T) {
+	repo := initRepo(t)
+	base := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	running := map[int64]bool{}
+	inUse := func(runID int64) bool { return running[runID] }
+
+	first, err := Reuse(base, "", 1, "feature", repo, inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	running[1] = true
+
+	// Run 1 commits, leaves an untracked file and scratch notes
+	if err := os.WriteFile(filepath.Join(first.RepoPath, "agent.txt"), []byte("work"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(first.RepoPath, "add", "agent.txt")
+	git(first.RepoPath, "commit", "-q", "-m", "agent work")
+	for _, path := range []string{filepath.Join(first.RepoPath, "draft.txt"), filepath.Join(first.ScratchpadPath("coder"), "notes.md")} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Reuse(base, "", 2, "feature", repo, inUse); err == nil || !strings.Contains(err.Error(), "in use by run 1") {
+		t.Fatalf("expected the workspace to be held by run 1, got %v", err)
+	}
+	other, err := Reuse(base, "", 3, "bugfix", repo, inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Path == first.Path {
+		t.Fatal("expected another workflow to get its own shared workspace")
+	}
+
+	// The source repo moves on; run 1 finishes and run 2 takes over
+	git(repo, "commit", "-q", "--allow-empty", "-m", "upstream")
+	head := git(repo, "rev-parse", "HEAD")
+	running[1] = false
+
+	second, err := Reuse(base, "", 2, "feature", repo, inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Path != first.Path || second.Branch != first.Branch {
+		t.Fatalf("expected %s (%s) to be reused, got %s (%s)", first.Path, first.Branch, second.Path, second.Branch)
+	}
+	if second.BaseCommit != head || git(second.RepoPath, "rev-parse", "HEAD") != head {
+		t.Fatalf("expected the workspace to be reset to the source HEAD %s", head)
+	}
+	for _, path := range []string{filepath.Join(second.RepoPath, "agent.txt"), filepath.Join(second.RepoPath, "draft.txt"), second.ScratchpadPath("coder")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be gone after reuse", path)
+		}
+	}
+	if owner, ok := SharedOwner(second.Path); !ok || owner != 2 {
+		t.Fatalf("expected run 2 to own the workspace, got %d (%v)", owner, ok)
+	}
+	if _, ok := SharedOwner(Dir(base, "", 1)); ok {
+		t.Fatal("expected a per-run workspace not to be shared")
+	}
+}
+
+func TestRunBranches(t *testing.T) {
+	repo := initRepo(t)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	into := DefaultBranch(repo)
+	if into != "main" && into != "master" {
+		t.Fatalf("expected the default branch to be main or master, got %q", into)
+	}
+
+	git("branch", "shop/run-1")
+	git("checkout", "-q", "-b", "shop/run-2")
+	git("commit", "-q", "--allow-empty", "-m", "unmerged work")
+	git("checkout", "-q", into)
+	git("branch", "shop/other/run-3")
+	git("branch", "shop/run-notes")
+	ws, err := Create(t.TempDir(), "", 4, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	branches, err := RunBranches(repo, "", into)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RunBranch{
+		{Name: "shop/run-1", RunID: 1, Merged: true},
+		{Name: "shop/run-2", RunID: 2},
+		{Name: "shop/run-4", RunID: 4, Merged: true, Worktree: ws.RepoPath},
+	}
+	if len(branches) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, branches)
+	}
+	for i := range want {
+		if branches[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], branches[i])
+		}
+	}
+
+	if err := DeleteBranch(repo, "shop/run-2"); err != nil {
+		t.Fatal(err)
+	}
+	// Un-namespaced branches, from before instance IDs, belong to every instance
+	branches, err = RunBranches(repo, "other", into)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range branches {
+		names = append(names, b.Name)
+		if b.Legacy != !strings.HasPrefix(b.Name, "shop/other/") {
+			t.Errorf("expected only un-namespaced branches marked legacy, got %+v", b)
+		}
+	}
+	if got := strings.Join(names, " "); got != "shop/run-1 shop/run-4 shop/other/run-3" {
+		t.Fatalf("expected the other instance's and un-namespaced branches, got %q", got)
+	}
+}
+
+func TestCreateRejectsRepoInsideWorkspaces(t *testing.T) {
+	base := t.TempDir()
+	repo := filepath.Join(base, "run-1", "repo")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+
+	if _, err := Create(base, "", 2, repo); err == nil || !strings.Contains(err.Error(), "inside shop's workspaces directory") {
+		t.Fatalf("expected a repo inside the workspaces dir rejected, got %v", err)
+	}
+	if _, err := os.Stat(Dir(base, "", 2)); !os.IsNotExist(err) {
+		t.Fatalf("expected no workspace created, got %v", err)
+	}
+	if _, err := Reuse(base, "", 2, "wf", repo, func(int64) bool { return false }); err == nil {
+		t.Fatal("expected a shared workspace of the nested repo rejected too")
+	}
+
+	// A sibling whose name merely starts with the workspaces dir is fine
+	if Within(base, base+"-repo") || !Within(base, base) || !Within(base, repo) {
+		t.Fatal("expected Within to compare whole path elements")
+	}
+}